@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const ackBindingLocation = "acknowledgment"
+
+// SetAcknowledgmentForm enables the acknowledgment button of the channel's
+// welcome posts.
+var SetAcknowledgmentForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "label",
+			Description:          "The label of the button, e.g. \"I've read the guidelines\". Leave empty to remove the button.",
+			AutocompletePosition: -1,
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_acknowledgment").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// AckReportForm reports who acknowledged the channel's welcome.
+var AckReportForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		channelField,
+	},
+	Submit: apps.NewCall("/ack_report").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// Acknowledge is the call made by the acknowledgment button of a welcome post.
+// Its state is the ID of the member the post welcomed; the post is expanded so
+// the button can be replaced once clicked.
+var Acknowledge = apps.NewCall("/acknowledge").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+	Channel:    apps.ExpandSummary,
+	Post:       apps.ExpandAll,
+})
+
+func acknowledgmentsKey(channelID string) string {
+	return "acks_" + channelID
+}
+
+// Acknowledgment tracks whether a member welcomed in a channel clicked the
+// acknowledgment button.
+type Acknowledgment struct {
+	WelcomedAt     int64 `json:"welcomed_at"`
+	AcknowledgedAt int64 `json:"acknowledged_at,omitempty"`
+}
+
+// GetAcknowledgments returns the acknowledgments of the channel's welcome, by
+// user ID.
+func (s *Store) GetAcknowledgments(channelID string) (map[string]Acknowledgment, error) {
+	acks := map[string]Acknowledgment{}
+	if err := s.kv.KVGet(KVAppPrefix, acknowledgmentsKey(channelID), &acks); err != nil {
+		return nil, err
+	}
+	if acks == nil {
+		acks = map[string]Acknowledgment{}
+	}
+	return acks, nil
+}
+
+// AddPendingAcknowledgment records that the user was asked to acknowledge the
+// channel's welcome. Earlier acknowledgments by the user are kept.
+func (s *Store) AddPendingAcknowledgment(channelID, userID string) error {
+	acks, err := s.GetAcknowledgments(channelID)
+	if err != nil {
+		return err
+	}
+	if _, ok := acks[userID]; ok {
+		return nil
+	}
+
+	acks[userID] = Acknowledgment{WelcomedAt: model.GetMillis()}
+	_, err = s.kv.KVSet(KVAppPrefix, acknowledgmentsKey(channelID), acks)
+	return err
+}
+
+// Acknowledge records that the user acknowledged the channel's welcome, and
+// returns when. Acknowledging again keeps the first time.
+func (s *Store) Acknowledge(channelID, userID string) (int64, error) {
+	acks, err := s.GetAcknowledgments(channelID)
+	if err != nil {
+		return 0, err
+	}
+
+	ack := acks[userID]
+	if ack.AcknowledgedAt != 0 {
+		return ack.AcknowledgedAt, nil
+	}
+	ack.AcknowledgedAt = model.GetMillis()
+	if ack.WelcomedAt == 0 {
+		ack.WelcomedAt = ack.AcknowledgedAt
+	}
+	acks[userID] = ack
+
+	_, err = s.kv.KVSet(KVAppPrefix, acknowledgmentsKey(channelID), acks)
+	return ack.AcknowledgedAt, err
+}
+
+// DeleteAcknowledgments removes the acknowledgments of the channel's welcome.
+func (s *Store) DeleteAcknowledgments(channelID string) error {
+	return s.kv.KVDelete(KVAppPrefix, acknowledgmentsKey(channelID))
+}
+
+func SetAcknowledgmentCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the acknowledgment button"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.Acknowledgment = strings.TrimSpace(c.GetValue("label", ""))
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the acknowledgment button"))
+		return
+	}
+
+	if welcome.Acknowledgment == "" {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Removed the acknowledgment button"))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members will be asked to click %q under the welcome message", welcome.Acknowledgment))
+}
+
+// AcknowledgeCall records the acknowledgment of the welcomed member, and
+// replaces the button of the post with the time it was clicked. Only the
+// member the post welcomed can acknowledge it.
+func AcknowledgeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	state := ackState{}
+	if userID, ok := c.State.(string); ok {
+		state.UserID = userID
+	} else {
+		data, _ := json.Marshal(c.State)
+		_ = json.Unmarshal(data, &state)
+	}
+	if state.ChannelID == "" && c.Context.Channel != nil {
+		state.ChannelID = c.Context.Channel.Id
+	}
+	if c.Context.ActingUser == nil || state.ChannelID == "" || state.UserID == "" {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't record your acknowledgment"))
+		return
+	}
+	if c.Context.ActingUser.Id != state.UserID {
+		httputils.WriteJSON(w,
+			errorResponse("this welcome message is addressed to someone else"))
+		return
+	}
+
+	acknowledgedAt, err := NewStore(req.Context(), c.Context).Acknowledge(state.ChannelID, state.UserID)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't record your acknowledgment"))
+		return
+	}
+	eventWebhook.Send(Event{
+		Type:        EventWelcomeAcknowledged,
+		Timestamp:   acknowledgedAt,
+		WelcomeKind: IndexKindChannel,
+		WelcomeID:   state.ChannelID,
+		UserID:      state.UserID,
+		ChannelID:   state.ChannelID,
+	})
+
+	if c.Context.Post != nil {
+		if err = markAcknowledged(asBot(req.Context(), c.Context), c.Context.Post, c.Context.ActingUser, acknowledgedAt); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Thanks, your acknowledgment was recorded"))
+}
+
+func AckReportCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	acks, err := store.GetAcknowledgments(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't get the acknowledgments"))
+		return
+	}
+	if len(acks) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("No member was asked to acknowledge the welcome message of ~%s yet", cc.Channel.Name))
+		return
+	}
+
+	userIDs := make([]string, 0, len(acks))
+	for userID := range acks {
+		userIDs = append(userIDs, userID)
+	}
+	usernames := map[string]string{}
+	users, _, err := asBot(req.Context(), cc).GetUsersByIds(userIDs)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+
+	var acknowledged, pending []string
+	for _, userID := range userIDs {
+		name := userID
+		if username, ok := usernames[userID]; ok {
+			name = "@" + username
+		}
+		ack := acks[userID]
+		if ack.AcknowledgedAt != 0 {
+			acknowledged = append(acknowledged, fmt.Sprintf("* %s on %s", name, formatMillis(ack.AcknowledgedAt)))
+		} else {
+			pending = append(pending, fmt.Sprintf("* %s, welcomed on %s", name, formatMillis(ack.WelcomedAt)))
+		}
+	}
+	sort.Strings(acknowledged)
+	sort.Strings(pending)
+
+	message := fmt.Sprintf("#### Acknowledgments of the welcome message of ~%s\n\n", cc.Channel.Name)
+	message += fmt.Sprintf("**Acknowledged (%d)**\n%s\n\n", len(acknowledged), strings.Join(acknowledged, "\n"))
+	message += fmt.Sprintf("**Not acknowledged yet (%d)**\n%s\n", len(pending), strings.Join(pending, "\n"))
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
+
+// ackState is the state of the acknowledgment button: the welcomed member, and
+// the channel whose welcome they acknowledge, as the post may be a direct
+// message. Buttons posted before the channel was added have the user ID alone
+// as their state.
+type ackState struct {
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// acknowledgmentBinding returns the in-post binding with the acknowledgment
+// button for the welcomed member of the channel.
+func acknowledgmentBinding(label, userID, channelID string) apps.Binding {
+	return apps.Binding{
+		AppID:       AppID,
+		Location:    ackBindingLocation,
+		Label:       "Please confirm",
+		Description: "Let the channel admins know you've read the message above.",
+		Bindings: []apps.Binding{
+			{
+				Location: "ack",
+				Label:    label,
+				Submit:   Acknowledge.WithState(ackState{UserID: userID, ChannelID: channelID}),
+			},
+		},
+	}
+}
+
+// markAcknowledged replaces the acknowledgment button of the welcome post with
+// who clicked it and when, keeping the post's other bindings.
+func markAcknowledged(client *appclient.Client, post *model.Post, user *model.User, acknowledgedAt int64) error {
+	data, err := json.Marshal(post.GetProp(apps.PropAppBindings))
+	if err != nil {
+		return err
+	}
+	var bindings []apps.Binding
+	if err = json.Unmarshal(data, &bindings); err != nil {
+		return err
+	}
+
+	for i := range bindings {
+		if bindings[i].Location != ackBindingLocation {
+			continue
+		}
+		bindings[i].Label = "Acknowledged"
+		bindings[i].Description = fmt.Sprintf("@%s acknowledged this message on %s.", user.Username, formatMillis(acknowledgedAt))
+		bindings[i].Bindings = nil
+	}
+
+	props := post.GetProps()
+	props[apps.PropAppBindings] = bindings
+	_, _, err = client.PatchPost(post.Id, &model.PostPatch{Props: &props})
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// AdminDisable and AdminEnable pause and resume all the welcomes, server-wide.
+var (
+	AdminDisable = apps.NewCall("/admin/disable").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	})
+	AdminEnable = apps.NewCall("/admin/enable").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	})
+)
+
+// AdminBinding groups the commands reserved to system admins.
+var AdminBinding = apps.Binding{
+	Label:       "admin", // Server-wide commands for system admins.
+	Description: "Server-wide commands for system admins",
+	Hint:        "[disable|enable|gc|forget|dead_letters]",
+	Bindings: []apps.Binding{
+		{
+			Label:  "disable", // Pauses all the welcomes.
+			Submit: AdminDisable,
+		},
+		{
+			Label:  "enable", // Resumes all the welcomes.
+			Submit: AdminEnable,
+		},
+		{
+			Label: "gc", // Finds and purges the data of channels and teams that no longer exist.
+			Form:  &AdminGCForm,
+		},
+		{
+			Label: "forget", // Removes everything stored about a user.
+			Form:  &AdminForgetForm,
+		},
+		{
+			Label: "dead_letters", // Lists the welcome deliveries that failed.
+			Form:  &AdminDeadLettersForm,
+		},
+	},
+}
+
+// welcomesPaused reports whether a system admin paused all the welcomes.
+func welcomesPaused(store *Store) bool {
+	settings, err := store.GetSettings()
+	if err != nil {
+		logger.Error(err)
+	}
+	return settings.WelcomesPaused
+}
+
+func AdminDisableCall(w http.ResponseWriter, req *http.Request) {
+	setWelcomesPaused(w, req, true)
+}
+
+func AdminEnableCall(w http.ResponseWriter, req *http.Request) {
+	setWelcomesPaused(w, req, false)
+}
+
+// setWelcomesPaused stores whether the welcomes are paused. The welcome
+// messages themselves are kept.
+func setWelcomesPaused(w http.ResponseWriter, req *http.Request, paused bool) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	settings, err := store.GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	settings.WelcomesPaused = paused
+	if err = store.SetSettings(settings); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if paused {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("All the welcome messages are paused. Use `admin enable` to resume them."))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("All the welcome messages are resumed."))
+}
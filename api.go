@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// APIPath is where the admin REST API is served.
+const APIPath = "/api/v1/"
+
+// minAPITokenLength is the shortest API token accepted, so it can't be
+// guessed.
+const minAPITokenLength = 16
+
+// maxAPIBodySize is the largest body of an API request read, in bytes, enough
+// for a welcome at its limits.
+const maxAPIBodySize = 1 << 20
+
+// apiSendRequest is the body of POST /api/v1/send: the member to welcome, by
+// username or ID, and the channel or team whose welcome they get.
+type apiSendRequest struct {
+	User      string `json:"user"`
+	ChannelID string `json:"channel_id,omitempty"`
+	TeamID    string `json:"team_id,omitempty"`
+}
+
+// APIHandler serves the admin REST API, for external tools like HR systems or
+// infrastructure as code to manage the welcome messages:
+//
+//	GET    /api/v1/welcomes              lists the configured welcomes
+//	GET    /api/v1/welcomes/{kind}/{id}  returns a welcome
+//	PUT    /api/v1/welcomes/{kind}/{id}  sets a welcome
+//	DELETE /api/v1/welcomes/{kind}/{id}  deletes a welcome
+//	POST   /api/v1/send                  sends a welcome to a member
+//
+// kind is channel or team, and the welcomes are JSON as the app stores them,
+// with channel and user IDs. The requests must be authenticated with the
+// token configured with API_TOKEN, e.g.
+//
+//	curl -H "Authorization: Bearer $API_TOKEN" $APP_URL/api/v1/welcomes
+//
+// The app acts as its bot, so it must be able to join the channels given. It
+// needs the bot's credentials, so the API is only available once Mattermost
+// made a call to the app since it started.
+func APIHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		// A token given without the Bearer scheme is rejected.
+		auth := req.Header.Get("Authorization")
+		given := strings.TrimPrefix(auth, "Bearer ")
+		if given == auth || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			apiError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+
+		cc, ok := scheduler.Context()
+		if !ok {
+			apiError(w, http.StatusServiceUnavailable, "the app has not been called by Mattermost yet, try again later")
+			return
+		}
+		// The bot is the acting user of the changes, e.g. to join channels,
+		// and the author of their revisions in the history.
+		cc.ActingUserAccessToken = cc.BotAccessToken
+		cc.ActingUser = &model.User{Id: cc.BotUserID}
+		req.Body = http.MaxBytesReader(w, req.Body, maxAPIBodySize)
+
+		path := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, APIPath), "/"), "/")
+		switch {
+		case len(path) == 1 && path[0] == "welcomes":
+			if req.Method != http.MethodGet {
+				apiMethodNotAllowed(w, http.MethodGet)
+				return
+			}
+			apiListWelcomes(req.Context(), w, cc)
+		case len(path) == 3 && path[0] == "welcomes":
+			apiWelcome(w, req, cc, path[1], path[2])
+		case len(path) == 1 && path[0] == "send":
+			if req.Method != http.MethodPost {
+				apiMethodNotAllowed(w, http.MethodPost)
+				return
+			}
+			apiSend(w, req, cc)
+		default:
+			apiError(w, http.StatusNotFound, "not found")
+		}
+	}
+}
+
+// apiError writes an error of the API, as JSON.
+func apiError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	httputils.WriteJSONStatus(w, code, map[string]string{
+		"error": fmt.Sprintf(format, args...),
+	})
+}
+
+// apiDecodeError writes the error decoding the body of a request: 413 if it
+// is too large, 400 otherwise.
+func apiDecodeError(w http.ResponseWriter, what string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apiError(w, http.StatusRequestEntityTooLarge, "the body must be at most %d bytes", maxAPIBodySize)
+		return
+	}
+	apiError(w, http.StatusBadRequest, "invalid %s: %s", what, err)
+}
+
+func apiMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// apiListWelcomes lists the indexed welcomes and farewells.
+func apiListWelcomes(ctx context.Context, w http.ResponseWriter, cc apps.Context) {
+	index, err := NewStore(ctx, cc).GetIndex()
+	if err != nil {
+		logger.Error(err)
+		apiError(w, http.StatusInternalServerError, "couldn't load the index")
+		return
+	}
+	httputils.WriteJSON(w, index)
+}
+
+// apiWelcome gets, sets or deletes the welcome of a channel or team.
+func apiWelcome(w http.ResponseWriter, req *http.Request, cc apps.Context, kind, id string) {
+	client := asBot(req.Context(), cc)
+	store := NewStore(req.Context(), cc)
+
+	switch kind {
+	case IndexKindChannel:
+		channel, _, err := client.GetChannel(id, "")
+		if err != nil {
+			apiError(w, http.StatusNotFound, "channel %s not found", id)
+			return
+		}
+		cc.Channel = channel
+	case IndexKindTeam:
+		team, _, err := client.GetTeam(id, "")
+		if err != nil {
+			apiError(w, http.StatusNotFound, "team %s not found", id)
+			return
+		}
+		cc.Team = team
+	default:
+		apiError(w, http.StatusNotFound, "the kind of welcome must be channel or team, got %q", kind)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		apiGetWelcome(w, cc, store)
+	case http.MethodPut:
+		if cc.Channel != nil {
+			apiSetChannelWelcome(w, req, cc, store)
+		} else {
+			apiSetTeamWelcome(w, req, cc, store)
+		}
+	case http.MethodDelete:
+		var err error
+		if cc.Channel != nil {
+			err = removeChannelWelcome(req.Context(), cc, store, cc.Channel)
+		} else {
+			err = removeTeamWelcome(req.Context(), cc, store, id)
+		}
+		if err != nil {
+			requestLogger(req).Error(err)
+			apiError(w, http.StatusInternalServerError, "couldn't delete the welcome")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apiMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func apiGetWelcome(w http.ResponseWriter, cc apps.Context, store *Store) {
+	var welcome interface{}
+	if cc.Channel != nil {
+		channelWelcome, err := store.GetChannelWelcome(cc.Channel.Id)
+		if err != nil {
+			logger.Error(err)
+			apiError(w, http.StatusInternalServerError, "couldn't load the welcome")
+			return
+		}
+		if channelWelcome != nil {
+			welcome = channelWelcome
+		}
+	} else {
+		teamWelcome, err := store.GetTeamWelcome(cc.Team.Id)
+		if err != nil {
+			logger.Error(err)
+			apiError(w, http.StatusInternalServerError, "couldn't load the welcome")
+			return
+		}
+		if teamWelcome != nil {
+			welcome = teamWelcome
+		}
+	}
+	if welcome == nil {
+		apiError(w, http.StatusNotFound, "no welcome is set")
+		return
+	}
+	httputils.WriteJSON(w, welcome)
+}
+
+func apiSetChannelWelcome(w http.ResponseWriter, req *http.Request, cc apps.Context, store *Store) {
+	welcome := ChannelWelcome{}
+	if err := json.NewDecoder(req.Body).Decode(&welcome); err != nil {
+		apiDecodeError(w, "welcome", err)
+		return
+	}
+	if len(welcome.Messages) == 0 {
+		apiError(w, http.StatusBadRequest, "the welcome has no messages")
+		return
+	}
+	if err := welcome.CheckLimits(); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	previous, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		apiError(w, http.StatusInternalServerError, "couldn't set the welcome")
+		return
+	}
+	// The pinned guide is the app's, not part of the configuration.
+	welcome.GuidePostID = ""
+	if previous != nil {
+		welcome.GuidePostID = previous.GuidePostID
+	}
+
+	if err = updateGuide(asBot(req.Context(), cc), cc.Channel, &welcome); err != nil {
+		requestLogger(req).Error(err)
+	}
+	if err = store.SetChannelWelcome(cc.Channel.Id, welcome); err != nil {
+		requestLogger(req).Error(err)
+		apiError(w, http.StatusInternalServerError, "couldn't set the welcome")
+		return
+	}
+	if err = enableChannelWelcome(req.Context(), cc); err != nil {
+		requestLogger(req).Error(err)
+		apiError(w, http.StatusBadGateway, "stored the welcome, but couldn't subscribe to the channel's join events: %s", err)
+		return
+	}
+	httputils.WriteJSON(w, welcome)
+}
+
+func apiSetTeamWelcome(w http.ResponseWriter, req *http.Request, cc apps.Context, store *Store) {
+	welcome := TeamWelcome{}
+	if err := json.NewDecoder(req.Body).Decode(&welcome); err != nil {
+		apiDecodeError(w, "welcome", err)
+		return
+	}
+	if welcome.Message == "" {
+		apiError(w, http.StatusBadRequest, "the welcome has no message")
+		return
+	}
+	if err := welcome.CheckLimits(); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if err := store.SetTeamWelcome(cc.Team.Id, welcome); err != nil {
+		requestLogger(req).Error(err)
+		apiError(w, http.StatusInternalServerError, "couldn't set the welcome")
+		return
+	}
+	if err := enableTeamWelcome(req.Context(), cc); err != nil {
+		requestLogger(req).Error(err)
+		apiError(w, http.StatusBadGateway, "stored the welcome, but couldn't subscribe to the team's join events: %s", err)
+		return
+	}
+	httputils.WriteJSON(w, welcome)
+}
+
+// apiSend queues the welcome of the channel or team for the member, like the
+// send command.
+func apiSend(w http.ResponseWriter, req *http.Request, cc apps.Context) {
+	body := apiSendRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		apiDecodeError(w, "request", err)
+		return
+	}
+	if body.User == "" || (body.ChannelID == "") == (body.TeamID == "") {
+		apiError(w, http.StatusBadRequest, "the user and either the channel_id or the team_id are required")
+		return
+	}
+
+	client := asBot(req.Context(), cc)
+	store := NewStore(req.Context(), cc)
+	user, _, err := client.GetUserByUsername(strings.ToLower(strings.TrimPrefix(body.User, "@")), "")
+	if err != nil && model.IsValidId(body.User) {
+		user, _, err = client.GetUser(body.User, "")
+	}
+	if err != nil {
+		apiError(w, http.StatusNotFound, "user %s not found", body.User)
+		return
+	}
+	if user.IsBot {
+		apiError(w, http.StatusBadRequest, "@%s is a bot, bots are not welcomed", user.Username)
+		return
+	}
+
+	if body.TeamID != "" {
+		team, _, err := client.GetTeam(body.TeamID, "")
+		if err != nil {
+			apiError(w, http.StatusNotFound, "team %s not found", body.TeamID)
+			return
+		}
+		welcome, err := store.GetTeamWelcome(team.Id)
+		if err != nil || welcome == nil {
+			apiError(w, http.StatusNotFound, "the team has no welcome message")
+			return
+		}
+		if _, _, err = client.GetTeamMember(team.Id, user.Id, ""); err != nil {
+			apiError(w, http.StatusBadRequest, "@%s isn't a member of the team", user.Username)
+			return
+		}
+		if err = queueTeamWelcome(req.Context(), cc, client, store, team, user, *welcome); err != nil {
+			requestLogger(req).Error(err)
+			apiError(w, http.StatusInternalServerError, "couldn't send the welcome message: %s", err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	channel, _, err := client.GetChannel(body.ChannelID, "")
+	if err != nil {
+		apiError(w, http.StatusNotFound, "channel %s not found", body.ChannelID)
+		return
+	}
+	welcome, err := store.GetChannelWelcome(channel.Id)
+	if err != nil || welcome == nil {
+		apiError(w, http.StatusNotFound, "~%s has no welcome message", channel.Name)
+		return
+	}
+	if _, _, err = client.GetChannelMember(channel.Id, user.Id, ""); err != nil {
+		apiError(w, http.StatusBadRequest, "@%s isn't a member of ~%s", user.Username, channel.Name)
+		return
+	}
+	team, _, err := client.GetTeam(channel.TeamId, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if err = queueChannelWelcome(req.Context(), cc, client, store, channel, team, user, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		apiError(w, http.StatusInternalServerError, "couldn't send the welcome message: %s", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
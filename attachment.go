@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Attachment is a message attachment shown under a welcome message, e.g. a
+// banner image with quick links. Its text fields are templates, like the
+// message.
+type Attachment struct {
+	Title      string            `json:"title,omitempty"`
+	TitleLink  string            `json:"title_link,omitempty"`
+	Text       string            `json:"text,omitempty"`
+	Color      string            `json:"color,omitempty"`
+	ImageURL   string            `json:"image_url,omitempty"`
+	ThumbURL   string            `json:"thumb_url,omitempty"`
+	AuthorName string            `json:"author_name,omitempty"`
+	AuthorIcon string            `json:"author_icon,omitempty"`
+	AuthorLink string            `json:"author_link,omitempty"`
+	Fields     []AttachmentField `json:"fields,omitempty"`
+}
+
+// AttachmentField is a titled value of an Attachment, shown side by side with
+// the other short fields.
+type AttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short,omitempty"`
+}
+
+var attachmentColorRegexp = regexp.MustCompile(`^(#[0-9a-fA-F]{6}|#[0-9a-fA-F]{3}|good|warning|danger)$`)
+
+// Validate checks the color and the URLs of the attachment, and that its
+// templates parse.
+func (a Attachment) Validate() error {
+	if a.Color != "" && !attachmentColorRegexp.MatchString(a.Color) {
+		return fmt.Errorf("the color must be like #2389d7, or good, warning or danger, got %q", a.Color)
+	}
+	for _, u := range []string{a.TitleLink, a.ImageURL, a.ThumbURL, a.AuthorIcon, a.AuthorLink} {
+		if u == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%q must be an http or https URL", u)
+		}
+	}
+
+	// The attachment is stored in the props of the post, which are limited
+	// in size.
+	props := model.StringInterfaceToJSON(model.StringInterface{
+		"attachments": []*model.SlackAttachment{a.Render(TemplateData{})},
+	})
+	if n := utf8.RuneCountInString(props); n > model.PostPropsMaxUserRunes {
+		return fmt.Errorf("the attachment is %d characters long, %d over the limit of %d", n, n-model.PostPropsMaxUserRunes, model.PostPropsMaxUserRunes)
+	}
+
+	templates := []string{a.Title, a.Text, a.AuthorName}
+	for _, f := range a.Fields {
+		templates = append(templates, f.Title, f.Value)
+	}
+	for _, t := range templates {
+		if _, err := RenderTemplate(t, TemplateData{}); err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	return nil
+}
+
+// Render renders the templates of the attachment for the new member.
+func (a Attachment) Render(data TemplateData) *model.SlackAttachment {
+	attachment := &model.SlackAttachment{
+		Fallback:   RenderWelcome(a.Title, data),
+		Title:      RenderWelcome(a.Title, data),
+		TitleLink:  a.TitleLink,
+		Text:       RenderWelcome(a.Text, data),
+		Color:      a.Color,
+		ImageURL:   a.ImageURL,
+		ThumbURL:   a.ThumbURL,
+		AuthorName: RenderWelcome(a.AuthorName, data),
+		AuthorIcon: a.AuthorIcon,
+		AuthorLink: a.AuthorLink,
+	}
+	for _, f := range a.Fields {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: RenderWelcome(f.Title, data),
+			Value: RenderWelcome(f.Value, data),
+			Short: model.SlackCompatibleBool(f.Short),
+		})
+	}
+	return attachment
+}
+
+// parseAttachmentFields parses the fields of an attachment given as
+// "Title: value" pairs, one per line or separated by semicolons.
+func parseAttachmentFields(value string) ([]AttachmentField, error) {
+	fields := []AttachmentField{}
+	for _, line := range strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == ';' }) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		title, value, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(title) == "" {
+			return nil, fmt.Errorf("the field %q must be like \"Title: value\"", line)
+		}
+		fields = append(fields, AttachmentField{
+			Title: strings.TrimSpace(title),
+			Value: strings.TrimSpace(value),
+			Short: true,
+		})
+	}
+	return fields, nil
+}
+
+// SetAttachmentForm sets the attachment shown under a message of the
+// channel's welcome.
+var SetAttachmentForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "title",
+			Label:       "title",
+			ModalLabel:  "Title",
+			Description: "The title of the attachment, e.g. Getting started",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "title_link",
+			Label:       "title_link",
+			ModalLabel:  "Title link",
+			Description: "The URL the title links to",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			TextSubtype: apps.TextFieldSubtypeTextarea,
+			Name:        "text",
+			Label:       "text",
+			ModalLabel:  "Text",
+			Description: "The markdown text of the attachment",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "color",
+			Label:       "color",
+			ModalLabel:  "Color",
+			Description: "The color of the attachment's border, e.g. #2389d7, good, warning or danger",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "image",
+			Label:       "image",
+			ModalLabel:  "Image URL",
+			Description: "The URL of a banner image",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "thumbnail",
+			Label:       "thumbnail",
+			ModalLabel:  "Thumbnail URL",
+			Description: "The URL of a thumbnail shown on the right",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "author",
+			Label:       "author",
+			ModalLabel:  "Author",
+			Description: "The name shown above the title, e.g. the team's name",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "author_icon",
+			Label:       "author_icon",
+			ModalLabel:  "Author icon URL",
+			Description: "The URL of the author's icon",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "author_link",
+			Label:       "author_link",
+			ModalLabel:  "Author link",
+			Description: "The URL the author links to",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			TextSubtype: apps.TextFieldSubtypeTextarea,
+			Name:        "fields",
+			Label:       "fields",
+			ModalLabel:  "Fields",
+			Description: "Fields like \"Handbook: https://example.com/handbook\", one per line or separated by semicolons",
+		},
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "remove",
+			Label:       "remove",
+			ModalLabel:  "Remove",
+			Description: "Remove the attachment of the message",
+		},
+		messageIndexField,
+		channelField,
+	},
+	Submit: apps.NewCall("/set_attachment").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+func SetAttachmentCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	index, err := strconv.Atoi(c.GetValue("index", "1"))
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("the message number must be a number")))
+		return
+	}
+
+	var attachment *Attachment
+	if !c.BoolValue("remove") {
+		fields, err := parseAttachmentFields(c.GetValue("fields", ""))
+		if err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		attachment = &Attachment{
+			Title:      c.GetValue("title", ""),
+			TitleLink:  c.GetValue("title_link", ""),
+			Text:       c.GetValue("text", ""),
+			Color:      c.GetValue("color", ""),
+			ImageURL:   c.GetValue("image", ""),
+			ThumbURL:   c.GetValue("thumbnail", ""),
+			AuthorName: c.GetValue("author", ""),
+			AuthorIcon: c.GetValue("author_icon", ""),
+			AuthorLink: c.GetValue("author_link", ""),
+			Fields:     fields,
+		}
+		if attachment.Title == "" && attachment.Text == "" && attachment.ImageURL == "" && len(fields) == 0 {
+			httputils.WriteJSON(w,
+				errorResponse("the attachment needs at least a title, a text, an image or fields"))
+			return
+		}
+		if err = attachment.Validate(); err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the attachment"))
+		return
+	}
+	message, found := welcome.Message(index)
+	if !found {
+		httputils.WriteJSON(w,
+			errorResponse("the welcome of ~%s has no message %d", cc.Channel.Name, index))
+		return
+	}
+
+	message.Attachment = attachment
+	if err = welcome.SetMessage(index, message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the attachment"))
+		return
+	}
+
+	if attachment == nil {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Removed the attachment of message %d of the welcome of ~%s", index, cc.Channel.Name))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Set the attachment of message %d of the welcome of ~%s. Use `preview` to see it.", index, cc.Channel.Name))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// BindingsSource is the call of the bindings. It expands what the permission
+// checks need, the bindings depending on what the acting user can manage.
+var BindingsSource = apps.NewCall("/bindings").WithExpand(apps.Expand{
+	ActingUser:    apps.ExpandSummary,
+	Channel:       apps.ExpandSummary,
+	ChannelMember: apps.ExpandAll,
+	Team:          apps.ExpandSummary,
+	TeamMember:    apps.ExpandAll,
+})
+
+// everyoneCommands are the subcommands anyone can use.
+var everyoneCommands = map[string]bool{
+	"help":    true,
+	"optout":  true,
+	"preview": true,
+}
+
+// teamCommands are the subcommands managing the team's welcome.
+var teamCommands = map[string]bool{
+	"set_team_welcome":       true,
+	"set_interests":          true,
+	"onboarding":             true,
+	"set_checklist":          true,
+	"checklist_report":       true,
+	"set_follow_up":          true,
+	"set_milestone":          true,
+	"survey":                 true,
+	"get_team_welcome":       true,
+	"delete_team_welcome":    true,
+	"set_channel_default":    true,
+	"delete_channel_default": true,
+	"set_team_farewell":      true,
+	"delete_team_farewell":   true,
+}
+
+// systemAdminCommands are the server-wide subcommands.
+var systemAdminCommands = map[string]bool{
+	"set_server_welcome":    true,
+	"get_server_welcome":    true,
+	"delete_server_welcome": true,
+	"export":                true,
+	"import":                true,
+	"set_required_role":     true,
+	"set_excluded_users":    true,
+	"set_rejoin_window":     true,
+	"set_retention":         true,
+	"admin":                 true,
+	"broadcast":             true,
+	"stats":                 true,
+}
+
+// bindingPermissions is what the acting user can manage in the call context.
+type bindingPermissions struct {
+	channel     bool
+	team        bool
+	systemAdmin bool
+	greeter     bool
+}
+
+// permissionsOf checks what the acting user can manage. The call must expand
+// the acting user, and the channel and team memberships.
+func permissionsOf(ctx context.Context, cc apps.Context) bindingPermissions {
+	store := NewStore(ctx, cc)
+	return bindingPermissions{
+		channel:     checkCanManageChannel(store, cc) == nil,
+		team:        checkCanManageTeam(store, cc) == nil,
+		systemAdmin: isSystemAdmin(cc),
+		greeter:     isGreeter(store, cc),
+	}
+}
+
+func (p bindingPermissions) any() bool {
+	return p.channel || p.team || p.systemAdmin
+}
+
+// canUse reports whether the acting user can use the subcommand. The
+// subcommands not listed in any of the sets manage the channel's welcome. The
+// greeters of the channel can send its welcome too.
+func (p bindingPermissions) canUse(label string) bool {
+	switch {
+	case everyoneCommands[label]:
+		return true
+	case label == "list" || label == SnippetBinding.Label:
+		return p.any()
+	case label == "send":
+		return p.channel || p.team || p.greeter
+	case label == "test":
+		return p.channel || p.team
+	case teamCommands[label]:
+		return p.team
+	case systemAdminCommands[label]:
+		return p.systemAdmin
+	}
+	return p.channel
+}
+
+// commandBinding returns the command with only the subcommands the acting
+// user can use. Everyone can start their onboarding, only the team managers
+// see its other subcommands.
+func commandBinding(p bindingPermissions) apps.Binding {
+	command := CommandBinding
+	command.Bindings = nil
+	for _, b := range CommandBinding.Bindings {
+		subcommands := []apps.Binding{}
+		labels := []string{}
+		for _, sub := range b.Bindings {
+			switch {
+			case p.canUse(sub.Label):
+			case sub.Label == OnboardingBinding.Label:
+				sub.Bindings = onboardingStartBindings()
+			default:
+				continue
+			}
+			subcommands = append(subcommands, sub)
+			labels = append(labels, sub.Label)
+		}
+		b.Bindings = subcommands
+		b.Hint = "[" + strings.Join(labels, "|") + "]"
+		command.Bindings = append(command.Bindings, b)
+	}
+	return command
+}
+
+func onboardingStartBindings() []apps.Binding {
+	for _, b := range OnboardingBinding.Bindings {
+		if b.Label == "start" {
+			return []apps.Binding{b}
+		}
+	}
+	return nil
+}
+
+// The bindings are cached for bindingsCacheTTL, for up to maxCachedBindings
+// combinations of roles and locales. The cache is cleared when the settings
+// change, the other replicas of the app catching up once their bindings
+// expire.
+const (
+	bindingsCacheTTL  = 5 * time.Minute
+	maxCachedBindings = 1000
+)
+
+var bindingsCache = newLRUCache("bindings", maxCachedBindings, bindingsCacheTTL)
+
+// bindingsCacheKey returns the key of the bindings of the call context:
+// besides the settings, the permissions depend on the acting user's roles
+// and on whether they greet the channel's members.
+func bindingsCacheKey(store *Store, cc apps.Context) string {
+	return fmt.Sprintf("%s|%s|%t|%t|%t|%t|%t", cc.MattermostSiteURL, callLocale(cc), cc.ActingUser != nil,
+		isSystemAdmin(cc), isTeamAdmin(cc), isChannelAdmin(cc), isGreeter(store, cc))
+}
+
+// BindingsCall returns the bindings the acting user can use: the channel
+// header button and the post menu item for the channel's managers, the
+// dashboard for anyone who manages welcomes, and the subcommands they have
+// the permissions for. The bindings are cached by role and locale.
+func BindingsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	key := bindingsCacheKey(NewStore(req.Context(), c.Context), c.Context)
+	if bindings, ok := bindingsCache.Get(key); ok {
+		httputils.WriteJSON(w, apps.NewDataResponse(bindings))
+		return
+	}
+
+	p := permissionsOf(req.Context(), c.Context)
+
+	bindings := []apps.Binding{}
+	header := ChannelHeaderBinding
+	header.Bindings = nil
+	for _, b := range ChannelHeaderBinding.Bindings {
+		if (b.Location == DashboardBinding.Location && p.any()) || p.channel {
+			header.Bindings = append(header.Bindings, b)
+		}
+	}
+	if len(header.Bindings) > 0 {
+		bindings = append(bindings, header)
+	}
+	if p.channel {
+		bindings = append(bindings, PostMenuBinding)
+	}
+	bindings = append(bindings, commandBinding(p))
+
+	bindingsCache.Put(key, bindings)
+	httputils.WriteJSON(w, apps.NewDataResponse(bindings))
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// broadcastInterval spaces out the direct messages of a broadcast, so sending
+// the welcome to a large channel doesn't flood the server's API.
+const broadcastInterval = 500 * time.Millisecond
+
+// broadcastPageSize is the number of channel members listed at a time.
+const broadcastPageSize = 200
+
+// maxDryRunRecipients caps the number of members listed by a dry run.
+const maxDryRunRecipients = 50
+
+// BroadcastForm sends the channel's welcome to all its current members, as
+// direct messages, after a confirmation.
+var BroadcastForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		channelField,
+		dryRunField,
+	},
+	Submit: apps.NewCall("/broadcast").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+	}),
+}
+
+// ConfirmBroadcast is submitted by the confirmation modal of a broadcast.
+var ConfirmBroadcast = apps.NewCall("/broadcast/confirm").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+})
+
+// broadcastState is the state of the confirmation modal: the channel whose
+// welcome is broadcast.
+type broadcastState struct {
+	ChannelID string `json:"channel_id"`
+}
+
+// BroadcastCall asks the system admin to confirm sending the channel's welcome
+// to its members.
+func BroadcastCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	welcome, err := NewStore(req.Context(), cc).GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the welcome message"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("~%s has no welcome message, set one with `set_channel_welcome` first", cc.Channel.Name))
+		return
+	}
+
+	if c.BoolValue("dry_run") {
+		httputils.WriteJSON(w, dryRunBroadcast(req.Context(), cc))
+		return
+	}
+
+	stats, _, err := asBot(req.Context(), cc).GetChannelStats(cc.Channel.Id, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewFormResponse(apps.Form{
+		Title:         "Broadcast the welcome message",
+		Header:        fmt.Sprintf("Send the welcome message of ~%s to its %d members, as direct messages? Members who were already welcomed will get it again.", cc.Channel.Name, stats.MemberCount),
+		Icon:          "icon.png",
+		Submit:        ConfirmBroadcast.WithState(broadcastState{ChannelID: cc.Channel.Id}),
+		SubmitButtons: "confirm",
+		Fields: []apps.Field{
+			{
+				Type: apps.FieldTypeStaticSelect,
+				Name: "confirm",
+				SelectStaticOptions: []apps.SelectOption{
+					{Label: "Send", Value: "send"},
+				},
+			},
+		},
+	}))
+}
+
+// ConfirmBroadcastCall queues a broadcast job for each member of the channel,
+// broadcastInterval apart.
+func ConfirmBroadcastCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	state := broadcastState{}
+	data, _ := json.Marshal(c.State)
+	if err := json.Unmarshal(data, &state); err != nil || state.ChannelID == "" {
+		httputils.WriteJSON(w,
+			errorResponse("invalid confirmation"))
+		return
+	}
+
+	userIDs, err := channelMemberIDs(asBot(req.Context(), c.Context), state.ChannelID, c.Context.BotUserID)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't list the members of the channel: %s", err))
+		return
+	}
+	runAt := model.GetMillis()
+	jobs := []Job{}
+	for _, userID := range userIDs {
+		jobs = append(jobs, Job{
+			ID:        model.NewId(),
+			Kind:      JobKindBroadcast,
+			RunAt:     runAt,
+			ChannelID: state.ChannelID,
+			UserID:    userID,
+		})
+		runAt += broadcastInterval.Milliseconds()
+	}
+
+	if err := scheduler.Enqueue(req.Context(), c.Context, jobs); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't queue the broadcast: %s", err))
+		return
+	}
+
+	duration := time.Duration(len(jobs)) * broadcastInterval
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Sending the welcome message to %d members, it will take about %s.", len(jobs), duration.Round(time.Second)))
+}
+
+// channelMemberIDs lists the members of the channel, but the bot.
+func channelMemberIDs(client *appclient.Client, channelID, botUserID string) ([]string, error) {
+	ids := []string{}
+	for page := 0; ; page++ {
+		members, _, err := client.GetChannelMembers(channelID, page, broadcastPageSize, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.UserId != botUserID {
+				ids = append(ids, member.UserId)
+			}
+		}
+		if len(members) < broadcastPageSize {
+			return ids, nil
+		}
+	}
+}
+
+// dryRunBroadcast reports the members the broadcast would send the welcome
+// to, skipping the bots and the excluded users as the broadcast jobs do.
+func dryRunBroadcast(ctx context.Context, cc apps.Context) apps.CallResponse {
+	client := asBot(ctx, cc)
+	ids, err := channelMemberIDs(client, cc.Channel.Id, cc.BotUserID)
+	if err != nil {
+		logger.Error(err)
+		return errorResponse("we couldn't list the members of the channel: %s", err)
+	}
+
+	store := NewStore(ctx, cc)
+	recipients := []string{}
+	for start := 0; start < len(ids); start += broadcastPageSize {
+		end := start + broadcastPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		users, _, err := client.GetUsersByIds(ids[start:end])
+		if err != nil {
+			logger.Error(err)
+			return errorResponse("we couldn't list the members of the channel: %s", err)
+		}
+		for _, user := range users {
+			if !user.IsBot && !skipWelcome(store, user) {
+				recipients = append(recipients, "@"+user.Username)
+			}
+		}
+	}
+
+	listed := recipients
+	if len(listed) > maxDryRunRecipients {
+		listed = listed[:maxDryRunRecipients]
+	}
+	message := fmt.Sprintf("Dry run, nothing was sent. The welcome message of ~%s would be sent to %d members, as direct messages, over about %s", cc.Channel.Name, len(recipients), (time.Duration(len(ids)) * broadcastInterval).Round(time.Second))
+	if len(listed) > 0 {
+		message += ": " + strings.Join(listed, ", ")
+	}
+	if more := len(recipients) - len(listed); more > 0 {
+		message += fmt.Sprintf(" and %d more", more)
+	}
+	return apps.NewTextResponse("%s.", message)
+}
+
+// broadcastJob sends the channel's welcome to the member of a JobKindBroadcast
+// job in a direct message, as it is when the job runs. The welcome is sent
+// without its acknowledgment button and reaction, which are tied to the
+// channel, and bots and excluded users are skipped.
+func broadcastJob(ctx context.Context, cc apps.Context, client *appclient.Client, store *Store, job Job) error {
+	welcome, err := store.GetChannelWelcome(job.ChannelID)
+	if err != nil || welcome == nil || welcome.Disabled {
+		return err
+	}
+	user, _, err := client.GetUser(job.UserID, "")
+	if err != nil {
+		return err
+	}
+	if user.IsBot || skipWelcome(store, user) {
+		return nil
+	}
+	channel, _, err := client.GetChannel(job.ChannelID, "")
+	if err != nil {
+		return err
+	}
+	team, _, err := client.GetTeam(channel.TeamId, "")
+	if err != nil {
+		logger.Error(err)
+	}
+
+	broadcast := rotateWelcome(*welcome)
+	broadcast.Acknowledgment = ""
+	broadcast.ReactToFirstPost = false
+	broadcast.MentionMember = false
+	jobs := welcomeJobs(client, channel.Id, user, broadcast, store.NewTemplateData(user, channel, team))
+	if err = deliverJobs(client, cc.BotUserID, user.Id, DeliveryDM, jobs); err != nil {
+		return err
+	}
+	return scheduler.Enqueue(ctx, cc, jobs)
+}
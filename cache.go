@@ -0,0 +1,156 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lruCache keeps up to size values in memory for ttl, evicting the least
+// recently used first. Its hits and misses are counted in the metrics under
+// its name.
+type lruCache struct {
+	name string
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+func newLRUCache(name string, size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		name:    name,
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get returns the value cached under the key, unless it expired.
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.Value.(*cacheEntry).expiresAt) {
+		c.remove(e)
+		ok = false
+	}
+	if !ok {
+		cacheLookups.WithLabelValues(c.name, "miss").Inc()
+		return nil, false
+	}
+	cacheLookups.WithLabelValues(c.name, "hit").Inc()
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
+}
+
+// Put caches the value under the key.
+func (c *lruCache) Put(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, value: value, expiresAt: time.Now().Add(c.ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Remove drops the value cached under the key.
+func (c *lruCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Clear drops all the values.
+func (c *lruCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+func (c *lruCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+// maxCachedWelcomes is how many welcome configurations are cached.
+const maxCachedWelcomes = 10000
+
+// welcomeCache caches the welcome configurations of the channels and teams,
+// as most join events are in channels and teams whose welcome didn't change,
+// or that have none. It is nil if caching is disabled.
+var welcomeCache *lruCache
+
+// cachedKV caches the welcome configurations of a Mattermost server in
+// welcomeCache, JSON-encoded so each get decodes a copy of its own. Setting or
+// deleting a welcome drops it from the cache, before and after, so a get
+// racing with it doesn't cache the previous welcome. The other replicas of
+// the app keep theirs until it expires, so it is opt in with a storage
+// backend.
+type cachedKV struct {
+	KVStore
+	site string
+}
+
+// cached reports whether the key is a welcome configuration's.
+func (kv cachedKV) cached(prefix, key string) bool {
+	return prefix == KVAppPrefix && (strings.HasPrefix(key, channelWelcomeKey("")) || strings.HasPrefix(key, teamWelcomeKey("")))
+}
+
+func (kv cachedKV) KVGet(prefix, key string, ref interface{}) error {
+	if !kv.cached(prefix, key) {
+		return kv.KVStore.KVGet(prefix, key, ref)
+	}
+	cacheKey := kv.site + "|" + key
+	if data, ok := welcomeCache.Get(cacheKey); ok {
+		return json.Unmarshal(data.(json.RawMessage), ref)
+	}
+
+	var data json.RawMessage
+	if err := kv.KVStore.KVGet(prefix, key, &data); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	welcomeCache.Put(cacheKey, data)
+	return json.Unmarshal(data, ref)
+}
+
+func (kv cachedKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	if kv.cached(prefix, key) {
+		welcomeCache.Remove(kv.site + "|" + key)
+		defer welcomeCache.Remove(kv.site + "|" + key)
+	}
+	return kv.KVStore.KVSet(prefix, key, value)
+}
+
+func (kv cachedKV) KVDelete(prefix, key string) error {
+	if kv.cached(prefix, key) {
+		welcomeCache.Remove(kv.site + "|" + key)
+		defer welcomeCache.Remove(kv.site + "|" + key)
+	}
+	return kv.KVStore.KVDelete(prefix, key)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// decodeCall decodes the call request from the body. If the body is not a
+// single, valid call request, it writes an error response and returns false.
+// Unknown fields are accepted, as newer Mattermost servers may send more
+// context than this version of the app knows about.
+func decodeCall(w http.ResponseWriter, req *http.Request) (apps.CallRequest, bool) {
+	c := apps.CallRequest{}
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&c)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after the call request")
+	}
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, errorResponse("invalid call request: %s", err))
+		return c, false
+	}
+	return c, true
+}
+
+// requireValues returns an error naming the first of the fields that has no
+// value, e.g. when a command is run without a required argument.
+func requireValues(c apps.CallRequest, names ...string) error {
+	for _, name := range names {
+		if strings.TrimSpace(c.GetValue(name, "")) == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+	}
+	return nil
+}
+
+// errorResponse returns an error response with the message formatted like
+// fmt.Sprintf, shown by Mattermost as the failure of the call.
+func errorResponse(format string, args ...interface{}) apps.CallResponse {
+	return apps.NewErrorResponse(fmt.Errorf(format, args...))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const channelCreatedPromptLocation = "channel_created_prompt"
+
+const channelCreatedPrompt = "Want to greet the people who join ~%s? Set a welcome message and the bot will post it to every new member."
+
+// ChannelCreated is the call Mattermost makes when a channel is created in a
+// team the app is subscribed to.
+var ChannelCreated = apps.NewCall("/event/channel-created").WithExpand(apps.Expand{
+	Channel: apps.ExpandSummary,
+})
+
+// BotJoinedTeam is the call Mattermost makes when the app's bot is added to a
+// team, to subscribe to the channels created in it.
+var BotJoinedTeam = apps.NewCall("/event/bot-joined-team").WithExpand(apps.Expand{
+	Team: apps.ExpandSummary,
+})
+
+// SubscribeToBotJoinedTeam registers the app for bot_joined_team events,
+// across all teams.
+func SubscribeToBotJoinedTeam(client *appclient.Client) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject: apps.SubjectBotJoinedTeam,
+		Call:    *BotJoinedTeam,
+	})
+}
+
+// SubscribeToChannelCreated registers the app for channel_created events in
+// the given team.
+func SubscribeToChannelCreated(client *appclient.Client, teamID string) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject: apps.SubjectChannelCreated,
+		TeamID:  teamID,
+		Call:    *ChannelCreated,
+	})
+}
+
+// subscribeToBotTeams subscribes to the channels created in every team the
+// bot is a member of.
+func subscribeToBotTeams(client *appclient.Client, botUserID string) error {
+	teams, _, err := client.GetTeamsForUser(botUserID, "")
+	if err != nil {
+		return err
+	}
+	for _, team := range teams {
+		if err = SubscribeToChannelCreated(client, team.Id); err != nil {
+			logger.Error(err)
+		}
+	}
+	return nil
+}
+
+// BotJoinedTeamCall subscribes to the channels created in the team the bot
+// was added to.
+func BotJoinedTeamCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	team := c.Context.Team
+	if team == nil {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+	if err := SubscribeToChannelCreated(asBot(req.Context(), c.Context), team.Id); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
+
+// ChannelCreatedCall offers the creator of a new channel to set its welcome
+// message, in an ephemeral post in the channel with a button opening the
+// welcome editor. Creators who aren't allowed to manage the channel's
+// welcome are not prompted, nor is anyone if disabled in the settings. The
+// bot joins new public channels of the teams with a default channel welcome,
+// so their members get it.
+func ChannelCreatedCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	channel := c.Context.Channel
+	if channel == nil || channel.IsGroupOrDirect() || channel.CreatorId == "" || channel.CreatorId == c.Context.BotUserID {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if hasChannelDefault(store, channel) {
+		if err := enableChannelDefaultIn(asBot(req.Context(), c.Context), c.Context.BotUserID, channel); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+	settings, err := store.GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if !settings.ChannelCreatedPrompt {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	creator, _, err := client.GetUser(channel.CreatorId, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if creator.IsBot {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	// The permissions of the creator are checked as if they were the acting
+	// user.
+	cc := c.Context
+	cc.ActingUser = creator
+	if member, _, err := client.GetChannelMember(channel.Id, creator.Id, ""); err == nil {
+		cc.ChannelMember = member
+	}
+	if member, _, err := client.GetTeamMember(channel.TeamId, creator.Id, ""); err == nil {
+		cc.TeamMember = member
+	}
+	if CheckCanManageChannel(cc, settings.RequiredRole) != nil {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		Message:   fmt.Sprintf(channelCreatedPrompt, channel.Name),
+	}
+	post.AddProp(apps.PropAppBindings, []apps.Binding{
+		{
+			AppID:    AppID,
+			Location: channelCreatedPromptLocation,
+			Bindings: []apps.Binding{
+				{
+					Location: "set_welcome",
+					Label:    "Set a welcome message",
+					Submit:   ChannelWelcomeEditorSource,
+				},
+			},
+		},
+	})
+	if err = createEphemeralPost(client, creator.Id, post); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
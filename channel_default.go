@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The layers of the welcome new members of a channel get: the channel's own
+// welcome, the team's default channel welcome for the channels without one,
+// or the default followed by the channel's messages.
+const (
+	WelcomeLayerChannel     = "channel"
+	WelcomeLayerTeamDefault = "team_default"
+	WelcomeLayerAppended    = "appended"
+)
+
+// The inheritance modes of a channel welcome: it replaces the team's default
+// channel welcome, or its messages are posted after the default's.
+const (
+	InheritanceOverride = "override"
+	InheritanceAppend   = "append"
+)
+
+// teamPageSize is the number of channels of a team listed at a time.
+const teamPageSize = 200
+
+// SetChannelDefaultForm sets a message of the team's default channel welcome,
+// which new members of the team's public channels get when the channel has no
+// welcome of its own.
+var SetChannelDefaultForm = apps.Form{
+	Title:  "Default channel welcome",
+	Header: "New members of the team's public channels that have no welcome of their own will be greeted with this message.",
+	Icon:   "icon.png",
+	Fields: []apps.Field{
+		welcomeMessageField,
+		messageIndexField,
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "delay",
+			Label:       "delay",
+			ModalLabel:  "Delay",
+			Description: "How long to wait before posting the message, after the previous one, in seconds or as a duration like 10m",
+		},
+	},
+	Submit: apps.NewCall("/set_channel_default").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// DeleteChannelDefault deletes the team's default channel welcome.
+var DeleteChannelDefault = apps.NewCall("/delete_channel_default").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Team:                  apps.ExpandSummary,
+	TeamMember:            apps.ExpandAll,
+})
+
+// SetInheritanceForm sets whether the channel's welcome replaces the team's
+// default channel welcome or follows it.
+var SetInheritanceForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeStaticSelect,
+			Name:                 "mode",
+			ModalLabel:           "Inheritance",
+			Description:          "Whether the channel's welcome replaces the team's default channel welcome, or is posted after it",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+			SelectStaticOptions: []apps.SelectOption{
+				{Label: "Replace the team default", Value: InheritanceOverride},
+				{Label: "Append to the team default", Value: InheritanceAppend},
+			},
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_inheritance").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+func channelDefaultKey(teamID string) string {
+	return "channel_default_" + teamID
+}
+
+// GetChannelDefault returns the default channel welcome of the team, or nil
+// if none was set.
+func (s *Store) GetChannelDefault(teamID string) (*ChannelWelcome, error) {
+	var data *ChannelWelcome
+	if err := s.kv.KVGet(KVAppPrefix, channelDefaultKey(teamID), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SetChannelDefault stores the default channel welcome of the team.
+func (s *Store) SetChannelDefault(teamID string, welcome ChannelWelcome) error {
+	_, err := s.kv.KVSet(KVAppPrefix, channelDefaultKey(teamID), welcome)
+	return err
+}
+
+// DeleteChannelDefault removes the default channel welcome of the team.
+func (s *Store) DeleteChannelDefault(teamID string) error {
+	return s.kv.KVDelete(KVAppPrefix, channelDefaultKey(teamID))
+}
+
+// GetEffectiveChannelWelcome returns the welcome new members of the channel
+// get, and the layer it comes from: the channel's own welcome, the team's
+// default channel welcome if the channel has none, or both if the channel's
+// welcome is appended to the default. Only public channels inherit the
+// default. It returns nil if neither is set.
+func (s *Store) GetEffectiveChannelWelcome(channel *model.Channel) (*ChannelWelcome, string, error) {
+	own, err := s.GetChannelWelcome(channel.Id)
+	if err != nil {
+		return nil, "", err
+	}
+	if (own != nil && !own.AppendToDefault) || channel.Type != model.ChannelTypeOpen {
+		return own, WelcomeLayerChannel, nil
+	}
+
+	def, err := s.GetChannelDefault(channel.TeamId)
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case def == nil || len(def.Messages) == 0:
+		return own, WelcomeLayerChannel, nil
+	case own == nil:
+		return def, WelcomeLayerTeamDefault, nil
+	}
+
+	appended := *own
+	appended.Messages = append(append([]WelcomeMessage{}, def.Messages...), own.Messages...)
+	return &appended, WelcomeLayerAppended, nil
+}
+
+// teamPublicChannels lists the public channels of the team.
+func teamPublicChannels(client *appclient.Client, teamID string) ([]*model.Channel, error) {
+	channels := []*model.Channel{}
+	for page := 0; ; page++ {
+		list, _, err := client.GetPublicChannelsForTeam(teamID, page, teamPageSize, "")
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, list...)
+		if len(list) < teamPageSize {
+			return channels, nil
+		}
+	}
+}
+
+// enableChannelDefaultIn adds the bot to the public channel and subscribes to
+// its join events, so its new members get the team's default channel welcome.
+func enableChannelDefaultIn(client *appclient.Client, botUserID string, channel *model.Channel) error {
+	if _, _, err := client.AddChannelMember(channel.Id, botUserID); err != nil {
+		return err
+	}
+	return SubscribeToChannel(client, channel.Id)
+}
+
+// enableChannelDefault adds the bot to the team, subscribes to the team's new
+// channels and enables the team's default channel welcome in its public
+// channels. It returns the number of channels it couldn't be enabled in.
+func enableChannelDefault(ctx context.Context, cc apps.Context) (int, error) {
+	_, _, err := asActingUser(ctx, cc).AddTeamMember(cc.Team.Id, cc.BotUserID)
+	if err != nil {
+		return 0, err
+	}
+	if err = SubscribeToChannelCreated(asBot(ctx, cc), cc.Team.Id); err != nil {
+		return 0, err
+	}
+	entry := NewTeamIndexEntry(cc.Team, cc.ActingUser)
+	entry.Kind = IndexKindChannelDefault
+	if err = NewStore(ctx, cc).PutIndexEntry(entry); err != nil {
+		return 0, err
+	}
+	return subscribeChannelDefault(ctx, cc, cc.Team.Id)
+}
+
+// subscribeChannelDefault enables the team's default channel welcome in all
+// its public channels, and returns the number of channels it couldn't be
+// enabled in.
+func subscribeChannelDefault(ctx context.Context, cc apps.Context, teamID string) (int, error) {
+	client := asBot(ctx, cc)
+	channels, err := teamPublicChannels(client, teamID)
+	if err != nil {
+		return 0, err
+	}
+	failed := 0
+	for _, channel := range channels {
+		if err = enableChannelDefaultIn(client, cc.BotUserID, channel); err != nil {
+			logger.Error(err)
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+// disableChannelDefault stops the join events of the team's public channels
+// that have no welcome of their own.
+func disableChannelDefault(ctx context.Context, cc apps.Context, store *Store, teamID string) error {
+	client := asBot(ctx, cc)
+	channels, err := teamPublicChannels(client, teamID)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		welcome, err := store.GetChannelWelcome(channel.Id)
+		if err != nil || welcome != nil {
+			continue
+		}
+		if err = UnsubscribeFromChannel(client, channel.Id); err != nil {
+			logger.Error(err)
+		}
+	}
+	return nil
+}
+
+// hasChannelDefault reports whether the channel inherits a default channel
+// welcome from its team.
+func hasChannelDefault(store *Store, channel *model.Channel) bool {
+	if channel.Type != model.ChannelTypeOpen || channel.TeamId == "" {
+		return false
+	}
+	def, err := store.GetChannelDefault(channel.TeamId)
+	if err != nil {
+		logger.Error(err)
+	}
+	return def != nil
+}
+
+func SetChannelDefaultCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	team := c.Context.Team
+	if team == nil {
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgTeamNotFound, nil))))
+		return
+	}
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := requireValues(c, "message"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	message := c.GetValue("message", "")
+	if err := checkWelcomeLength(message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	index, err := strconv.Atoi(c.GetValue("index", "1"))
+	if err != nil {
+		httputils.WriteJSON(w,
+			errorResponse("the message number must be a number"))
+		return
+	}
+	delay, err := parseDelay(c.GetValue("delay", "0"))
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	def, err := store.GetChannelDefault(team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the default channel welcome"))
+		return
+	}
+	if def == nil {
+		def = &ChannelWelcome{}
+	}
+	if err = def.SetMessage(index, WelcomeMessage{Message: message, DelaySeconds: delay}); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err = store.SetChannelDefault(team.Id, *def); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the default channel welcome"))
+		return
+	}
+
+	failed, err := enableChannelDefault(req.Context(), c.Context)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("stored the default channel welcome, but couldn't enable it in the team's channels: %s", err))
+		return
+	}
+
+	response := fmt.Sprintf("Stored message %d of %d of the default channel welcome. New members of the team's public channels without a welcome of their own will get it:\n%s",
+		index, len(def.Messages), quoted(message))
+	if failed > 0 {
+		response += fmt.Sprintf("\n\nThe bot couldn't be added to %d channels, see the app's logs.", failed)
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", lintResponse(c.Context, response, LintWelcome(message))))
+}
+
+func DeleteChannelDefaultCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	team := c.Context.Team
+	if team == nil {
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgTeamNotFound, nil))))
+		return
+	}
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := store.DeleteChannelDefault(team.Id); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't delete the default channel welcome"))
+		return
+	}
+	if err := store.RemoveIndexEntry(IndexKindChannelDefault, team.Id); err != nil {
+		requestLogger(req).Error(err)
+	}
+	if err := disableChannelDefault(req.Context(), c.Context, store, team.Id); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Deleted the default channel welcome of the team. The channels with their own welcome keep it."))
+}
+
+func SetInheritanceCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	mode := c.GetValue("mode", "")
+	if mode != InheritanceOverride && mode != InheritanceAppend {
+		httputils.WriteJSON(w,
+			errorResponse("the inheritance must be %s or %s", InheritanceOverride, InheritanceAppend))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the inheritance"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.AppendToDefault = mode == InheritanceAppend
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the inheritance"))
+		return
+	}
+
+	if welcome.AppendToDefault {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("New members of ~%s will get the team's default channel welcome, followed by the channel's messages.", cc.Channel.Name))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of ~%s will only get the channel's welcome, instead of the team's default.", cc.Channel.Name))
+}
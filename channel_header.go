@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	editorActionSave   = "save"
+	editorActionDelete = "delete"
+)
+
+// ChannelHeaderBinding adds buttons to the channel header that open the
+// welcome message editor for the current channel, and the welcome dashboard.
+var ChannelHeaderBinding = apps.Binding{
+	Location: apps.LocationChannelHeader,
+	Bindings: []apps.Binding{
+		{
+			Location:    "welcome",
+			Icon:        "icon.png",
+			Label:       "Welcome message",
+			Description: "Edit the welcome message of this channel",
+			Form:        apps.NewFormRef(ChannelWelcomeEditorSource),
+		},
+		DashboardBinding,
+	},
+}
+
+var ChannelWelcomeEditorSource = apps.NewCall("/channel_welcome_editor/form").WithExpand(apps.Expand{
+	Channel: apps.ExpandSummary,
+})
+
+// ChannelWelcomeEditorSubmit expands everything needed to either save or
+// delete the channel's welcome message.
+var ChannelWelcomeEditorSubmit = apps.NewCall("/channel_welcome_editor").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Channel:               apps.ExpandSummary,
+	ChannelMember:         apps.ExpandAll,
+	TeamMember:            apps.ExpandAll,
+})
+
+// ChannelWelcomeEditorFormCall returns the channel welcome editor, pre-filled
+// with the current message, with a Save button, and a Delete button if a
+// message is already set.
+func ChannelWelcomeEditorFormCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	var welcome *ChannelWelcome
+	if c.Context.Channel != nil {
+		var err error
+		welcome, err = NewStore(req.Context(), c.Context).GetChannelWelcome(c.Context.Channel.Id)
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewFormResponse(channelWelcomeEditor(c.Context.Channel, welcome)))
+}
+
+// channelWelcomeEditor returns the welcome message editor, pre-filled with
+// the welcome. The editor is for the current channel if channel is nil, or
+// else for the given one, and refuses to overwrite the welcome if it changed
+// in the meantime.
+func channelWelcomeEditor(channel *model.Channel, welcome *ChannelWelcome) apps.Form {
+	welcomeMessage, _ := welcome.Message(1)
+
+	actions := []apps.SelectOption{
+		{Label: "Save", Value: editorActionSave},
+	}
+	if welcome != nil {
+		actions = append(actions, apps.SelectOption{Label: "Delete", Value: editorActionDelete})
+	}
+
+	form := welcomeEditor(SetChannelWelcomeForm, welcomeMessage)
+	form.Submit = ChannelWelcomeEditorSubmit
+	form.SubmitButtons = "action"
+	form.Fields = append(form.Fields, apps.Field{
+		Type:                apps.FieldTypeStaticSelect,
+		Name:                "action",
+		SelectStaticOptions: actions,
+	})
+	if channel != nil {
+		form.Submit = form.Submit.WithState(newEditorState(channel.Id, welcome))
+		for i := range form.Fields {
+			if form.Fields[i].Name == channelField.Name {
+				form.Fields[i].Value = apps.SelectOption{Label: channel.DisplayName, Value: channel.Id}
+			}
+		}
+	}
+	return form
+}
+
+// ChannelWelcomeEditorCall saves the channel's welcome message, or asks to
+// confirm its deletion, depending on the button that was clicked.
+func ChannelWelcomeEditorCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.GetValue("action", editorActionSave) == editorActionDelete {
+		httputils.WriteJSON(w, confirmDeleteChannelWelcome(req.Context(), c))
+		return
+	}
+
+	httputils.WriteJSON(w, setChannelWelcome(req.Context(), c))
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The checks of the checklist items that are verified when clicked, rather
+// than trusted: the member has a profile picture, or a full name.
+const (
+	ChecklistCheckAvatar  = "avatar"
+	ChecklistCheckProfile = "profile"
+)
+
+// ChecklistItem is a task of the onboarding checklist of a team. Clicking an
+// item with a ChannelID joins the channel, and one with a Check is only
+// marked done if the check passes.
+type ChecklistItem struct {
+	Label     string `json:"label"`
+	Check     string `json:"check,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+}
+
+// ChecklistProgress is the progress of a member through the checklist of a
+// team: the indexes of the items they completed. The times are in
+// milliseconds.
+type ChecklistProgress struct {
+	Done        []int `json:"done"`
+	StartedAt   int64 `json:"started_at"`
+	CompletedAt int64 `json:"completed_at,omitempty"`
+}
+
+// IsDone reports whether the item at index is completed.
+func (p ChecklistProgress) IsDone(index int) bool {
+	for _, i := range p.Done {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// SetChecklistForm sets the checklist posted to new members of the team.
+var SetChecklistForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			Name:                 "items",
+			ModalLabel:           "Items",
+			Description:          "The tasks, one per line or separated by semicolons, e.g. \"Set your avatar: avatar; Fill in your profile: profile; Join the announcements: ~announcements; Read the guidelines\". Leave empty to remove the checklist.",
+			AutocompletePosition: 1,
+		},
+	},
+	Submit: apps.NewCall("/set_checklist").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// ChecklistReport shows the checklist progress of the members of the team.
+var ChecklistReport = apps.NewCall("/checklist_report").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+	Team:       apps.ExpandSummary,
+	TeamMember: apps.ExpandAll,
+})
+
+// CompleteChecklistItem is the call made by the buttons of the checklist
+// post. Its state is a checklistItemState.
+var CompleteChecklistItem = apps.NewCall("/checklist/done").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Post:                  apps.ExpandAll,
+})
+
+// checklistItemState is the state of a button of the checklist post. The
+// label is checked against the team's current checklist, in case it changed
+// since the post was made.
+type checklistItemState struct {
+	TeamID string `json:"team_id"`
+	UserID string `json:"user_id"`
+	Index  int    `json:"index"`
+	Label  string `json:"label"`
+}
+
+func checklistKey(teamID string) string {
+	return "checklist_" + teamID
+}
+
+// GetChecklistProgress returns the checklist progress of the members of the
+// team, by user ID.
+func (s *Store) GetChecklistProgress(teamID string) (map[string]ChecklistProgress, error) {
+	progress := map[string]ChecklistProgress{}
+	if err := s.kv.KVGet(KVAppPrefix, checklistKey(teamID), &progress); err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = map[string]ChecklistProgress{}
+	}
+	return progress, nil
+}
+
+// StartChecklist records that the checklist was posted to the user.
+func (s *Store) StartChecklist(teamID, userID string) error {
+	progress, err := s.GetChecklistProgress(teamID)
+	if err != nil {
+		return err
+	}
+	progress[userID] = ChecklistProgress{Done: []int{}, StartedAt: model.GetMillis()}
+	_, err = s.kv.KVSet(KVAppPrefix, checklistKey(teamID), progress)
+	return err
+}
+
+// CompleteChecklistItem marks the item at index done for the user, and
+// returns their progress. count is the number of items of the checklist.
+func (s *Store) CompleteChecklistItem(teamID, userID string, index, count int) (ChecklistProgress, error) {
+	progress, err := s.GetChecklistProgress(teamID)
+	if err != nil {
+		return ChecklistProgress{}, err
+	}
+
+	p, ok := progress[userID]
+	if !ok {
+		p = ChecklistProgress{StartedAt: model.GetMillis()}
+	}
+	if !p.IsDone(index) {
+		p.Done = append(p.Done, index)
+	}
+	if len(p.Done) >= count && p.CompletedAt == 0 {
+		p.CompletedAt = model.GetMillis()
+	}
+	progress[userID] = p
+
+	_, err = s.kv.KVSet(KVAppPrefix, checklistKey(teamID), progress)
+	return p, err
+}
+
+// DeleteChecklistProgress removes the checklist progress of the team.
+func (s *Store) DeleteChecklistProgress(teamID string) error {
+	return s.kv.KVDelete(KVAppPrefix, checklistKey(teamID))
+}
+
+// checklistPost renders the checklist with the items done checked, and the
+// buttons of the items left.
+func checklistPost(teamID, userID string, items []ChecklistItem, progress ChecklistProgress) (string, model.StringInterface) {
+	lines := []string{"#### Getting started"}
+	buttons := []apps.Binding{}
+	for i, item := range items {
+		if progress.IsDone(i) {
+			lines = append(lines, "- [x] "+item.Label)
+			continue
+		}
+		lines = append(lines, "- [ ] "+item.Label)
+		buttons = append(buttons, apps.Binding{
+			Location: apps.Location("item" + strconv.Itoa(i)),
+			Label:    item.Label,
+			Submit: CompleteChecklistItem.WithState(checklistItemState{
+				TeamID: teamID,
+				UserID: userID,
+				Index:  i,
+				Label:  item.Label,
+			}),
+		})
+	}
+	if len(buttons) == 0 {
+		lines = append(lines, "", ":tada: All done, welcome aboard!")
+		return strings.Join(lines, "\n"), model.StringInterface{}
+	}
+
+	return strings.Join(lines, "\n"), model.StringInterface{
+		apps.PropAppBindings: []apps.Binding{
+			{
+				AppID:       AppID,
+				Location:    "checklist",
+				Label:       "Mark as done",
+				Description: "Click a task once you've done it.",
+				Bindings:    buttons,
+			},
+		},
+	}
+}
+
+// checklistJob returns the job posting the checklist to the new member, in
+// the direct channel with the bot.
+func checklistJob(channelID, teamID, userID string, items []ChecklistItem, runAt int64) Job {
+	message, props := checklistPost(teamID, userID, items, ChecklistProgress{})
+	return Job{
+		ID:        model.NewId(),
+		RunAt:     runAt,
+		ChannelID: channelID,
+		Message:   message,
+		Props:     props,
+	}
+}
+
+// parseChecklistItems parses the items given as labels, optionally followed by
+// what completes them: "avatar", "profile", or a ~channel to join.
+func parseChecklistItems(client *appclient.Client, teamID, value string) ([]ChecklistItem, error) {
+	items := []ChecklistItem{}
+	for _, line := range strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == ';' }) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		item := ChecklistItem{Label: line}
+		if label, check, found := strings.Cut(line, ":"); found {
+			item.Label = strings.TrimSpace(label)
+			check = strings.TrimSpace(check)
+			switch {
+			case check == ChecklistCheckAvatar || check == ChecklistCheckProfile:
+				item.Check = check
+			case strings.HasPrefix(check, "~"):
+				channel, _, err := client.GetChannelByName(strings.TrimPrefix(check, "~"), teamID, "")
+				if err != nil {
+					return nil, fmt.Errorf("we couldn't find the channel %s", check)
+				}
+				item.ChannelID = channel.Id
+			default:
+				return nil, fmt.Errorf("the item %q must end with avatar, profile or a ~channel after the colon", line)
+			}
+		}
+		if item.Label == "" {
+			return nil, fmt.Errorf("the item %q has no label", line)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// checkChecklistItem verifies the item is done, or does it for the user when
+// it is joining a channel.
+func checkChecklistItem(ctx context.Context, cc apps.Context, item ChecklistItem) error {
+	client := asActingUser(ctx, cc)
+	userID := cc.ActingUser.Id
+
+	switch {
+	case item.ChannelID != "":
+		if _, _, err := client.AddChannelMember(item.ChannelID, userID); err != nil {
+			logger.Error(err)
+			return fmt.Errorf("we couldn't add you to the channel")
+		}
+	case item.Check != "":
+		user, _, err := client.GetUser(userID, "")
+		if err != nil {
+			logger.Error(err)
+			return fmt.Errorf("we couldn't check your profile")
+		}
+		if item.Check == ChecklistCheckAvatar && user.LastPictureUpdate == 0 {
+			return fmt.Errorf("you haven't set a profile picture yet, set one in your profile first")
+		}
+		if item.Check == ChecklistCheckProfile && (user.FirstName == "" || user.LastName == "") {
+			return fmt.Errorf("your profile has no full name yet, fill it in first")
+		}
+	}
+	return nil
+}
+
+// CompleteChecklistItemCall marks the clicked item done, after checking it,
+// and updates the checklist post.
+func CompleteChecklistItemCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	state := checklistItemState{}
+	data, _ := json.Marshal(c.State)
+	if err := json.Unmarshal(data, &state); err != nil || state.TeamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find this task"))
+		return
+	}
+	if c.Context.ActingUser.Id != state.UserID {
+		httputils.WriteJSON(w,
+			errorResponse("this checklist is someone else's"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	welcome, err := store.GetTeamWelcome(state.TeamID)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if welcome == nil || state.Index >= len(welcome.Checklist) || welcome.Checklist[state.Index].Label != state.Label {
+		httputils.WriteJSON(w,
+			errorResponse("the task %q is no longer part of the checklist", state.Label))
+		return
+	}
+
+	if err = checkChecklistItem(req.Context(), c.Context, welcome.Checklist[state.Index]); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	progress, err := store.CompleteChecklistItem(state.TeamID, state.UserID, state.Index, len(welcome.Checklist))
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't record the task as done"))
+		return
+	}
+
+	if c.Context.Post != nil {
+		message, props := checklistPost(state.TeamID, state.UserID, welcome.Checklist, progress)
+		_, _, err = asBot(req.Context(), c.Context).PatchPost(c.Context.Post.Id, &model.PostPatch{
+			Message: &message,
+			Props:   &props,
+		})
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
+
+func SetChecklistCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	items, err := parseChecklistItems(asActingUser(req.Context(), c.Context), c.Context.Team.Id, c.GetValue("items", ""))
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the checklist"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+
+	welcome.Checklist = items
+	if err = store.SetTeamWelcome(c.Context.Team.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the checklist"))
+		return
+	}
+
+	if len(items) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Removed the checklist of the team welcome"))
+		return
+	}
+	message, _ := checklistPost(c.Context.Team.Id, "", items, ChecklistProgress{})
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of the team will get this checklist after the welcome message:\n\n%s", message))
+}
+
+// ChecklistReportCall shows how many members completed the team's checklist,
+// and who is still working on it.
+func ChecklistReportCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if welcome == nil || len(welcome.Checklist) == 0 {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no checklist, set one with `set_checklist` first"))
+		return
+	}
+	progress, err := store.GetChecklistProgress(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the checklist progress"))
+		return
+	}
+	if len(progress) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("No member got the checklist of the team yet"))
+		return
+	}
+
+	userIDs := []string{}
+	for userID := range progress {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		return progress[userIDs[i]].StartedAt > progress[userIDs[j]].StartedAt
+	})
+	usernames := map[string]string{}
+	users, _, err := asBot(req.Context(), c.Context).GetUsersByIds(userIDs)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	for _, user := range users {
+		usernames[user.Id] = "@" + user.Username
+	}
+
+	// Each item counts the members who did it.
+	doneBy := make([]int, len(welcome.Checklist))
+	completed := 0
+	pending := []string{}
+	for _, userID := range userIDs {
+		p := progress[userID]
+		for _, i := range p.Done {
+			if i < len(doneBy) {
+				doneBy[i]++
+			}
+		}
+		if p.CompletedAt != 0 {
+			completed++
+			continue
+		}
+		name := usernames[userID]
+		if name == "" {
+			name = userID
+		}
+		pending = append(pending, fmt.Sprintf("* %s: %d of %d tasks, since %s", name, len(p.Done), len(welcome.Checklist), formatMillis(p.StartedAt)))
+	}
+
+	message := fmt.Sprintf("#### Checklist of %s\n\n%d of %d members completed it.\n\n", c.Context.Team.DisplayName, completed, len(progress))
+	for i, item := range welcome.Checklist {
+		message += fmt.Sprintf("* %s: %d done\n", item.Label, doneBy[i])
+	}
+	if len(pending) > 0 {
+		message += fmt.Sprintf("\n**In progress (%d)**\n%s\n", len(pending), strings.Join(pending, "\n"))
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// maxKVValueSize is the largest value, once JSON-encoded, stored under a
+// single key. It is kept well under the size the Mattermost KV store
+// accepts, as welcomes with many messages and attachments can get large.
+const maxKVValueSize = 64 * 1024
+
+// chunkManifest is stored in place of a value too large for a single key. The
+// value is gzipped and split into Chunks chunks, stored under their own keys.
+// Size and SHA256 are those of the compressed value, checked when reading it
+// back.
+type chunkManifest struct {
+	ID     string `json:"id"`
+	Chunks int    `json:"chunks"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// chunkedValue is what is stored under the key of a chunked value.
+type chunkedValue struct {
+	Manifest *chunkManifest `json:"__chunked"`
+}
+
+func chunkKey(key string, manifest chunkManifest, i int) string {
+	return key + "_chunk_" + manifest.ID + "_" + strconv.Itoa(i)
+}
+
+// chunkedKV is a KVStore splitting the values too large for a single key into
+// chunks, transparently. The chunks of a new value are written under new keys
+// before its manifest, and the chunks of the value it replaces are deleted
+// after, so readers never see a partially written value.
+type chunkedKV struct {
+	KVStore
+}
+
+// manifest returns the manifest stored under the key, or nil if the value
+// isn't chunked. raw is the value stored under the key.
+func (kv chunkedKV) manifest(raw json.RawMessage) *chunkManifest {
+	var value chunkedValue
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil
+	}
+	return value.Manifest
+}
+
+func (kv chunkedKV) KVGet(prefix, key string, ref interface{}) error {
+	var raw json.RawMessage
+	if err := kv.KVStore.KVGet(prefix, key, &raw); err != nil {
+		return err
+	}
+	manifest := kv.manifest(raw)
+	if manifest == nil {
+		if len(raw) == 0 {
+			raw = json.RawMessage("null")
+		}
+		return json.Unmarshal(raw, ref)
+	}
+
+	compressed := []byte{}
+	for i := 0; i < manifest.Chunks; i++ {
+		var chunk []byte
+		if err := kv.KVStore.KVGet(prefix, chunkKey(key, *manifest, i), &chunk); err != nil {
+			return err
+		}
+		compressed = append(compressed, chunk...)
+	}
+	sum := sha256.Sum256(compressed)
+	if len(compressed) != manifest.Size || hex.EncodeToString(sum[:]) != manifest.SHA256 {
+		return fmt.Errorf("the value of %s is corrupted: its chunks don't match its checksum", key)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, ref)
+}
+
+func (kv chunkedKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	var raw json.RawMessage
+	if err = kv.KVStore.KVGet(prefix, key, &raw); err != nil {
+		return false, err
+	}
+	previous := kv.manifest(raw)
+
+	var changed bool
+	if len(data) <= maxKVValueSize {
+		changed, err = kv.KVStore.KVSet(prefix, key, json.RawMessage(data))
+	} else {
+		changed, err = kv.setChunks(prefix, key, data)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if previous != nil {
+		kv.deleteChunks(prefix, key, *previous)
+	}
+	return changed, nil
+}
+
+// setChunks compresses the JSON-encoded value, stores its chunks and then
+// its manifest under the key.
+func (kv chunkedKV) setChunks(prefix, key string, data []byte) (bool, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return false, err
+	}
+	if err := writer.Close(); err != nil {
+		return false, err
+	}
+
+	sum := sha256.Sum256(compressed.Bytes())
+	manifest := chunkManifest{
+		ID:     model.NewId(),
+		Chunks: (compressed.Len() + maxKVValueSize - 1) / maxKVValueSize,
+		Size:   compressed.Len(),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+	// The chunks are base64-encoded, so they are a third larger than
+	// maxKVValueSize once stored.
+	for i := 0; i < manifest.Chunks; i++ {
+		chunk := compressed.Next(maxKVValueSize)
+		if _, err := kv.KVStore.KVSet(prefix, chunkKey(key, manifest, i), chunk); err != nil {
+			return false, err
+		}
+	}
+	return kv.KVStore.KVSet(prefix, key, chunkedValue{Manifest: &manifest})
+}
+
+// deleteChunks removes the chunks of a replaced or deleted value. A chunk
+// that can't be deleted is left behind, as the value itself was changed.
+func (kv chunkedKV) deleteChunks(prefix, key string, manifest chunkManifest) {
+	for i := 0; i < manifest.Chunks; i++ {
+		if err := kv.KVStore.KVDelete(prefix, chunkKey(key, manifest, i)); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+func (kv chunkedKV) KVDelete(prefix, key string) error {
+	var raw json.RawMessage
+	if err := kv.KVStore.KVGet(prefix, key, &raw); err != nil {
+		return err
+	}
+	if err := kv.KVStore.KVDelete(prefix, key); err != nil {
+		return err
+	}
+	if manifest := kv.manifest(raw); manifest != nil {
+		kv.deleteChunks(prefix, key, *manifest)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// randomText returns n characters that don't compress well, so the value is
+// split into several chunks.
+func randomText(t *testing.T, n int) string {
+	data := make([]byte, n/2)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(data)
+}
+
+func TestChunkedKVRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		chunks bool
+	}{
+		{name: "small", value: "hello"},
+		{name: "compressible", value: strings.Repeat("a", 4*maxKVValueSize), chunks: true},
+		{name: "large", value: randomText(t, 3*maxKVValueSize), chunks: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := NewMemoryKV()
+			kv := chunkedKV{memory}
+			if _, err := kv.KVSet(KVAppPrefix, "key", tt.value); err != nil {
+				t.Fatal(err)
+			}
+
+			var raw json.RawMessage
+			if err := memory.KVGet(KVAppPrefix, "key", &raw); err != nil {
+				t.Fatal(err)
+			}
+			if chunked := kv.manifest(raw) != nil; chunked != tt.chunks {
+				t.Errorf("chunked = %v, want %v", chunked, tt.chunks)
+			}
+
+			var got string
+			if err := kv.KVGet(KVAppPrefix, "key", &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.value {
+				t.Errorf("got %d characters back, want %d", len(got), len(tt.value))
+			}
+		})
+	}
+}
+
+func TestChunkedKVReplaceAndDelete(t *testing.T) {
+	memory := NewMemoryKV()
+	kv := chunkedKV{memory}
+	if _, err := kv.KVSet(KVAppPrefix, "key", randomText(t, 2*maxKVValueSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.KVSet(KVAppPrefix, "key", "small"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(memory.values); n != 1 {
+		t.Errorf("%d keys left after replacing the chunked value, want 1", n)
+	}
+
+	if _, err := kv.KVSet(KVAppPrefix, "key", randomText(t, 2*maxKVValueSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.KVDelete(KVAppPrefix, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(memory.values); n != 0 {
+		t.Errorf("%d keys left after deleting the chunked value, want 0", n)
+	}
+
+	var got *string
+	if err := kv.KVGet(KVAppPrefix, "key", &got); err != nil || got != nil {
+		t.Errorf("got %v, %v for a deleted key, want nil", got, err)
+	}
+}
+
+func TestChunkedKVCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(kv *MemoryKV, prefix, key string, manifest chunkManifest)
+	}{
+		{
+			name: "missing chunk",
+			corrupt: func(kv *MemoryKV, prefix, key string, manifest chunkManifest) {
+				_ = kv.KVDelete(prefix, chunkKey(key, manifest, manifest.Chunks-1))
+			},
+		},
+		{
+			name: "altered chunk",
+			corrupt: func(kv *MemoryKV, prefix, key string, manifest chunkManifest) {
+				var chunk []byte
+				_ = kv.KVGet(prefix, chunkKey(key, manifest, 0), &chunk)
+				chunk[len(chunk)/2] ^= 0xff
+				_, _ = kv.KVSet(prefix, chunkKey(key, manifest, 0), chunk)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := NewMemoryKV()
+			kv := chunkedKV{memory}
+			if _, err := kv.KVSet(KVAppPrefix, "key", randomText(t, 3*maxKVValueSize)); err != nil {
+				t.Fatal(err)
+			}
+			var value chunkedValue
+			if err := memory.KVGet(KVAppPrefix, "key", &value); err != nil || value.Manifest == nil {
+				t.Fatalf("the value wasn't chunked: %v", err)
+			}
+			tt.corrupt(memory, KVAppPrefix, "key", *value.Manifest)
+
+			var got string
+			err := kv.KVGet(KVAppPrefix, "key", &got)
+			if err == nil || !strings.Contains(err.Error(), "corrupted") {
+				t.Errorf("got error %v, want the value to be reported corrupted", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// cleanupInterval is how often the data of the channels that were archived
+// or deleted is cleaned up. Apps get no event when a channel is archived, so
+// the index is reconciled with the server periodically instead.
+const cleanupInterval = 24 * time.Hour
+
+// newCleanupJob returns the job of the next cleanup.
+func newCleanupJob() Job {
+	return Job{
+		ID:    model.NewId(),
+		Kind:  JobKindCleanup,
+		RunAt: model.GetMillis() + cleanupInterval.Milliseconds(),
+	}
+}
+
+// scheduleCleanup queues the next cleanup, replacing the one queued by a
+// previous installation, if any.
+func scheduleCleanup(ctx context.Context, cc apps.Context) error {
+	err := scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.Kind == JobKindCleanup
+	})
+	if err != nil {
+		return err
+	}
+	return scheduler.Enqueue(ctx, cc, []Job{newCleanupJob()})
+}
+
+// channelGone reports whether the channel was archived or deleted. Channels
+// the bot can't read for another reason are assumed to still exist.
+func channelGone(client *appclient.Client, channelID string) (bool, error) {
+	channel, resp, err := client.GetChannel(channelID, "")
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		return false, err
+	}
+	return channel.DeleteAt != 0, nil
+}
+
+// PurgeChannel removes everything stored for the channel: its welcome and
+// farewell, their index entries, statistics, acknowledgments and history.
+func (s *Store) PurgeChannel(channelID string) error {
+	keys := append(channelKeys(channelID), channelFarewellKey(channelID))
+	for _, key := range keys {
+		if err := s.kv.KVDelete(KVAppPrefix, key); err != nil {
+			return err
+		}
+	}
+	if err := s.RemoveIndexEntry(IndexKindChannel, channelID); err != nil {
+		return err
+	}
+	if err := s.RemoveIndexEntry(IndexKindChannelFarewell, channelID); err != nil {
+		return err
+	}
+	return s.DeleteStats(IndexKindChannel, channelID)
+}
+
+// purgeChannel removes the channel's data, its subscriptions and its queued
+// jobs.
+func purgeChannel(ctx context.Context, cc apps.Context, client *appclient.Client, store *Store, channelID string) error {
+	if err := store.PurgeChannel(channelID); err != nil {
+		return err
+	}
+	if err := UnsubscribeFromChannel(client, channelID); err != nil {
+		logger.Error(err)
+	}
+	if err := UnsubscribeFromChannelLeaves(client, channelID); err != nil {
+		logger.Error(err)
+	}
+	return scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.ChannelID == channelID || (job.WelcomeKind == IndexKindChannel && job.WelcomeID == channelID)
+	})
+}
+
+// cleanupJob purges the data of the indexed channels that were archived or
+// deleted, then queues the next cleanup.
+func cleanupJob(ctx context.Context, cc apps.Context, client *appclient.Client, store *Store) error {
+	defer func() {
+		if err := scheduler.Enqueue(ctx, cc, []Job{newCleanupJob()}); err != nil {
+			logger.Error(err)
+		}
+	}()
+
+	index, err := store.GetIndex()
+	if err != nil {
+		return err
+	}
+
+	checked := map[string]bool{}
+	purged := 0
+	for _, entry := range index {
+		if entry.Kind != IndexKindChannel && entry.Kind != IndexKindChannelFarewell {
+			continue
+		}
+		if checked[entry.ID] {
+			continue
+		}
+		checked[entry.ID] = true
+
+		gone, err := channelGone(client, entry.ID)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		if !gone {
+			continue
+		}
+		if err = purgeChannel(ctx, cc, client, store, entry.ID); err != nil {
+			logger.Error(err)
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		logger.Infof("cleaned up the data of %d archived or deleted channels", purged)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+)
+
+// mattermostTransport connects to Mattermost, at the end of the chain of
+// transports of mattermostHTTPClient.
+var mattermostTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// mattermostHTTPClient makes the requests to Mattermost, through the chain of
+// transports set up by setupAPIClient. The other requests of the app, e.g. to
+// the event webhook or the links checked, use their own clients.
+var mattermostHTTPClient = &http.Client{Transport: mattermostTransport}
+
+// setupAPIClient builds the chain of transports of the requests to
+// Mattermost: they are traced, then rate limited so the spans include the
+// wait, then measured. The rate limit is off if rate is 0, as is tracing
+// until the tracer is set.
+func setupAPIClient(rate float64, traced bool) {
+	var transport http.RoundTripper = apiTransport{next: mattermostTransport}
+	if rate > 0 {
+		transport = rateLimitedTransport{next: transport, bucket: newTokenBucket(rate)}
+	}
+	if traced {
+		transport = tracingTransport{next: transport}
+	}
+	mattermostHTTPClient = &http.Client{Transport: transport}
+}
+
+// asBot returns a client of Mattermost acting as the bot, which makes its
+// requests with mattermostHTTPClient, as part of the trace of ctx.
+func asBot(ctx context.Context, cc apps.Context) *appclient.Client {
+	return withAPITransport(ctx, appclient.AsBot(cc))
+}
+
+// asActingUser returns a client of Mattermost acting as the user of the call,
+// which makes its requests with mattermostHTTPClient, as part of the trace of
+// ctx.
+func asActingUser(ctx context.Context, cc apps.Context) *appclient.Client {
+	return withAPITransport(ctx, appclient.AsActingUser(cc))
+}
+
+func withAPITransport(ctx context.Context, client *appclient.Client) *appclient.Client {
+	httpClient := mattermostHTTPClient
+	if tracerProvider != nil {
+		httpClient = &http.Client{Transport: contextTransport{next: httpClient.Transport, ctx: ctx}}
+	}
+	client.Client4.HTTPClient = httpClient
+	client.ClientPP.HTTPClient = httpClient
+	return client
+}
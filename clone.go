@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// CloneForm copies the welcome of a channel to another channel. Both channels
+// are picked among the channels the acting user can manage.
+var CloneForm = apps.Form{
+	Title: "Copy a welcome message",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                apps.FieldTypeDynamicSelect,
+			Name:                "from",
+			Label:               "from",
+			ModalLabel:          "From",
+			Description:         "The channel to copy the welcome message from",
+			IsRequired:          true,
+			SelectDynamicLookup: LookupChannels,
+		},
+		{
+			Type:                apps.FieldTypeDynamicSelect,
+			Name:                "to",
+			Label:               "to",
+			ModalLabel:          "To",
+			Description:         "The channel to copy the welcome message to, the current one by default",
+			SelectDynamicLookup: LookupChannels,
+		},
+	},
+	Submit: apps.NewCall("/clone").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// CloneCall replaces the welcome of the target channel with a copy of the
+// source channel's welcome. The acting user must be able to manage both.
+// Recommended channels are only copied within a team, as they are offered to
+// the members of the target channel.
+func CloneCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := requireValues(c, "from"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	from, err := withChannelField(req.Context(), c, "from")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("we couldn't find the channel to copy from")))
+		return
+	}
+	to, err := withChannelField(req.Context(), c, "to")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("we couldn't find the channel to copy to")))
+		return
+	}
+	if from.Channel == nil || to.Channel == nil {
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgChannelNotFound, nil))))
+		return
+	}
+	if from.Channel.Id == to.Channel.Id {
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("the channels to copy from and to must be different")))
+		return
+	}
+	if to.Channel.IsGroupOrDirect() {
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("welcome messages can't be set for direct or group messages")))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err = checkCanManageChannel(store, from); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err = checkCanManageChannel(store, to); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(from.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't copy the welcome message"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("~"+from.Channel.Name+" has no welcome message")))
+		return
+	}
+	if from.Channel.TeamId != to.Channel.TeamId {
+		welcome.RecommendedChannels = nil
+	}
+	recommended := welcome.RecommendedChannels[:0]
+	for _, id := range welcome.RecommendedChannels {
+		if id != to.Channel.Id {
+			recommended = append(recommended, id)
+		}
+	}
+	welcome.RecommendedChannels = recommended
+
+	// The guide post of the source channel stays there: the target channel
+	// keeps its own, if any, or gets a new one once the bot is a member.
+	welcome.GuidePostID = ""
+	if existing, _ := store.GetChannelWelcome(to.Channel.Id); existing != nil {
+		welcome.GuidePostID = existing.GuidePostID
+		if !welcome.PinGuide {
+			if err = removeGuide(asBot(req.Context(), to), welcome); err != nil {
+				requestLogger(req).Error(err)
+			}
+		}
+	}
+
+	if err = store.SetChannelWelcome(to.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't copy the welcome message"))
+		return
+	}
+	if err = enableChannelWelcome(req.Context(), to); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgChannelSubscribeFailed, nil))))
+		return
+	}
+	if welcome.PinGuide {
+		if err = updateGuide(asBot(req.Context(), to), to.Channel, welcome); err != nil {
+			requestLogger(req).Error(err)
+		}
+		if err = store.SetChannelWelcome(to.Channel.Id, *welcome); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Copied the welcome message of ~%s to ~%s", from.Channel.Name, to.Channel.Name))
+}
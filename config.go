@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the app. It is read, by order
+// of precedence, from the command line flags, the environment variables and
+// the YAML file given with -config or CONFIG_FILE.
+type Config struct {
+	// Mode is how the app is deployed: as an HTTP service, as an AWS Lambda
+	// function, or as an OpenFaaS function. It is aws_lambda by default when
+	// running in Lambda.
+	Mode apps.DeployType `yaml:"mode"`
+
+	// RootURL is the URL Mattermost reaches the app at, e.g.
+	// http://welcomebot:8080. It is required in HTTP mode only, as Mattermost
+	// invokes the functions through AWS or the OpenFaaS gateway.
+	RootURL string `yaml:"root_url"`
+
+	// ServerAddress is the address the app listens on, e.g. :8080. A bare
+	// port number is accepted too.
+	ServerAddress string `yaml:"server_address"`
+
+	// DebugAddress is the address the pprof profiles are served on, e.g.
+	// localhost:6060; a bare port number is served on localhost. It must only
+	// be reachable by the operators, as the profiles reveal the app's
+	// internals. It is disabled if it is not set.
+	DebugAddress string `yaml:"debug_address"`
+
+	// The server's timeouts, so slow clients can't tie it up. The write
+	// timeout leaves room for the slowest calls, e.g. exports of large
+	// configurations.
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+
+	// The certificate and key files to serve HTTPS with, so the app can be
+	// exposed without a reverse proxy. The app serves plain HTTP if they are
+	// not set.
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+
+	// LogLevel is the lowest level logged: debug, info, warn or error. The
+	// entries are logfmt text, or JSON lines if LogFormat is json.
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"`
+
+	// RateLimit is how many requests per second each IP can make to the plain
+	// HTTP endpoints, e.g. the REST API and the webhook, and APIRateLimit how
+	// many requests per second the app makes to Mattermost at most. Either
+	// limit is disabled if it is 0.
+	RateLimit    float64 `yaml:"rate_limit"`
+	APIRateLimit float64 `yaml:"api_rate_limit"`
+
+	// Workers is how many join events are processed at once, and QueueSize
+	// how many can wait for a worker before the calls of Mattermost are held
+	// up. AWS Lambda functions process the events as they come.
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+
+	// WelcomeCacheTTL is how long the welcome configurations are cached in
+	// memory. A welcome changed through a replica of the app is seen by the
+	// others once their cache expires, so the cache is disabled by default
+	// with a storage backend, which the replicas share. Zero disables it.
+	WelcomeCacheTTL time.Duration `yaml:"welcome_cache_ttl"`
+
+	// Storage is where the app keeps its data: mattermost, the Mattermost KV
+	// store, or redis, postgres or mysql at StorageURL, e.g.
+	// redis://localhost:6379/0, for large deployments whose tracking data,
+	// e.g. acknowledgements and stats, outgrows the KV store. The data is not
+	// moved when the storage changes.
+	Storage    string `yaml:"storage"`
+	StorageURL string `yaml:"storage_url"`
+
+	// AppSecret authenticates the calls of Mattermost: the manifest asks for
+	// a JWT signed with it, and the calls without one are rejected. It must
+	// be entered as the app secret in the consent dialog of "/apps install".
+	// Mattermost only signs the calls of apps deployed over HTTP.
+	AppSecret string `yaml:"app_secret"`
+
+	// APIToken authenticates the requests to the admin REST API, e.g. from
+	// HR systems. The API is disabled if it is not set.
+	APIToken string `yaml:"api_token"`
+
+	// WebhookSecret signs the requests to the welcome webhook, e.g. from HR
+	// systems. The webhook is disabled if it is not set.
+	WebhookSecret string `yaml:"webhook_secret"`
+
+	// EventWebhookURL receives a JSON event each time a welcome is sent or
+	// acknowledged, signed with the webhook secret if it is set. No events
+	// are sent if it is not set.
+	EventWebhookURL string `yaml:"event_webhook_url"`
+
+	// SentryDSN is the DSN of the Sentry project the panics and the failed
+	// welcome deliveries are reported to, tagged with SentryEnvironment if it
+	// is set, e.g. "production". No errors are reported if it is not set.
+	SentryDSN         string `yaml:"sentry_dsn"`
+	SentryEnvironment string `yaml:"sentry_environment"`
+
+	// TracingEndpoint is the OTLP/HTTP endpoint of the OpenTelemetry
+	// collector the traces of the calls, KV store operations and requests to
+	// Mattermost are exported to, e.g. http://localhost:4318, with the
+	// TracingHeaders, e.g. "x-api-key=secret", as TracingServiceName. Only
+	// the TracingSampleRatio of the traces is recorded. No traces are
+	// recorded if it is not set.
+	TracingEndpoint    string  `yaml:"tracing_endpoint"`
+	TracingHeaders     string  `yaml:"tracing_headers"`
+	TracingServiceName string  `yaml:"tracing_service_name"`
+	TracingSampleRatio float64 `yaml:"tracing_sample_ratio"`
+
+	// PrintManifest makes the app print its manifest and exit, e.g. to
+	// package it in a bundle for appsctl.
+	PrintManifest bool `yaml:"-"`
+}
+
+// DefaultConfig is used for the settings that are not configured.
+var DefaultConfig = Config{
+	Mode:          apps.DeployHTTP,
+	ServerAddress: ":8080",
+	ReadTimeout:   10 * time.Second,
+	WriteTimeout:  60 * time.Second,
+	IdleTimeout:   120 * time.Second,
+	LogLevel:      "info",
+	LogFormat:     "text",
+	RateLimit:     10,
+	APIRateLimit:  20,
+	Workers:       8,
+	QueueSize:     1000,
+	Storage:       StorageMattermost,
+
+	WelcomeCacheTTL: time.Minute,
+
+	TracingServiceName: "welcomebot",
+	TracingSampleRatio: 1,
+}
+
+// LoadConfig reads the configuration from the file, the environment and the
+// command line arguments, and validates it.
+func LoadConfig(args []string) (Config, error) {
+	flags := flag.NewFlagSet("welcomebot", flag.ContinueOnError)
+	mode := flags.String("mode", "", "deployment mode, http, aws_lambda or open_faas (DEPLOY_MODE)")
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file")
+	rootURL := flags.String("root-url", "", "URL Mattermost reaches the app at (MANIFEST_ROOT_URL)")
+	address := flags.String("address", "", "address to listen on, e.g. :8080 (SERVER_PORT)")
+	debugAddress := flags.String("debug-address", "", "address to serve the pprof profiles on, e.g. localhost:6060 or a port on localhost (DEBUG_ADDRESS)")
+	printManifest := flags.Bool("manifest", false, "print the manifest and exit")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	config := DefaultConfig
+	welcomeCacheTTLSet := os.Getenv("WELCOME_CACHE_TTL") != ""
+	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+		config.Mode = apps.DeployAWSLambda
+	}
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return Config{}, err
+		}
+		if err = yaml.Unmarshal(data, &config); err != nil {
+			return Config{}, fmt.Errorf("invalid configuration file %s: %w", *configFile, err)
+		}
+		var set struct {
+			WelcomeCacheTTL *time.Duration `yaml:"welcome_cache_ttl"`
+		}
+		_ = yaml.Unmarshal(data, &set)
+		welcomeCacheTTLSet = welcomeCacheTTLSet || set.WelcomeCacheTTL != nil
+	}
+
+	var errs []string
+	setString := func(value string, field *string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	setMode := func(value string) {
+		if value != "" {
+			config.Mode = apps.DeployType(value)
+		}
+	}
+	setDuration := func(name string, field *time.Duration) {
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s must be a duration like 30s, got %q", name, value))
+			return
+		}
+		*field = d
+	}
+
+	setFloat := func(name string, field *float64) {
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s must be a number, got %q", name, value))
+			return
+		}
+		*field = f
+	}
+
+	setInt := func(name string, field *int) {
+		value := os.Getenv(name)
+		if value == "" {
+			return
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s must be a whole number, got %q", name, value))
+			return
+		}
+		*field = n
+	}
+
+	setMode(os.Getenv("DEPLOY_MODE"))
+	setString(os.Getenv("MANIFEST_ROOT_URL"), &config.RootURL)
+	setString(os.Getenv("SERVER_PORT"), &config.ServerAddress)
+	setString(os.Getenv("DEBUG_ADDRESS"), &config.DebugAddress)
+	setDuration("SERVER_READ_TIMEOUT", &config.ReadTimeout)
+	setDuration("SERVER_WRITE_TIMEOUT", &config.WriteTimeout)
+	setDuration("SERVER_IDLE_TIMEOUT", &config.IdleTimeout)
+	setString(os.Getenv("TLS_CERT_FILE"), &config.TLSCertFile)
+	setString(os.Getenv("TLS_KEY_FILE"), &config.TLSKeyFile)
+	setString(os.Getenv("LOG_LEVEL"), &config.LogLevel)
+	setString(os.Getenv("LOG_FORMAT"), &config.LogFormat)
+	setFloat("RATE_LIMIT", &config.RateLimit)
+	setFloat("API_RATE_LIMIT", &config.APIRateLimit)
+	setInt("WORKERS", &config.Workers)
+	setInt("QUEUE_SIZE", &config.QueueSize)
+	setDuration("WELCOME_CACHE_TTL", &config.WelcomeCacheTTL)
+	setString(os.Getenv("STORAGE"), &config.Storage)
+	setString(os.Getenv("STORAGE_URL"), &config.StorageURL)
+	setString(os.Getenv("APP_SECRET"), &config.AppSecret)
+	setString(os.Getenv("API_TOKEN"), &config.APIToken)
+	setString(os.Getenv("WEBHOOK_SECRET"), &config.WebhookSecret)
+	setString(os.Getenv("EVENT_WEBHOOK_URL"), &config.EventWebhookURL)
+	setString(os.Getenv("SENTRY_DSN"), &config.SentryDSN)
+	setString(os.Getenv("SENTRY_ENVIRONMENT"), &config.SentryEnvironment)
+	setString(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), &config.TracingEndpoint)
+	setString(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), &config.TracingHeaders)
+	setString(os.Getenv("OTEL_SERVICE_NAME"), &config.TracingServiceName)
+	setFloat("OTEL_TRACES_SAMPLER_ARG", &config.TracingSampleRatio)
+	setMode(*mode)
+	setString(*rootURL, &config.RootURL)
+	setString(*address, &config.ServerAddress)
+	setString(*debugAddress, &config.DebugAddress)
+	config.PrintManifest = *printManifest
+
+	if len(errs) > 0 {
+		return Config{}, errors.New(strings.Join(errs, "; "))
+	}
+	if _, err := strconv.Atoi(config.ServerAddress); err == nil {
+		config.ServerAddress = ":" + config.ServerAddress
+	}
+	// The replicas sharing a storage backend don't drop the welcomes the
+	// others cached, so the cache is opt in there.
+	if config.Storage != StorageMattermost && !welcomeCacheTTLSet {
+		config.WelcomeCacheTTL = 0
+	}
+	// Unlike the server, the profiles are only served locally by default.
+	if _, err := strconv.Atoi(config.DebugAddress); err == nil {
+		config.DebugAddress = "localhost:" + config.DebugAddress
+	}
+	return config, config.Validate()
+}
+
+// sameListenAddress reports whether listening on both addresses would serve
+// the same port of an interface, e.g. :8080 and localhost:8080.
+func sameListenAddress(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return portA == portB && (hostA == hostB || hostA == "" || hostB == "")
+}
+
+// Validate returns an error listing all the invalid settings.
+func (c Config) Validate() error {
+	var errs []string
+
+	switch c.Mode {
+	case apps.DeployHTTP:
+		errs = append(errs, c.validateRootURL()...)
+		errs = append(errs, c.validateServer()...)
+	case apps.DeployAWSLambda:
+		if c.RootURL != "" {
+			errs = append(errs, c.validateRootURL()...)
+		}
+	case apps.DeployOpenFAAS:
+		if c.RootURL != "" {
+			errs = append(errs, c.validateRootURL()...)
+		}
+		errs = append(errs, c.validateServer()...)
+	default:
+		errs = append(errs, fmt.Sprintf("the mode must be http, aws_lambda or open_faas, got %q", string(c.Mode)))
+	}
+
+	if _, err := ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Sprintf("the log format must be text or json, got %q", c.LogFormat))
+	}
+	if c.RateLimit < 0 || c.APIRateLimit < 0 {
+		errs = append(errs, "the rate limits can't be negative")
+	}
+	if c.WelcomeCacheTTL < 0 {
+		errs = append(errs, "the welcome cache TTL can't be negative, use 0 to disable the cache")
+	}
+	if c.Workers < 1 || c.QueueSize < 0 {
+		errs = append(errs, "there must be at least one worker, and the queue size can't be negative")
+	}
+	switch c.Storage {
+	case StorageMattermost:
+	case StorageRedis, StoragePostgres, StorageMySQL:
+		if c.StorageURL == "" {
+			errs = append(errs, fmt.Sprintf("the %s storage needs a storage URL, set STORAGE_URL", c.Storage))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("the storage must be mattermost, redis, postgres or mysql, got %q", c.Storage))
+	}
+	if c.DebugAddress != "" && c.Mode == apps.DeployAWSLambda {
+		errs = append(errs, "the debug address is not supported in aws_lambda mode, Lambda functions can't listen")
+	}
+	if c.DebugAddress != "" && sameListenAddress(c.DebugAddress, c.ServerAddress) {
+		errs = append(errs, "the debug address must differ from the server address, the profiles must not be exposed")
+	}
+	if c.AppSecret != "" && c.Mode != apps.DeployHTTP {
+		errs = append(errs, "the app secret is only supported in http mode, Mattermost doesn't sign the calls of other deployments")
+	}
+	if c.APIToken != "" && len(c.APIToken) < minAPITokenLength {
+		errs = append(errs, fmt.Sprintf("the API token must be at least %d characters", minAPITokenLength))
+	}
+	if c.WebhookSecret != "" && len(c.WebhookSecret) < minWebhookSecretLength {
+		errs = append(errs, fmt.Sprintf("the webhook secret must be at least %d characters", minWebhookSecretLength))
+	}
+	if c.EventWebhookURL != "" {
+		if u, err := url.Parse(c.EventWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("the event webhook URL must be an http or https URL, got %q", c.EventWebhookURL))
+		}
+	}
+	if c.TracingEndpoint != "" {
+		if u, err := url.Parse(c.TracingEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("the tracing endpoint must be an http or https URL, got %q", c.TracingEndpoint))
+		}
+		if _, err := parseTracingHeaders(c.TracingHeaders); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+			errs = append(errs, fmt.Sprintf("the tracing sample ratio must be between 0 and 1, got %v", c.TracingSampleRatio))
+		}
+	}
+	if c.SentryDSN != "" {
+		if _, _, err := parseSentryDSN(c.SentryDSN); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateRootURL checks the root URL is set and absolute.
+func (c Config) validateRootURL() []string {
+	var errs []string
+	if c.RootURL == "" {
+		errs = append(errs, "the root URL is required, set MANIFEST_ROOT_URL")
+	} else if u, err := url.Parse(c.RootURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("the root URL must be an http or https URL, got %q", c.RootURL))
+	}
+	return errs
+}
+
+// validateServer checks the settings of the HTTP server.
+func (c Config) validateServer() []string {
+	var errs []string
+
+	if _, port, err := net.SplitHostPort(c.ServerAddress); err != nil {
+		errs = append(errs, fmt.Sprintf("the server address must be like :8080, got %q", c.ServerAddress))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Sprintf("the server port must be between 1 and 65535, got %q", port))
+	}
+
+	for _, timeout := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"read timeout", c.ReadTimeout},
+		{"write timeout", c.WriteTimeout},
+		{"idle timeout", c.IdleTimeout},
+	} {
+		if timeout.d <= 0 {
+			errs = append(errs, fmt.Sprintf("the %s must be positive, got %s", timeout.name, timeout.d))
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, "both the TLS certificate and key files must be set to serve HTTPS")
+	}
+	for _, file := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Sprintf("the TLS file %s can't be read: %s", file, err))
+		}
+	}
+	return errs
+}
+
+// TLS reports whether the app serves HTTPS.
+func (c Config) TLS() bool {
+	return c.TLSCertFile != ""
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := DefaultConfig
+	valid.RootURL = "http://welcomebot:8080"
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		error  string
+	}{
+		{name: "valid", change: func(c *Config) {}},
+		{name: "no root URL", change: func(c *Config) { c.RootURL = "" }, error: "the root URL is required"},
+		{name: "relative root URL", change: func(c *Config) { c.RootURL = "welcomebot:8080" }, error: "the root URL must be an http or https URL"},
+		{name: "no port", change: func(c *Config) { c.ServerAddress = "" }, error: "the server address must be like :8080"},
+		{name: "port out of range", change: func(c *Config) { c.ServerAddress = ":70000" }, error: "the server port must be between 1 and 65535"},
+		{name: "no timeout", change: func(c *Config) { c.ReadTimeout = 0 }, error: "the read timeout must be positive"},
+		{name: "unknown mode", change: func(c *Config) { c.Mode = "kubernetes" }, error: "the mode must be http, aws_lambda or open_faas"},
+		{name: "lambda without root URL", change: func(c *Config) { c.Mode = apps.DeployAWSLambda; c.RootURL = "" }},
+		{name: "unknown log level", change: func(c *Config) { c.LogLevel = "verbose" }, error: "verbose"},
+		{name: "unknown log format", change: func(c *Config) { c.LogFormat = "xml" }, error: "the log format must be text or json"},
+		{name: "TLS key without certificate", change: func(c *Config) { c.TLSKeyFile = "key.pem" }, error: "both the TLS certificate and key files must be set"},
+		{name: "storage without URL", change: func(c *Config) { c.Storage = StorageRedis }, error: "the redis storage needs a storage URL"},
+		{name: "unknown storage", change: func(c *Config) { c.Storage = "etcd" }, error: "the storage must be mattermost, redis, postgres or mysql"},
+		{name: "debug on the server address", change: func(c *Config) { c.DebugAddress = "localhost:8080" }, error: "the debug address must differ"},
+		{name: "short API token", change: func(c *Config) { c.APIToken = "secret" }, error: "the API token must be at least"},
+		{name: "invalid event webhook", change: func(c *Config) { c.EventWebhookURL = "ftp://example.com" }, error: "the event webhook URL must be an http or https URL"},
+		{name: "sample ratio out of range", change: func(c *Config) { c.TracingEndpoint = "http://localhost:4318"; c.TracingSampleRatio = 2 }, error: "the tracing sample ratio must be between 0 and 1"},
+		{
+			name: "all errors",
+			change: func(c *Config) {
+				c.RootURL = ""
+				c.Workers = 0
+			},
+			error: "the root URL is required, set MANIFEST_ROOT_URL; there must be at least one worker",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.change(&c)
+			err := c.Validate()
+			if tt.error == "" {
+				if err != nil {
+					t.Errorf("err = %v, want valid", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("err = %v, want %q", err, tt.error)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "welcomebot.yaml")
+	data := "root_url: http://file:8080\nserver_address: \":9000\"\nlog_level: debug\nworkers: 2\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "")
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("MANIFEST_ROOT_URL", "http://env:8080")
+	t.Setenv("SERVER_PORT", "9001")
+	t.Setenv("SERVER_READ_TIMEOUT", "5s")
+	t.Setenv("DEBUG_ADDRESS", "6060")
+
+	config, err := LoadConfig([]string{"-root-url", "http://flag:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootURL != "http://flag:8080" {
+		t.Errorf("root URL = %q, want the flag's", config.RootURL)
+	}
+	if config.ServerAddress != ":9001" {
+		t.Errorf("server address = %q, want the port of the environment", config.ServerAddress)
+	}
+	if config.DebugAddress != "localhost:6060" {
+		t.Errorf("debug address = %q, want the port on localhost", config.DebugAddress)
+	}
+	if config.ReadTimeout != 5*time.Second || config.WriteTimeout != DefaultConfig.WriteTimeout {
+		t.Errorf("timeouts = %s and %s, want the environment's and the default", config.ReadTimeout, config.WriteTimeout)
+	}
+	if config.LogLevel != "debug" || config.Workers != 2 {
+		t.Errorf("log level %q and %d workers, want the file's", config.LogLevel, config.Workers)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		error string
+	}{
+		{name: "no root URL", error: "the root URL is required"},
+		{name: "invalid duration", env: map[string]string{"MANIFEST_ROOT_URL": "http://app:8080", "SERVER_READ_TIMEOUT": "5"}, error: "SERVER_READ_TIMEOUT must be a duration"},
+		{name: "invalid number", env: map[string]string{"MANIFEST_ROOT_URL": "http://app:8080", "WORKERS": "many"}, error: "WORKERS must be a whole number"},
+		{name: "missing file", env: map[string]string{"CONFIG_FILE": "/nonexistent/welcomebot.yaml"}, error: "welcomebot.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "")
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("MANIFEST_ROOT_URL", "")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := LoadConfig(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("err = %v, want %q", err, tt.error)
+			}
+		})
+	}
+}
+
+func TestLoadConfigWelcomeCache(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want time.Duration
+	}{
+		{name: "default", want: DefaultConfig.WelcomeCacheTTL},
+		{name: "storage backend", env: map[string]string{"STORAGE": StorageRedis, "STORAGE_URL": "redis://localhost:6379/0"}, want: 0},
+		{name: "storage backend, set", env: map[string]string{"STORAGE": StorageRedis, "STORAGE_URL": "redis://localhost:6379/0", "WELCOME_CACHE_TTL": "10s"}, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "")
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("MANIFEST_ROOT_URL", "http://app:8080")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, err := LoadConfig(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.WelcomeCacheTTL != tt.want {
+				t.Errorf("welcome cache TTL = %s, want %s", config.WelcomeCacheTTL, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// The actions of the dashboard, one button each.
+const (
+	dashboardActionEdit    = "edit"
+	dashboardActionPreview = "preview"
+	dashboardActionDelete  = "delete"
+)
+
+// DashboardBinding adds a button opening the welcome dashboard. Apps v1.1
+// have no app bar location: Mattermost shows the channel header buttons of
+// apps in the app bar when it is enabled.
+var DashboardBinding = apps.Binding{
+	Location:    "dashboard",
+	Icon:        "icon.png",
+	Label:       "Welcome dashboard",
+	Description: "See and manage all the welcome messages you can edit",
+	Form:        apps.NewFormRef(DashboardSource),
+}
+
+var DashboardSource = apps.NewCall("/dashboard/form").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Channel:               apps.ExpandSummary,
+	ChannelMember:         apps.ExpandAll,
+	TeamMember:            apps.ExpandAll,
+})
+
+var DashboardSubmit = apps.NewCall("/dashboard").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Channel:               apps.ExpandSummary,
+	ChannelMember:         apps.ExpandAll,
+	Team:                  apps.ExpandSummary,
+	TeamMember:            apps.ExpandAll,
+})
+
+// DashboardFormCall returns the dashboard: the channel welcomes the acting
+// user can manage, and buttons to edit, preview or delete the selected one.
+func DashboardFormCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	index, err := store.GetIndex()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't list the welcome messages"))
+		return
+	}
+
+	header := "| Channel | Messages | Status |\n| --- | --- | --- |\n"
+	options := []apps.SelectOption{}
+	for _, entry := range index {
+		if entry.Kind != IndexKindChannel || len(options) == maxLookupOptions {
+			continue
+		}
+		cc, err := withChannel(req.Context(), c.Context, entry.ID)
+		if err != nil || checkCanManageChannel(store, cc) != nil {
+			continue
+		}
+		welcome, err := store.GetChannelWelcome(entry.ID)
+		if err != nil || welcome == nil {
+			continue
+		}
+
+		status := "active"
+		if welcome.Disabled {
+			status = "paused"
+		}
+		header += fmt.Sprintf("| ~%s | %d | %s |\n", cc.Channel.Name, len(welcome.Messages), status)
+		options = append(options, apps.SelectOption{
+			Label: cc.Channel.DisplayName,
+			Value: cc.Channel.Id,
+		})
+	}
+	if len(options) == 0 {
+		httputils.WriteJSON(w,
+			errorResponse("there are no welcome messages you can manage"))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewFormResponse(apps.Form{
+		Title:  "Welcome dashboard",
+		Header: header,
+		Icon:   "icon.png",
+		Fields: []apps.Field{
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                "channel",
+				ModalLabel:          "Welcome",
+				SelectStaticOptions: options,
+				IsRequired:          true,
+			},
+			{
+				Type: apps.FieldTypeStaticSelect,
+				Name: "action",
+				SelectStaticOptions: []apps.SelectOption{
+					{Label: "Edit", Value: dashboardActionEdit},
+					{Label: "Preview", Value: dashboardActionPreview},
+					{Label: "Delete", Value: dashboardActionDelete},
+				},
+			},
+		},
+		Submit:        DashboardSubmit,
+		SubmitButtons: "action",
+	}))
+}
+
+// DashboardCall runs the action of the button clicked in the dashboard on the
+// selected welcome. The preview is posted in the current channel.
+func DashboardCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	action := c.GetValue("action", dashboardActionEdit)
+	if action == dashboardActionPreview {
+		httputils.WriteJSON(w, preview(req.Context(), c))
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil || cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the selected channel"))
+		return
+	}
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if action == dashboardActionDelete {
+		c.Context = cc
+		c.Values = nil
+		httputils.WriteJSON(w, confirmDeleteChannelWelcome(req.Context(), c))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	httputils.WriteJSON(w,
+		apps.NewFormResponse(channelWelcomeEditor(cc.Channel, welcome)))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const deadLettersKey = "dead_letters"
+
+// maxDeadLetters is how many failed deliveries are kept, the oldest are
+// dropped first.
+const maxDeadLetters = 500
+
+// DeadLetter is a welcome whose delivery failed, kept for system admins to
+// inspect and retry.
+type DeadLetter struct {
+	Job      Job    `json:"job"`
+	Error    string `json:"error"`
+	FailedAt int64  `json:"failed_at"`
+}
+
+// AdminDeadLettersForm lists the failed deliveries, and queues them again
+// with --retry or drops them with --clear.
+var AdminDeadLettersForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "retry",
+			Label:       "retry",
+			ModalLabel:  "Retry",
+			Description: "Queue the failed deliveries again",
+		},
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "clear",
+			Label:       "clear",
+			ModalLabel:  "Clear",
+			Description: "Drop the failed deliveries",
+		},
+	},
+	Submit: apps.NewCall("/admin/dead_letters").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// GetDeadLetters returns the failed deliveries, the oldest first.
+func (s *Store) GetDeadLetters() ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := s.kv.KVGet(KVAppPrefix, deadLettersKey, &letters)
+	return letters, err
+}
+
+// SetDeadLetters replaces the failed deliveries.
+func (s *Store) SetDeadLetters(letters []DeadLetter) error {
+	if len(letters) == 0 {
+		return s.kv.KVDelete(KVAppPrefix, deadLettersKey)
+	}
+	_, err := s.kv.KVSet(KVAppPrefix, deadLettersKey, letters)
+	return err
+}
+
+// AddDeadLetter records the failed delivery of the job.
+func (s *Store) AddDeadLetter(job Job, jobErr error) error {
+	unlock, err := s.Lock(deadLettersKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	letters, err := s.GetDeadLetters()
+	if err != nil {
+		return err
+	}
+	letters = append(letters, DeadLetter{Job: job, Error: jobErr.Error(), FailedAt: model.GetMillis()})
+	if len(letters) > maxDeadLetters {
+		letters = letters[len(letters)-maxDeadLetters:]
+	}
+	return s.SetDeadLetters(letters)
+}
+
+// RemoveDeadLetters removes the given failed deliveries, keeping the ones
+// recorded since they were read.
+func (s *Store) RemoveDeadLetters(removed []DeadLetter) error {
+	type letterKey struct {
+		jobID    string
+		failedAt int64
+	}
+	keys := map[letterKey]bool{}
+	for _, letter := range removed {
+		keys[letterKey{letter.Job.ID, letter.FailedAt}] = true
+	}
+
+	unlock, err := s.Lock(deadLettersKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	letters, err := s.GetDeadLetters()
+	if err != nil {
+		return err
+	}
+	kept := []DeadLetter{}
+	for _, letter := range letters {
+		if !keys[letterKey{letter.Job.ID, letter.FailedAt}] {
+			kept = append(kept, letter)
+		}
+	}
+	return s.SetDeadLetters(kept)
+}
+
+// forgetUserInDeadLetters removes the failed deliveries to the user. It
+// reports whether there were any.
+func (s *Store) forgetUserInDeadLetters(userID string) (bool, error) {
+	unlock, err := s.Lock(deadLettersKey)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	letters, err := s.GetDeadLetters()
+	if err != nil {
+		return false, err
+	}
+	kept := []DeadLetter{}
+	for _, letter := range letters {
+		if letter.Job.UserID != userID {
+			kept = append(kept, letter)
+		}
+	}
+	if len(kept) == len(letters) {
+		return false, nil
+	}
+	return true, s.SetDeadLetters(kept)
+}
+
+// deadLetterJob keeps the job if it delivered a welcome, so it isn't lost, and
+// reports the failure, the retries of the job having failed too.
+func deadLetterJob(store *Store, job Job, jobErr error) {
+	if job.WelcomeKind == "" {
+		return
+	}
+	deadLetters.Inc()
+	reportError(jobLogger(job), jobErr)
+	if err := store.AddDeadLetter(job, jobErr); err != nil {
+		logger.Error(err)
+	}
+}
+
+func AdminDeadLettersCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	letters, err := store.GetDeadLetters()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if len(letters) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("No welcome delivery failed."))
+		return
+	}
+
+	requeue, drop := c.BoolValue("retry"), c.BoolValue("clear")
+	if requeue {
+		jobs := []Job{}
+		for _, letter := range letters {
+			job := letter.Job
+			job.ID = model.NewId()
+			job.RunAt = model.GetMillis()
+			jobs = append(jobs, job)
+		}
+		if err = scheduler.Enqueue(req.Context(), c.Context, jobs); err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+	}
+	if requeue || drop {
+		if err = store.RemoveDeadLetters(letters); err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+	}
+	if requeue {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Queued the %d failed deliveries again.", len(letters)))
+		return
+	}
+	if drop {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Dropped the %d failed deliveries.", len(letters)))
+		return
+	}
+
+	message := fmt.Sprintf("%d welcome deliveries failed. Use `admin dead_letters --retry` to queue them again, or `--clear` to drop them.\n\n", len(letters))
+	message += "| Failed at | Welcome | Recipient | Error |\n|---|---|---|---|\n"
+	userIDs := []string{}
+	for _, letter := range letters {
+		if letter.Job.UserID != "" {
+			userIDs = append(userIDs, letter.Job.UserID)
+		}
+	}
+	usernames := map[string]string{}
+	if len(userIDs) > 0 {
+		users, _, err := asBot(req.Context(), c.Context).GetUsersByIds(userIDs)
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+		for _, user := range users {
+			usernames[user.Id] = user.Username
+		}
+	}
+	for _, letter := range letters {
+		recipient := "channel " + letter.Job.ChannelID
+		if letter.Job.UserID != "" {
+			recipient = letter.Job.UserID
+			if username, ok := usernames[letter.Job.UserID]; ok {
+				recipient = "@" + username
+			}
+		}
+		message += fmt.Sprintf("| %s | %s %s | %s | %s |\n",
+			time.UnixMilli(letter.FailedAt).UTC().Format(time.RFC3339),
+			indexKindNames[letter.Job.WelcomeKind], letter.Job.WelcomeID, recipient, strings.ReplaceAll(letter.Error, "|", "\\|"))
+	}
+	httputils.WriteJSON(w, apps.NewTextResponse("%s", message))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// DebugHandler serves the Go runtime profiles of net/http/pprof under
+// /debug/pprof/, e.g. to find the goroutines piling up in the scheduler or
+// the worker pool. It is served on its own address, which must not be exposed.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
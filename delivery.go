@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// The delivery modes of a channel welcome: posted in the channel, sent as a
+// direct message from the bot, posted in the channel as an ephemeral post
+// only the new member sees, or posted as a reply in the channel's welcome
+// thread.
+const (
+	DeliveryChannel   = ""
+	DeliveryDM        = "dm"
+	DeliveryEphemeral = "ephemeral"
+	DeliveryThread    = "thread"
+)
+
+// SetDeliveryForm sets how the channel's welcome is delivered to new members.
+var SetDeliveryForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeStaticSelect,
+			Name:                 "delivery",
+			Description:          "Where the welcome is sent to new members",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+			SelectStaticOptions: []apps.SelectOption{
+				{Label: "channel", Value: "channel"},
+				{Label: "dm", Value: DeliveryDM},
+				{Label: "ephemeral", Value: DeliveryEphemeral},
+				{Label: "thread", Value: DeliveryThread},
+			},
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_delivery").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// deliverJobs routes the post jobs of a channel welcome according to its
+// delivery mode: to the direct channel between the bot and the new member,
+// with the opt-out button under the first post, as ephemeral posts, or as
+// replies in the welcome thread. The other jobs, e.g. reactions, stay in the
+// channel.
+func deliverJobs(client *appclient.Client, botUserID, userID, delivery string, jobs []Job) error {
+	if delivery == DeliveryChannel {
+		return nil
+	}
+
+	dmID := ""
+	if delivery == DeliveryDM {
+		dm, _, err := client.CreateDirectChannel(botUserID, userID)
+		if err != nil {
+			return err
+		}
+		dmID = dm.Id
+	}
+
+	button := true
+	for i := range jobs {
+		if jobs[i].Kind != JobKindPost {
+			continue
+		}
+		switch delivery {
+		case DeliveryDM:
+			jobs[i].ChannelID = dmID
+			if button {
+				jobs[i] = withOptOutButton(jobs[i])
+				button = false
+			}
+		case DeliveryEphemeral:
+			jobs[i].Ephemeral = true
+			jobs[i].UserID = userID
+		case DeliveryThread:
+			jobs[i].Thread = true
+		}
+	}
+	return nil
+}
+
+func SetDeliveryCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = requireValues(c, "delivery"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	delivery := c.GetValue("delivery", "")
+	switch delivery {
+	case "channel":
+		delivery = DeliveryChannel
+	case DeliveryDM, DeliveryEphemeral, DeliveryThread:
+	default:
+		httputils.WriteJSON(w,
+			errorResponse("the delivery must be channel, dm, ephemeral or thread"))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the delivery"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.Delivery = delivery
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the delivery"))
+		return
+	}
+
+	var message string
+	switch delivery {
+	case DeliveryDM:
+		message = "The welcome of ~%s will be sent to new members as a direct message."
+	case DeliveryEphemeral:
+		message = "The welcome of ~%s will be posted in the channel for new members only."
+	case DeliveryThread:
+		message = "The welcome of ~%s will be posted as replies in a welcome thread of the channel."
+	default:
+		message = "The welcome of ~%s will be posted in the channel."
+	}
+	if welcome.DigestWindowSeconds > 0 {
+		message += " It is in digest mode though, and digests are always posted in the channel."
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse(message, cc.Channel.Name))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// SetDigestForm turns the digest mode of a channel on or off.
+var SetDigestForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "window",
+			Description:          "How long to collect new members before welcoming them together, e.g. 15m. Use 0 to welcome each member right away.",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_digest").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// digestMu serializes the updates of the pending digests, which are made both
+// by join events and by the scheduler, in this replica. The replicas also
+// take the digest's lock.
+var digestMu sync.Mutex
+
+func digestKey(channelID string) string {
+	return "digest_" + channelID
+}
+
+// Digest collects the members who joined a channel in digest mode, until they
+// are welcomed together.
+type Digest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// GetDigest returns the pending digest of the channel, or nil if there is none.
+func (s *Store) GetDigest(channelID string) (*Digest, error) {
+	var digest *Digest
+	if err := s.kv.KVGet(KVAppPrefix, digestKey(channelID), &digest); err != nil {
+		return nil, err
+	}
+	if digest == nil || len(digest.UserIDs) == 0 {
+		return nil, nil
+	}
+	return digest, nil
+}
+
+// AddToDigest adds the user to the pending digest of the channel. It reports
+// whether the digest was just started, in which case it has to be scheduled.
+func (s *Store) AddToDigest(channelID, userID string) (bool, error) {
+	digestMu.Lock()
+	defer digestMu.Unlock()
+	unlock, err := s.Lock(digestKey(channelID))
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	digest, err := s.GetDigest(channelID)
+	if err != nil {
+		return false, err
+	}
+	started := digest == nil
+	if started {
+		digest = &Digest{}
+	}
+
+	for _, id := range digest.UserIDs {
+		if id == userID {
+			return started, nil
+		}
+	}
+	digest.UserIDs = append(digest.UserIDs, userID)
+
+	_, err = s.kv.KVSet(KVAppPrefix, digestKey(channelID), digest)
+	return started, err
+}
+
+// TakeDigest returns the pending digest of the channel and removes it, so the
+// next member who joins starts a new one.
+func (s *Store) TakeDigest(channelID string) (*Digest, error) {
+	digestMu.Lock()
+	defer digestMu.Unlock()
+	unlock, err := s.Lock(digestKey(channelID))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	digest, err := s.GetDigest(channelID)
+	if err != nil || digest == nil {
+		return nil, err
+	}
+	return digest, s.kv.KVDelete(KVAppPrefix, digestKey(channelID))
+}
+
+func SetDigestCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = requireValues(c, "window"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	window, err := parseDelay(c.GetValue("window", "0"))
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the digest mode"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.DigestWindowSeconds = window
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the digest mode"))
+		return
+	}
+
+	if window == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("New members of ~%s will be welcomed one by one", cc.Channel.Name))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of ~%s will be welcomed together, every %s", cc.Channel.Name, welcome.DigestWindow()))
+}
+
+// addToDigest adds the new member to the channel's digest, and schedules the
+// digest post if the member is the first one of the window.
+func addToDigest(ctx context.Context, cc apps.Context, welcome ChannelWelcome) error {
+	started, err := NewStore(ctx, cc).AddToDigest(cc.Channel.Id, cc.User.Id)
+	if err != nil || !started {
+		return err
+	}
+
+	return scheduler.Enqueue(ctx, cc, []Job{{
+		ID:          model.NewId(),
+		Kind:        JobKindDigest,
+		RunAt:       model.GetMillis() + welcome.DigestWindow().Milliseconds(),
+		ChannelID:   cc.Channel.Id,
+		WelcomeKind: IndexKindChannel,
+		WelcomeID:   cc.Channel.Id,
+	}})
+}
+
+// runDigest posts the channel's pending digest: a single post mentioning all
+// the members who joined during the window, followed by the welcome messages.
+// A digest of a single member is rendered like a regular welcome, in their
+// language; digests of several members use the default messages.
+func runDigest(client *appclient.Client, store *Store, channelID string) error {
+	digest, err := store.TakeDigest(channelID)
+	if err != nil || digest == nil {
+		return err
+	}
+
+	welcome, err := store.GetChannelWelcome(channelID)
+	if err != nil || welcome == nil {
+		return err
+	}
+
+	channel, _, err := client.GetChannel(channelID, "")
+	if err != nil {
+		return err
+	}
+	var team *model.Team
+	if channel.TeamId != "" {
+		if team, _, err = client.GetTeam(channel.TeamId, ""); err != nil {
+			logger.Error(err)
+		}
+	}
+
+	users, _, err := client.GetUsersByIds(digest.UserIDs)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	var data TemplateData
+	var locale string
+	if len(users) == 1 {
+		data = store.NewTemplateData(users[0], channel, team)
+		locale = users[0].Locale
+	} else {
+		data = store.NewTemplateData(nil, channel, team)
+	}
+	data.Mentions = mentions(users)
+
+	messages := []string{fmt.Sprintf("Please welcome %s!", data.Mentions)}
+	for _, m := range welcome.Messages {
+		messages = append(messages, RenderWelcome(m.MessageFor(locale), data))
+	}
+
+	post := &model.Post{
+		ChannelId: channelID,
+		Message:   strings.Join(messages, "\n\n"),
+	}
+	if binding := recommendedChannelsBinding(client, welcome.RecommendedChannels); binding != nil {
+		post.AddProp(apps.PropAppBindings, []apps.Binding{*binding})
+	}
+
+	_, err = createPost(client, post)
+	return err
+}
+
+// mentions returns the @-mentions of the users, e.g. "@alice, @bob and @carol".
+func mentions(users []*model.User) string {
+	names := make([]string, len(users))
+	for i, user := range users {
+		names[i] = "@" + user.Username
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{names: []string{"alice"}, want: "@alice"},
+		{names: []string{"alice", "bob"}, want: "@alice and @bob"},
+		{names: []string{"alice", "bob", "carol"}, want: "@alice, @bob and @carol"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			users := []*model.User{}
+			for _, name := range tt.names {
+				users = append(users, &model.User{Username: name})
+			}
+			if got := mentions(users); got != tt.want {
+				t.Errorf("mentions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigest(t *testing.T) {
+	store := NewMemoryStore()
+	for i, user := range []string{"alice", "bob", "alice"} {
+		started, err := store.AddToDigest("channel", user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if started != (i == 0) {
+			t.Errorf("adding %s started the digest: %v", user, started)
+		}
+	}
+
+	digest, err := store.TakeDigest("channel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest == nil || !reflect.DeepEqual(digest.UserIDs, []string{"alice", "bob"}) {
+		t.Fatalf("took the digest %+v, want alice and bob once each", digest)
+	}
+	if digest, err = store.TakeDigest("channel"); err != nil || digest != nil {
+		t.Errorf("took the digest %+v, %v again", digest, err)
+	}
+	if started, _ := store.AddToDigest("channel", "carol"); !started {
+		t.Error("the first member after the digest was taken didn't start a new one")
+	}
+}
+
+func TestAddToDigestSchedulesOnce(t *testing.T) {
+	cc := schedulerContext(t)
+	previous := scheduler
+	scheduler = NewScheduler()
+	defer func() {
+		scheduler = previous
+	}()
+
+	cc.Channel = &model.Channel{Id: "channel"}
+	welcome := ChannelWelcome{DigestWindowSeconds: 15 * 60}
+	for _, user := range []string{"alice", "bob"} {
+		cc.User = &model.User{Id: user}
+		if err := addToDigest(context.Background(), cc, welcome); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := NewStore(context.Background(), cc).GetJobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Kind != JobKindDigest || entries[0].ChannelID != "channel" {
+		t.Fatalf("queued %+v, want a single digest job", entries)
+	}
+	if wait := entries[0].RunAt - model.GetMillis(); wait < 14*60*1000 || wait > 15*60*1000 {
+		t.Errorf("the digest runs in %dms, want at the end of the window", wait)
+	}
+}
+
+func TestRunDigest(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []string
+		want  string
+	}{
+		{name: "one member", users: []string{"alice"}, want: "Please welcome @alice!\n\n¡Hola Alice!"},
+		{name: "several members", users: []string{"alice", "bob"}, want: "Please welcome @alice and @bob!\n\nHello !"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryBackend(t)
+			fake := newFakeMattermost(t)
+			fake.channels = []*model.Channel{{Id: "channel", Name: "town-square"}}
+			fake.users = map[string]*model.User{
+				"alice-token": {Id: "alice", Username: "alice", FirstName: "Alice", Locale: "es"},
+				"bob-token":   {Id: "bob", Username: "bob", FirstName: "Bob"},
+			}
+			cc := callContext(fake, nil, nil)
+			store := NewStore(context.Background(), cc)
+			welcome := ChannelWelcome{Messages: []WelcomeMessage{{
+				Message:      "Hello {{.FirstName}}!",
+				Translations: map[string]string{"es": "¡Hola {{.FirstName}}!"},
+			}}}
+			if err := store.SetChannelWelcome("channel", welcome); err != nil {
+				t.Fatal(err)
+			}
+			for _, user := range tt.users {
+				if _, err := store.AddToDigest("channel", user); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := runDigest(asBot(context.Background(), cc), store, "channel"); err != nil {
+				t.Fatal(err)
+			}
+			if len(fake.posts) != 1 {
+				t.Fatalf("%d posts created, want 1", len(fake.posts))
+			}
+			post := fake.posts[0]
+			if post.ChannelId != "channel" || post.Message != tt.want {
+				t.Errorf("posted %q in %s, want %q", post.Message, post.ChannelId, tt.want)
+			}
+			if digest, _ := store.GetDigest("channel"); digest != nil {
+				t.Error("the digest is still pending")
+			}
+		})
+	}
+}
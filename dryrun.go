@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// dryRunField makes a command report what it would change, or send, without
+// changing or sending anything.
+var dryRunField = apps.Field{
+	Type:        apps.FieldTypeBool,
+	Name:        "dry_run",
+	Label:       "dry_run",
+	ModalLabel:  "Dry run",
+	Description: "Report what would change without changing anything",
+}
+
+// quoted formats the message as a markdown quote, to show it in a report.
+func quoted(message string) string {
+	return "> " + strings.ReplaceAll(message, "\n", "\n> ")
+}
+
+// dryRunSetResponse reports the message a set command would store, and the
+// one it would replace, if any.
+func dryRunSetResponse(cc apps.Context, message, previous string, warnings []string) apps.CallResponse {
+	report := T(cc, msgDryRunSet, nil) + "\n" + quoted(message)
+	if previous != "" {
+		report += "\n\n" + T(cc, msgDryRunReplacing, nil) + "\n" + quoted(previous)
+	}
+	return apps.NewTextResponse("%s", lintResponse(cc, report, warnings))
+}
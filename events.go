@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The types of the events posted to the event webhook.
+const (
+	EventWelcomeSent         = "welcome_sent"
+	EventWelcomeAcknowledged = "welcome_acknowledged"
+)
+
+// EventTypeHeader is the header holding the type of the event posted.
+const EventTypeHeader = "X-Welcomebot-Event"
+
+// eventTimeout is how long the event webhook has to answer.
+const eventTimeout = 5 * time.Second
+
+// Event is posted to the event webhook as JSON each time a welcome is sent or
+// acknowledged. WelcomeKind and WelcomeID are the welcome, IndexKindChannel or
+// IndexKindTeam and its ID, and UserID the member, empty for the digests that
+// welcome several members at once. Timestamp is in milliseconds.
+type Event struct {
+	Type        string `json:"type"`
+	Timestamp   int64  `json:"timestamp"`
+	WelcomeKind string `json:"welcome_kind"`
+	WelcomeID   string `json:"welcome_id"`
+	UserID      string `json:"user_id,omitempty"`
+	ChannelID   string `json:"channel_id,omitempty"`
+}
+
+// EventWebhook posts the events to an external URL, e.g. to feed onboarding
+// progress to analytics or HR tools. The events are signed like the requests
+// to the welcome webhook, with the WebhookSignatureHeader header, if a webhook
+// secret is set.
+type EventWebhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// eventWebhook is the event webhook configured, nil if there is none.
+var eventWebhook *EventWebhook
+
+// NewEventWebhook returns the event webhook posting to the URL.
+func NewEventWebhook(url, secret string) *EventWebhook {
+	return &EventWebhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: eventTimeout},
+	}
+}
+
+// Send posts the event, if the webhook is configured. The events are best
+// effort: the errors are logged, and the event dropped.
+func (e *EventWebhook) Send(event Event) {
+	if e == nil {
+		return
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = model.GetMillis()
+	}
+	if err := e.post(event); err != nil {
+		logger.With("event", event.Type, "welcome_id", event.WelcomeID).Warnf("failed to send the event: %v", err)
+	}
+}
+
+func (e *EventWebhook) post(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, event.Type)
+	if e.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, webhookSignature(e.secret, data))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sentEvent returns the event of the job that sent a welcome.
+func sentEvent(job Job) Event {
+	return Event{
+		Type:        EventWelcomeSent,
+		WelcomeKind: job.WelcomeKind,
+		WelcomeID:   job.WelcomeID,
+		UserID:      job.UserID,
+		ChannelID:   job.ChannelID,
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// SetExcludedUsersForm sets the usernames that are never welcomed, on top of
+// the bots.
+var SetExcludedUsersForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "patterns",
+			Description:          "Username patterns separated by spaces, e.g. svc-* *-test. Leave empty to only skip bots.",
+			AutocompletePosition: -1,
+		},
+	},
+	Submit: apps.NewCall("/set_excluded_users").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// isExcludedUser reports whether the user must not be welcomed: bot accounts,
+// including the ones of plugins and integrations, deactivated accounts, and
+// the users whose username matches one of the excluded patterns.
+func isExcludedUser(settings Settings, user *model.User) bool {
+	if user.IsBot || user.DeleteAt != 0 {
+		return true
+	}
+	for _, pattern := range settings.ExcludedUsernames {
+		if matched, _ := path.Match(pattern, user.Username); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// skipWelcome reports whether the user must not be welcomed, either because
+// the welcomes are paused or because the user is excluded.
+func skipWelcome(store *Store, user *model.User) bool {
+	settings, err := store.GetSettings()
+	if err != nil {
+		logger.Error(err)
+	}
+	return settings.WelcomesPaused || isExcludedUser(settings, user)
+}
+
+func SetExcludedUsersCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	patterns := strings.Fields(strings.ToLower(c.GetValue("patterns", "")))
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			httputils.WriteJSON(w,
+				errorResponse("%s is not a valid pattern", pattern))
+			return
+		}
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	settings, err := store.GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	settings.ExcludedUsernames = patterns
+	if err = store.SetSettings(settings); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if len(patterns) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Only bots won't be welcomed."))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Bots and the users matching `%s` won't be welcomed.", strings.Join(patterns, "`, `")))
+}
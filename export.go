@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Export sends the export of every stored welcome to the acting user.
+var Export = apps.NewCall("/export").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+})
+
+// ImportForm accepts either an export of this app or the configuration of the
+// legacy welcome bot plugin, pasted or as an uploaded file.
+var ImportForm = apps.Form{
+	Title:  "Import welcome messages",
+	Header: "Paste an export of the Welcome Bot app, or the configuration of the Welcome Bot plugin: its \"WelcomeMessages\" setting, or the whole plugin settings from config.json. You can also upload the file in a direct message to the bot, and give the link to the post.",
+	Icon:   "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			Name:                 "config",
+			ModalLabel:           "Configuration",
+			AutocompletePosition: -1,
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "file",
+			Label:       "file",
+			ModalLabel:  "File",
+			Description: "The link to the post the file is attached to, or the ID of the file",
+		},
+		dryRunField,
+	},
+	Submit: apps.NewCall("/import").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+	}),
+}
+
+// ExportData is the export of all the welcomes and farewells of the app.
+// Channels and teams are identified by name, and recommended channels and
+// greeters are exported by name too, so an export can be imported in another
+// server.
+type ExportData struct {
+	App        string            `json:"app"`
+	Version    string            `json:"version"`
+	ExportedAt int64             `json:"exported_at"`
+	Settings   Settings          `json:"settings"`
+	Snippets   map[string]string `json:"snippets,omitempty"`
+	Server     *ServerWelcome    `json:"server_welcome,omitempty"`
+	Channels   []ChannelExport   `json:"channels"`
+	Teams      []TeamExport      `json:"teams"`
+}
+
+// ChannelExport is the exported configuration of a channel.
+type ChannelExport struct {
+	TeamName    string          `json:"team_name"`
+	ChannelName string          `json:"channel_name"`
+	Welcome     *ChannelWelcome `json:"welcome,omitempty"`
+	Farewell    *Farewell       `json:"farewell,omitempty"`
+}
+
+// TeamExport is the exported configuration of a team.
+type TeamExport struct {
+	TeamName string       `json:"team_name"`
+	Welcome  *TeamWelcome `json:"welcome,omitempty"`
+	Farewell *Farewell    `json:"farewell,omitempty"`
+
+	// ChannelDefault is the welcome of the team's public channels that have
+	// none of their own.
+	ChannelDefault *ChannelWelcome `json:"channel_default,omitempty"`
+}
+
+// isExport reports whether data is an export of this app, rather than a
+// configuration of the legacy plugin.
+func isExport(data []byte) bool {
+	var header struct {
+		App string `json:"app"`
+	}
+	return json.Unmarshal(data, &header) == nil && header.App == AppID
+}
+
+// exportAll collects the welcomes and farewells listed in the index. Entries
+// whose channel or team can't be found anymore are left out.
+func exportAll(store *Store) (*ExportData, error) {
+	settings, err := store.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+	snippets, err := store.GetSnippets()
+	if err != nil {
+		return nil, err
+	}
+	server, err := store.GetServerWelcome()
+	if err != nil {
+		return nil, err
+	}
+	index, err := store.GetIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	client := store.client
+	export := &ExportData{
+		App:        AppID,
+		Version:    string(Manifest.Version),
+		ExportedAt: model.GetMillis(),
+		Settings:   settings,
+		Snippets:   snippets,
+		Server:     server,
+		Channels:   []ChannelExport{},
+		Teams:      []TeamExport{},
+	}
+	channels := map[string]*ChannelExport{}
+	teams := map[string]*TeamExport{}
+	teamNames := map[string]string{}
+
+	channelExport := func(channelID string) *ChannelExport {
+		if e, ok := channels[channelID]; ok {
+			return e
+		}
+		channel, _, err := client.GetChannel(channelID, "")
+		if err != nil {
+			logger.Error(err)
+			return nil
+		}
+		teamName, ok := teamNames[channel.TeamId]
+		if !ok {
+			team, _, err := client.GetTeam(channel.TeamId, "")
+			if err != nil {
+				logger.Error(err)
+				return nil
+			}
+			teamName = team.Name
+			teamNames[channel.TeamId] = teamName
+		}
+		channels[channelID] = &ChannelExport{TeamName: teamName, ChannelName: channel.Name}
+		return channels[channelID]
+	}
+	teamExport := func(teamID string) *TeamExport {
+		if e, ok := teams[teamID]; ok {
+			return e
+		}
+		team, _, err := client.GetTeam(teamID, "")
+		if err != nil {
+			logger.Error(err)
+			return nil
+		}
+		teams[teamID] = &TeamExport{TeamName: team.Name}
+		return teams[teamID]
+	}
+
+	for _, entry := range index {
+		switch entry.Kind {
+		case IndexKindChannel:
+			welcome, err := store.GetChannelWelcome(entry.ID)
+			if err != nil {
+				return nil, err
+			}
+			if e := channelExport(entry.ID); e != nil && welcome != nil {
+				welcome.RecommendedChannels = channelNames(client, welcome.RecommendedChannels)
+				welcome.Greeters = usernames(client, welcome.Greeters)
+				e.Welcome = welcome
+			}
+		case IndexKindChannelFarewell:
+			farewell, err := store.GetChannelFarewell(entry.ID)
+			if err != nil {
+				return nil, err
+			}
+			if e := channelExport(entry.ID); e != nil {
+				e.Farewell = farewell
+			}
+		case IndexKindTeam:
+			welcome, err := store.GetTeamWelcome(entry.ID)
+			if err != nil {
+				return nil, err
+			}
+			if e := teamExport(entry.ID); e != nil && welcome != nil {
+				welcome.RecommendedChannels = channelNames(client, welcome.RecommendedChannels)
+				for i := range welcome.Interests {
+					welcome.Interests[i].Channels = channelNames(client, welcome.Interests[i].Channels)
+				}
+				// The channels of the checklist items are exported by name
+				// too, or dropped if they are gone.
+				for i, item := range welcome.Checklist {
+					if item.ChannelID != "" {
+						welcome.Checklist[i].ChannelID = strings.Join(channelNames(client, []string{item.ChannelID}), "")
+					}
+				}
+				e.Welcome = welcome
+			}
+		case IndexKindTeamFarewell:
+			farewell, err := store.GetTeamFarewell(entry.ID)
+			if err != nil {
+				return nil, err
+			}
+			if e := teamExport(entry.ID); e != nil {
+				e.Farewell = farewell
+			}
+		case IndexKindChannelDefault:
+			welcome, err := store.GetChannelDefault(entry.ID)
+			if err != nil {
+				return nil, err
+			}
+			if e := teamExport(entry.ID); e != nil && welcome != nil {
+				welcome.RecommendedChannels = channelNames(client, welcome.RecommendedChannels)
+				welcome.Greeters = usernames(client, welcome.Greeters)
+				e.ChannelDefault = welcome
+			}
+		}
+	}
+
+	// The index is sorted by kind and name, keep that order.
+	seen := map[*ChannelExport]bool{}
+	for _, entry := range index {
+		if e, ok := channels[entry.ID]; ok && !seen[e] {
+			seen[e] = true
+			export.Channels = append(export.Channels, *e)
+		}
+	}
+	seenTeams := map[*TeamExport]bool{}
+	for _, entry := range index {
+		if e, ok := teams[entry.ID]; ok && !seenTeams[e] {
+			seenTeams[e] = true
+			export.Teams = append(export.Teams, *e)
+		}
+	}
+	return export, nil
+}
+
+// channelNames returns the names of the channels, leaving out the ones that
+// can't be found.
+func channelNames(client *appclient.Client, channelIDs []string) []string {
+	names := []string{}
+	for _, id := range channelIDs {
+		channel, _, err := client.GetChannel(id, "")
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		names = append(names, channel.Name)
+	}
+	return names
+}
+
+// channelIDs returns the IDs of the channels of the team, by name. Channels
+// that can't be found are reported in notes.
+func channelIDs(client *appclient.Client, teamID string, names []string, notes *[]string) []string {
+	ids := []string{}
+	for _, name := range names {
+		channel, _, err := client.GetChannelByName(name, teamID, "")
+		if err != nil {
+			*notes = append(*notes, fmt.Sprintf("channel %s was not found", name))
+			continue
+		}
+		ids = append(ids, channel.Id)
+	}
+	return ids
+}
+
+// importExport restores an export made by exportAll, overwriting the welcomes
+// and farewells of the channels and teams it contains, and returns a report
+// of what was imported. In a dry run, nothing is written and the report is of
+// what would be imported.
+func importExport(ctx context.Context, cc apps.Context, data []byte, dryRun bool) (string, error) {
+	export := ExportData{}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return "", fmt.Errorf("invalid export: %w", err)
+	}
+
+	client := asActingUser(ctx, cc)
+	store := NewStore(ctx, cc)
+	report := []string{}
+
+	if isValidManagerRole(export.Settings.RequiredRole) {
+		if !dryRun {
+			if err := store.SetSettings(export.Settings); err != nil {
+				return "", err
+			}
+		}
+		report = append(report, importReportLine("settings", nil, dryRun))
+	}
+	if len(export.Snippets) > 0 {
+		if !dryRun {
+			if err := store.SetSnippets(export.Snippets); err != nil {
+				return "", err
+			}
+		}
+		report = append(report, importReportLine("snippets", nil, dryRun))
+	}
+	if export.Server != nil && export.Server.Message != "" {
+		notes := []string{}
+		if err := checkWelcomeLength(export.Server.Message); err != nil {
+			notes = append(notes, "skipped the welcome, "+err.Error())
+		} else if dryRun {
+			// The welcome is valid, nothing is written.
+		} else if err = store.SetServerWelcome(*export.Server); err != nil {
+			return "", err
+		} else if err = SubscribeToUserCreated(asBot(ctx, cc)); err != nil {
+			logger.Error(err)
+			notes = append(notes, "couldn't subscribe to the new accounts")
+		}
+		report = append(report, importReportLine("server welcome", notes, dryRun))
+	}
+
+	for _, e := range export.Channels {
+		name := e.TeamName + " ~" + e.ChannelName
+		team, _, err := client.GetTeamByName(e.TeamName, "")
+		if err != nil {
+			report = append(report, fmt.Sprintf("* %s: skipped, the team was not found", name))
+			continue
+		}
+		channel, _, err := client.GetChannelByName(e.ChannelName, team.Id, "")
+		if err != nil {
+			report = append(report, fmt.Sprintf("* %s: skipped, the channel was not found", name))
+			continue
+		}
+
+		channelContext := cc
+		channelContext.Team = team
+		channelContext.Channel = channel
+		notes := []string{}
+
+		if e.Welcome != nil && len(e.Welcome.Messages) > 0 {
+			welcome := *e.Welcome
+			welcome.RecommendedChannels = channelIDs(client, team.Id, welcome.RecommendedChannels, &notes)
+			welcome.Greeters = userIDs(client, welcome.Greeters, &notes)
+			welcome.GuidePostID = ""
+			if err = welcome.CheckLimits(); err != nil {
+				notes = append(notes, "skipped the welcome, "+err.Error())
+			} else if dryRun {
+				// The welcome is valid, nothing is written.
+			} else if err = store.SetChannelWelcome(channel.Id, welcome); err != nil {
+				return "", err
+			} else if err = enableChannelWelcome(ctx, channelContext); err != nil {
+				logger.Error(err)
+				notes = append(notes, "couldn't subscribe to the channel's join events")
+			} else if welcome.PinGuide {
+				if err = updateGuide(asBot(ctx, channelContext), channel, &welcome); err != nil {
+					logger.Error(err)
+					notes = append(notes, "couldn't pin the channel guide")
+				}
+				if err = store.SetChannelWelcome(channel.Id, welcome); err != nil {
+					return "", err
+				}
+			}
+		}
+		if e.Farewell != nil && e.Farewell.Message != "" && !dryRun {
+			if err = store.SetChannelFarewell(channel.Id, *e.Farewell); err != nil {
+				return "", err
+			}
+			if err = enableChannelFarewell(ctx, channelContext); err != nil {
+				logger.Error(err)
+				notes = append(notes, "couldn't subscribe to the channel's leave events")
+			}
+		}
+		report = append(report, importReportLine(name, notes, dryRun))
+	}
+
+	for _, e := range export.Teams {
+		team, _, err := client.GetTeamByName(e.TeamName, "")
+		if err != nil {
+			report = append(report, fmt.Sprintf("* %s: skipped, the team was not found", e.TeamName))
+			continue
+		}
+
+		teamContext := cc
+		teamContext.Team = team
+		notes := []string{}
+
+		if e.Welcome != nil && e.Welcome.Message != "" {
+			welcome := *e.Welcome
+			welcome.RecommendedChannels = channelIDs(client, team.Id, welcome.RecommendedChannels, &notes)
+			for i := range welcome.Interests {
+				welcome.Interests[i].Channels = channelIDs(client, team.Id, welcome.Interests[i].Channels, &notes)
+			}
+			for i, item := range welcome.Checklist {
+				if item.ChannelID != "" {
+					welcome.Checklist[i].ChannelID = strings.Join(channelIDs(client, team.Id, []string{item.ChannelID}, &notes), "")
+				}
+			}
+			if err = welcome.CheckLimits(); err != nil {
+				notes = append(notes, "skipped the welcome, "+err.Error())
+			} else if dryRun {
+				// The welcome is valid, nothing is written.
+			} else if err = store.SetTeamWelcome(team.Id, welcome); err != nil {
+				return "", err
+			} else if err = enableTeamWelcome(ctx, teamContext); err != nil {
+				logger.Error(err)
+				notes = append(notes, "couldn't subscribe to the team's join events")
+			}
+		}
+		if e.Farewell != nil && e.Farewell.Message != "" && !dryRun {
+			if err = store.SetTeamFarewell(team.Id, *e.Farewell); err != nil {
+				return "", err
+			}
+			if err = enableTeamFarewell(ctx, teamContext); err != nil {
+				logger.Error(err)
+				notes = append(notes, "couldn't subscribe to the team's leave events")
+			}
+		}
+		if e.ChannelDefault != nil && len(e.ChannelDefault.Messages) > 0 {
+			welcome := *e.ChannelDefault
+			welcome.RecommendedChannels = channelIDs(client, team.Id, welcome.RecommendedChannels, &notes)
+			welcome.Greeters = userIDs(client, welcome.Greeters, &notes)
+			if err = welcome.CheckLimits(); err != nil {
+				notes = append(notes, "skipped the default channel welcome, "+err.Error())
+			} else if dryRun {
+				// The default is valid, nothing is written.
+			} else if err = store.SetChannelDefault(team.Id, welcome); err != nil {
+				return "", err
+			} else if _, err = enableChannelDefault(ctx, teamContext); err != nil {
+				logger.Error(err)
+				notes = append(notes, "couldn't enable the default channel welcome in the team's channels")
+			}
+		}
+		report = append(report, importReportLine(team.Name, notes, dryRun))
+	}
+
+	if len(report) == 0 {
+		return "", errors.New("the export has no welcome messages")
+	}
+	return strings.Join(report, "\n"), nil
+}
+
+func importReportLine(name string, notes []string, dryRun bool) string {
+	line := fmt.Sprintf("* %s: imported", name)
+	if dryRun {
+		line = fmt.Sprintf("* %s: would be imported", name)
+	}
+	if len(notes) > 0 {
+		line += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return line
+}
+
+// readImportFile downloads the file to import. value is the ID of the file,
+// or a link to the post it is attached to, e.g. a permalink.
+func readImportFile(client *appclient.Client, value string) ([]byte, error) {
+	var id string
+	parts := strings.Split(strings.TrimSpace(value), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if model.IsValidId(parts[i]) {
+			id = parts[i]
+			break
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("%s is not a file ID or a link to a post", value)
+	}
+
+	if infos, _, err := client.GetFileInfosForPost(id, ""); err == nil {
+		if len(infos) == 0 {
+			return nil, errors.New("the post has no file attached")
+		}
+		id = infos[0].Id
+	}
+
+	data, _, err := client.GetFile(id)
+	if err != nil {
+		return nil, fmt.Errorf("we couldn't download the file: %w", err)
+	}
+	return data, nil
+}
+
+// ExportCall sends the export of all the welcomes to the acting user, as a JSON
+// file in a direct message from the bot. System admins only, as it contains
+// the welcomes of every channel and team.
+func ExportCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	export, err := exportAll(store)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't export the welcome messages"))
+		return
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't export the welcome messages"))
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	dm, _, err := client.CreateDirectChannel(c.Context.BotUserID, c.Context.ActingUser.Id)
+	if err == nil {
+		err = sendExport(client, dm.Id, data)
+	}
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't send you the export"))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Sent you the export of %d channels and %d teams as a direct message", len(export.Channels), len(export.Teams)))
+}
+
+// sendExport uploads the export and posts it in the channel.
+func sendExport(client *appclient.Client, channelID string, data []byte) error {
+	filename := fmt.Sprintf("welcomebot-export-%s.json", time.Now().UTC().Format("2006-01-02"))
+	upload, _, err := client.UploadFile(data, channelID, filename)
+	if err != nil {
+		return err
+	}
+	if len(upload.FileInfos) == 0 {
+		return errors.New("the export was not uploaded")
+	}
+
+	_, err = createPost(client, &model.Post{
+		ChannelId: channelID,
+		Message:   "Here is the export of the welcome messages. Import it with `/welcomebot import --file` and the link to this post.",
+		FileIds:   model.StringArray{upload.FileInfos[0].Id},
+	})
+	return err
+}
+
+// ImportCall imports the pasted or uploaded configuration, either an export of
+// this app or the legacy plugin configuration. System admins only, as it sets
+// the welcome of any channel or team.
+func ImportCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	data := []byte(c.GetValue("config", ""))
+	if file := c.GetValue("file", ""); file != "" {
+		var err error
+		if data, err = readImportFile(asActingUser(req.Context(), c.Context), file); err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		httputils.WriteJSON(w, apps.NewErrorResponse(
+			errors.New("paste the configuration to import, or give the link to the post of the file with --file")))
+		return
+	}
+
+	var report string
+	var err error
+	var source string
+	dryRun := c.BoolValue("dry_run")
+	if isExport(data) {
+		source = "export"
+		report, err = importExport(req.Context(), c.Context, data, dryRun)
+	} else {
+		source = "Welcome Bot plugin configuration"
+		report, err = importLegacyConfig(req.Context(), c.Context, data, dryRun)
+	}
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if dryRun {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Dry run, nothing was imported. Importing the %s would give:\n%s", source, report))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Imported the %s:\n%s", source, report))
+}
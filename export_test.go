@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// exportServer returns a fake server with the team acme and its channels
+// town-square and random, whose IDs are prefixed with prefix, as they differ
+// from a server to another.
+func exportServer(t *testing.T, prefix string) *fakeMattermost {
+	fake := newFakeMattermost(t)
+	fake.teams = []*model.Team{{Id: prefix + "team", Name: "acme"}}
+	fake.channels = []*model.Channel{
+		{Id: prefix + "town", Name: "town-square", TeamId: prefix + "team"},
+		{Id: prefix + "random", Name: "random", TeamId: prefix + "team"},
+	}
+	return fake
+}
+
+func TestExportImport(t *testing.T) {
+	admin := &model.User{Id: "admin", Roles: model.SystemAdminRoleId}
+
+	useMemoryBackend(t)
+	staging := exportServer(t, "staging-")
+	cc := callContext(staging, admin, nil)
+	store := NewStore(context.Background(), cc)
+	if err := store.SetSettings(Settings{RequiredRole: model.TeamAdminRoleId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetSnippets(map[string]string{"rules": "Be nice."}); err != nil {
+		t.Fatal(err)
+	}
+	teamWelcome := TeamWelcome{
+		Message:             "Welcome to Acme!",
+		RecommendedChannels: []string{"staging-random", "deleted"},
+		Checklist:           []ChecklistItem{{Label: "Say hi", ChannelID: "staging-town"}},
+	}
+	if err := store.SetTeamWelcome("staging-team", teamWelcome); err != nil {
+		t.Fatal(err)
+	}
+	channelWelcome := ChannelWelcome{
+		Messages:            []WelcomeMessage{{Message: "Welcome to the town square"}},
+		RecommendedChannels: []string{"staging-random"},
+	}
+	if err := store.SetChannelWelcome("staging-town", channelWelcome); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []IndexEntry{
+		NewTeamIndexEntry(staging.teams[0], admin),
+		NewChannelIndexEntry(staging.channels[0], admin),
+	} {
+		if err := store.PutIndexEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	export, err := exportAll(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Teams) != 1 || export.Teams[0].TeamName != "acme" || export.Teams[0].Welcome == nil {
+		t.Fatalf("exported the teams %+v", export.Teams)
+	}
+	if got := export.Teams[0].Welcome.RecommendedChannels; !reflect.DeepEqual(got, []string{"random"}) {
+		t.Errorf("exported the recommended channels %v, want the names of the ones left", got)
+	}
+	if got := export.Teams[0].Welcome.Checklist[0].ChannelID; got != "town-square" {
+		t.Errorf("exported the checklist channel %q, want its name", got)
+	}
+	if len(export.Channels) != 1 || export.Channels[0].ChannelName != "town-square" || export.Channels[0].TeamName != "acme" {
+		t.Fatalf("exported the channels %+v", export.Channels)
+	}
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isExport(data) {
+		t.Error("the export isn't recognized as one")
+	}
+
+	useMemoryBackend(t)
+	production := exportServer(t, "production-")
+	cc = callContext(production, admin, nil)
+	if _, err = importExport(context.Background(), cc, data, true); err != nil {
+		t.Fatal(err)
+	}
+	store = NewStore(context.Background(), cc)
+	if welcome, _ := store.GetTeamWelcome("production-team"); welcome != nil {
+		t.Fatal("the dry run imported the team welcome")
+	}
+
+	report, err := importExport(context.Background(), cc, data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"* settings: imported", "* snippets: imported", "* acme ~town-square: imported", "* acme: imported"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("the report %q doesn't tell %q", report, want)
+		}
+	}
+
+	settings, err := store.GetSettings()
+	if err != nil || settings.RequiredRole != model.TeamAdminRoleId {
+		t.Errorf("imported the settings %+v, %v", settings, err)
+	}
+	snippets, err := store.GetSnippets()
+	if err != nil || snippets["rules"] != "Be nice." {
+		t.Errorf("imported the snippets %v, %v", snippets, err)
+	}
+	team, err := store.GetTeamWelcome("production-team")
+	if err != nil || team == nil {
+		t.Fatalf("imported the team welcome %+v, %v", team, err)
+	}
+	if team.Message != teamWelcome.Message || !reflect.DeepEqual(team.RecommendedChannels, []string{"production-random"}) || team.Checklist[0].ChannelID != "production-town" {
+		t.Errorf("imported the team welcome %+v, want the channels of the server imported to", team)
+	}
+	channel, err := store.GetChannelWelcome("production-town")
+	if err != nil || channel == nil {
+		t.Fatalf("imported the channel welcome %+v, %v", channel, err)
+	}
+	if channel.Messages[0].Message != channelWelcome.Messages[0].Message || !reflect.DeepEqual(channel.RecommendedChannels, []string{"production-random"}) {
+		t.Errorf("imported the channel welcome %+v", channel)
+	}
+}
+
+func TestImportExportInvalid(t *testing.T) {
+	useMemoryBackend(t)
+	fake := exportServer(t, "")
+	cc := callContext(fake, &model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+
+	tests := []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{name: "not json", data: "{", error: "invalid export"},
+		{name: "empty", data: `{"app": "` + AppID + `"}`, error: "no welcome messages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := importExport(context.Background(), cc, []byte(tt.data), false)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("err = %v, want %q", err, tt.error)
+			}
+		})
+	}
+
+	report, err := importExport(context.Background(), cc, []byte(`{"teams": [{"team_name": "gone", "welcome": {"message": "Hi"}}]}`), false)
+	if err != nil || !strings.Contains(report, "* gone: skipped, the team was not found") {
+		t.Errorf("report = %q, %v, want the team skipped", report, err)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// How a channel farewell is delivered: posted in the channel, or sent to the
+// channel admins as a direct message.
+const (
+	FarewellModePost   = "post"
+	FarewellModeNotify = "notify"
+)
+
+// adminsPageSize is the page size used to walk channel and team members when
+// looking for admins to notify.
+const adminsPageSize = 200
+
+// Farewell is the message sent when a member leaves a channel or a team.
+// Team farewells are always sent to the team admins.
+type Farewell struct {
+	Message string `json:"message"`
+	Mode    string `json:"mode,omitempty"`
+}
+
+func channelFarewellKey(channelID string) string {
+	return "channel_farewell_" + channelID
+}
+
+func teamFarewellKey(teamID string) string {
+	return "team_farewell_" + teamID
+}
+
+// GetChannelFarewell returns the farewell of the channel, or nil if none was
+// set.
+func (s *Store) GetChannelFarewell(channelID string) (*Farewell, error) {
+	return s.getFarewell(channelFarewellKey(channelID))
+}
+
+// SetChannelFarewell stores the farewell of the channel.
+func (s *Store) SetChannelFarewell(channelID string, farewell Farewell) error {
+	_, err := s.kv.KVSet(KVAppPrefix, channelFarewellKey(channelID), farewell)
+	return err
+}
+
+// DeleteChannelFarewell removes the farewell of the channel.
+func (s *Store) DeleteChannelFarewell(channelID string) error {
+	return s.kv.KVDelete(KVAppPrefix, channelFarewellKey(channelID))
+}
+
+// GetTeamFarewell returns the farewell of the team, or nil if none was set.
+func (s *Store) GetTeamFarewell(teamID string) (*Farewell, error) {
+	return s.getFarewell(teamFarewellKey(teamID))
+}
+
+// SetTeamFarewell stores the farewell of the team.
+func (s *Store) SetTeamFarewell(teamID string, farewell Farewell) error {
+	_, err := s.kv.KVSet(KVAppPrefix, teamFarewellKey(teamID), farewell)
+	return err
+}
+
+// DeleteTeamFarewell removes the farewell of the team.
+func (s *Store) DeleteTeamFarewell(teamID string) error {
+	return s.kv.KVDelete(KVAppPrefix, teamFarewellKey(teamID))
+}
+
+func (s *Store) getFarewell(key string) (*Farewell, error) {
+	var farewell *Farewell
+	if err := s.kv.KVGet(KVAppPrefix, key, &farewell); err != nil {
+		return nil, err
+	}
+	if farewell == nil || farewell.Message == "" {
+		return nil, nil
+	}
+	return farewell, nil
+}
+
+// farewellMessageField is the editor for farewell messages.
+var farewellMessageField = apps.Field{
+	Type:                 apps.FieldTypeText,
+	TextSubtype:          apps.TextFieldSubtypeTextarea,
+	TextMaxLength:        model.PostMessageMaxRunesV2,
+	Name:                 "message",
+	ModalLabel:           "Farewell message",
+	Description:          "Markdown and template variables like {{.UserName}} are supported.",
+	IsRequired:           true,
+	AutocompletePosition: -1,
+}
+
+var SetChannelFarewellForm = apps.Form{
+	Title:  "Channel farewell message",
+	Header: "This message will be sent when a member leaves the channel.",
+	Icon:   "icon.png",
+	Fields: []apps.Field{
+		farewellMessageField,
+		channelField,
+		{
+			Type:        apps.FieldTypeStaticSelect,
+			Name:        "mode",
+			Label:       "mode",
+			ModalLabel:  "Send to",
+			Description: "Post the message in the channel, or send it to the channel admins",
+			SelectStaticOptions: []apps.SelectOption{
+				{Label: "the channel", Value: FarewellModePost},
+				{Label: "the channel admins", Value: FarewellModeNotify},
+			},
+		},
+	},
+	Submit: apps.NewCall("/set_channel_farewell").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+var DeleteChannelFarewellForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		channelField,
+	},
+	Submit: apps.NewCall("/delete_channel_farewell").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+var SetTeamFarewellForm = apps.Form{
+	Title:  "Team farewell message",
+	Header: "The team admins will get this message when a member leaves the team.",
+	Icon:   "icon.png",
+	Fields: []apps.Field{
+		farewellMessageField,
+	},
+	Submit: apps.NewCall("/set_team_farewell").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+var DeleteTeamFarewell = apps.NewCall("/delete_team_farewell").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+	Team:       apps.ExpandSummary,
+	TeamMember: apps.ExpandAll,
+})
+
+func SetChannelFarewellCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+	if cc.Channel.IsGroupOrDirect() {
+		httputils.WriteJSON(w, apps.NewErrorResponse(
+			errors.New("farewell messages can't be set for direct or group messages")))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = requireValues(c, "message"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	farewell := Farewell{
+		Message: c.GetValue("message", ""),
+		Mode:    c.GetValue("mode", FarewellModePost),
+	}
+	if farewell.Mode != FarewellModePost && farewell.Mode != FarewellModeNotify {
+		httputils.WriteJSON(w, apps.NewErrorResponse(
+			fmt.Errorf("the mode must be %s or %s", FarewellModePost, FarewellModeNotify)))
+		return
+	}
+	if err = checkWelcomeLength(farewell.Message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = store.SetChannelFarewell(cc.Channel.Id, farewell); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set your message"))
+		return
+	}
+	if err = enableChannelFarewell(req.Context(), cc); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("stored the farewell message, but couldn't subscribe to the channel's leave events"))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Stored the channel farewell message:\n %s", farewell.Message))
+}
+
+// enableChannelFarewell adds the bot to the channel so it can post the
+// farewell, subscribes to the channel's leave events and records the channel
+// in the welcome index.
+func enableChannelFarewell(ctx context.Context, cc apps.Context) error {
+	_, _, err := asActingUser(ctx, cc).AddChannelMember(cc.Channel.Id, cc.BotUserID)
+	if err != nil {
+		return err
+	}
+
+	if err = SubscribeToChannelLeaves(asBot(ctx, cc), cc.Channel.Id); err != nil {
+		return err
+	}
+
+	entry := NewChannelIndexEntry(cc.Channel, cc.ActingUser)
+	entry.Kind = IndexKindChannelFarewell
+	return NewStore(ctx, cc).PutIndexEntry(entry)
+}
+
+func DeleteChannelFarewellCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = store.DeleteChannelFarewell(cc.Channel.Id); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't delete the farewell message"))
+		return
+	}
+	if err = UnsubscribeFromChannelLeaves(asBot(req.Context(), cc), cc.Channel.Id); err != nil {
+		requestLogger(req).Error(err)
+	}
+	if err = store.RemoveIndexEntry(IndexKindChannelFarewell, cc.Channel.Id); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Deleted the channel's farewell message"))
+}
+
+func SetTeamFarewellCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := requireValues(c, "message"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	farewell := Farewell{
+		Message: c.GetValue("message", ""),
+		Mode:    FarewellModeNotify,
+	}
+	if err := checkWelcomeLength(farewell.Message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := store.SetTeamFarewell(c.Context.Team.Id, farewell); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set your message"))
+		return
+	}
+	if err := enableTeamFarewell(req.Context(), c.Context); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("stored the farewell message, but couldn't subscribe to the team's leave events"))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Stored the team farewell message:\n %s", farewell.Message))
+}
+
+// enableTeamFarewell adds the bot to the team, subscribes to the team's leave
+// events and records the team in the welcome index.
+func enableTeamFarewell(ctx context.Context, cc apps.Context) error {
+	_, _, err := asActingUser(ctx, cc).AddTeamMember(cc.Team.Id, cc.BotUserID)
+	if err != nil {
+		return err
+	}
+
+	if err = SubscribeToTeamLeaves(asBot(ctx, cc), cc.Team.Id); err != nil {
+		return err
+	}
+
+	entry := NewTeamIndexEntry(cc.Team, cc.ActingUser)
+	entry.Kind = IndexKindTeamFarewell
+	return NewStore(ctx, cc).PutIndexEntry(entry)
+}
+
+func DeleteTeamFarewellCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := store.DeleteTeamFarewell(c.Context.Team.Id); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't delete the team farewell message"))
+		return
+	}
+	// The leave events still cancel the drip campaigns and milestones, if any.
+	if welcome, _ := store.GetTeamWelcome(c.Context.Team.Id); welcome == nil || (len(welcome.FollowUps) == 0 && len(welcome.Milestones) == 0) {
+		if err := UnsubscribeFromTeamLeaves(asBot(req.Context(), c.Context), c.Context.Team.Id); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+	if err := store.RemoveIndexEntry(IndexKindTeamFarewell, c.Context.Team.Id); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Deleted the team's farewell message"))
+}
+
+// UserLeftChannelCall renders the channel's farewell for the member who left,
+// and posts it in the channel or sends it to the channel admins.
+func UserLeftChannelCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	user := c.Context.User
+	channel := c.Context.Channel
+	if user == nil || channel == nil || user.Id == c.Context.BotUserID {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	farewell, err := store.GetChannelFarewell(channel.Id)
+	if err != nil || farewell == nil {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	message := RenderWelcome(farewell.Message, store.NewTemplateData(user, channel, c.Context.Team))
+
+	if farewell.Mode == FarewellModeNotify {
+		var adminIDs []string
+		adminIDs, err = channelAdminIDs(client, channel.Id, c.Context.BotUserID)
+		if err == nil {
+			notifyAdmins(client, adminIDs, message)
+		}
+	} else {
+		_, err = createPost(client, &model.Post{
+			ChannelId: channel.Id,
+			Message:   message,
+		})
+	}
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
+
+// UserLeftTeamCall renders the team's farewell for the member who left, and
+// sends it to the team admins.
+func UserLeftTeamCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	user := c.Context.User
+	team := c.Context.Team
+	if user == nil || team == nil || user.Id == c.Context.BotUserID {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	cancelCampaign(req.Context(), c.Context, store, team.Id, user.Id)
+	cancelMilestones(req.Context(), c.Context, team.Id, user.Id)
+
+	farewell, err := store.GetTeamFarewell(team.Id)
+	if err != nil || farewell == nil {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	adminIDs, err := teamAdminIDs(client, team.Id, c.Context.BotUserID)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	notifyAdmins(client, adminIDs, RenderWelcome(farewell.Message, store.NewTemplateData(user, nil, team)))
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
+
+// notifyAdmins sends the message to each admin as a direct message from the
+// bot.
+func notifyAdmins(client *appclient.Client, adminIDs []string, message string) {
+	for _, adminID := range adminIDs {
+		if _, err := client.DMPost(adminID, &model.Post{Message: message}); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// channelAdminIDs lists the channel admins other than the bot.
+func channelAdminIDs(client *appclient.Client, channelID, botUserID string) ([]string, error) {
+	ids := []string{}
+	for page := 0; ; page++ {
+		members, _, err := client.GetChannelMembers(channelID, page, adminsPageSize, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.SchemeAdmin && member.UserId != botUserID {
+				ids = append(ids, member.UserId)
+			}
+		}
+		if len(members) < adminsPageSize {
+			return ids, nil
+		}
+	}
+}
+
+// teamAdminIDs lists the team admins other than the bot.
+func teamAdminIDs(client *appclient.Client, teamID, botUserID string) ([]string, error) {
+	ids := []string{}
+	for page := 0; ; page++ {
+		members, _, err := client.GetTeamMembers(teamID, page, adminsPageSize, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.SchemeAdmin && member.UserId != botUserID {
+				ids = append(ids, member.UserId)
+			}
+		}
+		if len(members) < adminsPageSize {
+			return ids, nil
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// FollowUp is a message of the drip campaign of a team, sent to new members
+// as a direct message some time after they joined.
+type FollowUp struct {
+	Message      string `json:"message"`
+	DelaySeconds int    `json:"delay_seconds"`
+}
+
+// Delay returns DelaySeconds as a time.Duration.
+func (f FollowUp) Delay() time.Duration {
+	return time.Duration(f.DelaySeconds) * time.Second
+}
+
+// Campaign is the state of the drip campaign of a member: when they joined,
+// how many follow-ups were sent, and when the campaign was canceled because
+// they left the team. The times are in milliseconds.
+type Campaign struct {
+	JoinedAt   int64 `json:"joined_at"`
+	Sent       int   `json:"sent"`
+	CanceledAt int64 `json:"canceled_at,omitempty"`
+}
+
+// SetFollowUpForm adds, replaces or removes a follow-up of the team's drip
+// campaign.
+var SetFollowUpForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			TextMaxLength:        model.PostMessageMaxRunesV2,
+			Name:                 "message",
+			ModalLabel:           "Message",
+			Description:          "The follow-up message, with template variables like the welcome. Leave empty to remove the follow-up.",
+			AutocompletePosition: -1,
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "delay",
+			Label:       "delay",
+			ModalLabel:  "Delay",
+			Description: "How long after joining the team the message is sent, e.g. 1d, 3d or 36h",
+		},
+		{
+			Type:        apps.FieldTypeText,
+			TextSubtype: apps.TextFieldSubtypeNumber,
+			Name:        "index",
+			Label:       "index",
+			ModalLabel:  "Follow-up number",
+			Description: "The number of the follow-up, a new one by default",
+		},
+	},
+	Submit: apps.NewCall("/set_follow_up").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandSummary,
+		TeamMember: apps.ExpandAll,
+	}),
+}
+
+func campaignKey(teamID string) string {
+	return "campaign_" + teamID
+}
+
+// GetCampaigns returns the drip campaigns of the members of the team, by user
+// ID.
+func (s *Store) GetCampaigns(teamID string) (map[string]Campaign, error) {
+	campaigns := map[string]Campaign{}
+	if err := s.kv.KVGet(KVAppPrefix, campaignKey(teamID), &campaigns); err != nil {
+		return nil, err
+	}
+	if campaigns == nil {
+		campaigns = map[string]Campaign{}
+	}
+	return campaigns, nil
+}
+
+// UpdateCampaign applies update to the campaign of the user in the team, and
+// stores it.
+func (s *Store) UpdateCampaign(teamID, userID string, update func(*Campaign)) error {
+	campaigns, err := s.GetCampaigns(teamID)
+	if err != nil {
+		return err
+	}
+	campaign := campaigns[userID]
+	update(&campaign)
+	campaigns[userID] = campaign
+
+	_, err = s.kv.KVSet(KVAppPrefix, campaignKey(teamID), campaigns)
+	return err
+}
+
+// DeleteCampaigns removes the drip campaigns of the team.
+func (s *Store) DeleteCampaigns(teamID string) error {
+	return s.kv.KVDelete(KVAppPrefix, campaignKey(teamID))
+}
+
+// parseFollowUpDelay parses a delay like parseDelay does, or as a number of
+// days like "3d".
+func parseFollowUpDelay(value string) (int, error) {
+	if strings.HasSuffix(value, "d") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && n >= 0 {
+			return n * 24 * 60 * 60, nil
+		}
+	}
+	seconds, err := parseDelay(value)
+	if err != nil {
+		return 0, errors.New("the delay must be a number of days like 3d, or a duration like 36h")
+	}
+	return seconds, nil
+}
+
+// followUpJobs starts the drip campaign of the new member, and returns the
+// jobs sending the rendered follow-ups to the direct channel with the bot.
+func followUpJobs(store *Store, channelID, teamID string, user *model.User, followUps []FollowUp, data TemplateData) []Job {
+	now := model.GetMillis()
+	err := store.UpdateCampaign(teamID, user.Id, func(campaign *Campaign) {
+		*campaign = Campaign{JoinedAt: now}
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+
+	jobs := []Job{}
+	for _, followUp := range followUps {
+		jobs = append(jobs, Job{
+			ID:        model.NewId(),
+			Kind:      JobKindFollowUp,
+			RunAt:     now + followUp.Delay().Milliseconds(),
+			ChannelID: channelID,
+			Message:   RenderWelcome(followUp.Message, data),
+			UserID:    user.Id,
+			TeamID:    teamID,
+		})
+	}
+	return jobs
+}
+
+// followUpJob sends the follow-up of a JobKindFollowUp job, unless the member
+// left the team in the meantime.
+func followUpJob(client *appclient.Client, store *Store, job Job) error {
+	campaigns, err := store.GetCampaigns(job.TeamID)
+	if err != nil {
+		return err
+	}
+	if campaigns[job.UserID].CanceledAt != 0 {
+		return nil
+	}
+
+	member, _, err := client.GetTeamMember(job.TeamID, job.UserID, "")
+	if err != nil || member.DeleteAt != 0 {
+		return store.UpdateCampaign(job.TeamID, job.UserID, func(campaign *Campaign) {
+			campaign.CanceledAt = model.GetMillis()
+		})
+	}
+
+	if err = postJob(client, job); err != nil {
+		return err
+	}
+
+	return store.UpdateCampaign(job.TeamID, job.UserID, func(campaign *Campaign) {
+		campaign.Sent++
+	})
+}
+
+// cancelCampaign stops the drip campaign of a member who left the team, and
+// drops their queued follow-ups.
+func cancelCampaign(ctx context.Context, cc apps.Context, store *Store, teamID, userID string) {
+	campaigns, err := store.GetCampaigns(teamID)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if campaign, ok := campaigns[userID]; !ok || campaign.CanceledAt != 0 {
+		return
+	}
+
+	err = store.UpdateCampaign(teamID, userID, func(campaign *Campaign) {
+		campaign.CanceledAt = model.GetMillis()
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+	err = scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.Kind == JobKindFollowUp && job.TeamID == teamID && job.UserID == userID
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+func SetFollowUpCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	message := c.GetValue("message", "")
+	if err := checkWelcomeLength(message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if _, err := RenderTemplate(message, TemplateData{}); err != nil {
+		httputils.WriteJSON(w, errorResponse("invalid template: %s", err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the follow-up"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+
+	index, err := strconv.Atoi(c.GetValue("index", strconv.Itoa(len(welcome.FollowUps)+1)))
+	if err != nil || index < 1 || index > len(welcome.FollowUps)+1 {
+		httputils.WriteJSON(w,
+			errorResponse("the follow-up number must be between 1 and %d", len(welcome.FollowUps)+1))
+		return
+	}
+
+	if message == "" {
+		if index > len(welcome.FollowUps) {
+			httputils.WriteJSON(w,
+				errorResponse("there is no follow-up %d to remove", index))
+			return
+		}
+		welcome.FollowUps = append(welcome.FollowUps[:index-1], welcome.FollowUps[index:]...)
+	} else {
+		if err = requireValues(c, "delay"); err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		delay, err := parseFollowUpDelay(c.GetValue("delay", ""))
+		if err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		followUp := FollowUp{Message: message, DelaySeconds: delay}
+		if index > len(welcome.FollowUps) {
+			welcome.FollowUps = append(welcome.FollowUps, followUp)
+		} else {
+			welcome.FollowUps[index-1] = followUp
+		}
+	}
+
+	if err = store.SetTeamWelcome(c.Context.Team.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the follow-up"))
+		return
+	}
+	// The campaigns are canceled when members leave the team.
+	if len(welcome.FollowUps) > 0 {
+		if err = SubscribeToTeamLeaves(asBot(req.Context(), c.Context), c.Context.Team.Id); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", followUpsSummary(welcome.FollowUps)))
+}
+
+// followUpsSummary lists the follow-ups of the team's drip campaign, in the
+// order they are sent.
+func followUpsSummary(followUps []FollowUp) string {
+	if len(followUps) == 0 {
+		return "The team has no follow-up messages."
+	}
+
+	order := make([]int, len(followUps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return followUps[order[i]].DelaySeconds < followUps[order[j]].DelaySeconds
+	})
+
+	message := "New members of the team will get these follow-up messages:\n"
+	for _, i := range order {
+		message += fmt.Sprintf("\n**Follow-up %d** (after %s)\n%s\n", i+1, followUps[i].Delay(), followUps[i].Message)
+	}
+	return message
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// AdminForgetForm removes everything the app stored about a user, e.g. to
+// honor a data deletion request once they left. Apps get no event when an
+// account is deactivated, so a system admin runs it.
+var AdminForgetForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "user",
+			ModalLabel:           "User",
+			Description:          "The username of the user to forget, e.g. @alice, or their ID if the account was deleted",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+		},
+	},
+	Submit: apps.NewCall("/admin/forget").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// forgetUserInMap removes the user from the map stored under the key, whose
+// values are by user ID. It reports whether there was anything to remove.
+func (s *Store) forgetUserInMap(key, userID string) (bool, error) {
+	records := map[string]json.RawMessage{}
+	if err := s.kv.KVGet(KVAppPrefix, key, &records); err != nil {
+		return false, err
+	}
+	if _, ok := records[userID]; !ok {
+		return false, nil
+	}
+	delete(records, userID)
+	_, err := s.kv.KVSet(KVAppPrefix, key, records)
+	return true, err
+}
+
+// forgetUserInDigest removes the user from the pending digest of the channel.
+// It reports whether they were in it.
+func (s *Store) forgetUserInDigest(channelID, userID string) (bool, error) {
+	digest, err := s.GetDigest(channelID)
+	if err != nil || digest == nil {
+		return false, err
+	}
+	userIDs := []string{}
+	for _, id := range digest.UserIDs {
+		if id != userID {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == len(digest.UserIDs) {
+		return false, nil
+	}
+	_, err = s.kv.KVSet(KVAppPrefix, digestKey(channelID), Digest{UserIDs: userIDs})
+	return true, err
+}
+
+// userRecordChannels returns the channels and teams whose data holds records
+// by user ID. The channels getting the default channel welcome of their team
+// are not indexed, but are subscribed to.
+func (s *Store) userRecordChannels() (channelIDs, teamIDs []string, err error) {
+	index, err := s.GetIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	subscribed, err := s.subscribedChannelIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, channelID := range subscribed {
+		if !seen[channelID] {
+			seen[channelID] = true
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	for _, entry := range index {
+		switch entry.Kind {
+		case IndexKindChannel:
+			if !seen[entry.ID] {
+				seen[entry.ID] = true
+				channelIDs = append(channelIDs, entry.ID)
+			}
+		case IndexKindTeam:
+			teamIDs = append(teamIDs, entry.ID)
+		}
+	}
+	return channelIDs, teamIDs, nil
+}
+
+// channelUserRecordKeys are the keys of the records the channel's welcome
+// keeps by user ID: acknowledgments, recommended channel joins, welcome times
+// and variant assignments.
+func channelUserRecordKeys(channelID string) []string {
+	return []string{acknowledgmentsKey(channelID), recommendedJoinsKey(channelID),
+		welcomedKey(channelID), variantsKey(channelID)}
+}
+
+// teamUserRecordKeys are the keys of the records the team's welcome keeps by
+// user ID: onboarding and checklist progress, drip campaigns and survey
+// responses.
+func teamUserRecordKeys(teamID string) []string {
+	return []string{onboardingKey(teamID), checklistKey(teamID),
+		campaignKey(teamID), surveyKey(teamID)}
+}
+
+// ForgetUser removes the records of the user from the data of the channels and
+// teams, and from the pending digests, as well as their guest promotion,
+// opt-out and failed deliveries. The statistics only hold counters, not users.
+// It returns the number of records removed.
+func (s *Store) ForgetUser(userID string) (int, error) {
+	channelIDs, teamIDs, err := s.userRecordChannels()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	found, err := s.forgetUserInDeadLetters(userID)
+	if err != nil {
+		return removed, err
+	}
+	if found {
+		removed++
+	}
+
+	keys := []string{guestsKey, optOutsKey}
+	for _, channelID := range channelIDs {
+		keys = append(keys, channelUserRecordKeys(channelID)...)
+
+		found, err := s.forgetUserInDigest(channelID, userID)
+		if err != nil {
+			return removed, err
+		}
+		if found {
+			removed++
+		}
+	}
+	for _, teamID := range teamIDs {
+		keys = append(keys, teamUserRecordKeys(teamID)...)
+	}
+	for _, key := range keys {
+		found, err := s.forgetUserInMap(key, userID)
+		if err != nil {
+			return removed, err
+		}
+		if found {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// AdminForgetCall removes the user's records and their queued jobs.
+func AdminForgetCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err := requireValues(c, "user"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	value := strings.TrimPrefix(strings.TrimSpace(c.GetValue("user", "")), "@")
+	client := asBot(req.Context(), c.Context)
+	userID, name := value, value
+	if user, _, err := client.GetUserByUsername(strings.ToLower(value), ""); err == nil {
+		userID, name = user.Id, "@"+user.Username
+	} else if !model.IsValidId(value) {
+		httputils.WriteJSON(w,
+			errorResponse("user @%s was not found", value))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	removed, err := store.ForgetUser(userID)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't remove all the data of %s: %s", name, err))
+		return
+	}
+	// Jobs about the user, and the direct messages queued for them.
+	dmID := ""
+	if dm, _, err := client.CreateDirectChannel(c.Context.BotUserID, userID); err == nil {
+		dmID = dm.Id
+	}
+	err = scheduler.Cancel(req.Context(), c.Context, func(job Job) bool {
+		return job.UserID == userID || (dmID != "" && job.ChannelID == dmID)
+	})
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Removed %d records of %s.", removed, name))
+}
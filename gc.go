@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// AdminGCForm reports the indexed channels and teams that no longer exist,
+// and purges their data with --purge.
+var AdminGCForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "purge",
+			Label:       "purge",
+			ModalLabel:  "Purge",
+			Description: "Remove the data of the channels and teams that no longer exist",
+		},
+	},
+	Submit: apps.NewCall("/admin/gc").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// indexKindNames describe the kinds of index entries in the gc report.
+var indexKindNames = map[string]string{
+	IndexKindChannel:         "channel welcome",
+	IndexKindTeam:            "team welcome",
+	IndexKindChannelFarewell: "channel farewell",
+	IndexKindTeamFarewell:    "team farewell",
+	IndexKindChannelDefault:  "default channel welcome",
+}
+
+// teamGone reports whether the team was archived or deleted.
+func teamGone(client *appclient.Client, teamID string) (bool, error) {
+	team, resp, err := client.GetTeam(teamID, "")
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		return false, err
+	}
+	return team.DeleteAt != 0, nil
+}
+
+// PurgeTeam removes everything stored for the team: its welcome, farewell and
+// default channel welcome, their index entries, statistics, campaigns and
+// survey responses.
+func (s *Store) PurgeTeam(teamID string) error {
+	keys := append(teamKeys(teamID), teamFarewellKey(teamID), channelDefaultKey(teamID))
+	for _, key := range keys {
+		if err := s.kv.KVDelete(KVAppPrefix, key); err != nil {
+			return err
+		}
+	}
+	if err := s.RemoveIndexEntry(IndexKindTeam, teamID); err != nil {
+		return err
+	}
+	if err := s.RemoveIndexEntry(IndexKindTeamFarewell, teamID); err != nil {
+		return err
+	}
+	if err := s.RemoveIndexEntry(IndexKindChannelDefault, teamID); err != nil {
+		return err
+	}
+	return s.DeleteStats(IndexKindTeam, teamID)
+}
+
+// purgeTeam removes the team's data, its subscriptions and its queued jobs.
+func purgeTeam(ctx context.Context, cc apps.Context, client *appclient.Client, store *Store, teamID string) error {
+	if err := store.PurgeTeam(teamID); err != nil {
+		return err
+	}
+	if err := UnsubscribeFromTeam(client, teamID); err != nil {
+		logger.Error(err)
+	}
+	if err := UnsubscribeFromTeamLeaves(client, teamID); err != nil {
+		logger.Error(err)
+	}
+	return scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.TeamID == teamID || (job.WelcomeKind == IndexKindTeam && job.WelcomeID == teamID)
+	})
+}
+
+// AdminGCCall checks that every channel and team of the index still exists,
+// and lists those that don't. With --purge, their data is removed too.
+func AdminGCCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	index, err := store.GetIndex()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the index"))
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	purge := c.BoolValue("purge")
+	orphans := []IndexEntry{}
+	gone := map[string]bool{}
+	unchecked := 0
+	for _, entry := range index {
+		isGone, checked := gone[entry.ID]
+		if !checked {
+			if entry.Kind == IndexKindTeam || entry.Kind == IndexKindTeamFarewell || entry.Kind == IndexKindChannelDefault {
+				isGone, err = teamGone(client, entry.ID)
+			} else {
+				isGone, err = channelGone(client, entry.ID)
+			}
+			if err != nil {
+				requestLogger(req).Error(err)
+				unchecked++
+				continue
+			}
+			gone[entry.ID] = isGone
+		}
+		if isGone {
+			orphans = append(orphans, entry)
+		}
+	}
+
+	message := ""
+	switch {
+	case len(orphans) == 0:
+		message = "All the channels and teams of the welcome messages still exist."
+	case purge:
+		message = fmt.Sprintf("Removed the data of %d welcome and farewell messages whose channel or team no longer exists:\n", len(orphans))
+	default:
+		message = fmt.Sprintf("Found %d welcome and farewell messages whose channel or team no longer exists. Use `admin gc --purge` to remove their data:\n", len(orphans))
+	}
+
+	purged := map[string]bool{}
+	for _, entry := range orphans {
+		message += fmt.Sprintf("* %s (%s)\n", entry.Name, indexKindNames[entry.Kind])
+		if !purge || purged[entry.ID] {
+			continue
+		}
+		purged[entry.ID] = true
+		if entry.Kind == IndexKindTeam || entry.Kind == IndexKindTeamFarewell || entry.Kind == IndexKindChannelDefault {
+			err = purgeTeam(req.Context(), c.Context, client, store, entry.ID)
+		} else {
+			err = purgeChannel(req.Context(), c.Context, client, store, entry.ID)
+		}
+		if err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w,
+				errorResponse("we couldn't remove the data of %s: %s", entry.Name, err))
+			return
+		}
+	}
+	if unchecked > 0 {
+		message += fmt.Sprintf("\n%d channels or teams couldn't be checked, see the app's logs.", unchecked)
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// maxGreeters caps the number of greeters of a channel, as each of them gets
+// a direct message for every new member.
+const maxGreeters = 10
+
+// SetGreetersForm sets the users notified when a new member joins the
+// channel, so they can follow up personally.
+var SetGreetersForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "greeters",
+			ModalLabel:           "Greeters",
+			Description:          "Usernames separated by spaces, e.g. @alice @bob. Leave empty to stop notifying anyone.",
+			AutocompletePosition: -1,
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_greeters").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// usernames returns the usernames of the users, skipping the ones that can't
+// be found.
+func usernames(client *appclient.Client, userIDs []string) []string {
+	names := []string{}
+	if len(userIDs) == 0 {
+		return names
+	}
+	users, _, err := client.GetUsersByIds(userIDs)
+	if err != nil {
+		logger.Error(err)
+		return names
+	}
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	return names
+}
+
+// userIDs returns the IDs of the users, by username. Users that can't be
+// found are reported in notes.
+func userIDs(client *appclient.Client, names []string, notes *[]string) []string {
+	ids := []string{}
+	for _, name := range names {
+		user, _, err := client.GetUserByUsername(name, "")
+		if err != nil {
+			*notes = append(*notes, fmt.Sprintf("user @%s was not found", name))
+			continue
+		}
+		ids = append(ids, user.Id)
+	}
+	return ids
+}
+
+// memberSummary describes the profile of the new member for the greeters.
+func memberSummary(user *model.User, channel *model.Channel) string {
+	summary := fmt.Sprintf("#### @%s joined ~%s\n", user.Username, channel.Name)
+	if name := user.GetFullName(); name != "" {
+		summary += fmt.Sprintf("\n* **Name:** %s", name)
+	}
+	if user.Nickname != "" {
+		summary += fmt.Sprintf("\n* **Nickname:** %s", user.Nickname)
+	}
+	if user.Position != "" {
+		summary += fmt.Sprintf("\n* **Position:** %s", user.Position)
+	}
+	if user.IsGuest() {
+		summary += "\n* **Guest account**"
+	}
+	timezone := user.Timezone["manualTimezone"]
+	if user.Timezone["useAutomaticTimezone"] == "true" {
+		timezone = user.Timezone["automaticTimezone"]
+	}
+	if timezone != "" {
+		summary += fmt.Sprintf("\n* **Time zone:** %s", timezone)
+	}
+	if user.Locale != "" {
+		summary += fmt.Sprintf("\n* **Language:** %s", user.Locale)
+	}
+	summary += fmt.Sprintf("\n* **Account created:** %s", formatMillis(user.CreateAt))
+	return summary + "\n\nSend them a direct message to say hi!"
+}
+
+// notifyGreeters sends the profile summary of the new member to each greeter
+// of the channel, as a direct message from the bot. The greeter who joined
+// isn't notified of themselves.
+func notifyGreeters(client *appclient.Client, botUserID string, channel *model.Channel, user *model.User, greeters []string) {
+	message := memberSummary(user, channel)
+	for _, greeterID := range greeters {
+		if greeterID == user.Id {
+			continue
+		}
+		dm, _, err := client.CreateDirectChannel(botUserID, greeterID)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		_, err = createPost(client, &model.Post{
+			ChannelId: dm.Id,
+			Message:   message,
+		})
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+func SetGreetersCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	names := []string{}
+	for _, name := range strings.Fields(c.GetValue("greeters", "")) {
+		names = append(names, strings.ToLower(strings.TrimPrefix(name, "@")))
+	}
+	if len(names) > maxGreeters {
+		httputils.WriteJSON(w,
+			errorResponse("a channel can have up to %d greeters, got %d", maxGreeters, len(names)))
+		return
+	}
+	notes := []string{}
+	greeters := userIDs(asActingUser(req.Context(), cc), names, &notes)
+	if len(notes) > 0 {
+		httputils.WriteJSON(w,
+			errorResponse("%s", strings.Join(notes, ", ")))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the greeters"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.Greeters = greeters
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the greeters"))
+		return
+	}
+
+	if len(greeters) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Nobody will be notified of the new members of ~%s.", cc.Channel.Name))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("@%s will be notified of the new members of ~%s.", strings.Join(names, ", @"), cc.Channel.Name))
+}
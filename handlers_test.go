@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// fakeMattermost serves the requests the handlers make to Mattermost: it
+// creates the posts, direct channels and team members asked for, accepts the
+// subscriptions, lists none, looks up its teams, channels and users, and
+// answers 404 to the rest. It records the requests made.
+type fakeMattermost struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+	posts    []*model.Post
+
+	// teams and channels are looked up by ID or name, users by ID or by
+	// their access token, the key of users.
+	teams    []*model.Team
+	channels []*model.Channel
+	users    map[string]*model.User
+}
+
+func newFakeMattermost(t *testing.T) *fakeMattermost {
+	fake := &fakeMattermost{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeMattermost) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req.Method+" "+req.URL.Path)
+
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path == "/api/v4/posts":
+		post := &model.Post{}
+		_ = json.Unmarshal(body, post)
+		post.Id = model.NewId()
+		f.posts = append(f.posts, post)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(post)
+	case req.Method == http.MethodPost && req.URL.Path == "/api/v4/channels/direct":
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(&model.Channel{Id: model.NewId(), Type: model.ChannelTypeDirect})
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/members"):
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("{}"))
+	case req.Method == http.MethodPost && req.URL.Path == "/plugins/com.mattermost.apps/api/v1/subscribe":
+		_, _ = w.Write([]byte("{}"))
+	case req.Method == http.MethodGet && req.URL.Path == "/plugins/com.mattermost.apps/api/v1/subscribe":
+		_, _ = w.Write([]byte("[]"))
+	case req.Method == http.MethodPost && req.URL.Path == "/api/v4/users/ids":
+		var ids []string
+		_ = json.Unmarshal(body, &ids)
+		found := []*model.User{}
+		for _, id := range ids {
+			for _, user := range f.users {
+				if user.Id == id {
+					found = append(found, user)
+				}
+			}
+		}
+		f.found(w, found)
+	case req.Method == http.MethodGet && req.URL.Path == "/api/v4/users/me":
+		f.found(w, f.users[strings.TrimPrefix(req.Header.Get(model.HeaderAuth), model.HeaderToken+" ")])
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/api/v4/teams/name/"):
+		name := strings.TrimPrefix(req.URL.Path, "/api/v4/teams/name/")
+		var found *model.Team
+		for _, team := range f.teams {
+			if team.Name == name {
+				found = team
+			}
+		}
+		f.found(w, found)
+	case req.Method == http.MethodGet && strings.Contains(req.URL.Path, "/channels/name/"):
+		teamPath, name, _ := strings.Cut(req.URL.Path, "/channels/name/")
+		var found *model.Channel
+		for _, channel := range f.channels {
+			if channel.Name == name && "/api/v4/teams/"+channel.TeamId == teamPath {
+				found = channel
+			}
+		}
+		f.found(w, found)
+	case req.Method == http.MethodGet && strings.Count(req.URL.Path, "/") == 4 && strings.HasPrefix(req.URL.Path, "/api/v4/teams/"):
+		var found *model.Team
+		for _, team := range f.teams {
+			if "/api/v4/teams/"+team.Id == req.URL.Path {
+				found = team
+			}
+		}
+		f.found(w, found)
+	case req.Method == http.MethodGet && strings.Count(req.URL.Path, "/") == 4 && strings.HasPrefix(req.URL.Path, "/api/v4/channels/"):
+		var found *model.Channel
+		for _, channel := range f.channels {
+			if "/api/v4/channels/"+channel.Id == req.URL.Path {
+				found = channel
+			}
+		}
+		f.found(w, found)
+	default:
+		f.found(w, nil)
+	}
+}
+
+// found answers with the value, or 404 if it is nil.
+func (f *fakeMattermost) found(w http.ResponseWriter, value interface{}) {
+	if value == nil || reflect.ValueOf(value).IsNil() {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"id":"app.not_found","status_code":404}`))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// made reports whether a request was made to the method and path.
+func (f *fakeMattermost) made(request string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.requests {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+// useMemoryBackend keeps the data of the stores in memory for the length of
+// the test.
+func useMemoryBackend(t *testing.T) {
+	previous := backend
+	backend = NewMemoryBackend()
+	t.Cleanup(func() {
+		backend = previous
+	})
+}
+
+// callContext returns the context of a call made to the fake server by the
+// user, in the team.
+func callContext(fake *fakeMattermost, user *model.User, team *model.Team) apps.Context {
+	cc := apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			ActingUser:            user,
+			MattermostSiteURL:     fake.URL,
+			BotUserID:             "bot",
+			BotAccessToken:        "bot-token",
+			ActingUserAccessToken: "user-token",
+			Team:                  team,
+		},
+	}
+	return cc
+}
+
+// call serves the call request with the handler, and returns its response.
+func call(t *testing.T, handler http.HandlerFunc, c apps.CallRequest) apps.CallResponse {
+	t.Helper()
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/call", bytes.NewReader(data)))
+
+	resp := apps.CallResponse{}
+	if err = json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestMemoryBackendKeepsSitesApart(t *testing.T) {
+	b := NewMemoryBackend()
+	if _, err := b.KV("https://a.example.com").KVSet(KVAppPrefix, "key", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := b.KV("https://b.example.com").KVGet(KVAppPrefix, "key", &got); err != nil || got != "" {
+		t.Errorf("the other site reads %q, %v", got, err)
+	}
+	if err := b.KV("https://a.example.com").KVGet(KVAppPrefix, "key", &got); err != nil || got != "a" {
+		t.Errorf("the site reads %q, %v, want a", got, err)
+	}
+}
+
+func TestSetTeamWelcomeCall(t *testing.T) {
+	team := &model.Team{Id: "team", Name: "team", DisplayName: "Team"}
+	admin := &model.User{Id: "admin", Username: "admin", Roles: model.SystemAdminRoleId + " " + model.SystemUserRoleId}
+	member := &model.User{Id: "member", Username: "member", Roles: model.SystemUserRoleId}
+
+	tests := []struct {
+		name   string
+		user   *model.User
+		team   *model.Team
+		values map[string]interface{}
+		error  string
+	}{
+		{name: "no team", user: admin, values: map[string]interface{}{"message": "Hi"}, error: "team"},
+		{name: "not a manager", user: member, team: team, values: map[string]interface{}{"message": "Hi"}, error: "can manage"},
+		{name: "no message", user: admin, team: team, values: map[string]interface{}{}, error: "message is required"},
+		{name: "guest variant first", user: admin, team: team, values: map[string]interface{}{"message": "Hi", "guest": true}, error: "has no welcome message"},
+		{name: "stored", user: admin, team: team, values: map[string]interface{}{"message": "Welcome to {{.Team}}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryBackend(t)
+			fake := newFakeMattermost(t)
+			cc := callContext(fake, tt.user, tt.team)
+
+			resp := call(t, SetTeamWelcomeCall, apps.CallRequest{Context: cc, Values: tt.values})
+			if tt.error != "" {
+				if resp.Type != apps.CallResponseTypeError || !strings.Contains(resp.Text, tt.error) {
+					t.Errorf("response = %s %q, want an error about %q", resp.Type, resp.Text, tt.error)
+				}
+				if tt.team != nil {
+					if welcome, _ := NewStore(context.Background(), cc).GetTeamWelcome(tt.team.Id); welcome != nil {
+						t.Error("the welcome was stored")
+					}
+				}
+				return
+			}
+
+			if resp.Type != apps.CallResponseTypeOK {
+				t.Fatalf("response = %s %q", resp.Type, resp.Text)
+			}
+			store := NewStore(context.Background(), cc)
+			welcome, err := store.GetTeamWelcome(team.Id)
+			if err != nil || welcome == nil || welcome.Message != "Welcome to {{.Team}}" {
+				t.Errorf("the stored welcome is %+v, %v", welcome, err)
+			}
+			if !fake.made("POST /api/v4/teams/team/members") {
+				t.Error("the bot wasn't added to the team")
+			}
+			if !fake.made("POST /plugins/com.mattermost.apps/api/v1/subscribe") {
+				t.Error("the app didn't subscribe to the team's joins")
+			}
+			index, err := store.GetIndex()
+			if err != nil || len(index) != 1 || index[0].ID != team.Id {
+				t.Errorf("the index is %+v, %v", index, err)
+			}
+		})
+	}
+}
+
+func TestGetTeamWelcomeCall(t *testing.T) {
+	useMemoryBackend(t)
+	fake := newFakeMattermost(t)
+	team := &model.Team{Id: "team", Name: "team"}
+	cc := callContext(fake, &model.User{Id: "member", Roles: model.SystemUserRoleId}, team)
+
+	resp := call(t, GetTeamWelcomeCall, apps.CallRequest{Context: cc})
+	if resp.Type != apps.CallResponseTypeOK || strings.Contains(resp.Text, "Hello") {
+		t.Errorf("response without a welcome = %s %q", resp.Type, resp.Text)
+	}
+
+	if err := NewStore(context.Background(), cc).SetTeamWelcome(team.Id, TeamWelcome{Message: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	resp = call(t, GetTeamWelcomeCall, apps.CallRequest{Context: cc})
+	if resp.Type != apps.CallResponseTypeOK || !strings.Contains(resp.Text, "Hello") {
+		t.Errorf("response = %s %q, want the welcome", resp.Type, resp.Text)
+	}
+}
+
+func TestUserJoinedTeamCallIgnoresTheBot(t *testing.T) {
+	useMemoryBackend(t)
+	fake := newFakeMattermost(t)
+	team := &model.Team{Id: "team", Name: "team"}
+	cc := callContext(fake, nil, team)
+	cc.User = &model.User{Id: cc.BotUserID}
+	if err := NewStore(context.Background(), cc).SetTeamWelcome(team.Id, TeamWelcome{Message: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := call(t, UserJoinedTeamCall, apps.CallRequest{Context: cc})
+	if resp.Type != apps.CallResponseTypeOK {
+		t.Errorf("response = %s %q", resp.Type, resp.Text)
+	}
+	if fake.made("POST /api/v4/channels/direct") {
+		t.Error("the bot was welcomed")
+	}
+}
+
+func TestDecodeCallRejectsTrailingData(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetTeamWelcomeCall(w, httptest.NewRequest(http.MethodPost, "/call", strings.NewReader(`{} {}`)))
+
+	resp := apps.CallResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != apps.CallResponseTypeError || !strings.Contains(resp.Text, "unexpected data") {
+		t.Errorf("response = %s %q", resp.Type, resp.Text)
+	}
+}
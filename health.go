@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The paths of the liveness and readiness probes.
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// HealthzHandler reports that the process is alive.
+func HealthzHandler(w http.ResponseWriter, req *http.Request) {
+	httputils.WriteJSON(w, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler reports whether the app can serve calls: its manifest must be
+// valid, and the Mattermost server and the storage backend must be reachable.
+// The server is only known once it made a call to the app, e.g. to install it,
+// so until then its check is skipped rather than failed, as failing would keep
+// the app from ever being installed.
+func ReadyzHandler(w http.ResponseWriter, req *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if err := checkManifest(); err != nil {
+		checks["manifest"] = err.Error()
+		ready = false
+	} else {
+		checks["manifest"] = "ok"
+	}
+
+	if cc, ok := scheduler.Context(); !ok || cc.MattermostSiteURL == "" {
+		checks["mattermost"] = "unknown, no call received yet"
+	} else if _, _, err := model.NewAPIv4Client(cc.MattermostSiteURL).GetPing(); err != nil {
+		checks["mattermost"] = fmt.Sprintf("unreachable: %s", err)
+		ready = false
+	} else {
+		checks["mattermost"] = "ok"
+	}
+
+	if backend != nil {
+		if err := backend.Ping(); err != nil {
+			checks["storage"] = fmt.Sprintf("unreachable: %s", err)
+			ready = false
+		} else {
+			checks["storage"] = "ok"
+		}
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	httputils.WriteJSONStatus(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// checkManifest makes sure the served manifest decodes and validates, as
+// Mattermost does when installing the app.
+func checkManifest() error {
+	data, err := json.Marshal(Manifest)
+	if err != nil {
+		return err
+	}
+	m, err := apps.DecodeCompatibleManifest(data)
+	if err != nil {
+		return err
+	}
+	return m.Validate()
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// helpEntry documents a subcommand: its arguments, which are the same in
+// every language, and what it does.
+type helpEntry struct {
+	args    string
+	message *i18n.Message
+}
+
+// helpEntries documents the subcommands by their path in the command, e.g.
+// "survey enable". The subcommands without an entry are left out of the help.
+var helpEntries = map[string]helpEntry{
+	"help": {
+		message: &i18n.Message{
+			ID:    "help_help",
+			Other: "show the commands you can use",
+		},
+	},
+	"optout": {
+		args: " [--undo]",
+		message: &i18n.Message{
+			ID:    "help_optout",
+			Other: "stop the direct messages of the Welcome Bot to you, or get them again with `--undo`",
+		},
+	},
+	"preview": {
+		args: " [team-name] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_preview",
+			Other: "preview the welcome message for the current or given channel, or for the given team name. The welcome is posted in the current channel, only visible to you, exactly as new members will see it, rendered for the current user.",
+		},
+	},
+	"list": {
+		args: " [page]",
+		message: &i18n.Message{
+			ID:    "help_list",
+			Other: "list the channels and teams for which welcome or farewell messages were defined",
+		},
+	},
+	"set_channel_welcome": {
+		args: " [welcome-message] [--channel channel] [--index n] [--delay duration] [--locale locale] [--guest] [--added] [--check_links] [--dry_run]",
+		message: &i18n.Message{
+			ID:    "help_set_channel_welcome",
+			Other: "set the welcome message for the current or given channel. Channels can have a sequence of messages: use `--index` to set the n-th one, and `--delay` to wait before posting it, e.g. `--delay 10m`. Use `--locale` to set the variant sent to members using that language, e.g. `--locale es`, `--guest` to set the variant sent to guest accounts, and `--added` to set the variant sent to members added by someone else. Direct channels are not supported.",
+		},
+	},
+	"get_channel_welcome": {
+		message: &i18n.Message{
+			ID:    "help_get_channel_welcome",
+			Other: "print the welcome message set for the current channel (if any)",
+		},
+	},
+	"delete_channel_welcome": {
+		args: " [--index n] [--locale locale] [--guest] [--added] [--dry_run]",
+		message: &i18n.Message{
+			ID:    "help_delete_channel_welcome",
+			Other: "delete the welcome message for the current channel (if any), or only its n-th message, or only a language, guest or added members variant, after confirming it in a dialog",
+		},
+	},
+	"clone": {
+		args: " --from channel [--to channel]",
+		message: &i18n.Message{
+			ID:    "help_clone",
+			Other: "copy the welcome message of a channel to the current or given channel, replacing its welcome message",
+		},
+	},
+	"set_recommended_channels": {
+		args: " [channel-names] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_recommended_channels",
+			Other: "offer new members of the current or given channel buttons to join these channels, under the last welcome message",
+		},
+	},
+	"set_links": {
+		args: " [links] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_links",
+			Other: "add buttons opening external pages under the last welcome message of the current or given channel. The links are like `Employee Handbook: https://example.com/handbook`, separated by semicolons. Leave them empty to remove the buttons.",
+		},
+	},
+	"set_acknowledgment": {
+		args: " [label] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_acknowledgment",
+			Other: "ask new members of the current or given channel to click a button with this label under the last welcome message, e.g. \"I've read the guidelines\"",
+		},
+	},
+	"ack_report": {
+		args: " [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_ack_report",
+			Other: "show who has and hasn't acknowledged the welcome message of the current or given channel",
+		},
+	},
+	"set_digest": {
+		args: " [window] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_digest",
+			Other: "welcome the new members of the current or given channel together, in a single post every `window`, e.g. `15m`. Use `0` to welcome each member right away.",
+		},
+	},
+	"set_mention": {
+		args: " [on|off] [--react] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_mention",
+			Other: "@-mention new members of the current or given channel in the first welcome message, so it shows in their mentions. With `--react`, the bot also reacts with :wave: to their first message in the channel.",
+		},
+	},
+	"set_attachment": {
+		args: " [--title title] [--text text] [--image url] [--fields fields] [--index n] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_attachment",
+			Other: "show an attachment under a message of the welcome of the current or given channel, e.g. a banner image with quick links. It can also have a `--title_link`, a `--color` like `#2389d7`, a `--thumbnail`, and an `--author` with an `--author_icon` and `--author_link`. The fields are like `Handbook: https://example.com/handbook`, separated by semicolons. The title, text, author and fields support template variables. Use `--remove` to remove the attachment.",
+		},
+	},
+	"set_delivery": {
+		args: " [channel|dm|ephemeral|thread] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_delivery",
+			Other: "post the welcome message of the current or given channel in the channel (the default), send it to new members as a direct message from the bot, post it in the channel visible to the new member only, or post it as a reply in a single welcome thread of the channel, which the bot starts if there is none. Ephemeral messages are lost if the member is offline when they are sent.",
+		},
+	},
+	"set_pin": {
+		args: " [on|off] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_pin",
+			Other: "keep a pinned channel guide post with the welcome messages of the current or given channel, updated whenever they change, so earlier members can find them too",
+		},
+	},
+	"set_greeters": {
+		args: " [usernames] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_greeters",
+			Other: "send these users a direct message with the profile of each new member of the current or given channel, so they can follow up personally, e.g. `@alice @bob`. Leave them empty to stop notifying anyone.",
+		},
+	},
+	"set_variant": {
+		args: " [message] --name name [--weight n] [--selection random|round_robin] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_variant",
+			Other: "add a variant of the first welcome message of the current or given channel, to compare how well each text does. Each new member gets one of the variants, picked at random in proportion to their weights, or in turns with `--selection round_robin`. Leave the message empty to remove the variant. Digests don't use the variants.",
+		},
+	},
+	"variant_report": {
+		args: " [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_variant_report",
+			Other: "show how many new members of the current or given channel got each variant, and how many of them acknowledged the welcome and joined a recommended channel",
+		},
+	},
+	"set_rotation": {
+		args: " [messages] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_rotation",
+			Other: "send new members of the current or given channel one of these alternative texts of the first welcome message, or the message itself, at random, so frequent joiners don't always read the same text. The texts are separated by a line with `---`. Leave them empty to always send the same text.",
+		},
+	},
+	"history": {
+		args: " [--channel channel] [--team]",
+		message: &i18n.Message{
+			ID:    "help_history",
+			Other: "show who created, updated or deleted the welcome message of the current or given channel, or of the current team with `--team`, and when, for the last 20 changes",
+		},
+	},
+	"rollback": {
+		args: " [revision] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_rollback",
+			Other: "restore the welcome message of the current or given channel as it was after a revision shown by `history`, by default the one before the last change. The restore can be rolled back too.",
+		},
+	},
+	"toggle": {
+		args: " [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_toggle",
+			Other: "pause the welcome message of the current or given channel, e.g. while importing many users, or resume it if it is paused. The message is kept.",
+		},
+	},
+	"send": {
+		args: " [@user] [--channel channel] [--team]",
+		message: &i18n.Message{
+			ID:    "help_send",
+			Other: "send the welcome message of the current or given channel, or of the team with `--team`, to a member again, e.g. if they missed it or joined before it was set.",
+		},
+	},
+	"test": {
+		args: " [--channel channel] [--team]",
+		message: &i18n.Message{
+			ID:    "help_test",
+			Other: "send yourself the welcome message of the current or given channel, or of the team with `--team`, with its delays, delivery and buttons, exactly as a new member would get it.",
+		},
+	},
+	"broadcast": {
+		args: " [--channel channel] [--dry_run]",
+		message: &i18n.Message{
+			ID:    "help_broadcast",
+			Other: "send the welcome message of the current or given channel to all its members as direct messages, after confirming it, e.g. to roll out a new welcome. System admins only.",
+		},
+	},
+	"set_inheritance": {
+		args: " [override|append] [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_set_inheritance",
+			Other: "set whether the welcome of the current or given channel replaces the team's default channel welcome, or is posted after it",
+		},
+	},
+	"set_team_welcome": {
+		args: " [welcome-message] [--guest] [--added] [--promotion] [--check_links] [--dry_run]",
+		message: &i18n.Message{
+			ID:    "help_set_team_welcome",
+			Other: "set the welcome message sent as a direct message to new members of the current team, or its variant for guest accounts with `--guest`, or for members added by someone else with `--added`, or the message sent to guests once they are promoted to members with `--promotion`",
+		},
+	},
+	"set_interests": {
+		args: " [interests]",
+		message: &i18n.Message{
+			ID:    "help_set_interests",
+			Other: "ask new members of the current team to pick an interest under the team welcome, and add them to its channels. The interests are like `Frontend: web design`, with the names of their channels, separated by semicolons. Leave them empty to remove the picker.",
+		},
+	},
+	"onboarding start": {
+		message: &i18n.Message{
+			ID:    "help_onboarding_start",
+			Other: "start or resume your own onboarding",
+		},
+	},
+	"onboarding status": {
+		message: &i18n.Message{
+			ID:    "help_onboarding_status",
+			Other: "show how far the members of the current team went in their onboarding",
+		},
+	},
+	"onboarding enable": {
+		message: &i18n.Message{
+			ID:    "help_onboarding_enable",
+			Other: "offer new members of the current team, after the team welcome, to complete their profile, choose their notifications and join channels in a few forms",
+		},
+	},
+	"onboarding disable": {
+		message: &i18n.Message{
+			ID:    "help_onboarding_disable",
+			Other: "stop offering the onboarding to new members of the current team",
+		},
+	},
+	"set_checklist": {
+		args: " [items]",
+		message: &i18n.Message{
+			ID:    "help_set_checklist",
+			Other: "post a checklist to new members of the current team after the team welcome, which they tick off as they go. The items are separated by semicolons, and can end with what completes them: `avatar` and `profile` are checked against the member's profile, and a `~channel` joins it, e.g. `Set your avatar: avatar; Join the announcements: ~announcements; Read the guidelines`. Leave them empty to remove the checklist.",
+		},
+	},
+	"checklist_report": {
+		message: &i18n.Message{
+			ID:    "help_checklist_report",
+			Other: "show how many members of the current team completed the checklist, and who is still on it",
+		},
+	},
+	"set_follow_up": {
+		args: " [message] --delay 3d [--index n]",
+		message: &i18n.Message{
+			ID:    "help_set_follow_up",
+			Other: "add a follow-up message to the drip campaign of the current team, sent to new members as a direct message the given time after they joined, e.g. `1d`, `3d` or `7d`. Pass `--index` to replace a follow-up, or to remove it with an empty message. The campaign of a member stops when they leave the team.",
+		},
+	},
+	"set_milestone": {
+		args: " [message] --after 1y [--channel channel] [--index n]",
+		message: &i18n.Message{
+			ID:    "help_set_milestone",
+			Other: "add a milestone to the current team, congratulating members the given time after they joined, e.g. `1w`, `30d` or `1y`, in a direct message or in the channel given with `--channel`. Pass `--index` to replace a milestone, or to remove it with an empty message. The milestones of a member are dropped when they leave the team.",
+		},
+	},
+	"survey enable": {
+		args: " --delay 7d [--question text]",
+		message: &i18n.Message{
+			ID:    "help_survey_enable",
+			Other: "ask new members of the current team to rate their start and leave a comment, the given time after they joined",
+		},
+	},
+	"survey disable": {
+		message: &i18n.Message{
+			ID:    "help_survey_disable",
+			Other: "stop surveying new members of the current team, keeping the responses",
+		},
+	},
+	"survey report": {
+		message: &i18n.Message{
+			ID:    "help_survey_report",
+			Other: "show the average rating, how the ratings spread and the latest comments of the current team's survey",
+		},
+	},
+	"get_team_welcome": {
+		message: &i18n.Message{
+			ID:    "help_get_team_welcome",
+			Other: "print the welcome message set for the current team (if any)",
+		},
+	},
+	"delete_team_welcome": {
+		args: " [--dry_run]",
+		message: &i18n.Message{
+			ID:    "help_delete_team_welcome",
+			Other: "delete the welcome message for the current team (if any)",
+		},
+	},
+	"set_channel_default": {
+		args: " [welcome-message] [--index n] [--delay duration]",
+		message: &i18n.Message{
+			ID:    "help_set_channel_default",
+			Other: "set the default welcome of the current team's public channels, which new members of the channels without a welcome of their own get. The bot joins the team's public channels.",
+		},
+	},
+	"delete_channel_default": {
+		message: &i18n.Message{
+			ID:    "help_delete_channel_default",
+			Other: "delete the default channel welcome of the current team (if any)",
+		},
+	},
+	"set_channel_farewell": {
+		args: " [farewell-message] [--channel channel] [--mode post|notify]",
+		message: &i18n.Message{
+			ID:    "help_set_channel_farewell",
+			Other: "set the message posted in the current or given channel when a member leaves it, or sent to the channel admins with `--mode notify`",
+		},
+	},
+	"delete_channel_farewell": {
+		args: " [--channel channel]",
+		message: &i18n.Message{
+			ID:    "help_delete_channel_farewell",
+			Other: "delete the farewell message of the current or given channel",
+		},
+	},
+	"set_team_farewell": {
+		args: " [farewell-message]",
+		message: &i18n.Message{
+			ID:    "help_set_team_farewell",
+			Other: "set the message sent to the team admins when a member leaves the current team",
+		},
+	},
+	"delete_team_farewell": {
+		message: &i18n.Message{
+			ID:    "help_delete_team_farewell",
+			Other: "delete the farewell message of the current team",
+		},
+	},
+	"set_server_welcome": {
+		args: " [welcome-message] [--check_links] [--dry_run]",
+		message: &i18n.Message{
+			ID:    "help_set_server_welcome",
+			Other: "set the message sent in a direct message to every new account of the server, whichever teams they join, e.g. a compliance notice. System admins only.",
+		},
+	},
+	"get_server_welcome": {
+		message: &i18n.Message{
+			ID:    "help_get_server_welcome",
+			Other: "print the server welcome message (if any). System admins only.",
+		},
+	},
+	"delete_server_welcome": {
+		message: &i18n.Message{
+			ID:    "help_delete_server_welcome",
+			Other: "delete the server welcome message (if any). System admins only.",
+		},
+	},
+	"export": {
+		message: &i18n.Message{
+			ID:    "help_export",
+			Other: "get all the welcome and farewell messages as a JSON file, in a direct message",
+		},
+	},
+	"import": {
+		args: " [config] [--file link] [--dry_run]",
+		message: &i18n.Message{
+			ID:    "help_import",
+			Other: "import an export, or the team welcome messages configured in the Welcome Bot plugin, pasted or from the file attached to the linked post",
+		},
+	},
+	"set_required_role": {
+		args: " [channel_admin|team_admin|system_admin]",
+		message: &i18n.Message{
+			ID:    "help_set_required_role",
+			Other: "set the role required to manage welcome messages",
+		},
+	},
+	"set_excluded_users": {
+		args: " [patterns]",
+		message: &i18n.Message{
+			ID:    "help_set_excluded_users",
+			Other: "never welcome the users whose username matches one of these patterns, e.g. `svc-* *-test`. Bots and deactivated users are never welcomed.",
+		},
+	},
+	"set_rejoin_window": {
+		args: " [days]",
+		message: &i18n.Message{
+			ID:    "help_set_rejoin_window",
+			Other: "don't welcome again the members who leave a channel and rejoin it within this many days of their welcome, 30 by default. Use `0` to welcome them every time.",
+		},
+	},
+	"set_retention": {
+		args: " [days]",
+		message: &i18n.Message{
+			ID:    "help_set_retention",
+			Other: "remove the acknowledgments, onboarding and checklist progress, survey responses and other records of members this many days after their last update, e.g. `90`. The delivery statistics are kept. Use `0` to keep the records forever, the default.",
+		},
+	},
+	"admin disable": {
+		message: &i18n.Message{
+			ID:    "help_admin_disable",
+			Other: "pause all the welcome messages across the server, e.g. during an incident. The messages are kept.",
+		},
+	},
+	"admin enable": {
+		message: &i18n.Message{
+			ID:    "help_admin_enable",
+			Other: "resume all the welcome messages across the server",
+		},
+	},
+	"admin gc": {
+		args: " [--purge]",
+		message: &i18n.Message{
+			ID:    "help_admin_gc",
+			Other: "list the welcome and farewell messages whose channel or team was archived or deleted, e.g. after a reorganization, and remove their data with `--purge`",
+		},
+	},
+	"admin forget": {
+		args: " [@user]",
+		message: &i18n.Message{
+			ID:    "help_admin_forget",
+			Other: "remove everything the Welcome Bot stored about the user, e.g. their acknowledgments, onboarding and survey responses, to honor a data deletion request. Pass the user ID if the account was deleted.",
+		},
+	},
+	"admin dead_letters": {
+		args: " [--retry] [--clear]",
+		message: &i18n.Message{
+			ID:    "help_admin_dead_letters",
+			Other: "list the welcome messages whose delivery failed after being retried, e.g. during an outage, and queue them again with `--retry` or drop them with `--clear`",
+		},
+	},
+	"snippet set": {
+		args: " [name] [text]",
+		message: &i18n.Message{
+			ID:    "help_snippet_set",
+			Other: "set a text shared by the welcome messages, e.g. the code of conduct, which they insert with `{{include \"name\"}}`. System admins only.",
+		},
+	},
+	"snippet delete": {
+		args: " [name]",
+		message: &i18n.Message{
+			ID:    "help_snippet_delete",
+			Other: "delete a snippet, the welcome messages including it leave it out. System admins only.",
+		},
+	},
+	"snippet list": {
+		message: &i18n.Message{
+			ID:    "help_snippet_list",
+			Other: "list the snippets the welcome messages can include",
+		},
+	},
+	"stats": {
+		args: " [--reset]",
+		message: &i18n.Message{
+			ID:    "help_stats",
+			Other: "show how many welcome messages were sent, failed and skipped in each channel and team, then reset the counters with `--reset`",
+		},
+	},
+}
+
+var msgHelpTemplates = &i18n.Message{
+	ID:    "help_templates",
+	Other: "Welcome messages can use the {{.Variables}} variables. Use {{.IsAdmin}} and {{.IsGuest}} to address system admins or guest accounts only. The {{.Functions}} functions format the variables, e.g. {{.TitleExample}}, {{.DateExample}} or {{.LinkExample}}. In digest mode, {{.Mentions}} mentions all the members welcomed together. Snippets shared by the welcomes are inserted with {{.Include}}.",
+}
+
+// helpTemplateData fills in msgHelpTemplates. The template syntax can't be in
+// the message itself, as it would be executed when localizing it.
+var helpTemplateData = map[string]interface{}{
+	"Variables":    "`{{.UserName}}`, `{{.NickName}}`, `{{.FirstName}}`, `{{.LastName}}`, `{{.FullName}}`, `{{.DisplayName}}`, `{{.ChannelName}}`, `{{.ChannelDisplayName}}`, `{{.TeamName}}`, `{{.TeamDisplayName}}`, `{{.Greeting}}`, `{{.AddedBy}}`",
+	"IsAdmin":      "`{{if .IsAdmin}}...{{end}}`",
+	"IsGuest":      "`{{if .IsGuest}}...{{end}}`",
+	"Functions":    "`upper`, `lower`, `title`, `trim`, `default`, `trunc`, `now`, `date`, `link`, `channel`, `mention`",
+	"TitleExample": "`{{title (default \"friend\" .FirstName)}}`",
+	"DateExample":  "`{{date \"January 2\" now}}`",
+	"LinkExample":  "`{{link \"the handbook\" \"https://example.com\"}}`",
+	"Mentions":     "`{{.Mentions}}`",
+	"Include":      "`{{include \"name\"}}`",
+}
+
+// commandHelp lists the subcommands of the command the acting user can use,
+// in the order of the bindings and in the acting user's language.
+func commandHelp(cc apps.Context, p bindingPermissions) string {
+	lines := []string{}
+	var add func(command, path string, bindings []apps.Binding)
+	add = func(command, path string, bindings []apps.Binding) {
+		for _, b := range bindings {
+			if len(b.Bindings) > 0 {
+				add(command, strings.TrimSpace(path+" "+b.Label), b.Bindings)
+				continue
+			}
+			key := strings.TrimSpace(path + " " + b.Label)
+			entry, ok := helpEntries[key]
+			if !ok {
+				continue
+			}
+			lines = append(lines, "* `/"+command+" "+key+entry.args+"` - "+T(cc, entry.message, nil))
+		}
+	}
+	for _, command := range commandBinding(p).Bindings {
+		add(command.Label, "", command.Bindings)
+	}
+
+	return strings.Join(lines, "\n") + "\n\n" + T(cc, msgHelpTemplates, helpTemplateData)
+}
+
+func HelpCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", commandHelp(c.Context, permissionsOf(req.Context(), c.Context))))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// maxRevisions caps the number of revisions of a channel's or team's welcome
+// kept in its history, the oldest being dropped first.
+const maxRevisions = 20
+
+// The changes a revision records.
+const (
+	RevisionCreated = "created"
+	RevisionUpdated = "updated"
+	RevisionDeleted = "deleted"
+)
+
+// Revision is a change of the welcome of a channel or team: who made it and
+// when, and the welcome as it was after the change, Welcome for a channel and
+// TeamWelcome for a team, nil if it was deleted. Revisions are numbered from 1
+// in each channel and team. The time is in milliseconds.
+type Revision struct {
+	Number      int             `json:"number"`
+	At          int64           `json:"at"`
+	UserID      string          `json:"user_id"`
+	Action      string          `json:"action"`
+	Welcome     *ChannelWelcome `json:"welcome,omitempty"`
+	TeamWelcome *TeamWelcome    `json:"team_welcome,omitempty"`
+}
+
+// HistoryForm shows the changes of the channel's welcome, or of the team's.
+var HistoryForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		channelField,
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "team",
+			Label:       "team",
+			ModalLabel:  "Team",
+			Description: "Show the changes of the current team's welcome message instead",
+		},
+	},
+	Submit: apps.NewCall("/history").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+func historyKey(channelID string) string {
+	return "history_" + channelID
+}
+
+func teamHistoryKey(teamID string) string {
+	return "team_history_" + teamID
+}
+
+// GetHistory returns the revisions of the channel's welcome, the oldest
+// first.
+func (s *Store) GetHistory(channelID string) ([]Revision, error) {
+	return s.getRevisions(historyKey(channelID))
+}
+
+// GetTeamHistory returns the revisions of the team's welcome, the oldest
+// first.
+func (s *Store) GetTeamHistory(teamID string) ([]Revision, error) {
+	return s.getRevisions(teamHistoryKey(teamID))
+}
+
+func (s *Store) getRevisions(key string) ([]Revision, error) {
+	revisions := []Revision{}
+	if err := s.kv.KVGet(KVAppPrefix, key, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// addRevision records a change of the channel's welcome by the acting user of
+// the store. Changes made without an acting user, e.g. by the scheduler, are
+// not recorded.
+func (s *Store) addRevision(channelID string, welcome *ChannelWelcome) error {
+	return s.recordRevision(historyKey(channelID), Revision{Welcome: welcome}, welcome == nil)
+}
+
+// addTeamRevision records a change of the team's welcome, as addRevision.
+func (s *Store) addTeamRevision(teamID string, welcome *TeamWelcome) error {
+	return s.recordRevision(teamHistoryKey(teamID), Revision{TeamWelcome: welcome}, welcome == nil)
+}
+
+// recordRevision appends the revision, holding the welcome after the change,
+// to the history stored under the key.
+func (s *Store) recordRevision(key string, revision Revision, deleted bool) error {
+	if s.actingUserID == "" {
+		return nil
+	}
+	revisions, err := s.getRevisions(key)
+	if err != nil {
+		return err
+	}
+
+	revision.Number = 1
+	revision.At = model.GetMillis()
+	revision.UserID = s.actingUserID
+	revision.Action = RevisionCreated
+	if len(revisions) > 0 {
+		last := revisions[len(revisions)-1]
+		revision.Number = last.Number + 1
+		if last.Action != RevisionDeleted {
+			revision.Action = RevisionUpdated
+		}
+	}
+	if deleted {
+		revision.Action = RevisionDeleted
+	}
+
+	revisions = append(revisions, revision)
+	if len(revisions) > maxRevisions {
+		revisions = revisions[len(revisions)-maxRevisions:]
+	}
+	_, err = s.kv.KVSet(KVAppPrefix, key, revisions)
+	return err
+}
+
+// revisionSummary describes the welcome of a revision in a few words.
+func revisionSummary(revision Revision) string {
+	if team := revision.TeamWelcome; team != nil {
+		variants := []string{}
+		for name, message := range map[string]string{"guest": team.GuestMessage, "added": team.AddedMessage, "promotion": team.PromotionMessage} {
+			if message != "" {
+				variants = append(variants, name)
+			}
+		}
+		if len(variants) == 0 {
+			return ""
+		}
+		sort.Strings(variants)
+		return " (with the " + strings.Join(variants, ", ") + " messages)"
+	}
+	if revision.Welcome == nil {
+		return ""
+	}
+	welcome := revision.Welcome
+	summary := fmt.Sprintf("%d messages", len(welcome.Messages))
+	if len(welcome.Messages) == 1 {
+		summary = "1 message"
+	}
+	if welcome.Disabled {
+		summary += ", paused"
+	}
+	return " (" + summary + ")"
+}
+
+// HistoryCall shows the changes of the channel's welcome, or of the team's
+// with --team, the most recent first.
+func HistoryCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	var cc apps.Context
+	var revisions []Revision
+	var name string
+	var err error
+	if c.BoolValue("team") {
+		cc = c.Context
+		if cc.Team == nil {
+			httputils.WriteJSON(w,
+				apps.NewErrorResponse(errors.New(T(cc, msgTeamNotFound, nil))))
+			return
+		}
+		store := NewStore(req.Context(), cc)
+		if err = checkCanManageTeam(store, cc); err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		name = "the team " + cc.Team.DisplayName
+		revisions, err = store.GetTeamHistory(cc.Team.Id)
+	} else {
+		cc, err = withSelectedChannel(req.Context(), c)
+		if err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		if cc.Channel == nil {
+			httputils.WriteJSON(w,
+				errorResponse("we couldn't find the current channel"))
+			return
+		}
+		store := NewStore(req.Context(), cc)
+		if err = checkCanManageChannel(store, cc); err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		name = "~" + cc.Channel.Name
+		revisions, err = store.GetHistory(cc.Channel.Id)
+	}
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the history"))
+		return
+	}
+	if len(revisions) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("The welcome message of %s wasn't changed yet", name))
+		return
+	}
+
+	userIDs := []string{}
+	for _, revision := range revisions {
+		userIDs = append(userIDs, revision.UserID)
+	}
+	usernames := map[string]string{}
+	users, _, err := asBot(req.Context(), cc).GetUsersByIds(userIDs)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+
+	message := fmt.Sprintf("#### History of the welcome message of %s\n\n", name)
+	for i := len(revisions) - 1; i >= 0; i-- {
+		revision := revisions[i]
+		name := revision.UserID
+		if username, ok := usernames[revision.UserID]; ok {
+			name = "@" + username
+		}
+		message += fmt.Sprintf("* **#%d** %s %s it on %s%s\n", revision.Number, name, revision.Action, formatMillis(revision.At), revisionSummary(revision))
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+)
+
+// i18nFiles holds the translations of the bot's responses. English is the
+// default language: its messages are defined in the code, next to where they
+// are used.
+//
+//go:embed i18n/*.json
+var i18nFiles embed.FS
+
+var bundle = newBundle()
+
+func newBundle() *i18n.Bundle {
+	b := i18n.NewBundle(language.English)
+	b.RegisterUnmarshalFunc("json", json.Unmarshal)
+
+	files, err := i18nFiles.ReadDir("i18n")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	for _, file := range files {
+		if _, err = b.LoadMessageFileFS(i18nFiles, "i18n/"+file.Name()); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	return b
+}
+
+// T localizes the message in the language of the acting user. The call must
+// expand the locale or the acting user. data is used to fill in the message
+// template, if any.
+func T(cc apps.Context, message *i18n.Message, data map[string]interface{}) string {
+	return localize(callLocale(cc), message, data)
+}
+
+// localize localizes the message in the given language, English if empty.
+func localize(locale string, message *i18n.Message, data map[string]interface{}) string {
+	localizer := i18n.NewLocalizer(bundle, locale)
+	localized, err := localizer.Localize(&i18n.LocalizeConfig{
+		DefaultMessage: message,
+		TemplateData:   data,
+	})
+	if err != nil {
+		logger.Error(err)
+		return message.Other
+	}
+	return localized
+}
+
+// callLocale returns the locale of the acting user.
+func callLocale(cc apps.Context) string {
+	if cc.Locale != "" {
+		return cc.Locale
+	}
+	if cc.ActingUser != nil {
+		return cc.ActingUser.Locale
+	}
+	return ""
+}
+
+// normalizeLocale validates a locale given by a user, e.g. "es" or "pt-BR", and
+// returns it in its canonical form.
+func normalizeLocale(locale string) (string, error) {
+	tag, err := language.Parse(strings.TrimSpace(locale))
+	if err != nil {
+		return "", err
+	}
+	return tag.String(), nil
+}
+
+var (
+	msgChannelNotFound = &i18n.Message{
+		ID:    "channel_not_found",
+		Other: "We couldn't find the current channel",
+	}
+	msgTeamNotFound = &i18n.Message{
+		ID:    "team_not_found",
+		Other: "We couldn't find the current team",
+	}
+	msgSetWelcomeFailed = &i18n.Message{
+		ID:    "set_welcome_failed",
+		Other: "We couldn't set your message",
+	}
+	msgDeleteWelcomeFailed = &i18n.Message{
+		ID:    "delete_welcome_failed",
+		Other: "We couldn't delete the welcome message",
+	}
+	msgChannelSubscribeFailed = &i18n.Message{
+		ID:    "channel_subscribe_failed",
+		Other: "Stored the welcome message, but couldn't subscribe to the channel's join events",
+	}
+	msgTeamSubscribeFailed = &i18n.Message{
+		ID:    "team_subscribe_failed",
+		Other: "Stored the welcome message, but couldn't subscribe to the team's join events",
+	}
+	msgChannelWelcomeStored = &i18n.Message{
+		ID:    "channel_welcome_stored",
+		Other: "Stored welcome message {{.Index}} of {{.Count}}:\n {{.Message}}",
+	}
+	msgChannelWelcomeVariantStored = &i18n.Message{
+		ID:    "channel_welcome_variant_stored",
+		Other: "Stored the {{.Locale}} variant of welcome message {{.Index}}:\n {{.Message}}",
+	}
+	msgChannelWelcomeGuestStored = &i18n.Message{
+		ID:    "channel_welcome_guest_stored",
+		Other: "Stored the guest variant of welcome message {{.Index}}:\n {{.Message}}",
+	}
+	msgChannelWelcomeAddedStored = &i18n.Message{
+		ID:    "channel_welcome_added_stored",
+		Other: "Stored the variant for added members of welcome message {{.Index}}:\n {{.Message}}",
+	}
+	msgChannelWelcomeNotSet = &i18n.Message{
+		ID:    "channel_welcome_not_set",
+		Other: "You need to set the channel's welcome message with `set_channel_welcome`",
+	}
+	msgChannelWelcomeChanged = &i18n.Message{
+		ID:    "channel_welcome_changed",
+		Other: "Someone else changed the welcome message since you opened the editor. Reload it and retry, so their changes are not lost.",
+	}
+	msgChannelWelcomeIs = &i18n.Message{
+		ID:    "channel_welcome_is",
+		Other: "Welcome message is:\n {{.Message}}",
+	}
+	msgChannelWelcomesAre = &i18n.Message{
+		ID:    "channel_welcomes_are",
+		Other: "Welcome messages are:\n",
+	}
+	msgChannelWelcomePaused = &i18n.Message{
+		ID:    "channel_welcome_paused",
+		Other: "\n_The welcome message is paused, use `toggle` to resume it._",
+	}
+	msgChannelWelcomeFromDefault = &i18n.Message{
+		ID:    "channel_welcome_from_default",
+		Other: "\n_The channel has no welcome of its own, new members get the team's default channel welcome._",
+	}
+	msgChannelWelcomeAppended = &i18n.Message{
+		ID:    "channel_welcome_appended",
+		Other: "\n_New members get the team's default channel welcome, followed by the channel's messages._",
+	}
+	msgChannelWelcomeDeleted = &i18n.Message{
+		ID:    "channel_welcome_deleted",
+		Other: "Deleted the channel's welcome message",
+	}
+	msgChannelWelcomeMessageDeleted = &i18n.Message{
+		ID:    "channel_welcome_message_deleted",
+		Other: "Deleted welcome message {{.Index}}, {{.Count}} left",
+	}
+	msgChannelWelcomeVariantDeleted = &i18n.Message{
+		ID:    "channel_welcome_variant_deleted",
+		Other: "Deleted the {{.Locale}} variant of welcome message {{.Index}}",
+	}
+	msgChannelWelcomeGuestDeleted = &i18n.Message{
+		ID:    "channel_welcome_guest_deleted",
+		Other: "Deleted the guest variant of welcome message {{.Index}}",
+	}
+	msgChannelWelcomeAddedDeleted = &i18n.Message{
+		ID:    "channel_welcome_added_deleted",
+		Other: "Deleted the variant for added members of welcome message {{.Index}}",
+	}
+	msgConfirmDeleteTitle = &i18n.Message{
+		ID:    "confirm_delete_title",
+		Other: "Delete the welcome message",
+	}
+	msgConfirmDeleteWelcome = &i18n.Message{
+		ID:    "confirm_delete_welcome",
+		Other: "Are you sure you want to delete the welcome message of ~{{.Channel}}? This can't be undone.",
+	}
+	msgConfirmDeleteMessage = &i18n.Message{
+		ID:    "confirm_delete_message",
+		Other: "Are you sure you want to delete welcome message {{.Index}}? This can't be undone.",
+	}
+	msgConfirmDeleteVariant = &i18n.Message{
+		ID:    "confirm_delete_variant",
+		Other: "Are you sure you want to delete the {{.Locale}} variant of welcome message {{.Index}}? This can't be undone.",
+	}
+	msgConfirmDeleteGuest = &i18n.Message{
+		ID:    "confirm_delete_guest",
+		Other: "Are you sure you want to delete the guest variant of welcome message {{.Index}}? This can't be undone.",
+	}
+	msgConfirmDeleteAdded = &i18n.Message{
+		ID:    "confirm_delete_added",
+		Other: "Are you sure you want to delete the variant for added members of welcome message {{.Index}}? This can't be undone.",
+	}
+	msgConfirmDeleteButton = &i18n.Message{
+		ID:    "confirm_delete_button",
+		Other: "Delete",
+	}
+	msgTeamWelcomeStored = &i18n.Message{
+		ID:    "team_welcome_stored",
+		Other: "Stored the team welcome message:\n {{.Message}}",
+	}
+	msgTeamWelcomeGuestStored = &i18n.Message{
+		ID:    "team_welcome_guest_stored",
+		Other: "Stored the guest variant of the team welcome message:\n {{.Message}}",
+	}
+	msgTeamWelcomeAddedStored = &i18n.Message{
+		ID:    "team_welcome_added_stored",
+		Other: "Stored the variant for added members of the team welcome message:\n {{.Message}}",
+	}
+	msgTeamWelcomePromotionStored = &i18n.Message{
+		ID:    "team_welcome_promotion_stored",
+		Other: "Stored the message for guests of the team promoted to members:\n {{.Message}}",
+	}
+	msgTeamWelcomeNotSet = &i18n.Message{
+		ID:    "team_welcome_not_set",
+		Other: "You need to set the team's welcome message with `set_team_welcome`",
+	}
+	msgTeamWelcomeIs = &i18n.Message{
+		ID:    "team_welcome_is",
+		Other: "Team welcome message is:\n {{.Message}}",
+	}
+	msgGuestVariant = &i18n.Message{
+		ID:    "guest_variant",
+		Other: "guests",
+	}
+	msgAddedVariant = &i18n.Message{
+		ID:    "added_variant",
+		Other: "added by someone else",
+	}
+	msgPromotionVariant = &i18n.Message{
+		ID:    "promotion_variant",
+		Other: "guests promoted to members",
+	}
+	msgTeamWelcomeDeleted = &i18n.Message{
+		ID:    "team_welcome_deleted",
+		Other: "Deleted the team's welcome message",
+	}
+	msgDeleteTeamWelcomeFailed = &i18n.Message{
+		ID:    "delete_team_welcome_failed",
+		Other: "We couldn't delete the team welcome message",
+	}
+	msgLintWarnings = &i18n.Message{
+		ID:    "lint_warnings",
+		Other: "Check the message before new members get it:",
+	}
+	msgDryRunSet = &i18n.Message{
+		ID:    "dry_run_set",
+		Other: "Dry run, nothing was changed. The message would be:",
+	}
+	msgDryRunReplacing = &i18n.Message{
+		ID:    "dry_run_replacing",
+		Other: "It would replace:",
+	}
+	msgDryRunDelete = &i18n.Message{
+		ID:    "dry_run_delete",
+		Other: "Dry run, nothing was deleted. This would be deleted:",
+	}
+	msgDryRunDeleteTeam = &i18n.Message{
+		ID:    "dry_run_delete_team",
+		Other: "Dry run, nothing was deleted. The team's welcome message would be deleted, with its onboarding, checklist progress, drip campaigns and survey responses:",
+	}
+	msgGoodMorning = &i18n.Message{
+		ID:    "good_morning",
+		Other: "Good morning",
+	}
+	msgGoodAfternoon = &i18n.Message{
+		ID:    "good_afternoon",
+		Other: "Good afternoon",
+	}
+	msgGoodEvening = &i18n.Message{
+		ID:    "good_evening",
+		Other: "Good evening",
+	}
+)
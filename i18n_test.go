@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestLocalize(t *testing.T) {
+	data := map[string]interface{}{"Index": 1, "Count": 2, "Message": "Hola"}
+	tests := []struct {
+		name   string
+		locale string
+		want   string
+	}{
+		{name: "default", locale: "", want: "Stored welcome message 1 of 2:\n Hola"},
+		{name: "english", locale: "en", want: "Stored welcome message 1 of 2:\n Hola"},
+		{name: "translated", locale: "es", want: "Guardado el mensaje de bienvenida 1 de 2:\n Hola"},
+		{name: "regional variant", locale: "es-MX", want: "Guardado el mensaje de bienvenida 1 de 2:\n Hola"},
+		{name: "untranslated", locale: "ja", want: "Stored welcome message 1 of 2:\n Hola"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localize(tt.locale, msgChannelWelcomeStored, data); got != tt.want {
+				t.Errorf("localized %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallLocale(t *testing.T) {
+	user := &model.User{Locale: "es"}
+	tests := []struct {
+		name string
+		cc   apps.Context
+		want string
+	}{
+		{name: "none", want: ""},
+		{name: "acting user", cc: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: user}}, want: "es"},
+		{name: "expanded locale", cc: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: user, Locale: "fr"}}, want: "fr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := callLocale(tt.cc); got != tt.want {
+				t.Errorf("locale = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	cc := apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: user}}
+	if got := T(cc, msgTeamNotFound, nil); got != "No encontramos el equipo actual" {
+		t.Errorf("T = %q, want the message in the acting user's language", got)
+	}
+}
+
+func TestNormalizeLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+		valid  bool
+	}{
+		{locale: "es", want: "es", valid: true},
+		{locale: " pt_br ", want: "pt-BR", valid: true},
+		{locale: "zh-hant", want: "zh-Hant", valid: true},
+		{locale: "not a locale"},
+		{locale: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			got, err := normalizeLocale(tt.locale)
+			if (err == nil) != tt.valid {
+				t.Fatalf("err = %v, want valid %v", err, tt.valid)
+			}
+			if got != tt.want {
+				t.Errorf("normalized %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageForUser(t *testing.T) {
+	m := WelcomeMessage{
+		Message:      "Welcome!",
+		Translations: map[string]string{"es": "¡Bienvenido!", "pt-BR": "Bem-vindo!"},
+		GuestMessage: "Welcome, guest!",
+	}
+	tests := []struct {
+		name string
+		user *model.User
+		want string
+	}{
+		{name: "default locale", user: &model.User{}, want: "Welcome!"},
+		{name: "translated", user: &model.User{Locale: "es"}, want: "¡Bienvenido!"},
+		{name: "base language", user: &model.User{Locale: "es-MX"}, want: "¡Bienvenido!"},
+		{name: "regional", user: &model.User{Locale: "pt-BR"}, want: "Bem-vindo!"},
+		{name: "other region", user: &model.User{Locale: "pt-PT"}, want: "Welcome!"},
+		{name: "untranslated", user: &model.User{Locale: "ja"}, want: "Welcome!"},
+		{name: "guest", user: &model.User{Locale: "es", Roles: model.SystemGuestRoleId}, want: "Welcome, guest!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.MessageForUser(tt.user); got != tt.want {
+				t.Errorf("message = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelWelcomeTranslations(t *testing.T) {
+	w := ChannelWelcome{Messages: []WelcomeMessage{{Message: "Welcome!"}}}
+	if err := w.SetTranslation(2, "es", "¡Bienvenido!"); err == nil {
+		t.Error("a variant was set for a message that doesn't exist")
+	}
+	if err := w.SetTranslation(1, "fr", "Bienvenue !"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetTranslation(1, "es", "¡Bienvenido!"); err != nil {
+		t.Fatal(err)
+	}
+	if locales := w.Messages[0].Locales(); !reflect.DeepEqual(locales, []string{"es", "fr"}) {
+		t.Errorf("locales = %v, want [es fr]", locales)
+	}
+
+	if err := w.RemoveTranslation(1, "fr"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RemoveTranslation(1, "fr"); err == nil {
+		t.Error("a variant was removed twice")
+	}
+	if locales := w.Messages[0].Locales(); !reflect.DeepEqual(locales, []string{"es"}) {
+		t.Errorf("locales = %v, want [es]", locales)
+	}
+}
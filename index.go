@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const welcomeIndexKey = "welcome_index"
+
+const (
+	IndexKindChannel         = "channel"
+	IndexKindTeam            = "team"
+	IndexKindChannelFarewell = "channel_farewell"
+	IndexKindTeamFarewell    = "team_farewell"
+	IndexKindChannelDefault  = "channel_default"
+)
+
+// IndexEntry records a configured channel or team welcome or farewell. The KV
+// store can't be enumerated, so the index is what `list` and other bulk
+// operations walk.
+type IndexEntry struct {
+	Kind       string `json:"kind"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	AuthorID   string `json:"author_id,omitempty"`
+	AuthorName string `json:"author_name,omitempty"`
+	UpdatedAt  int64  `json:"updated_at,omitempty"`
+}
+
+// NewChannelIndexEntry returns the index entry for a channel welcome set by
+// author.
+func NewChannelIndexEntry(channel *model.Channel, author *model.User) IndexEntry {
+	entry := IndexEntry{
+		Kind:      IndexKindChannel,
+		ID:        channel.Id,
+		Name:      channel.Name,
+		UpdatedAt: model.GetMillis(),
+	}
+	if author != nil {
+		entry.AuthorID = author.Id
+		entry.AuthorName = author.Username
+	}
+	return entry
+}
+
+// NewTeamIndexEntry returns the index entry for a team welcome set by author.
+func NewTeamIndexEntry(team *model.Team, author *model.User) IndexEntry {
+	entry := IndexEntry{
+		Kind:      IndexKindTeam,
+		ID:        team.Id,
+		Name:      team.Name,
+		UpdatedAt: model.GetMillis(),
+	}
+	if author != nil {
+		entry.AuthorID = author.Id
+		entry.AuthorName = author.Username
+	}
+	return entry
+}
+
+// GetIndex returns all the indexed welcomes, sorted by kind and name. If the
+// index was never written, e.g. right after upgrading from a version without
+// it, it is rebuilt from the app's subscriptions.
+func (s *Store) GetIndex() ([]IndexEntry, error) {
+	var index []IndexEntry
+	if err := s.kv.KVGet(KVAppPrefix, welcomeIndexKey, &index); err != nil {
+		return nil, err
+	}
+	if index != nil {
+		return index, nil
+	}
+
+	index, err := s.rebuildIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index, s.saveIndex(index)
+}
+
+// PutIndexEntry adds the entry to the index, replacing the existing entry for
+// the same channel or team.
+func (s *Store) PutIndexEntry(entry IndexEntry) error {
+	index, err := s.GetIndex()
+	if err != nil {
+		return err
+	}
+
+	index = removeIndexEntry(index, entry.Kind, entry.ID)
+	index = append(index, entry)
+	return s.saveIndex(index)
+}
+
+// RemoveIndexEntry removes the channel or team from the index.
+func (s *Store) RemoveIndexEntry(kind, id string) error {
+	index, err := s.GetIndex()
+	if err != nil {
+		return err
+	}
+
+	return s.saveIndex(removeIndexEntry(index, kind, id))
+}
+
+func (s *Store) saveIndex(index []IndexEntry) error {
+	if index == nil {
+		index = []IndexEntry{}
+	}
+	sort.Slice(index, func(i, j int) bool {
+		if index[i].Kind != index[j].Kind {
+			return index[i].Kind < index[j].Kind
+		}
+		return index[i].Name < index[j].Name
+	})
+
+	_, err := s.kv.KVSet(KVAppPrefix, welcomeIndexKey, index)
+	return err
+}
+
+// rebuildIndex recreates the index entries from the join and leave event
+// subscriptions, which exist for every channel and team with a welcome or
+// farewell message. The author
+// and modification time of these entries are unknown.
+func (s *Store) rebuildIndex() ([]IndexEntry, error) {
+	subs, err := s.client.GetSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	index := []IndexEntry{}
+	for _, sub := range subs {
+		switch {
+		case sub.Subject == apps.SubjectUserJoinedChannel && sub.ChannelID != "":
+			entry := IndexEntry{Kind: IndexKindChannel, ID: sub.ChannelID, Name: sub.ChannelID}
+			if channel, _, err := s.client.GetChannel(sub.ChannelID, ""); err == nil {
+				entry.Name = channel.Name
+			}
+			index = append(index, entry)
+
+		case sub.Subject == apps.SubjectUserJoinedTeam && sub.TeamID != "":
+			entry := IndexEntry{Kind: IndexKindTeam, ID: sub.TeamID, Name: sub.TeamID}
+			if team, _, err := s.client.GetTeam(sub.TeamID, ""); err == nil {
+				entry.Name = team.Name
+			}
+			index = append(index, entry)
+
+		case sub.Subject == apps.SubjectUserLeftChannel && sub.ChannelID != "":
+			entry := IndexEntry{Kind: IndexKindChannelFarewell, ID: sub.ChannelID, Name: sub.ChannelID}
+			if channel, _, err := s.client.GetChannel(sub.ChannelID, ""); err == nil {
+				entry.Name = channel.Name
+			}
+			index = append(index, entry)
+
+		case sub.Subject == apps.SubjectUserLeftTeam && sub.TeamID != "":
+			entry := IndexEntry{Kind: IndexKindTeamFarewell, ID: sub.TeamID, Name: sub.TeamID}
+			if team, _, err := s.client.GetTeam(sub.TeamID, ""); err == nil {
+				entry.Name = team.Name
+			}
+			index = append(index, entry)
+		}
+	}
+	return index, nil
+}
+
+func removeIndexEntry(index []IndexEntry, kind, id string) []IndexEntry {
+	out := []IndexEntry{}
+	for _, entry := range index {
+		if entry.Kind == kind && entry.ID == id {
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+const gettingStarted = `Thanks for installing the Welcome Bot! Here's how to get started:
+
+1. Go to a channel and run |/welcomebot set_channel_welcome| to greet everyone who joins it.
+2. Run |/welcomebot set_team_welcome| to send new team members a direct message.
+3. Use |/welcomebot preview| to check how your message looks, and |/welcomebot list| to see every configured welcome.
+
+Run |/welcomebot help| for the full list of commands.`
+
+const channelJoinHint = `Hi! I can greet new members of this channel. Run |/welcomebot set_channel_welcome| to set a welcome message.`
+
+// OnInstall is called by Mattermost once the app is installed.
+var OnInstall = apps.NewCall("/install").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+})
+
+// OnUninstall is called by Mattermost before the app is uninstalled.
+var OnUninstall = apps.NewCall("/uninstall").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+})
+
+// InstallCall provisions the app: it subscribes to the events the app needs,
+// including the creation of channels in the bot's teams, seeds the default
+// settings, schedules the cleanup of archived channels, the checks for
+// promoted guests and the pruning of old records, and sends a getting-started
+// DM to the admin who installed the app.
+// Subscriptions to the join events of channels and teams configured by a
+// previous installation are restored from the welcome index.
+func InstallCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	store := NewStore(req.Context(), c.Context)
+
+	if err := SubscribeToBotJoinedChannel(client); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := SubscribeToBotJoinedTeam(client); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err := subscribeToBotTeams(client, c.Context.BotUserID); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	if welcome, err := store.GetServerWelcome(); err != nil {
+		requestLogger(req).Error(err)
+	} else if welcome != nil {
+		if err = SubscribeToUserCreated(client); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	if err := store.SeedSettings(); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := scheduleCleanup(req.Context(), c.Context); err != nil {
+		requestLogger(req).Error(err)
+	}
+	if err := schedulePromotionCheck(req.Context(), c.Context); err != nil {
+		requestLogger(req).Error(err)
+	}
+	if err := scheduleRetention(req.Context(), c.Context); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	index, err := store.GetIndex()
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	for _, entry := range index {
+		switch entry.Kind {
+		case IndexKindChannel:
+			err = SubscribeToChannel(client, entry.ID)
+		case IndexKindTeam:
+			err = SubscribeToTeam(client, entry.ID)
+		case IndexKindChannelFarewell:
+			err = SubscribeToChannelLeaves(client, entry.ID)
+		case IndexKindTeamFarewell:
+			err = SubscribeToTeamLeaves(client, entry.ID)
+		case IndexKindChannelDefault:
+			_, err = subscribeChannelDefault(req.Context(), c.Context, entry.ID)
+		}
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	if c.Context.ActingUser != nil {
+		if _, err = client.DM(c.Context.ActingUser.Id, "%s", gettingStarted); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Installed the Welcome Bot."))
+}
+
+// UninstallCall tears the app down: it removes all the data the app stored in
+// KV and deletes its subscriptions, so nothing is left behind on the server.
+func UninstallCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+
+	if c.Context.ActingUser != nil {
+		requestLogger(req).Infof("uninstalling the app, requested by %s", c.Context.ActingUser.Username)
+	}
+
+	if err := NewStore(req.Context(), c.Context).DeleteAll(); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	requestLogger(req).Info("deleted the app's KV data")
+
+	subs, err := client.GetSubscriptions()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	for i := range subs {
+		if err = client.Unsubscribe(&subs[i]); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+	requestLogger(req).Infof("deleted %d subscriptions", len(subs))
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Removed all the Welcome Bot data."))
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Interest is an option of the interest picker of a team welcome, and the
+// channels new members who pick it are added to.
+type Interest struct {
+	Name     string   `json:"name"`
+	Channels []string `json:"channels"`
+}
+
+// SetInterestsForm sets the options of the interest picker of the team's
+// welcome.
+var SetInterestsForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			Name:                 "interests",
+			ModalLabel:           "Interests",
+			Description:          "Interests like \"Frontend: web design\", with the names of their channels separated by spaces, one per line or separated by semicolons. Leave empty to remove the picker.",
+			AutocompletePosition: 1,
+		},
+	},
+	Submit: apps.NewCall("/set_interests").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// PickInterest is the call made by the interest picker of the team welcome
+// post. Its state is a pickInterestState.
+var PickInterest = apps.NewCall("/pick_interest").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+})
+
+// pickInterestState is the state of an option of the interest picker. The
+// channels are looked up when the option is picked, so the picker of earlier
+// welcomes follows the changes of the interests.
+type pickInterestState struct {
+	TeamID string `json:"team_id"`
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+}
+
+// interestsBinding returns the in-post binding with the interest picker of the
+// team. It returns nil if the team has no interests.
+func interestsBinding(teamID string, interests []Interest) *apps.Binding {
+	if len(interests) == 0 {
+		return nil
+	}
+
+	options := []apps.Binding{}
+	for i, interest := range interests {
+		options = append(options, apps.Binding{
+			Location: apps.Location("interest" + strconv.Itoa(i)),
+			Label:    interest.Name,
+			Submit: PickInterest.WithState(pickInterestState{
+				TeamID: teamID,
+				Index:  i,
+				Name:   interest.Name,
+			}),
+		})
+	}
+	return &apps.Binding{
+		AppID:       AppID,
+		Location:    "interests",
+		Label:       "What are you interested in?",
+		Description: "Pick a topic to join its channels.",
+		Bindings: []apps.Binding{
+			{
+				Location: "pick",
+				Label:    "Pick a topic",
+				Bindings: options,
+			},
+		},
+	}
+}
+
+// PickInterestCall adds the user who picked an interest to its channels, on
+// their behalf, and confirms the channels they joined in a direct message.
+func PickInterestCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	state := pickInterestState{}
+	data, _ := json.Marshal(c.State)
+	if err := json.Unmarshal(data, &state); err != nil || state.TeamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find this interest"))
+		return
+	}
+
+	welcome, err := NewStore(req.Context(), c.Context).GetTeamWelcome(state.TeamID)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if welcome == nil || state.Index >= len(welcome.Interests) || welcome.Interests[state.Index].Name != state.Name {
+		httputils.WriteJSON(w,
+			errorResponse("the interest %q is no longer offered", state.Name))
+		return
+	}
+	interest := welcome.Interests[state.Index]
+
+	client := asActingUser(req.Context(), c.Context)
+	userID := c.Context.ActingUser.Id
+	joined := []string{}
+	for _, channelID := range interest.Channels {
+		channel, _, err := client.GetChannel(channelID, "")
+		if err != nil {
+			requestLogger(req).Error(err)
+			continue
+		}
+		if _, _, err = client.AddChannelMember(channelID, userID); err != nil {
+			requestLogger(req).Error(err)
+			continue
+		}
+		joined = append(joined, "~"+channel.Name)
+	}
+	if len(joined) == 0 {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't add you to the channels of %s", interest.Name))
+		return
+	}
+
+	message := fmt.Sprintf("You picked **%s** and joined %s.", interest.Name, strings.Join(joined, ", "))
+	bot := asBot(req.Context(), c.Context)
+	dm, _, err := bot.CreateDirectChannel(c.Context.BotUserID, userID)
+	if err == nil {
+		_, err = createPost(bot, &model.Post{
+			ChannelId: dm.Id,
+			Message:   message,
+		})
+	}
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("%s", message))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
+
+func SetInterestsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	// The interests are parsed like the fields of an attachment, as
+	// "Name: channels" pairs.
+	pairs, err := parseAttachmentFields(c.GetValue("interests", ""))
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	client := asActingUser(req.Context(), c.Context)
+	interests := []Interest{}
+	for _, pair := range pairs {
+		interest := Interest{Name: pair.Title}
+		for _, name := range strings.Fields(pair.Value) {
+			name = strings.TrimPrefix(name, "~")
+			channel, _, err := client.GetChannelByName(name, c.Context.Team.Id, "")
+			if err != nil {
+				httputils.WriteJSON(w,
+					errorResponse("we couldn't find the channel %s", name))
+				return
+			}
+			interest.Channels = append(interest.Channels, channel.Id)
+		}
+		if len(interest.Channels) == 0 {
+			httputils.WriteJSON(w,
+				errorResponse("the interest %q has no channels", interest.Name))
+			return
+		}
+		interests = append(interests, interest)
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the interests"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+
+	welcome.Interests = interests
+	if err = store.SetTeamWelcome(c.Context.Team.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the interests"))
+		return
+	}
+
+	if len(interests) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Removed the interest picker of the team welcome"))
+		return
+	}
+	names := []string{}
+	for _, interest := range interests {
+		names = append(names, interest.Name)
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of the team will be asked to pick among %s", strings.Join(names, ", ")))
+}
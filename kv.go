@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// KVStore is the key-value storage the Store keeps the app's data in. The
+// apps client implements it with the Mattermost KV store, the storage backends
+// with their own, MemoryKV in memory. The handlers create their Store with
+// NewStore, so the KV store is swapped by setting the backend, e.g. to a
+// MemoryBackend in the tests.
+type KVStore interface {
+	KVGet(prefix, key string, ref interface{}) error
+	KVSet(prefix, key string, value interface{}) (bool, error)
+	KVDelete(prefix, key string) error
+}
+
+// Poster creates posts. The apps client implements it, MemoryPoster records
+// the posts instead. Only the retries of the posts take a Poster: the handlers
+// otherwise call Mattermost with the clients of asBot and asActingUser, at the
+// site URL of the call context, which the tests point at a fake server.
+type Poster interface {
+	CreatePost(post *model.Post) (*model.Post, error)
+}
+
+// MemoryKV is a KVStore keeping the values in memory, JSON-encoded like the
+// Mattermost KV store does. Getting a missing key decodes null.
+type MemoryKV struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+// NewMemoryKV returns an empty MemoryKV.
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{
+		values: map[string][]byte{},
+	}
+}
+
+func (kv *MemoryKV) KVGet(prefix, key string, ref interface{}) error {
+	kv.mu.Lock()
+	data, ok := kv.values[prefix+"/"+key]
+	kv.mu.Unlock()
+
+	if !ok {
+		data = []byte("null")
+	}
+	return json.Unmarshal(data, ref)
+}
+
+func (kv *MemoryKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.values[prefix+"/"+key] = data
+	return true, nil
+}
+
+func (kv *MemoryKV) KVDelete(prefix, key string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	delete(kv.values, prefix+"/"+key)
+	return nil
+}
+
+// MemoryPoster is a Poster recording the posts it is given.
+type MemoryPoster struct {
+	mu    sync.Mutex
+	Posts []*model.Post
+}
+
+func (p *MemoryPoster) CreatePost(post *model.Post) (*model.Post, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	created := post.Clone()
+	created.Id = model.NewId()
+	created.CreateAt = model.GetMillis()
+	p.Posts = append(p.Posts, created)
+	return created, nil
+}
+
+// MemoryBackend is a Backend keeping the data of each Mattermost server in a
+// MemoryKV of its own.
+type MemoryBackend struct {
+	mu    sync.Mutex
+	sites map[string]*MemoryKV
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		sites: map[string]*MemoryKV{},
+	}
+}
+
+func (b *MemoryBackend) KV(siteURL string) KVStore {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kv, ok := b.sites[siteURL]
+	if !ok {
+		kv = NewMemoryKV()
+		b.sites[siteURL] = kv
+	}
+	return kv
+}
+
+func (b *MemoryBackend) Locker(siteURL string) Locker {
+	return kvLocker{b.KV(siteURL)}
+}
+
+func (b *MemoryBackend) Ping() error {
+	return nil
+}
+
+func (b *MemoryBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/awslabs/aws-lambda-go-api-proxy/httpadapter"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// LambdaFunction is the name of the AWS Lambda function serving all the calls,
+// and of the executable in the function's package, e.g. built with
+//
+//	GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o welcome-bot
+//
+// The app bundle deployed with appsctl holds the manifest, the zipped
+// executable and the icon in static/, which Mattermost then serves from S3.
+const LambdaFunction = "welcome-bot"
+
+// LambdaFunctions maps all the calls to the single function of the app.
+var LambdaFunctions = []apps.AWSLambdaFunction{
+	{
+		Path:    "/",
+		Name:    LambdaFunction,
+		Handler: LambdaFunction,
+		Runtime: "go1.x",
+	},
+}
+
+// runLambda serves the calls Mattermost invokes the function with. The process
+// is frozen between invocations, so there is no scheduler loop: the jobs that
+// are due are run after each call instead, and the delayed welcomes are sent
+// with the first call after they are due.
+func runLambda(next http.Handler) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req)
+		scheduler.runDue()
+	})
+	lambda.Start(httpadapter.New(handler).ProxyWithContext)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// leaseSettleDelay is how long kvLocker waits before reading a lease it wrote
+// back, for the writes of the replicas racing for it to settle.
+const leaseSettleDelay = 500 * time.Millisecond
+
+// replicaID identifies the replica holding a lease, among the replicas of the
+// app running behind a load balancer.
+var replicaID = newReplicaID()
+
+func newReplicaID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "welcomebot"
+	}
+	return host + "-" + model.NewId()[:8]
+}
+
+// Locker grants leases: a lease is held by a single holder at a time, until
+// it expires or is released, e.g. so a single replica runs the scheduled
+// jobs.
+type Locker interface {
+	// AcquireLease acquires the lease for the holder, or renews it if the
+	// holder has it, for ttl. It reports whether the holder has the lease.
+	AcquireLease(name, holder string, ttl time.Duration) (bool, error)
+
+	// ReleaseLease releases the lease if the holder has it.
+	ReleaseLease(name, holder string) error
+}
+
+func leaseKey(name string) string {
+	return "lease_" + name
+}
+
+// Lease is a lease stored by kvLocker.
+type Lease struct {
+	Holder    string `json:"holder"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// kvLocker keeps the leases in a KVStore. The Mattermost KV store can't write
+// atomically, so the replicas racing for an expired lease may all get it: a
+// replica writing the lease reads it back after leaseSettleDelay, and gives
+// it up if another replica wrote it since.
+type kvLocker struct {
+	kv KVStore
+}
+
+func (l kvLocker) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	var lease Lease
+	if err := l.kv.KVGet(KVAppPrefix, leaseKey(name), &lease); err != nil {
+		return false, err
+	}
+	now := model.GetMillis()
+	if lease.Holder != holder && lease.ExpiresAt > now {
+		return false, nil
+	}
+
+	acquiring := lease.Holder != holder
+	lease = Lease{Holder: holder, ExpiresAt: now + ttl.Milliseconds()}
+	if _, err := l.kv.KVSet(KVAppPrefix, leaseKey(name), lease); err != nil {
+		return false, err
+	}
+	if !acquiring {
+		return true, nil
+	}
+
+	time.Sleep(leaseSettleDelay)
+	if err := l.kv.KVGet(KVAppPrefix, leaseKey(name), &lease); err != nil {
+		return false, err
+	}
+	return lease.Holder == holder, nil
+}
+
+func (l kvLocker) ReleaseLease(name, holder string) error {
+	var lease Lease
+	if err := l.kv.KVGet(KVAppPrefix, leaseKey(name), &lease); err != nil {
+		return err
+	}
+	if lease.Holder != holder {
+		return nil
+	}
+	return l.kv.KVDelete(KVAppPrefix, leaseKey(name))
+}
+
+// AcquireLease acquires or renews the lease for the holder, with the locker
+// of the storage backend, which is atomic, or in the KV store.
+func (s *Store) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	ok, err := s.locker.AcquireLease(name, holder, ttl)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire the %s lease: %w", name, err)
+	}
+	return ok, nil
+}
+
+// ReleaseLease releases the lease if the holder has it.
+func (s *Store) ReleaseLease(name, holder string) error {
+	return s.locker.ReleaseLease(name, holder)
+}
+
+// The locks serializing the updates of a key across the replicas: a lock is
+// held for lockTTL at most, if its holder dies, and waited for lockTimeout at
+// most, trying again every lockRetryDelay.
+const (
+	lockTTL        = 3 * storageTimeout
+	lockTimeout    = storageTimeout
+	lockRetryDelay = 20 * time.Millisecond
+)
+
+func lockName(name string) string {
+	return "lock_" + name
+}
+
+// Lock takes the lock of the name, shared by the replicas of the app and the
+// goroutines of each, for reading and writing a key that several may update,
+// e.g. the job queue. It returns the function releasing it. The Mattermost KV
+// store can't be locked atomically: without a storage backend, which the
+// replicas need, Lock doesn't wait and the callers only serialize the updates
+// of their own replica.
+func (s *Store) Lock(name string) (func(), error) {
+	if _, ok := s.locker.(kvLocker); ok {
+		return func() {}, nil
+	}
+
+	holder := replicaID + "-" + model.NewId()[:8]
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		held, err := s.locker.AcquireLease(lockName(name), holder, lockTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %w", name, err)
+		}
+		if held {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the %s lock", name)
+		}
+		time.Sleep(lockRetryDelay)
+	}
+	return func() {
+		if err := s.locker.ReleaseLease(lockName(name), holder); err != nil {
+			logger.Errorf("failed to unlock %s: %v", name, err)
+		}
+	}, nil
+}
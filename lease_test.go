@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestKVLocker(t *testing.T) {
+	tests := []struct {
+		name   string
+		lease  *Lease
+		holder string
+		held   bool
+	}{
+		{name: "free", holder: "a", held: true},
+		{name: "held by the holder", lease: &Lease{Holder: "a", ExpiresAt: model.GetMillis() + 60000}, holder: "a", held: true},
+		{name: "held by another", lease: &Lease{Holder: "b", ExpiresAt: model.GetMillis() + 60000}, holder: "a"},
+		{name: "expired", lease: &Lease{Holder: "b", ExpiresAt: model.GetMillis() - 1}, holder: "a", held: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			if tt.lease != nil {
+				if _, err := store.kv.KVSet(KVAppPrefix, leaseKey(schedulerLease), tt.lease); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			held, err := store.AcquireLease(schedulerLease, tt.holder, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if held != tt.held {
+				t.Errorf("held = %v, want %v", held, tt.held)
+			}
+		})
+	}
+}
+
+func TestKVLockerRelease(t *testing.T) {
+	store := NewMemoryStore()
+	if held, err := store.AcquireLease(schedulerLease, "a", time.Minute); err != nil || !held {
+		t.Fatalf("failed to acquire the lease: %v", err)
+	}
+
+	if err := store.ReleaseLease(schedulerLease, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := store.AcquireLease(schedulerLease, "b", time.Minute); held {
+		t.Error("another holder released the lease")
+	}
+
+	if err := store.ReleaseLease(schedulerLease, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := store.AcquireLease(schedulerLease, "b", time.Minute); !held {
+		t.Error("the lease wasn't released")
+	}
+}
+
+// takeOverKV lets a racing replica write the lease while kvLocker waits for
+// the writes to settle.
+type takeOverKV struct {
+	KVStore
+	holder string
+}
+
+func (kv takeOverKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	changed, err := kv.KVStore.KVSet(prefix, key, value)
+	if err == nil && key == leaseKey(schedulerLease) {
+		_, err = kv.KVStore.KVSet(prefix, key, Lease{Holder: kv.holder, ExpiresAt: model.GetMillis() + 60000})
+	}
+	return changed, err
+}
+
+func TestKVLockerRace(t *testing.T) {
+	locker := kvLocker{takeOverKV{KVStore: NewMemoryKV(), holder: "b"}}
+	held, err := locker.AcquireLease(schedulerLease, "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held {
+		t.Error("the lease is held by both replicas")
+	}
+}
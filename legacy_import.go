@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// legacyPluginID is the ID of the welcome bot plugin this app replaces.
+const legacyPluginID = "com.mattermost.welcomebot"
+
+// LegacyImportPath is the HTTP endpoint accepting the legacy plugin's
+// configuration, for admins migrating with a script rather than the command.
+const LegacyImportPath = "/api/import/legacy"
+
+// maxLegacyConfigSize is the largest legacy configuration read, in bytes.
+const maxLegacyConfigSize = 1 << 20
+
+// LegacyWelcomeMessage is a team welcome, as configured in the legacy plugin.
+type LegacyWelcomeMessage struct {
+	TeamName          string
+	DelayInSeconds    int
+	Message           []string
+	AttachmentMessage []string
+	Actions           []LegacyAction
+}
+
+// LegacyAction is a button or automatic action of a legacy team welcome, adding
+// the new member to channels.
+type LegacyAction struct {
+	ActionType        string
+	ActionDisplayName string
+	ActionName        string
+	ChannelsAddedTo   []string
+}
+
+// legacyTemplateVariables maps the template variables of the legacy plugin to
+// the ones of the app.
+var legacyTemplateVariables = strings.NewReplacer(
+	"{{.UserDisplayName}}", "{{.DisplayName}}",
+	"{{.User.Username}}", "{{.UserName}}",
+	"{{.User.Nickname}}", "{{.NickName}}",
+	"{{.User.FirstName}}", "{{.FirstName}}",
+	"{{.User.LastName}}", "{{.LastName}}",
+)
+
+// parseLegacyConfig accepts the legacy plugin's WelcomeMessages setting, either
+// alone, in the plugin's settings object or in the PluginSettings section of
+// config.json. Keys are matched case-insensitively, config.json stores them in
+// lower case.
+func parseLegacyConfig(data []byte) ([]LegacyWelcomeMessage, error) {
+	var messages []LegacyWelcomeMessage
+	if err := json.Unmarshal(data, &messages); err == nil {
+		return messages, nil
+	}
+
+	var settings struct {
+		WelcomeMessages []LegacyWelcomeMessage
+		PluginSettings  struct {
+			Plugins map[string]struct {
+				WelcomeMessages []LegacyWelcomeMessage
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	if len(settings.WelcomeMessages) > 0 {
+		return settings.WelcomeMessages, nil
+	}
+	if plugin, ok := settings.PluginSettings.Plugins[legacyPluginID]; ok {
+		return plugin.WelcomeMessages, nil
+	}
+	return nil, errors.New("no WelcomeMessages setting found")
+}
+
+// importLegacyConfig stores the legacy team welcomes as team welcomes of the
+// app, and returns a report of what was imported. The channels of the actions
+// become recommended channels: automatic actions can't be replicated, their
+// channels are offered as buttons too. In a dry run, nothing is stored.
+func importLegacyConfig(ctx context.Context, cc apps.Context, data []byte, dryRun bool) (string, error) {
+	legacy, err := parseLegacyConfig(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid configuration: %w", err)
+	}
+	if len(legacy) == 0 {
+		return "", errors.New("the configuration has no welcome messages")
+	}
+
+	client := asActingUser(ctx, cc)
+	store := NewStore(ctx, cc)
+	report := []string{}
+
+	for _, l := range legacy {
+		team, _, err := client.GetTeamByName(l.TeamName, "")
+		if err != nil {
+			report = append(report, fmt.Sprintf("* %s: skipped, the team was not found", l.TeamName))
+			continue
+		}
+
+		message := strings.Join(l.Message, "\n")
+		if len(l.AttachmentMessage) > 0 {
+			message += "\n\n" + strings.Join(l.AttachmentMessage, "\n")
+		}
+		welcome := TeamWelcome{
+			Message:      legacyTemplateVariables.Replace(message),
+			DelaySeconds: l.DelayInSeconds,
+		}
+
+		notes := []string{}
+		for _, action := range l.Actions {
+			for _, name := range action.ChannelsAddedTo {
+				channel, _, err := client.GetChannelByName(name, team.Id, "")
+				if err != nil {
+					notes = append(notes, fmt.Sprintf("channel %s was not found", name))
+					continue
+				}
+				welcome.RecommendedChannels = append(welcome.RecommendedChannels, channel.Id)
+			}
+			if action.ActionType == "automatic" {
+				notes = append(notes, fmt.Sprintf("the automatic action %s is now a button", action.ActionName))
+			}
+		}
+
+		if err = checkWelcomeLength(welcome.Message); err != nil {
+			report = append(report, fmt.Sprintf("* %s: skipped, %s", team.Name, err))
+			continue
+		}
+		if dryRun {
+			report = append(report, importReportLine(team.Name, notes, dryRun))
+			continue
+		}
+		if err = store.SetTeamWelcome(team.Id, welcome); err != nil {
+			return "", err
+		}
+
+		teamContext := cc
+		teamContext.Team = team
+		if err = enableTeamWelcome(ctx, teamContext); err != nil {
+			logger.Error(err)
+			notes = append(notes, "couldn't subscribe to the team's join events")
+		}
+
+		report = append(report, importReportLine(team.Name, notes, dryRun))
+	}
+
+	return strings.Join(report, "\n"), nil
+}
+
+// LegacyImportHandler is the HTTP endpoint version of the import command. The
+// request body is the legacy configuration, and the request must be
+// authenticated with the access token of a system admin, e.g.
+//
+//	curl -H "Authorization: Bearer $TOKEN" --data @config.json $APP_URL/api/import/legacy
+//
+// The app needs the bot's credentials, so the endpoint is only available once
+// Mattermost made a call to the app since it started.
+func LegacyImportHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cc, ok := scheduler.Context()
+	if !ok {
+		http.Error(w, "the app has not been called by Mattermost yet, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		http.Error(w, "missing access token", http.StatusUnauthorized)
+		return
+	}
+	// The user of the token is only known once it is looked up.
+	cc.ActingUserAccessToken = token
+	cc.ActingUser = &model.User{}
+
+	me, _, err := asActingUser(req.Context(), cc).GetMe("")
+	if err != nil {
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+	cc.ActingUser = me
+	if !me.IsSystemAdmin() {
+		http.Error(w, "only system admins can import welcome messages", http.StatusForbidden)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxLegacyConfigSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("the configuration must be at most %d bytes", maxLegacyConfigSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := importLegacyConfig(req.Context(), cc, data, false)
+	if err != nil {
+		requestLogger(req).Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, report)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const legacyMessages = `[{
+	"TeamName": "acme",
+	"DelayInSeconds": 5,
+	"Message": ["Welcome {{.UserDisplayName}}!", "Say hi, @{{.User.Username}}."],
+	"AttachmentMessage": ["Read the handbook."],
+	"Actions": [{
+		"ActionType": "automatic",
+		"ActionName": "join",
+		"ChannelsAddedTo": ["town-square", "gone"]
+	}]
+}, {
+	"TeamName": "missing",
+	"Message": ["Hi"]
+}]`
+
+func TestParseLegacyConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		teams []string
+	}{
+		{name: "setting", data: legacyMessages, teams: []string{"acme", "missing"}},
+		{name: "plugin settings", data: `{"WelcomeMessages": [{"TeamName": "acme"}]}`, teams: []string{"acme"}},
+		{name: "config.json", data: `{"PluginSettings": {"Plugins": {"com.mattermost.welcomebot": {"welcomemessages": [{"teamname": "acme"}]}}}}`, teams: []string{"acme"}},
+		{name: "no setting", data: `{"PluginSettings": {}}`},
+		{name: "invalid", data: `not json`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := parseLegacyConfig([]byte(tt.data))
+			if (err == nil) != (tt.teams != nil) {
+				t.Fatalf("err = %v, want teams %v", err, tt.teams)
+			}
+			teams := []string{}
+			for _, m := range messages {
+				teams = append(teams, m.TeamName)
+			}
+			if tt.teams != nil && !reflect.DeepEqual(teams, tt.teams) {
+				t.Errorf("teams = %v, want %v", teams, tt.teams)
+			}
+		})
+	}
+}
+
+func TestImportLegacyConfig(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		name := "import"
+		if dryRun {
+			name = "dry run"
+		}
+		t.Run(name, func(t *testing.T) {
+			useMemoryBackend(t)
+			fake := newFakeMattermost(t)
+			team := &model.Team{Id: "team", Name: "acme"}
+			fake.teams = []*model.Team{team}
+			fake.channels = []*model.Channel{{Id: "channel", Name: "town-square", TeamId: team.Id}}
+			cc := callContext(fake, &model.User{Id: "admin", Roles: model.SystemAdminRoleId}, nil)
+
+			report, err := importLegacyConfig(context.Background(), cc, []byte(legacyMessages), dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported := "* acme: imported"
+			if dryRun {
+				imported = "* acme: would be imported"
+			}
+			for _, want := range []string{
+				imported,
+				"channel gone was not found",
+				"the automatic action join is now a button",
+				"* missing: skipped, the team was not found",
+			} {
+				if !strings.Contains(report, want) {
+					t.Errorf("the report %q doesn't tell %q", report, want)
+				}
+			}
+
+			welcome, err := NewStore(context.Background(), cc).GetTeamWelcome(team.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dryRun {
+				if welcome != nil || fake.made("POST /plugins/com.mattermost.apps/api/v1/subscribe") {
+					t.Error("the dry run imported the welcome")
+				}
+				return
+			}
+			want := &TeamWelcome{
+				Message:             "Welcome {{.DisplayName}}!\nSay hi, @{{.UserName}}.\n\nRead the handbook.",
+				DelaySeconds:        5,
+				RecommendedChannels: []string{"channel"},
+			}
+			if !reflect.DeepEqual(welcome, want) {
+				t.Errorf("imported %+v, want %+v", welcome, want)
+			}
+			if !fake.made("POST /plugins/com.mattermost.apps/api/v1/subscribe") {
+				t.Error("the app didn't subscribe to the team's joins")
+			}
+		})
+	}
+}
+
+func TestLegacyImportHandler(t *testing.T) {
+	useMemoryBackend(t)
+	fake := newFakeMattermost(t)
+	fake.teams = []*model.Team{{Id: "team", Name: "acme"}}
+	fake.users = map[string]*model.User{
+		"admin-token":  {Id: "admin", Roles: model.SystemAdminRoleId},
+		"member-token": {Id: "member", Roles: model.SystemUserRoleId},
+	}
+
+	previous := scheduler
+	scheduler = NewScheduler()
+	defer func() {
+		scheduler = previous
+	}()
+	serve := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, LegacyImportPath, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		LegacyImportHandler(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodPost, "admin-token", legacyMessages); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status before any call = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	scheduler.SetContext(callContext(fake, nil, nil))
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "not a post", method: http.MethodGet, token: "admin-token", status: http.StatusMethodNotAllowed},
+		{name: "no token", method: http.MethodPost, body: legacyMessages, status: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodPost, token: "other", body: legacyMessages, status: http.StatusUnauthorized},
+		{name: "not an admin", method: http.MethodPost, token: "member-token", body: legacyMessages, status: http.StatusForbidden},
+		{name: "too large", method: http.MethodPost, token: "admin-token", body: strings.Repeat(" ", maxLegacyConfigSize+1), status: http.StatusRequestEntityTooLarge},
+		{name: "invalid", method: http.MethodPost, token: "admin-token", body: "not json", status: http.StatusBadRequest},
+		{name: "imported", method: http.MethodPost, token: "admin-token", body: legacyMessages, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.token, tt.body)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+// linkCheckTimeout is how long a link has to respond when checked.
+const linkCheckTimeout = 5 * time.Second
+
+// maxCheckedLinks caps the number of links checked per message, so saving a
+// message doesn't wait on dozens of requests.
+const maxCheckedLinks = 20
+
+var linkURLRegexp = regexp.MustCompile(`https?://[^\s()<>\[\]"'` + "`" + `]+`)
+
+// maxLinkRedirects caps the redirects followed when checking a link.
+const maxLinkRedirects = 5
+
+// errForbiddenAddress is returned when a link resolves to an address of the
+// app's network.
+var errForbiddenAddress = errors.New("the address is not public")
+
+// linkCheckClient requests the links with its own transport, through no
+// proxy, which only connects to public addresses: the links are given by the
+// members, and must not let them probe the app's network, e.g. the metadata
+// endpoints of the cloud providers. The addresses are checked once resolved,
+// for each connection, redirects included.
+var linkCheckClient = &http.Client{
+	Timeout: linkCheckTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: linkCheckTimeout,
+			Control: checkLinkAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: linkCheckTimeout,
+		MaxIdleConns:        maxCheckedLinks,
+		IdleConnTimeout:     30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxLinkRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxLinkRedirects)
+		}
+		return checkLinkURL(req.URL)
+	},
+}
+
+// checkLinkAddress is the dialer's control, rejecting the connections to the
+// loopback, private, link-local, multicast and unspecified addresses.
+func checkLinkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return errForbiddenAddress
+	}
+	return nil
+}
+
+// checkLinkURL makes sure the link, or the target of its redirect, is an HTTP
+// one.
+func checkLinkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// messageURLs returns the distinct HTTP links of the message, in order.
+func messageURLs(message string) []string {
+	urls := []string{}
+	seen := map[string]bool{}
+	for _, u := range linkURLRegexp.FindAllString(message, -1) {
+		u = strings.TrimRight(u, ".,;:!?")
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+// checkLink requests the URL, with HEAD, or GET for the servers that don't
+// support HEAD, and returns a warning if it's unreachable, or "" if it
+// resolves. The warning doesn't tell why, so the checks reveal nothing of the
+// app's network; the reason is logged.
+func checkLink(u string) string {
+	parsed, err := url.Parse(u)
+	if err == nil {
+		err = checkLinkURL(parsed)
+	}
+	var resp *http.Response
+	if err == nil {
+		resp, err = linkCheckClient.Head(u)
+	}
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = linkCheckClient.Get(u)
+	}
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("responded %s", resp.Status)
+		}
+	}
+	if err != nil {
+		logger.Debugf("the link %s is unreachable: %v", u, err)
+		return fmt.Sprintf("%s is unreachable", u)
+	}
+	return ""
+}
+
+// CheckLinks requests the HTTP links of the message, concurrently, and
+// returns a warning per dead link. Onboarding docs move around, and a broken
+// link is the first thing a new member would click.
+func CheckLinks(message string) []string {
+	urls := messageURLs(message)
+	warnings := []string{}
+	if len(urls) > maxCheckedLinks {
+		warnings = append(warnings, fmt.Sprintf("only the first %d of %d links were checked", maxCheckedLinks, len(urls)))
+		urls = urls[:maxCheckedLinks]
+	}
+
+	results := make([]string, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			results[i] = checkLink(u)
+		}(i, u)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if result != "" {
+			warnings = append(warnings, result)
+		}
+	}
+	return warnings
+}
+
+// checkLinksField lets the author check the links of the message when saving
+// it. It's off by default, as the links are requested from the app's server.
+var checkLinksField = apps.Field{
+	Type:        apps.FieldTypeBool,
+	Name:        "check_links",
+	Label:       "check_links",
+	ModalLabel:  "Check links",
+	Description: "Check that the links of the message still work",
+}
+
+// saveWarnings lints the saved message and the snippets it includes, and
+// checks its links if asked to.
+func saveWarnings(ctx context.Context, c apps.CallRequest, message string) []string {
+	warnings := append(LintWelcome(message), snippetWarnings(NewStore(ctx, c.Context), message)...)
+	if c.BoolValue("check_links") {
+		warnings = append(warnings, CheckLinks(message)...)
+	}
+	return warnings
+}
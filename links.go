@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// maxWelcomeLinks caps the number of link buttons of a welcome, so they fit
+// under the post.
+const maxWelcomeLinks = 10
+
+// WelcomeLink is a button of the welcome post opening an external page, e.g.
+// the employee handbook.
+type WelcomeLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// OpenLink is the call made by the link buttons of the welcome post. Its state
+// is the URL to open.
+var OpenLink = apps.NewCall("/open_link")
+
+// SetLinksForm sets the link buttons under the last message of the channel's
+// welcome.
+var SetLinksForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			Name:                 "links",
+			ModalLabel:           "Links",
+			Description:          "Links like \"Employee Handbook: https://example.com/handbook\", one per line or separated by semicolons. Leave empty to remove the buttons.",
+			AutocompletePosition: 1,
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_links").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// isLinkURL reports whether u is an absolute http or https URL.
+func isLinkURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// linksBinding returns the in-post binding with a button opening each link.
+// It returns nil if there is no link.
+func linksBinding(links []WelcomeLink) *apps.Binding {
+	if len(links) == 0 {
+		return nil
+	}
+
+	buttons := []apps.Binding{}
+	for i, link := range links {
+		buttons = append(buttons, apps.Binding{
+			Location: apps.Location("link" + strconv.Itoa(i)),
+			Label:    link.Label,
+			Submit:   OpenLink.WithState(link.URL),
+		})
+	}
+	return &apps.Binding{
+		AppID:    AppID,
+		Location: "links",
+		Label:    "Useful links",
+		Bindings: buttons,
+	}
+}
+
+// OpenLinkCall navigates the user who clicked a link button to its URL, in
+// their browser.
+func OpenLinkCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	u, _ := c.State.(string)
+	if !isLinkURL(u) {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't open the link"))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.CallResponse{
+		Type:               apps.CallResponseTypeNavigate,
+		NavigateToURL:      u,
+		UseExternalBrowser: true,
+	})
+}
+
+func SetLinksCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	// The links are parsed like the fields of an attachment, as
+	// "Label: URL" pairs.
+	pairs, err := parseAttachmentFields(c.GetValue("links", ""))
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if len(pairs) > maxWelcomeLinks {
+		httputils.WriteJSON(w,
+			errorResponse("a welcome can have up to %d links, got %d", maxWelcomeLinks, len(pairs)))
+		return
+	}
+	links := []WelcomeLink{}
+	for _, pair := range pairs {
+		if !isLinkURL(pair.Value) {
+			httputils.WriteJSON(w,
+				errorResponse("the link %q must be an http or https URL, got %q", pair.Title, pair.Value))
+			return
+		}
+		links = append(links, WelcomeLink{Label: pair.Title, URL: pair.Value})
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the links"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.Links = links
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the links"))
+		return
+	}
+
+	if len(links) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Removed the link buttons of the welcome of ~%s", cc.Channel.Name))
+		return
+	}
+	labels := []string{}
+	for _, link := range links {
+		labels = append(labels, link.Label)
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("The welcome of ~%s will have buttons to open %s", cc.Channel.Name, strings.Join(labels, ", ")))
+}
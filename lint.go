@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+var (
+	linkSpaceRegexp    = regexp.MustCompile(`\]\s+\(`)
+	linkEmptyRegexp    = regexp.MustCompile(`\]\(\s*\)`)
+	linkUnclosedRegexp = regexp.MustCompile(`\]\([^)]*$`)
+	inlineCodeRegexp   = regexp.MustCompile("`[^`]*`")
+)
+
+// templateVariables are the names of the variables of TemplateData.
+func templateVariables() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(TemplateData{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() {
+			names[t.Field(i).Name] = true
+		}
+	}
+	return names
+}
+
+// LintWelcome checks a welcome message for the mistakes that don't prevent
+// saving it but would show in the posted message: variables that don't exist,
+// code blocks left open and broken link syntax. It returns a warning per
+// mistake found, empty if none.
+func LintWelcome(message string) []string {
+	warnings := []string{}
+
+	tmpl, err := template.New("welcome").Funcs(templateFuncs).Parse(message)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("the template doesn't parse, the message will be posted as is: %s", err))
+	} else if tmpl.Tree != nil {
+		unknown := map[string]bool{}
+		lintNode(tmpl.Tree.Root, templateVariables(), unknown)
+		names := []string{}
+		for name := range unknown {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			warnings = append(warnings, fmt.Sprintf("`{{.%s}}` isn't a variable, the message will be posted as is", name))
+		}
+	}
+
+	fence := ""
+	fenceLine := 0
+	for i, line := range strings.Split(message, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			fenceLine = i + 1
+			continue
+		}
+
+		line = inlineCodeRegexp.ReplaceAllString(line, "")
+		switch {
+		case linkSpaceRegexp.MatchString(line):
+			warnings = append(warnings, fmt.Sprintf("line %d: a link has a space between its `]` and `(`, it won't be a link", i+1))
+		case linkEmptyRegexp.MatchString(line):
+			warnings = append(warnings, fmt.Sprintf("line %d: a link has no URL", i+1))
+		case linkUnclosedRegexp.MatchString(line):
+			warnings = append(warnings, fmt.Sprintf("line %d: a link's URL isn't closed with `)`", i+1))
+		}
+	}
+	if fence != "" {
+		warnings = append(warnings, fmt.Sprintf("line %d: the code block opened with %s is never closed", fenceLine, fence))
+	}
+
+	return warnings
+}
+
+// lintNode collects the fields of the template data used by the node that
+// aren't variables. The bodies of range and with are skipped, as their dot
+// isn't the template data.
+func lintNode(node parse.Node, variables, unknown map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			lintNode(child, variables, unknown)
+		}
+	case *parse.ActionNode:
+		lintNode(n.Pipe, variables, unknown)
+	case *parse.IfNode:
+		lintNode(n.Pipe, variables, unknown)
+		lintNode(n.List, variables, unknown)
+		lintNode(n.ElseList, variables, unknown)
+	case *parse.RangeNode:
+		lintNode(n.Pipe, variables, unknown)
+		lintNode(n.ElseList, variables, unknown)
+	case *parse.WithNode:
+		lintNode(n.Pipe, variables, unknown)
+		lintNode(n.ElseList, variables, unknown)
+	case *parse.TemplateNode:
+		lintNode(n.Pipe, variables, unknown)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			lintNode(cmd, variables, unknown)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			lintNode(arg, variables, unknown)
+		}
+	case *parse.FieldNode:
+		if !variables[n.Ident[0]] {
+			unknown[n.Ident[0]] = true
+		}
+	case *parse.ChainNode:
+		lintNode(n.Node, variables, unknown)
+	}
+}
+
+// lintResponse appends the warnings, if any, to the response to saving a
+// message.
+func lintResponse(cc apps.Context, message string, warnings []string) string {
+	if len(warnings) == 0 {
+		return message
+	}
+	return message + "\n\n" + T(cc, msgLintWarnings, nil) + "\n* " + strings.Join(warnings, "\n* ")
+}
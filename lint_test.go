@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintWelcome(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		warnings []string
+	}{
+		{name: "clean", message: "Welcome {{.FirstName}}! Read [the handbook](https://example.com)."},
+		{name: "conditionals", message: "{{if .IsGuest}}Ask {{.AddedBy}}.{{else}}Hi {{.UserName}}{{end}}"},
+		{name: "range body", message: "{{range .Vars}}{{.Anything}}{{end}}"},
+		{name: "unknown variables", message: "Hi {{.Username}} {{.Team}} {{.Username}}", warnings: []string{"`{{.Team}}` isn't a variable", "`{{.Username}}` isn't a variable"}},
+		{name: "unknown in a function", message: `{{.Nick | default "friend"}}`, warnings: []string{"`{{.Nick}}` isn't a variable"}},
+		{name: "broken template", message: "Hi {{.FirstName", warnings: []string{"the template doesn't parse"}},
+		{name: "open code block", message: "Run:\n```\nmake\n", warnings: []string{"line 2: the code block opened with ``` is never closed"}},
+		{name: "closed code block", message: "Run:\n~~~\n[broken] (link)\n~~~"},
+		{name: "space in link", message: "Read\n[the handbook] (https://example.com)", warnings: []string{"line 2: a link has a space"}},
+		{name: "empty link", message: "Read [the handbook]()", warnings: []string{"line 1: a link has no URL"}},
+		{name: "unclosed link", message: "Read [the handbook](https://example.com", warnings: []string{"line 1: a link's URL isn't closed"}},
+		{name: "inline code", message: "Type `[x] (y)` to check a box"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := LintWelcome(tt.message)
+			if len(warnings) != len(tt.warnings) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.warnings))
+			}
+			for i, want := range tt.warnings {
+				if !strings.HasPrefix(warnings[i], want) {
+					t.Errorf("warning %d = %q, want %q", i, warnings[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry. Entries below the logger's level are
+// discarded.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// levelNames are the names of the levels, as configured and logged.
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// ParseLevel returns the level of the name, e.g. "info".
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("the log level must be debug, info, warn or error, got %q", name)
+}
+
+// Logger writes leveled log entries with fields, e.g. the request ID and the
+// channel of a call, as logfmt text or JSON lines. Loggers returned by With
+// share the output of their parent.
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	level  Level
+	json   bool
+	fields []interface{}
+}
+
+// logger is the app's logger, configured at startup.
+var logger = NewLogger(os.Stderr, LevelInfo, false)
+
+// NewLogger returns a logger writing the entries of the level and above to
+// out, as JSON lines if json is set.
+func NewLogger(out io.Writer, level Level, json bool) *Logger {
+	return &Logger{
+		out:   out,
+		mu:    &sync.Mutex{},
+		level: level,
+		json:  json,
+	}
+}
+
+// With returns a logger adding the fields, given as key and value pairs, to
+// its entries.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append([]interface{}{}, l.fields...), keyvals...)
+	return &child
+}
+
+func (l *Logger) Debug(args ...interface{}) { l.log(LevelDebug, fmt.Sprint(args...)) }
+func (l *Logger) Info(args ...interface{})  { l.log(LevelInfo, fmt.Sprint(args...)) }
+func (l *Logger) Warn(args ...interface{})  { l.log(LevelWarn, fmt.Sprint(args...)) }
+func (l *Logger) Error(args ...interface{}) { l.log(LevelError, fmt.Sprint(args...)) }
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs the error and exits.
+func (l *Logger) Fatal(args ...interface{}) {
+	l.log(LevelError, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string) {
+	if level < l.level {
+		return
+	}
+
+	var line []byte
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if l.json {
+		entry := map[string]interface{}{
+			"time":  now,
+			"level": levelNames[level],
+			"msg":   msg,
+		}
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			entry[fmt.Sprint(l.fields[i])] = jsonValue(l.fields[i+1])
+		}
+		line, _ = json.Marshal(entry)
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "time=%s level=%s msg=%s", now, levelNames[level], logfmtValue(msg))
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			fmt.Fprintf(&b, " %v=%s", l.fields[i], logfmtValue(fmt.Sprint(l.fields[i+1])))
+		}
+		line = []byte(b.String())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(append(line, '\n'))
+}
+
+// jsonValue returns the value of a field as logged in JSON: errors and other
+// values that don't marshal to JSON are logged as text.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	if _, err := json.Marshal(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	return value
+}
+
+// logfmtValue quotes the value if needed to log it as logfmt.
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// stdLogWriter logs the entries written with the standard log package, e.g.
+// by the HTTP server, as warnings.
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	logger.Warn(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying the logger, e.g. of a request.
+func withLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// requestLogger returns the logger of the request, with its ID and the
+// entities of the call, or the app's logger.
+func requestLogger(req *http.Request) *Logger {
+	if l, ok := req.Context().Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return logger
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// maxLookupOptions caps the number of options returned by a lookup.
+const maxLookupOptions = 25
+
+// LookupChannels returns the channels of the current team the acting user can
+// manage the welcome message of.
+var LookupChannels = apps.NewCall("/lookup/channels").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Team:                  apps.ExpandSummary,
+	TeamMember:            apps.ExpandAll,
+})
+
+// channelField lets the user pick a channel other than the current one.
+var channelField = apps.Field{
+	Type:                apps.FieldTypeDynamicSelect,
+	Name:                "channel",
+	Label:               "channel",
+	ModalLabel:          "Channel",
+	Description:         "The channel to use instead of the current one",
+	SelectDynamicLookup: LookupChannels,
+}
+
+func LookupChannelsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w, apps.NewLookupResponse(nil))
+		return
+	}
+
+	client := asActingUser(req.Context(), c.Context)
+	userID := c.Context.ActingUser.Id
+
+	channels, _, err := client.GetChannelsForTeamForUser(c.Context.Team.Id, userID, false, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	members, _, err := client.GetChannelMembersForUser(userID, c.Context.Team.Id, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	membership := map[string]model.ChannelMember{}
+	for _, member := range members {
+		membership[member.ChannelId] = member
+	}
+
+	settings, err := NewStore(req.Context(), c.Context).GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	query := strings.ToLower(c.Query)
+	options := []apps.SelectOption{}
+	for _, channel := range channels {
+		if channel.IsGroupOrDirect() {
+			continue
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(channel.Name), query) &&
+			!strings.Contains(strings.ToLower(channel.DisplayName), query) {
+			continue
+		}
+
+		cc := c.Context
+		if member, ok := membership[channel.Id]; ok {
+			cc.ChannelMember = &member
+		}
+		if CheckCanManageChannel(cc, settings.RequiredRole) != nil {
+			continue
+		}
+
+		options = append(options, apps.SelectOption{
+			Label: channel.DisplayName,
+			Value: channel.Id,
+		})
+	}
+
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Label < options[j].Label
+	})
+	if len(options) > maxLookupOptions {
+		options = options[:maxLookupOptions]
+	}
+
+	httputils.WriteJSON(w, apps.NewLookupResponse(options))
+}
+
+// withSelectedChannel returns the call context with the channel chosen in the
+// "channel" field, if any, in place of the channel the call was made from. The
+// acting user's memberships are fetched, as they are only expanded for the
+// current channel.
+func withSelectedChannel(ctx context.Context, c apps.CallRequest) (apps.Context, error) {
+	return withChannelField(ctx, c, "channel")
+}
+
+// withChannelField is withSelectedChannel for a channel field other than
+// "channel".
+func withChannelField(ctx context.Context, c apps.CallRequest, field string) (apps.Context, error) {
+	return withChannel(ctx, c.Context, c.GetValue(field, ""))
+}
+
+// withChannel returns the call context for the channel instead of the current
+// one, with the acting user's memberships in the channel and its team. The
+// context is returned as is if channelID is empty or the current channel.
+func withChannel(ctx context.Context, cc apps.Context, channelID string) (apps.Context, error) {
+	if channelID == "" || (cc.Channel != nil && cc.Channel.Id == channelID) {
+		return cc, nil
+	}
+
+	client := asActingUser(ctx, cc)
+	channel, _, err := client.GetChannel(channelID, "")
+	if err != nil {
+		return cc, err
+	}
+	cc.Channel = channel
+	cc.ChannelMember = nil
+	cc.TeamMember = nil
+
+	if cc.ActingUser == nil {
+		return cc, nil
+	}
+	if member, _, err := client.GetChannelMember(channelID, cc.ActingUser.Id, ""); err == nil {
+		cc.ChannelMember = member
+	}
+	if channel.TeamId != "" {
+		if member, _, err := client.GetTeamMember(channel.TeamId, cc.ActingUser.Id, ""); err == nil {
+			cc.TeamMember = member
+		}
+	}
+	return cc, nil
+}
@@ -1,45 +1,51 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
 	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
 )
 
 //go:embed icon.png
 var IconData []byte
 
-var RootURL string = os.Getenv("MANIFEST_ROOT_URL")
-var ServerPort string = os.Getenv("SERVER_PORT")
-
 const AppID = "welcome-bot"
 const KVAppPrefix = "wb"
-const commandHelp = `* |/welcomebot preview [team-name] | - preview the welcome message for the given team name. The current user's username will be used to render the template.
-* |/welcomebot list| - list the teams for which welcome messages were defined
-* |/welcomebot set_channel_welcome [welcome-message]| - set the welcome message for the given channel. Direct channels are not supported.
-* |/welcomebot get_channel_welcome| - print the welcome message set for the given channel (if any)
-* |/welcomebot delete_channel_welcome| - delete the welcome message for the given channel (if any)
-`
+
+const listPageSize = 20
+const snippetLength = 50
 
 // Manifest declares the app's metadata. It must be provided for the app to be
 // installable. In this example, the following permissions are requested:
 //   - Create posts as a bot.
 //   - Add icons to the channel header that will call back into your app when
 //     clicked.
+//   - Add a post menu item saving a post as the channel's welcome message.
 //   - Add a /-command with a callback.
+//   - Be notified when users join and leave channels, to post the welcome
+//     and farewell messages.
+//   - Be notified when channels are created, to offer their creators to set
+//     a welcome message.
 var Manifest = apps.Manifest{
 	// App ID must be unique across all Mattermost Apps.
 	AppID: AppID,
 
-	// App's release/version.
-	Version: "v0.1.0",
+	// App's release/version, set when building it.
+	Version: manifestVersion(Version),
 
 	// A (long) display name for the app.
 	DisplayName: "Welcome Bot",
@@ -48,6 +54,11 @@ var Manifest = apps.Manifest{
 	// and forms.
 	Icon: "icon.png",
 
+	// Provision subscriptions and settings once installed, and clean them
+	// up when uninstalled.
+	OnInstall:   OnInstall,
+	OnUninstall: OnUninstall,
+
 	// HomepageURL is required for an app to be installable.
 	HomepageURL: "https://github.com/mattermost/mattermost-app-welcomebot",
 
@@ -55,58 +66,258 @@ var Manifest = apps.Manifest{
 	RequestedPermissions: []apps.Permission{
 		apps.PermissionActAsBot,
 		apps.PermissionActAsUser,
+		apps.PermissionUserJoinedChannelNotification,
 	},
 
-	// Add UI elements: a /-command, and a channel header button.
+	// The bindings depend on the acting user's permissions.
+	Bindings: BindingsSource,
+
+	// Add UI elements: a /-command, a channel header button, and a post
+	// menu item.
 	RequestedLocations: []apps.Location{
 		apps.LocationChannelHeader,
 		apps.LocationCommand,
+		apps.LocationPostMenu,
 	},
 
-	// Running the app as an HTTP service is the only deployment option
-	// supported.
+	// The app runs as an HTTP service, as an AWS Lambda function or as an
+	// OpenFaaS function. The root URL of the HTTP service is set from the
+	// configuration on startup.
 	Deploy: apps.Deploy{
-		HTTP: &apps.HTTP{
-			RootURL: RootURL,
+		HTTP: &apps.HTTP{},
+		AWSLambda: &apps.AWSLambda{
+			Functions: LambdaFunctions,
+		},
+		OpenFAAS: &apps.OpenFAAS{
+			Functions: OpenFAASFunctions,
 		},
 	},
 }
 
-// The details for the App UI bindings
-var Bindings = []apps.Binding{
-	{
-		Location: "/command",
-		Bindings: []apps.Binding{
-			{
-				Icon:        "icon.png",
-				Label:       "mybot",
-				Description: "Welcome Bot app", // appears in autocomplete.
-				Hint:        "[help|list|preview|set_channel_welcome|get_channel_welcome|delete_channel_welcome]",          // appears in autocomplete, usually indicates as to what comes after choosing the option.
-				Bindings: []apps.Binding{
-					{
-						Label: "help", // displays usage information
-						Submit: ShowHelp,
-					},
-					{
-						Label: "list", // Lists the teams for which greetings were defined
-						Submit:  ShowList,
-					},
-					{
-						Label: "preview", // Send ephemeral messages to the user
-						Form:  &ShowPreviewForTeamForm,
-					},
-					{
-						Label: "set_channel_welcome", // Sets the given text as current's channel welcome message.
-						Form:  &SetChannelWelcomeForm,
-					},
-					{
-						Label: "get_channel_welcome",  // Sets the current channel's welcome message
-						Submit:  GetChannelWelcome,
-					},
-					{
-						Label: "delete_channel_welcome",  // Deletes the current channel's welcome message.
-						Submit:  DeleteChannelWelcome,
-					},
+// CommandBinding is the /-command and all its subcommands. BindingsCall only
+// returns the ones the acting user can use.
+var CommandBinding = apps.Binding{
+	Location: "/command",
+	Bindings: []apps.Binding{
+		{
+			Icon:        "icon.png",
+			Label:       "mybot",
+			Description: "Welcome Bot app", // appears in autocomplete.
+			// Hint appears in autocomplete, usually indicates as to what comes after
+			// choosing the option.
+			Hint: "[help|optout|list|preview|set_channel_welcome|get_channel_welcome|delete_channel_welcome|clone|set_attachment|set_recommended_channels|set_links|set_acknowledgment|ack_report|set_digest|set_mention|set_delivery|set_pin|set_greeters|set_variant|variant_report|set_rotation|history|rollback|toggle|send|test|broadcast|set_inheritance|set_team_welcome|set_interests|onboarding|set_checklist|checklist_report|set_follow_up|set_milestone|survey|get_team_welcome|delete_team_welcome|set_channel_default|delete_channel_default|set_channel_farewell|delete_channel_farewell|set_team_farewell|delete_team_farewell|set_server_welcome|get_server_welcome|delete_server_welcome|export|import|set_required_role|set_excluded_users|set_rejoin_window|set_retention|admin|snippet|stats]",
+			Bindings: []apps.Binding{
+				{
+					Label:  "help", // displays usage information
+					Submit: ShowHelp,
+				},
+				{
+					Label: "optout", // Stops the bot's direct messages to the acting user.
+					Form:  &OptOutForm,
+				},
+				{
+					Label: "list", // Lists the channels and teams for which greetings were defined
+					Form:  &ListForm,
+				},
+				{
+					Label: "preview", // Send ephemeral messages to the user
+					Form:  &ShowPreviewForTeamForm,
+				},
+				{
+					Label: "set_channel_welcome", // Sets the given text as current's channel welcome message.
+					Form:  apps.NewFormRef(SetChannelWelcomeFormSource),
+				},
+				{
+					Label:  "get_channel_welcome", // Sets the current channel's welcome message
+					Submit: GetChannelWelcome,
+				},
+				{
+					Label: "delete_channel_welcome", // Deletes the current channel's welcome message.
+					Form:  &DeleteChannelWelcomeForm,
+				},
+				{
+					Label: "clone", // Copies a channel's welcome message to another channel.
+					Form:  &CloneForm,
+				},
+				{
+					Label: "set_attachment", // Shows an attachment under a welcome message.
+					Form:  &SetAttachmentForm,
+				},
+				{
+					Label: "set_recommended_channels", // Sets the channels new members are invited to join.
+					Form:  &SetRecommendedChannelsForm,
+				},
+				{
+					Label: "set_links", // Adds link buttons to the welcome message.
+					Form:  &SetLinksForm,
+				},
+				{
+					Label: "set_acknowledgment", // Adds an acknowledgment button to the welcome message.
+					Form:  &SetAcknowledgmentForm,
+				},
+				{
+					Label: "ack_report", // Shows who acknowledged the welcome message.
+					Form:  &AckReportForm,
+				},
+				{
+					Label: "set_digest", // Welcomes new members together in a single post.
+					Form:  &SetDigestForm,
+				},
+				{
+					Label: "set_mention", // Mentions new members in the welcome message.
+					Form:  &SetMentionForm,
+				},
+				{
+					Label: "set_delivery", // Sends the welcome in the channel, as a DM, as an ephemeral post or in a thread.
+					Form:  &SetDeliveryForm,
+				},
+				{
+					Label: "set_pin", // Keeps a pinned channel guide post.
+					Form:  &SetPinForm,
+				},
+				{
+					Label: "set_greeters", // Notifies greeters of the channel's new members.
+					Form:  &SetGreetersForm,
+				},
+				{
+					Label: "set_variant", // Sets a variant of the welcome message, for A/B testing.
+					Form:  &SetVariantForm,
+				},
+				{
+					Label: "variant_report", // Compares the variants of the welcome message.
+					Form:  &VariantReportForm,
+				},
+				{
+					Label: "set_rotation", // Sets alternative texts of the welcome message, picked at random.
+					Form:  &SetRotationForm,
+				},
+				{
+					Label: "history", // Shows the changes of the welcome message.
+					Form:  &HistoryForm,
+				},
+				{
+					Label: "rollback", // Restores an earlier revision of the welcome message.
+					Form:  &RollbackForm,
+				},
+				{
+					Label: "toggle", // Pauses or resumes the channel's welcome message.
+					Form:  &ToggleForm,
+				},
+				{
+					Label: "send", // Sends the welcome message to a member again.
+					Form:  &SendForm,
+				},
+				{
+					Label: "test", // Sends the welcome message to the acting user as if they just joined.
+					Form:  &TestForm,
+				},
+				{
+					Label: "broadcast", // Sends the welcome message to all the channel's members.
+					Form:  &BroadcastForm,
+				},
+				{
+					Label: "set_inheritance", // Sets whether the channel's welcome replaces the team's default.
+					Form:  &SetInheritanceForm,
+				},
+				{
+					Label: "set_team_welcome", // Sets the given text as the current team's welcome message.
+					Form:  apps.NewFormRef(SetTeamWelcomeFormSource),
+				},
+				{
+					Label: "set_interests", // Sets the interest picker of the team's welcome message.
+					Form:  &SetInterestsForm,
+				},
+				OnboardingBinding,
+				{
+					Label: "set_checklist", // Sets the checklist posted to new members of the team.
+					Form:  &SetChecklistForm,
+				},
+				{
+					Label:  "checklist_report", // Shows the team's checklist progress.
+					Submit: ChecklistReport,
+				},
+				{
+					Label: "set_follow_up", // Sets a follow-up message of the team's drip campaign.
+					Form:  &SetFollowUpForm,
+				},
+				{
+					Label: "set_milestone", // Sets a milestone congratulation of the team's members.
+					Form:  &SetMilestoneForm,
+				},
+				SurveyBinding,
+				{
+					Label:  "get_team_welcome", // Shows the current team's welcome message
+					Submit: GetTeamWelcome,
+				},
+				{
+					Label: "delete_team_welcome", // Deletes the current team's welcome message.
+					Form:  &DeleteTeamWelcomeForm,
+				},
+				{
+					Label: "set_channel_default", // Sets the default welcome of the team's public channels.
+					Form:  &SetChannelDefaultForm,
+				},
+				{
+					Label:  "delete_channel_default", // Deletes the team's default channel welcome.
+					Submit: DeleteChannelDefault,
+				},
+				{
+					Label: "set_channel_farewell", // Sets the message sent when a member leaves the channel.
+					Form:  &SetChannelFarewellForm,
+				},
+				{
+					Label: "delete_channel_farewell", // Deletes the channel's farewell message.
+					Form:  &DeleteChannelFarewellForm,
+				},
+				{
+					Label: "set_team_farewell", // Sets the message sent when a member leaves the team.
+					Form:  &SetTeamFarewellForm,
+				},
+				{
+					Label:  "delete_team_farewell", // Deletes the team's farewell message.
+					Submit: DeleteTeamFarewell,
+				},
+				{
+					Label: "set_server_welcome", // Sets the message sent to every new account of the server.
+					Form:  &SetServerWelcomeForm,
+				},
+				{
+					Label:  "get_server_welcome", // Shows the server welcome message.
+					Submit: GetServerWelcome,
+				},
+				{
+					Label:  "delete_server_welcome", // Deletes the server welcome message.
+					Submit: DeleteServerWelcome,
+				},
+				{
+					Label:  "export", // Sends all the welcomes as a JSON file.
+					Submit: Export,
+				},
+				{
+					Label: "import", // Imports an export, or the configuration of the Welcome Bot plugin.
+					Form:  &ImportForm,
+				},
+				{
+					Label: "set_required_role", // Sets the role required to manage welcome messages.
+					Form:  &SetRequiredRoleForm,
+				},
+				{
+					Label: "set_excluded_users", // Sets the usernames that are never welcomed.
+					Form:  &SetExcludedUsersForm,
+				},
+				{
+					Label: "set_rejoin_window", // Sets how long rejoining members are not welcomed again.
+					Form:  &SetRejoinWindowForm,
+				},
+				{
+					Label: "set_retention", // Sets how long the records of members are kept.
+					Form:  &SetRetentionForm,
+				},
+				AdminBinding,
+				SnippetBinding,
+				{
+					Label: "stats", // Shows the welcome delivery statistics.
+					Form:  &StatsForm,
 				},
 			},
 		},
@@ -118,136 +329,1700 @@ var ShowPreviewForTeamForm = apps.Form{
 	Icon:  "icon.png",
 	Fields: []apps.Field{
 		{
-			Type: "text",
-			Name: "Team Name",
+			Type:                 "text",
+			Name:                 "team_name",
+			Description:          "Preview the team welcome for this team instead of the current channel's welcome",
+			AutocompletePosition: 1,
 		},
+		channelField,
 	},
-	Submit: apps.NewCall("/preview").WithExpand(apps.Expand{ActingUserAccessToken: apps.ExpandAll}),
+	Submit: apps.NewCall("/preview").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		Team:                  apps.ExpandSummary,
+	}),
 }
 
-var SetChannelWelcomeForm = apps.Form{
+var ListForm = apps.Form{
 	Title: "Welcome Bot",
 	Icon:  "icon.png",
 	Fields: []apps.Field{
 		{
-			Type: "text",
-			Name: "team_name",
+			Type:                 "text",
+			TextSubtype:          apps.TextFieldSubtypeNumber,
+			Name:                 "page",
+			Description:          "The page of the list to show",
+			AutocompletePosition: 1,
+		},
+	},
+	Submit: apps.NewCall("/list"),
+}
+
+// SetChannelWelcomeForm is the modal editor for the channel's welcome message.
+// It is served by SetChannelWelcomeFormCall, pre-filled with the existing
+// message.
+var SetChannelWelcomeForm = apps.Form{
+	Title:  "Channel welcome message",
+	Header: "New members of the channel will be greeted with this message.",
+	Icon:   "icon.png",
+	Fields: []apps.Field{
+		welcomeMessageField,
+		channelField,
+		messageIndexField,
+		localeField,
+		guestField,
+		addedField,
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "delay",
+			Label:       "delay",
+			ModalLabel:  "Delay",
+			Description: "How long to wait before posting the message, after the previous one, in seconds or as a duration like 10m",
 		},
+		checkLinksField,
+		dryRunField,
+	},
+	Submit: apps.NewCall("/set_channel_welcome").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// SetTeamWelcomeForm is the modal editor for the team's welcome message. It is
+// served by SetTeamWelcomeFormCall, pre-filled with the existing message.
+var SetTeamWelcomeForm = apps.Form{
+	Title:  "Team welcome message",
+	Header: "New members of the team will get this message as a direct message.",
+	Icon:   "icon.png",
+	Fields: []apps.Field{
+		welcomeMessageField,
+		guestField,
+		addedField,
+		promotionField,
+		checkLinksField,
+		dryRunField,
+	},
+	Submit: apps.NewCall("/set_team_welcome").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// welcomeMessageField is the multi-line markdown editor for welcome messages,
+// limited to the length of a Mattermost post.
+var welcomeMessageField = apps.Field{
+	Type:                 apps.FieldTypeText,
+	TextSubtype:          apps.TextFieldSubtypeTextarea,
+	TextMaxLength:        model.PostMessageMaxRunesV2,
+	Name:                 "message",
+	ModalLabel:           "Welcome message",
+	Description:          fmt.Sprintf("Markdown and template variables like {{.UserName}} are supported, up to %d characters.", model.PostMessageMaxRunesV2),
+	IsRequired:           true,
+	AutocompletePosition: -1,
+}
+
+// messageIndexField selects a message of the channel's welcome sequence.
+var messageIndexField = apps.Field{
+	Type:        apps.FieldTypeText,
+	TextSubtype: apps.TextFieldSubtypeNumber,
+	Name:        "index",
+	Label:       "index",
+	ModalLabel:  "Message number",
+	Description: "The number of the message in the channel's welcome sequence, 1 by default",
+}
+
+// localeField selects the language variant of a message, e.g. "es". New
+// members get the variant matching their locale, or the default message.
+var localeField = apps.Field{
+	Type:        apps.FieldTypeText,
+	Name:        "locale",
+	Label:       "locale",
+	ModalLabel:  "Language",
+	Description: "The language of this variant of the message, e.g. es. Leave empty for the default message.",
+}
+
+// addedField selects the variant of the message for members added by someone
+// else.
+var addedField = apps.Field{
+	Type:        apps.FieldTypeBool,
+	Name:        "added",
+	Label:       "added",
+	ModalLabel:  "Added members",
+	Description: "The variant of the message for members added by someone else, rather than who joined on their own",
+}
+
+// promotionField selects the message of a team's guests once they are
+// promoted to members.
+var promotionField = apps.Field{
+	Type:        apps.FieldTypeBool,
+	Name:        "promotion",
+	Label:       "promotion",
+	ModalLabel:  "Promoted guests",
+	Description: "The message sent to guests once they are promoted to members",
+}
+
+// guestField selects the variant of the message for guest accounts.
+var guestField = apps.Field{
+	Type:        apps.FieldTypeBool,
+	Name:        "guest",
+	Label:       "guest",
+	ModalLabel:  "Guests",
+	Description: "The variant of the message for guest accounts",
+}
+
+var SetChannelWelcomeFormSource = apps.NewCall("/set_channel_welcome/form").WithExpand(apps.Expand{
+	Channel: apps.ExpandSummary,
+})
+var SetTeamWelcomeFormSource = apps.NewCall("/set_team_welcome/form").WithExpand(apps.Expand{
+	Team: apps.ExpandSummary,
+})
+
+var SetRequiredRoleForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
 		{
-			Type: "text",
-			Name: "message",
+			Type:                 apps.FieldTypeStaticSelect,
+			Name:                 "role",
+			Description:          "The role required to manage welcome messages",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+			SelectStaticOptions: []apps.SelectOption{
+				{Label: "channel admin", Value: model.ChannelAdminRoleId},
+				{Label: "team admin", Value: model.TeamAdminRoleId},
+				{Label: "system admin", Value: model.SystemAdminRoleId},
+			},
 		},
 	},
-	Submit: apps.NewCall("/set_channel_welcome").WithExpand(apps.Expand{ActingUserAccessToken: apps.ExpandAll}),
+	Submit: apps.NewCall("/set_required_role").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
 }
 
-var ShowHelp = apps.NewCall("/help").WithExpand(apps.Expand{ActingUserAccessToken: apps.ExpandAll})
-var ShowList = apps.NewCall("/list")
-var GetChannelWelcome = apps.NewCall("/get_channel_welcome")
-var DeleteChannelWelcome = apps.NewCall("/delete_channel_welcome")
+var ShowHelp = apps.NewCall("/help").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Channel:               apps.ExpandSummary,
+	ChannelMember:         apps.ExpandAll,
+	TeamMember:            apps.ExpandAll,
+	Locale:                apps.ExpandAll,
+})
+var GetChannelWelcome = apps.NewCall("/get_channel_welcome").WithExpand(apps.Expand{
+	Channel: apps.ExpandSummary,
+	Locale:  apps.ExpandAll,
+})
+var DeleteChannelWelcomeForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		messageIndexField,
+		localeField,
+		guestField,
+		addedField,
+		dryRunField,
+	},
+	Submit: apps.NewCall("/delete_channel_welcome").WithExpand(apps.Expand{
+		ActingUser:    apps.ExpandSummary,
+		Channel:       apps.ExpandSummary,
+		ChannelMember: apps.ExpandAll,
+		TeamMember:    apps.ExpandAll,
+	}),
+}
+
+// ConfirmDeleteChannelWelcome is submitted by the confirmation modal of
+// delete_channel_welcome. Its state is a deleteWelcomeState.
+var ConfirmDeleteChannelWelcome = apps.NewCall("/delete_channel_welcome/confirm").WithExpand(apps.Expand{
+	ActingUser:    apps.ExpandSummary,
+	Channel:       apps.ExpandSummary,
+	ChannelMember: apps.ExpandAll,
+	TeamMember:    apps.ExpandAll,
+})
+
+// DeleteTeamWelcomeForm deletes the team's welcome, or reports what would be
+// deleted with --dry_run.
+var DeleteTeamWelcomeForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		dryRunField,
+	},
+	Submit: DeleteTeamWelcome,
+}
 
-// main sets up the http server, with paths mapped for the static assets, the
-// bindings callback, and the send function.
+var GetTeamWelcome = apps.NewCall("/get_team_welcome").WithExpand(apps.Expand{
+	Team:   apps.ExpandSummary,
+	Locale: apps.ExpandAll,
+})
+var DeleteTeamWelcome = apps.NewCall("/delete_team_welcome").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+	Team:       apps.ExpandSummary,
+	TeamMember: apps.ExpandAll,
+})
+
+// main sets up the http server, or the AWS Lambda function, with paths mapped
+// for the static assets, the bindings callback, and the calls.
 func main() {
+	config, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		logger.Fatal(err)
+	}
+	level, _ := ParseLevel(config.LogLevel)
+	logger = NewLogger(os.Stderr, level, config.LogFormat == "json")
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	if config.RootURL != "" {
+		Manifest.Deploy.HTTP.RootURL = config.RootURL
+		Manifest.Deploy.HTTP.UseJWT = config.AppSecret != ""
+	} else {
+		Manifest.Deploy.HTTP = nil
+	}
+	if config.PrintManifest {
+		data, err := json.MarshalIndent(Manifest, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	if config.Storage != StorageMattermost {
+		if backend, err = OpenBackend(config.Storage, config.StorageURL); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if config.WelcomeCacheTTL > 0 {
+		welcomeCache = newLRUCache("welcomes", maxCachedWelcomes, config.WelcomeCacheTTL)
+	}
+
+	if config.TracingEndpoint != "" {
+		headers, _ := parseTracingHeaders(config.TracingHeaders)
+		if err = setupTracing(config.TracingEndpoint, headers, config.TracingServiceName, config.TracingSampleRatio, config.Mode == apps.DeployAWSLambda); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	setupAPIClient(config.APIRateLimit, config.TracingEndpoint != "")
+	if config.EventWebhookURL != "" {
+		eventWebhook = NewEventWebhook(config.EventWebhookURL, config.WebhookSecret)
+	}
+	if config.SentryDSN != "" {
+		reporter, err := NewSentryReporter(config.SentryDSN, config.SentryEnvironment, config.Mode == apps.DeployAWSLambda)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		errorReporter = reporter
+	}
+	r := NewRouter(config.AppSecret, config.RateLimit)
+	if config.Mode != apps.DeployAWSLambda {
+		joinPool = NewWorkerPool(config.Workers, config.QueueSize)
+	}
+
 	// Serve static assets: the manifest and the icon.
-	http.HandleFunc("/manifest.json",
+	r.Handle("/manifest.json",
 		httputils.DoHandleJSON(Manifest))
-	http.HandleFunc("/static/icon.png",
+	r.Handle("/static/icon.png",
 		httputils.DoHandleData("image/png", IconData))
 
 	// Bindings callback.
-	http.HandleFunc("/bindings",
-		httputils.DoHandleJSON(apps.NewDataResponse(Bindings)))
+	r.Call(BindingsSource.Path, BindingsCall)
 
-	http.HandleFunc("/preview", PreviewCall)
-	http.HandleFunc("/help", HelpCall)
-	http.HandleFunc("/list", ListCall)
-	http.HandleFunc("/set_channel_welcome", SetChannelWelcomeCall)
-	http.HandleFunc("/get_channel_welcome", GetChannelWelcomeCall)
-	http.HandleFunc("/delete_channel_welcome", DeleteChannelWelcomeCall)
+	// Lifecycle callbacks.
+	r.Call(OnInstall.Path, InstallCall)
+	r.Call(OnUninstall.Path, UninstallCall)
 
-	fmt.Printf("Use '/apps install http %s/manifest.json' to install the app\n", RootURL)
-	log.Fatal(http.ListenAndServe(ServerPort, nil))
-}
+	// Lookups for dynamic select fields.
+	r.Call(LookupChannels.Path, LookupChannelsCall)
 
-func HelpCall(w http.ResponseWriter, req *http.Request) {
-	httputils.WriteJSON(w,
-		apps.NewTextResponse(commandHelp))
+	r.Call("/preview", PreviewCall)
+	r.Call("/help", HelpCall)
+	r.Call("/optout", OptOutCall)
+	r.Call("/optout/button", OptOutCall)
+	r.Call("/list", ListCall)
+	r.Call("/set_channel_welcome", SetChannelWelcomeCall)
+	r.Call(SetChannelWelcomeFormSource.Path, SetChannelWelcomeFormCall)
+	r.Call(ChannelWelcomeEditorSource.Path, ChannelWelcomeEditorFormCall)
+	r.Call(ChannelWelcomeEditorSubmit.Path, ChannelWelcomeEditorCall)
+	r.Call(SaveAsWelcome.Path, SaveAsWelcomeCall)
+	r.Call(DashboardSource.Path, DashboardFormCall)
+	r.Call(DashboardSubmit.Path, DashboardCall)
+	r.Call("/get_channel_welcome", GetChannelWelcomeCall)
+	r.Call("/delete_channel_welcome", DeleteChannelWelcomeCall)
+	r.Call(ConfirmDeleteChannelWelcome.Path, ConfirmDeleteChannelWelcomeCall)
+	r.Call("/clone", CloneCall)
+	r.Call("/set_attachment", SetAttachmentCall)
+	r.Call("/set_recommended_channels", SetRecommendedChannelsCall)
+	r.Call(JoinRecommendedChannel.Path, JoinRecommendedChannelCall)
+	r.Call("/set_links", SetLinksCall)
+	r.Call(OpenLink.Path, OpenLinkCall)
+	r.Call("/set_acknowledgment", SetAcknowledgmentCall)
+	r.Call(Acknowledge.Path, AcknowledgeCall)
+	r.Call("/ack_report", AckReportCall)
+	r.Call("/set_digest", SetDigestCall)
+	r.Call("/set_mention", SetMentionCall)
+	r.Call("/set_delivery", SetDeliveryCall)
+	r.Call("/set_pin", SetPinCall)
+	r.Call("/set_greeters", SetGreetersCall)
+	r.Call("/set_variant", SetVariantCall)
+	r.Call("/variant_report", VariantReportCall)
+	r.Call("/set_rotation", SetRotationCall)
+	r.Call("/history", HistoryCall)
+	r.Call("/rollback", RollbackCall)
+	r.Call("/toggle", ToggleCall)
+	r.Call("/send", SendCall)
+	r.Call("/test", TestCall)
+	r.Call("/broadcast", BroadcastCall)
+	r.Call(ConfirmBroadcast.Path, ConfirmBroadcastCall)
+	r.Call("/set_inheritance", SetInheritanceCall)
+	r.Call("/set_team_welcome", SetTeamWelcomeCall)
+	r.Call("/set_interests", SetInterestsCall)
+	r.Call(PickInterest.Path, PickInterestCall)
+	r.Call(StartOnboarding.Path, StartOnboardingCall)
+	r.Call(SubmitOnboardingProfile.Path, SubmitOnboardingProfileCall)
+	r.Call(SubmitOnboardingNotifications.Path, SubmitOnboardingNotificationsCall)
+	r.Call(SubmitOnboardingChannels.Path, SubmitOnboardingChannelsCall)
+	r.Call(LookupOnboardingChannels.Path, LookupOnboardingChannelsCall)
+	r.Call(OnboardingEnable.Path, OnboardingEnableCall)
+	r.Call(OnboardingDisable.Path, OnboardingDisableCall)
+	r.Call(OnboardingStatus.Path, OnboardingStatusCall)
+	r.Call("/set_checklist", SetChecklistCall)
+	r.Call(ChecklistReport.Path, ChecklistReportCall)
+	r.Call(CompleteChecklistItem.Path, CompleteChecklistItemCall)
+	r.Call("/set_follow_up", SetFollowUpCall)
+	r.Call("/set_milestone", SetMilestoneCall)
+	r.Call(SurveyEnableForm.Submit.Path, SurveyEnableCall)
+	r.Call(SurveyDisable.Path, SurveyDisableCall)
+	r.Call(SurveyReport.Path, SurveyReportCall)
+	r.Call(TakeSurvey.Path, TakeSurveyCall)
+	r.Call(SubmitSurvey.Path, SubmitSurveyCall)
+	r.Call(SetTeamWelcomeFormSource.Path, SetTeamWelcomeFormCall)
+	r.Call("/get_team_welcome", GetTeamWelcomeCall)
+	r.Call("/delete_team_welcome", DeleteTeamWelcomeCall)
+	r.Call("/set_channel_default", SetChannelDefaultCall)
+	r.Call(DeleteChannelDefault.Path, DeleteChannelDefaultCall)
+	r.Call("/set_channel_farewell", SetChannelFarewellCall)
+	r.Call("/delete_channel_farewell", DeleteChannelFarewellCall)
+	r.Call("/set_team_farewell", SetTeamFarewellCall)
+	r.Call(DeleteTeamFarewell.Path, DeleteTeamFarewellCall)
+	r.Call(SetServerWelcomeForm.Submit.Path, SetServerWelcomeCall)
+	r.Call(GetServerWelcome.Path, GetServerWelcomeCall)
+	r.Call(DeleteServerWelcome.Path, DeleteServerWelcomeCall)
+	r.Call(Export.Path, ExportCall)
+	r.Call("/import", ImportCall)
+	r.Call("/set_required_role", SetRequiredRoleCall)
+	r.Call("/set_excluded_users", SetExcludedUsersCall)
+	r.Call("/set_rejoin_window", SetRejoinWindowCall)
+	r.Call("/set_retention", SetRetentionCall)
+	r.Call(AdminDisable.Path, AdminDisableCall)
+	r.Call(AdminEnable.Path, AdminEnableCall)
+	r.Call(AdminGCForm.Submit.Path, AdminGCCall)
+	r.Call(AdminForgetForm.Submit.Path, AdminForgetCall)
+	r.Call(AdminDeadLettersForm.Submit.Path, AdminDeadLettersCall)
+	r.Call(SetSnippetForm.Submit.Path, SetSnippetCall)
+	r.Call(DeleteSnippetForm.Submit.Path, DeleteSnippetCall)
+	r.Call(ListSnippets.Path, ListSnippetsCall)
+	r.Call("/stats", StatsCall)
+
+	// Plain HTTP endpoints, called by admins and monitoring rather than by
+	// Mattermost.
+	r.Handle(LegacyImportPath, LegacyImportHandler)
+	if config.APIToken != "" {
+		r.Handle(APIPath, APIHandler(config.APIToken))
+	}
+	if config.WebhookSecret != "" {
+		r.Handle(WebhookPath, WebhookHandler(config.WebhookSecret))
+	}
+	r.Handle(MetricsPath, MetricsHandler)
+	r.Handle(HealthzPath, HealthzHandler)
+	r.Handle(ReadyzPath, ReadyzHandler)
+	r.Handle(VersionPath, VersionHandler)
+	if config.Mode == apps.DeployOpenFAAS {
+		r.Handle(OpenFAASHealthPath, HealthzHandler)
+	}
+
+	// Subscription callbacks.
+	r.Call(UserJoinedChannel.Path, joinPool.Handle(UserJoinedChannelCall))
+	r.Call(UserJoinedTeam.Path, joinPool.Handle(UserJoinedTeamCall))
+	r.Call(UserLeftChannel.Path, UserLeftChannelCall)
+	r.Call(UserLeftTeam.Path, UserLeftTeamCall)
+	r.Call(BotJoinedChannel.Path, BotJoinedChannelCall)
+	r.Call(BotJoinedTeam.Path, BotJoinedTeamCall)
+	r.Call(ChannelCreated.Path, ChannelCreatedCall)
+	r.Call(UserCreated.Path, joinPool.Handle(UserCreatedCall))
+
+	if config.Mode == apps.DeployAWSLambda {
+		runLambda(r)
+		return
+	}
+	if config.Mode == apps.DeployHTTP {
+		logger.Infof("Use '/apps install http %s/manifest.json' to install the app", config.RootURL)
+	}
+	serve(r, config)
 }
 
+// PreviewCall shows the welcome of the current or given channel, or of the
+// given team, rendered for the acting user. The welcome posts are created as
+// ephemeral posts in the current channel, from the bot and with their buttons,
+// exactly as new members will see them. If the bot isn't allowed to create
+// ephemeral posts, the rendered messages are returned as text instead.
 func PreviewCall(w http.ResponseWriter, req *http.Request) {
-	httputils.WriteJSON(w,
-		apps.NewTextResponse("Shown Welcome Bot Preview"))
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	httputils.WriteJSON(w, preview(req.Context(), c))
+}
+
+// preview renders the welcome of the current or selected channel, or of the
+// selected team, as PreviewCall does.
+func preview(ctx context.Context, c apps.CallRequest) apps.CallResponse {
+	cc, err := withSelectedChannel(ctx, c)
+	if err != nil {
+		return errorResponse("we couldn't find the selected channel")
+	}
+
+	client := asBot(ctx, c.Context)
+	store := NewStore(ctx, cc)
+	channel := cc.Channel
+	team := cc.Team
+
+	var messages []string
+	var jobs []Job
+
+	if teamName := c.GetValue("team_name", ""); teamName != "" {
+		team, _, err = asActingUser(ctx, c.Context).GetTeamByName(teamName, "")
+		if err != nil {
+			return errorResponse("we couldn't find the team %s", teamName)
+		}
+		channel = nil
+		var welcome *TeamWelcome
+		welcome, err = store.GetTeamWelcome(team.Id)
+		if welcome != nil {
+			messages = append(messages, welcome.MessageForUser(cc.ActingUser))
+			jobs = append(jobs, teamWelcomeJob(client, "", team.Id, cc.ActingUser, *welcome,
+				store.NewTemplateData(cc.ActingUser, nil, team)))
+		}
+	} else if channel != nil {
+		var welcome *ChannelWelcome
+		welcome, err = store.GetChannelWelcome(channel.Id)
+		if welcome != nil {
+			for _, m := range welcome.Messages {
+				messages = append(messages, m.MessageForUser(cc.ActingUser))
+			}
+			jobs = welcomeJobs(client, "", cc.ActingUser, *welcome,
+				store.NewTemplateData(cc.ActingUser, channel, team))
+		}
+	}
+
+	if err != nil || len(messages) == 0 {
+		return errorResponse("there is no welcome message to preview")
+	}
+
+	data := store.NewTemplateData(cc.ActingUser, channel, team)
+	rendered := make([]string, len(messages))
+	for i, message := range messages {
+		rendered[i], err = RenderTemplate(message, data)
+		if err != nil {
+			return errorResponse("welcome message %d has an invalid template: %s", i+1, err)
+		}
+	}
+
+	if c.Context.Channel != nil {
+		err = postPreview(client, c.Context.Channel.Id, c.Context.ActingUser.Id, jobs)
+		if err == nil {
+			return apps.NewTextResponse("")
+		}
+		logger.Error(err)
+	}
+
+	return apps.NewTextResponse("%s", strings.Join(rendered, "\n\n---\n\n"))
+}
+
+// postPreview creates the posts of the welcome jobs as ephemeral posts in the
+// channel, only visible to the user.
+func postPreview(client *appclient.Client, channelID, userID string, jobs []Job) error {
+	for _, job := range jobs {
+		if job.Kind != JobKindPost {
+			continue
+		}
+		post := &model.Post{
+			ChannelId: channelID,
+			Message:   job.Message,
+		}
+		post.SetProps(job.Props)
+		if err := createEphemeralPost(client, userID, post); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func ListCall(w http.ResponseWriter, req *http.Request) {
-	var welcomeMessages string
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
 
-	c := apps.CallRequest{}
-	json.NewDecoder(req.Body).Decode(&c)
+	page, err := strconv.Atoi(c.GetValue("page", "1"))
+	if err != nil || page < 1 {
+		httputils.WriteJSON(w,
+			errorResponse("the page must be a positive number"))
+		return
+	}
 
-	client := appclient.AsBot(c.Context)
-	err := client.KVGet(KVAppPrefix, "welcome_message", &welcomeMessages)
-	var message string
+	store := NewStore(req.Context(), c.Context)
+	if err = store.MigrateLegacyWelcome(); err != nil {
+		requestLogger(req).Error(err)
+	}
 
+	index, err := store.GetIndex()
 	if err != nil {
-		message = "There are no welcome messages defined. You need to set the `welcome_messages` with set_welcome_message"
-	} else {
-		message = fmt.Sprintf("%s:\n %s", "Here is the list of the welcome messages", welcomeMessages)
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't list the welcome messages"))
+		return
+	}
+
+	if len(index) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("There are no welcome messages defined. You need to set one with `set_channel_welcome` or `set_team_welcome`"))
+		return
+	}
+
+	pages := (len(index) + listPageSize - 1) / listPageSize
+	if page > pages {
+		httputils.WriteJSON(w,
+			errorResponse("there are only %d pages of welcome messages", pages))
+		return
+	}
+
+	first := (page - 1) * listPageSize
+	last := first + listPageSize
+	if last > len(index) {
+		last = len(index)
+	}
+
+	message := ""
+	if welcomesPaused(store) {
+		message = "**All the welcome messages are paused.** A system admin can resume them with `admin enable`.\n\n"
+	}
+	message += "Here is the list of the welcome and farewell messages:\n\n" +
+		"| Type | Name | Message | Author | Last modified |\n" +
+		"| --- | --- | --- | --- | --- |\n"
+	for _, entry := range index[first:last] {
+		var welcomeMessage string
+		name := entry.Name
+		switch entry.Kind {
+		case IndexKindChannel:
+			name = "~" + name
+			var welcome *ChannelWelcome
+			welcome, err = store.GetChannelWelcome(entry.ID)
+			if m, ok := welcome.Message(1); ok {
+				welcomeMessage = m.Message
+				if n := len(welcome.Messages); n > 1 {
+					welcomeMessage = fmt.Sprintf("(%d messages) %s", n, welcomeMessage)
+				}
+				if welcome.Disabled {
+					welcomeMessage = "(paused) " + welcomeMessage
+				}
+			}
+		case IndexKindTeam:
+			var welcome *TeamWelcome
+			welcome, err = store.GetTeamWelcome(entry.ID)
+			welcomeMessage = welcome.GetMessage()
+		case IndexKindChannelFarewell:
+			name = "~" + name
+			var farewell *Farewell
+			if farewell, err = store.GetChannelFarewell(entry.ID); farewell != nil {
+				welcomeMessage = farewell.Message
+			}
+		case IndexKindTeamFarewell:
+			var farewell *Farewell
+			if farewell, err = store.GetTeamFarewell(entry.ID); farewell != nil {
+				welcomeMessage = farewell.Message
+			}
+		case IndexKindChannelDefault:
+			var welcome *ChannelWelcome
+			welcome, err = store.GetChannelDefault(entry.ID)
+			if m, ok := welcome.Message(1); ok {
+				welcomeMessage = m.Message
+				if n := len(welcome.Messages); n > 1 {
+					welcomeMessage = fmt.Sprintf("(%d messages) %s", n, welcomeMessage)
+				}
+			}
+		}
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+
+		author := "unknown"
+		if entry.AuthorName != "" {
+			author = "@" + entry.AuthorName
+		}
+		modified := "unknown"
+		if entry.UpdatedAt != 0 {
+			modified = formatMillis(entry.UpdatedAt)
+		}
+
+		message += fmt.Sprintf("| %s | %s | %s | %s | %s |\n",
+			entry.Kind, name, snippet(welcomeMessage), author, modified)
+	}
+
+	if pages > 1 {
+		message += fmt.Sprintf("\nPage %d of %d. Use `list [page]` to see the other pages.", page, pages)
 	}
 
 	httputils.WriteJSON(w,
-		apps.NewTextResponse(message))
+		apps.NewTextResponse("%s", message))
+}
+
+// snippet shortens a welcome message so it fits in a single markdown table
+// cell.
+func snippet(message string) string {
+	message = strings.Join(strings.Fields(message), " ")
+	message = strings.ReplaceAll(message, "|", "\\|")
+	if len([]rune(message)) > snippetLength {
+		message = string([]rune(message)[:snippetLength]) + "…"
+	}
+	return message
+}
+
+// formatMillis formats a timestamp in milliseconds, as stored by the app.
+func formatMillis(millis int64) string {
+	return time.UnixMilli(millis).UTC().Format("2006-01-02 15:04 MST")
 }
 
 func SetChannelWelcomeCall(w http.ResponseWriter, req *http.Request) {
-	c := apps.CallRequest{}
-	json.NewDecoder(req.Body).Decode(&c)
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	httputils.WriteJSON(w, setChannelWelcome(req.Context(), c))
+}
+
+// setChannelWelcome stores the submitted message as the welcome of the current
+// or selected channel.
+func setChannelWelcome(ctx context.Context, c apps.CallRequest) apps.CallResponse {
+	cc, err := withSelectedChannel(ctx, c)
+	if err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	c.Context = cc
+
+	if c.Context.Channel == nil {
+		return apps.NewErrorResponse(errors.New(T(c.Context, msgChannelNotFound, nil)))
+	}
+	if c.Context.Channel.IsGroupOrDirect() {
+		return apps.NewErrorResponse(errors.New("welcome messages can't be set for direct or group messages"))
+	}
+
+	store := NewStore(ctx, c.Context)
+	if err := checkCanManageChannel(store, c.Context); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+
+	if err = requireValues(c, "message"); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	welcomeMessage := c.GetValue("message", "")
+	if err = checkWelcomeLength(welcomeMessage); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+
+	index, err := strconv.Atoi(c.GetValue("index", "1"))
+	if err != nil {
+		return apps.NewErrorResponse(errors.New("the message number must be a number"))
+	}
+	delay, err := parseDelay(c.GetValue("delay", "0"))
+	if err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	locale := c.GetValue("locale", "")
+	if locale != "" {
+		if locale, err = normalizeLocale(locale); err != nil {
+			return apps.NewErrorResponse(fmt.Errorf("invalid locale: %w", err))
+		}
+	}
+	guest := c.BoolValue("guest")
+	if guest && locale != "" {
+		return apps.NewErrorResponse(errors.New("the guest variant can't have a locale"))
+	}
+	added := c.BoolValue("added")
+	if added && (guest || locale != "") {
+		return apps.NewErrorResponse(errors.New("the variant for added members can't be for guests or have a locale"))
+	}
+
+	welcome, err := store.GetChannelWelcome(c.Context.Channel.Id)
+	if err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(c.Context, msgSetWelcomeFailed, nil)))
+	}
+	if welcome == nil {
+		welcome = &ChannelWelcome{}
+	}
+	if state, ok := editorState(c); ok && state.ChannelID == c.Context.Channel.Id && state.Version != welcome.Version {
+		return apps.NewErrorResponse(errors.New(T(c.Context, msgChannelWelcomeChanged, nil)))
+	}
+
+	existing, _ := welcome.Message(index)
+	previous := existing.Message
+	switch {
+	case guest:
+		previous = existing.GuestMessage
+		err = welcome.SetGuestMessage(index, welcomeMessage)
+	case added:
+		previous = existing.AddedMessage
+		err = welcome.SetAddedMessage(index, welcomeMessage)
+	case locale != "":
+		previous = existing.Translations[locale]
+		err = welcome.SetTranslation(index, locale, welcomeMessage)
+	default:
+		// Replacing the default message keeps its variants.
+		err = welcome.SetMessage(index, WelcomeMessage{
+			Message:      welcomeMessage,
+			DelaySeconds: delay,
+			Translations: existing.Translations,
+			GuestMessage: existing.GuestMessage,
+			AddedMessage: existing.AddedMessage,
+			Attachment:   existing.Attachment,
+		})
+	}
+	if err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	if err = welcome.CheckLimits(); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	if c.BoolValue("dry_run") {
+		return dryRunSetResponse(c.Context, welcomeMessage, previous, saveWarnings(ctx, c, welcomeMessage))
+	}
 
-	welcomeMessage := c.Values["message"]
+	if err = updateGuide(asBot(ctx, c.Context), c.Context.Channel, welcome); err != nil {
+		logger.Error(err)
+	}
+	if err = store.SetChannelWelcome(c.Context.Channel.Id, *welcome); err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(c.Context, msgSetWelcomeFailed, nil)))
+	}
+	if err = enableChannelWelcome(ctx, c.Context); err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(c.Context, msgChannelSubscribeFailed, nil)))
+	}
 
-	client := appclient.AsBot(c.Context)
-	isSet, err := client.KVSet(KVAppPrefix, "welcome_message", &welcomeMessage)
 	var message string
+	switch {
+	case guest:
+		message = T(c.Context, msgChannelWelcomeGuestStored, map[string]interface{}{
+			"Index":   index,
+			"Message": welcomeMessage,
+		})
+	case added:
+		message = T(c.Context, msgChannelWelcomeAddedStored, map[string]interface{}{
+			"Index":   index,
+			"Message": welcomeMessage,
+		})
+	case locale != "":
+		message = T(c.Context, msgChannelWelcomeVariantStored, map[string]interface{}{
+			"Locale":  locale,
+			"Index":   index,
+			"Message": welcomeMessage,
+		})
+	default:
+		message = T(c.Context, msgChannelWelcomeStored, map[string]interface{}{
+			"Index":   index,
+			"Count":   len(welcome.Messages),
+			"Message": welcomeMessage,
+		})
+	}
 
-	if err != nil || !isSet {
-		log.Println(err)
-		message = "We couldn't set your message"
-	} else {
-		message = fmt.Sprintf("%s:\n %s", "Stored the welcome message", welcomeMessage)
+	return apps.NewTextResponse("%s", lintResponse(c.Context, message, saveWarnings(ctx, c, welcomeMessage)))
+}
+
+// parseDelay parses a delay given either as a number of seconds or as a
+// duration like "10m", and returns it in seconds.
+func parseDelay(value string) (int, error) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return seconds, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return int(d.Seconds()), nil
 	}
+	return 0, errors.New("the delay must be a positive number of seconds, or a duration like 10m")
+}
 
-	httputils.WriteJSON(w,
-		apps.NewTextResponse(message))
+// SetChannelWelcomeFormCall returns the channel welcome editor, pre-filled
+// with the message currently set for the channel.
+func SetChannelWelcomeFormCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Channel == nil {
+		httputils.WriteJSON(w,
+			apps.NewFormResponse(welcomeEditor(SetChannelWelcomeForm, WelcomeMessage{})))
+		return
+	}
+
+	welcome, err := NewStore(req.Context(), c.Context).GetChannelWelcome(c.Context.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	welcomeMessage, _ := welcome.Message(1)
+
+	form := welcomeEditor(SetChannelWelcomeForm, welcomeMessage)
+	form.Submit = form.Submit.WithState(newEditorState(c.Context.Channel.Id, welcome))
+	httputils.WriteJSON(w, apps.NewFormResponse(form))
+}
+
+// welcomeEditorState is the state of the channel welcome editors: the channel
+// and the version of its welcome they were opened with. Saving to that
+// channel is refused if its welcome was changed since.
+type welcomeEditorState struct {
+	ChannelID string `json:"channel_id"`
+	Version   int    `json:"version"`
+}
+
+func newEditorState(channelID string, welcome *ChannelWelcome) welcomeEditorState {
+	state := welcomeEditorState{ChannelID: channelID}
+	if welcome != nil {
+		state.Version = welcome.Version
+	}
+	return state
+}
+
+// editorState returns the state of the editor the call was submitted from, if
+// any. Commands typed in full have none, and always save.
+func editorState(c apps.CallRequest) (welcomeEditorState, bool) {
+	state := welcomeEditorState{}
+	if c.State == nil {
+		return state, false
+	}
+	data, _ := json.Marshal(c.State)
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false
+	}
+	return state, true
+}
+
+// welcomeEditor returns a copy of the editor form with the message and delay
+// fields set from message.
+func welcomeEditor(form apps.Form, message WelcomeMessage) apps.Form {
+	fields := make([]apps.Field, len(form.Fields))
+	copy(fields, form.Fields)
+	for i := range fields {
+		switch {
+		case fields[i].Name == "message" && message.Message != "":
+			fields[i].Value = message.Message
+		case fields[i].Name == "delay" && message.DelaySeconds != 0:
+			fields[i].Value = strconv.Itoa(message.DelaySeconds)
+		}
+	}
+	form.Fields = fields
+	return form
+}
+
+// enableChannelWelcome makes sure the bot is able to post in the channel the
+// call was made from, subscribes to its join events and records the channel in
+// the welcome index.
+func enableChannelWelcome(ctx context.Context, cc apps.Context) error {
+	_, _, err := asActingUser(ctx, cc).AddChannelMember(cc.Channel.Id, cc.BotUserID)
+	if err != nil {
+		return err
+	}
+
+	if err = SubscribeToChannel(asBot(ctx, cc), cc.Channel.Id); err != nil {
+		return err
+	}
+
+	return NewStore(ctx, cc).PutIndexEntry(NewChannelIndexEntry(cc.Channel, cc.ActingUser))
 }
 
 func GetChannelWelcomeCall(w http.ResponseWriter, req *http.Request) {
-	var welcomeMessage string
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
 
-	c := apps.CallRequest{}
-	json.NewDecoder(req.Body).Decode(&c)
+	if c.Context.Channel == nil {
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgChannelNotFound, nil))))
+		return
+	}
 
-	client := appclient.AsBot(c.Context)
-	err := client.KVGet(KVAppPrefix, "welcome_message", &welcomeMessage)
+	store := NewStore(req.Context(), c.Context)
+	if err := store.MigrateLegacyWelcome(); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	welcome, layer, err := store.GetEffectiveChannelWelcome(c.Context.Channel)
 	var message string
 
-	if err != nil {
-		message = "You need to set the `welcome_messages` with set_welcome_message"
+	if err != nil || welcome == nil {
+		message = T(c.Context, msgChannelWelcomeNotSet, nil)
+	} else if len(welcome.Messages) == 1 && len(welcome.Messages[0].Translations) == 0 && welcome.Messages[0].GuestMessage == "" && welcome.Messages[0].AddedMessage == "" {
+		message = T(c.Context, msgChannelWelcomeIs, map[string]interface{}{
+			"Message": welcome.Messages[0].Message,
+		})
 	} else {
-		message = fmt.Sprintf("%s:\n %s", "Welcome message is", welcomeMessage)
+		message = T(c.Context, msgChannelWelcomesAre, nil)
+		for i, m := range welcome.Messages {
+			message += fmt.Sprintf("\n**Message %d**", i+1)
+			if m.DelaySeconds > 0 {
+				message += fmt.Sprintf(" (after %s)", m.Delay())
+			}
+			message += "\n" + m.Message + "\n"
+			for _, locale := range m.Locales() {
+				message += fmt.Sprintf("\n_%s_\n%s\n", locale, m.Translations[locale])
+			}
+			if m.GuestMessage != "" {
+				message += fmt.Sprintf("\n_%s_\n%s\n", T(c.Context, msgGuestVariant, nil), m.GuestMessage)
+			}
+			if m.AddedMessage != "" {
+				message += fmt.Sprintf("\n_%s_\n%s\n", T(c.Context, msgAddedVariant, nil), m.AddedMessage)
+			}
+		}
+	}
+	if welcome != nil && welcome.Disabled {
+		message += T(c.Context, msgChannelWelcomePaused, nil)
 	}
+	if welcome != nil && layer == WelcomeLayerTeamDefault {
+		message += T(c.Context, msgChannelWelcomeFromDefault, nil)
+	} else if welcome != nil && layer == WelcomeLayerAppended {
+		message += T(c.Context, msgChannelWelcomeAppended, nil)
+	}
+	message += recommendedChannelsSummary(store, c.Context.Channel.Id, welcome)
 
 	httputils.WriteJSON(w,
-		apps.NewTextResponse(message))
+		apps.NewTextResponse("%s", message))
+}
+
+// deleteWelcomeState is the state of the deletion confirmation modal: what the
+// user asked to delete.
+type deleteWelcomeState struct {
+	ChannelID string `json:"channel_id"`
+	Index     string `json:"index,omitempty"`
+	Locale    string `json:"locale,omitempty"`
+	Guest     bool   `json:"guest,omitempty"`
+	Added     bool   `json:"added,omitempty"`
 }
 
 func DeleteChannelWelcomeCall(w http.ResponseWriter, req *http.Request) {
-	c := apps.CallRequest{}
-	json.NewDecoder(req.Body).Decode(&c)
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	httputils.WriteJSON(w, confirmDeleteChannelWelcome(req.Context(), c))
+}
+
+// confirmDeleteChannelWelcome returns the modal asking the user to confirm the
+// deletion of the channel's welcome, or of one of its messages or variants,
+// with a preview of what will be deleted.
+func confirmDeleteChannelWelcome(ctx context.Context, c apps.CallRequest) apps.CallResponse {
+	cc := c.Context
+	if cc.Channel == nil {
+		return apps.NewErrorResponse(errors.New(T(cc, msgChannelNotFound, nil)))
+	}
+
+	store := NewStore(ctx, cc)
+	if err := checkCanManageChannel(store, cc); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	if err := store.MigrateLegacyWelcome(); err != nil {
+		logger.Error(err)
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+	if welcome == nil {
+		return apps.NewErrorResponse(errors.New("the channel has no welcome message"))
+	}
+
+	state := deleteWelcomeState{
+		ChannelID: cc.Channel.Id,
+		Index:     c.GetValue("index", ""),
+		Locale:    c.GetValue("locale", ""),
+		Guest:     c.BoolValue("guest"),
+		Added:     c.BoolValue("added"),
+	}
+
+	var question string
+	var previews []string
+	switch {
+	case state.Guest:
+		if state.Index == "" {
+			state.Index = "1"
+		}
+		i, err := strconv.Atoi(state.Index)
+		if err != nil {
+			return apps.NewErrorResponse(errors.New("the message number must be a number"))
+		}
+		m, _ := welcome.Message(i)
+		if m.GuestMessage == "" {
+			return apps.NewErrorResponse(fmt.Errorf("message number %d has no guest variant", i))
+		}
+		question = T(cc, msgConfirmDeleteGuest, map[string]interface{}{"Index": i})
+		previews = []string{m.GuestMessage}
+
+	case state.Added:
+		if state.Index == "" {
+			state.Index = "1"
+		}
+		i, err := strconv.Atoi(state.Index)
+		if err != nil {
+			return apps.NewErrorResponse(errors.New("the message number must be a number"))
+		}
+		m, _ := welcome.Message(i)
+		if m.AddedMessage == "" {
+			return apps.NewErrorResponse(fmt.Errorf("message number %d has no variant for added members", i))
+		}
+		question = T(cc, msgConfirmDeleteAdded, map[string]interface{}{"Index": i})
+		previews = []string{m.AddedMessage}
+
+	case state.Locale != "":
+		if state.Index == "" {
+			state.Index = "1"
+		}
+		i, err := strconv.Atoi(state.Index)
+		if err != nil {
+			return apps.NewErrorResponse(errors.New("the message number must be a number"))
+		}
+		if state.Locale, err = normalizeLocale(state.Locale); err != nil {
+			return apps.NewErrorResponse(fmt.Errorf("invalid locale: %w", err))
+		}
+		m, _ := welcome.Message(i)
+		translation, ok := m.Translations[state.Locale]
+		if !ok {
+			return apps.NewErrorResponse(fmt.Errorf("message number %d has no %s variant", i, state.Locale))
+		}
+		question = T(cc, msgConfirmDeleteVariant, map[string]interface{}{"Locale": state.Locale, "Index": i})
+		previews = []string{translation}
+
+	case state.Index != "":
+		i, err := strconv.Atoi(state.Index)
+		if err != nil {
+			return apps.NewErrorResponse(errors.New("the message number must be a number"))
+		}
+		m, ok := welcome.Message(i)
+		if !ok {
+			return apps.NewErrorResponse(fmt.Errorf("there is no message number %d", i))
+		}
+		question = T(cc, msgConfirmDeleteMessage, map[string]interface{}{"Index": i})
+		previews = []string{m.Message}
+
+	default:
+		question = T(cc, msgConfirmDeleteWelcome, map[string]interface{}{"Channel": cc.Channel.Name})
+		for _, m := range welcome.Messages {
+			previews = append(previews, m.Message)
+		}
+	}
+
+	quotes := make([]string, len(previews))
+	for i, preview := range previews {
+		quotes[i] = quoted(preview)
+	}
+	if c.BoolValue("dry_run") {
+		return apps.NewTextResponse("%s\n%s", T(cc, msgDryRunDelete, nil), strings.Join(quotes, "\n\n"))
+	}
+
+	return apps.NewFormResponse(apps.Form{
+		Title:         T(cc, msgConfirmDeleteTitle, nil),
+		Header:        question + "\n\n" + strings.Join(quotes, "\n\n"),
+		Icon:          "icon.png",
+		Submit:        ConfirmDeleteChannelWelcome.WithState(state),
+		SubmitButtons: "confirm",
+		Fields: []apps.Field{
+			{
+				Type: apps.FieldTypeStaticSelect,
+				Name: "confirm",
+				SelectStaticOptions: []apps.SelectOption{
+					{Label: T(cc, msgConfirmDeleteButton, nil), Value: "delete"},
+				},
+			},
+		},
+	})
+}
+
+// ConfirmDeleteChannelWelcomeCall deletes what the user confirmed the deletion
+// of in the modal.
+func ConfirmDeleteChannelWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	state := deleteWelcomeState{}
+	data, _ := json.Marshal(c.State)
+	if err := json.Unmarshal(data, &state); err != nil || state.ChannelID == "" {
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("invalid confirmation")))
+		return
+	}
+	if c.Context.Channel == nil || c.Context.Channel.Id != state.ChannelID {
+		httputils.WriteJSON(w, apps.NewErrorResponse(errors.New("the confirmation was made from another channel")))
+		return
+	}
+
+	c.Values = map[string]interface{}{
+		"index":  state.Index,
+		"locale": state.Locale,
+		"guest":  state.Guest,
+		"added":  state.Added,
+	}
+	httputils.WriteJSON(w, deleteChannelWelcome(req.Context(), c))
+}
+
+// deleteChannelWelcome removes the channel's welcome and stops listening to
+// its join events.
+func deleteChannelWelcome(ctx context.Context, c apps.CallRequest) apps.CallResponse {
+	if c.Context.Channel == nil {
+		return apps.NewErrorResponse(errors.New(T(c.Context, msgChannelNotFound, nil)))
+	}
+
+	store := NewStore(ctx, c.Context)
+	if err := checkCanManageChannel(store, c.Context); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+
+	if err := store.MigrateLegacyWelcome(); err != nil {
+		logger.Error(err)
+	}
+
+	if c.BoolValue("guest") {
+		return deleteChannelWelcomeGuest(ctx, c.Context, store, c.GetValue("index", "1"))
+	}
+	if c.BoolValue("added") {
+		return deleteChannelWelcomeAdded(ctx, c.Context, store, c.GetValue("index", "1"))
+	}
+	if locale := c.GetValue("locale", ""); locale != "" {
+		return deleteChannelWelcomeVariant(ctx, c.Context, store, c.GetValue("index", "1"), locale)
+	}
+	if index := c.GetValue("index", ""); index != "" {
+		return deleteChannelWelcomeMessage(ctx, c.Context, store, index)
+	}
+
+	if err := removeChannelWelcome(ctx, c.Context, store, c.Context.Channel); err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(c.Context, msgDeleteWelcomeFailed, nil)))
+	}
+
+	return apps.NewTextResponse("%s", T(c.Context, msgChannelWelcomeDeleted, nil))
+}
+
+// removeChannelWelcome deletes the channel's welcome, its pinned guide and the
+// data of its members. The join events of the channel stop, unless it gets the
+// team's default channel welcome instead.
+func removeChannelWelcome(ctx context.Context, cc apps.Context, store *Store, channel *model.Channel) error {
+	welcome, err := store.GetChannelWelcome(channel.Id)
+	if err != nil {
+		return err
+	}
+	if welcome != nil {
+		if err = removeGuide(asBot(ctx, cc), welcome); err != nil {
+			logger.Error(err)
+		}
+	}
+
+	if err = store.DeleteChannelWelcome(channel.Id); err != nil {
+		return err
+	}
+	// The channel's new members get the team's default channel welcome
+	// instead, if there is one.
+	if !hasChannelDefault(store, channel) {
+		if err = UnsubscribeFromChannel(asBot(ctx, cc), channel.Id); err != nil {
+			logger.Error(err)
+		}
+	}
+	if err = store.RemoveIndexEntry(IndexKindChannel, channel.Id); err != nil {
+		logger.Error(err)
+	}
+	if err = store.DeleteRecommendedJoins(channel.Id); err != nil {
+		logger.Error(err)
+	}
+	if err = store.DeleteAcknowledgments(channel.Id); err != nil {
+		logger.Error(err)
+	}
+	if err = store.DeleteVariantAssignments(channel.Id); err != nil {
+		logger.Error(err)
+	}
+	if err = store.DeleteWelcomed(channel.Id); err != nil {
+		logger.Error(err)
+	}
+	if err = store.DeleteWelcomeThread(channel.Id); err != nil {
+		logger.Error(err)
+	}
+	return nil
+}
+
+// deleteChannelWelcomeMessage removes a single message from the channel's
+// welcome sequence. Removing the last message deletes the welcome altogether,
+// with its subscription and tracking data, as deleting it does.
+func deleteChannelWelcomeMessage(ctx context.Context, cc apps.Context, store *Store, index string) apps.CallResponse {
+	channelID := cc.Channel.Id
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return apps.NewErrorResponse(errors.New("the message number must be a number"))
+	}
+
+	welcome, err := store.GetChannelWelcome(channelID)
+	if err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+	if welcome == nil {
+		return apps.NewErrorResponse(errors.New("the channel has no welcome message"))
+	}
+	if err = welcome.RemoveMessage(i); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+
+	if len(welcome.Messages) == 0 {
+		err = removeChannelWelcome(ctx, cc, store, cc.Channel)
+	} else {
+		if err = updateGuide(asBot(ctx, cc), cc.Channel, welcome); err != nil {
+			logger.Error(err)
+		}
+		err = store.SetChannelWelcome(channelID, *welcome)
+	}
+	if err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+
+	return apps.NewTextResponse("%s", T(cc, msgChannelWelcomeMessageDeleted, map[string]interface{}{
+		"Index": i,
+		"Count": len(welcome.Messages),
+	}))
+}
+
+// deleteChannelWelcomeVariant removes the variant of a message of the channel's
+// welcome for the locale.
+func deleteChannelWelcomeVariant(ctx context.Context, cc apps.Context, store *Store, index, locale string) apps.CallResponse {
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return apps.NewErrorResponse(errors.New("the message number must be a number"))
+	}
+	if locale, err = normalizeLocale(locale); err != nil {
+		return apps.NewErrorResponse(fmt.Errorf("invalid locale: %w", err))
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+	if welcome == nil {
+		return apps.NewErrorResponse(errors.New("the channel has no welcome message"))
+	}
+	if err = welcome.RemoveTranslation(i, locale); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	if err = updateGuide(asBot(ctx, cc), cc.Channel, welcome); err != nil {
+		logger.Error(err)
+	}
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+
+	return apps.NewTextResponse("%s", T(cc, msgChannelWelcomeVariantDeleted, map[string]interface{}{
+		"Locale": locale,
+		"Index":  i,
+	}))
+}
+
+// deleteChannelWelcomeGuest removes the guest variant of a message of the
+// channel's welcome sequence.
+func deleteChannelWelcomeGuest(ctx context.Context, cc apps.Context, store *Store, index string) apps.CallResponse {
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return apps.NewErrorResponse(errors.New("the message number must be a number"))
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+	if welcome == nil {
+		return apps.NewErrorResponse(errors.New("the channel has no welcome message"))
+	}
+	if err = welcome.RemoveGuestMessage(i); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	if err = updateGuide(asBot(ctx, cc), cc.Channel, welcome); err != nil {
+		logger.Error(err)
+	}
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+
+	return apps.NewTextResponse("%s", T(cc, msgChannelWelcomeGuestDeleted, map[string]interface{}{
+		"Index": i,
+	}))
+}
+
+// deleteChannelWelcomeAdded removes the variant for added members of a
+// message of the channel's welcome sequence.
+func deleteChannelWelcomeAdded(ctx context.Context, cc apps.Context, store *Store, index string) apps.CallResponse {
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return apps.NewErrorResponse(errors.New("the message number must be a number"))
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+	if welcome == nil {
+		return apps.NewErrorResponse(errors.New("the channel has no welcome message"))
+	}
+	if err = welcome.RemoveAddedMessage(i); err != nil {
+		return apps.NewErrorResponse(err)
+	}
+	if err = updateGuide(asBot(ctx, cc), cc.Channel, welcome); err != nil {
+		logger.Error(err)
+	}
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		logger.Error(err)
+		return apps.NewErrorResponse(errors.New(T(cc, msgDeleteWelcomeFailed, nil)))
+	}
+
+	return apps.NewTextResponse("%s", T(cc, msgChannelWelcomeAddedDeleted, map[string]interface{}{
+		"Index": i,
+	}))
+}
+
+func SetTeamWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgTeamNotFound, nil))))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := requireValues(c, "message"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	welcomeMessage := c.GetValue("message", "")
+	if err := checkWelcomeLength(welcomeMessage); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgSetWelcomeFailed, nil))))
+		return
+	}
+	guest := c.BoolValue("guest")
+	added := c.BoolValue("added")
+	promotion := c.BoolValue("promotion")
+	if guest && added {
+		httputils.WriteJSON(w,
+			errorResponse("the variant for added members can't be for guests"))
+		return
+	}
+	if promotion && (guest || added) {
+		httputils.WriteJSON(w,
+			errorResponse("the promotion message can't be combined with --guest or --added"))
+		return
+	}
+	if welcome == nil {
+		if guest || added || promotion {
+			httputils.WriteJSON(w,
+				errorResponse("the team has no welcome message, set it without --guest, --added or --promotion first"))
+			return
+		}
+		welcome = &TeamWelcome{}
+	}
+	previous := welcome.Message
+	switch {
+	case guest:
+		previous = welcome.GuestMessage
+		welcome.GuestMessage = welcomeMessage
+	case added:
+		previous = welcome.AddedMessage
+		welcome.AddedMessage = welcomeMessage
+	case promotion:
+		previous = welcome.PromotionMessage
+		welcome.PromotionMessage = welcomeMessage
+	default:
+		welcome.Message = welcomeMessage
+	}
+	if err = welcome.CheckLimits(); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if c.BoolValue("dry_run") {
+		httputils.WriteJSON(w, dryRunSetResponse(c.Context, welcomeMessage, previous, saveWarnings(req.Context(), c, welcomeMessage)))
+		return
+	}
+
+	if err = store.SetTeamWelcome(c.Context.Team.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgSetWelcomeFailed, nil))))
+		return
+	}
+	if err = enableTeamWelcome(req.Context(), c.Context); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgTeamSubscribeFailed, nil))))
+		return
+	}
+
+	stored := msgTeamWelcomeStored
+	if guest {
+		stored = msgTeamWelcomeGuestStored
+	} else if added {
+		stored = msgTeamWelcomeAddedStored
+	} else if promotion {
+		stored = msgTeamWelcomePromotionStored
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", lintResponse(c.Context, T(c.Context, stored, map[string]interface{}{
+			"Message": welcomeMessage,
+		}), saveWarnings(req.Context(), c, welcomeMessage))))
+}
+
+// SetTeamWelcomeFormCall returns the team welcome editor, pre-filled with the
+// message currently set for the team.
+func SetTeamWelcomeFormCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	var welcome *TeamWelcome
+	if c.Context.Team != nil {
+		var err error
+		welcome, err = NewStore(req.Context(), c.Context).GetTeamWelcome(c.Context.Team.Id)
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewFormResponse(welcomeEditor(SetTeamWelcomeForm, WelcomeMessage{Message: welcome.GetMessage()})))
+}
+
+// enableTeamWelcome adds the bot to the team the call was made from, so it can
+// see who joins, subscribes to the team's join events and records the team in
+// the welcome index.
+func enableTeamWelcome(ctx context.Context, cc apps.Context) error {
+	_, _, err := asActingUser(ctx, cc).AddTeamMember(cc.Team.Id, cc.BotUserID)
+	if err != nil {
+		return err
+	}
+
+	if err = SubscribeToTeam(asBot(ctx, cc), cc.Team.Id); err != nil {
+		return err
+	}
+
+	return NewStore(ctx, cc).PutIndexEntry(NewTeamIndexEntry(cc.Team, cc.ActingUser))
+}
+
+func GetTeamWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgTeamNotFound, nil))))
+		return
+	}
+
+	welcome, err := NewStore(req.Context(), c.Context).GetTeamWelcome(c.Context.Team.Id)
+	var message string
+
+	if err != nil || welcome == nil {
+		message = T(c.Context, msgTeamWelcomeNotSet, nil)
+	} else {
+		message = T(c.Context, msgTeamWelcomeIs, map[string]interface{}{
+			"Message": welcome.Message,
+		})
+		if welcome.GuestMessage != "" {
+			message += fmt.Sprintf("\n\n_%s_\n%s", T(c.Context, msgGuestVariant, nil), welcome.GuestMessage)
+		}
+		if welcome.AddedMessage != "" {
+			message += fmt.Sprintf("\n\n_%s_\n%s", T(c.Context, msgAddedVariant, nil), welcome.AddedMessage)
+		}
+		if welcome.PromotionMessage != "" {
+			message += fmt.Sprintf("\n\n_%s_\n%s", T(c.Context, msgPromotionVariant, nil), welcome.PromotionMessage)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
+
+func DeleteTeamWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgTeamNotFound, nil))))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if c.BoolValue("dry_run") {
+		welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+		if welcome == nil {
+			httputils.WriteJSON(w,
+				apps.NewTextResponse("%s", T(c.Context, msgTeamWelcomeNotSet, nil)))
+			return
+		}
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("%s\n%s", T(c.Context, msgDryRunDeleteTeam, nil), quoted(welcome.Message)))
+		return
+	}
+
+	if err := removeTeamWelcome(req.Context(), c.Context, store, c.Context.Team.Id); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			apps.NewErrorResponse(errors.New(T(c.Context, msgDeleteTeamWelcomeFailed, nil))))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", T(c.Context, msgTeamWelcomeDeleted, nil)))
+}
+
+// removeTeamWelcome deletes the team's welcome, the data of its members and
+// their queued milestones, and stops the join events of the team.
+func removeTeamWelcome(ctx context.Context, cc apps.Context, store *Store, teamID string) error {
+	if err := store.DeleteTeamWelcome(teamID); err != nil {
+		return err
+	}
+	if err := UnsubscribeFromTeam(asBot(ctx, cc), teamID); err != nil {
+		logger.Error(err)
+	}
+	if err := store.RemoveIndexEntry(IndexKindTeam, teamID); err != nil {
+		logger.Error(err)
+	}
+	if err := store.DeleteOnboarding(teamID); err != nil {
+		logger.Error(err)
+	}
+	if err := store.DeleteChecklistProgress(teamID); err != nil {
+		logger.Error(err)
+	}
+	if err := store.DeleteCampaigns(teamID); err != nil {
+		logger.Error(err)
+	}
+	err := scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.Kind == JobKindMilestone && job.TeamID == teamID
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+	if err := store.DeleteSurveyResponses(teamID); err != nil {
+		logger.Error(err)
+	}
+	return nil
+}
+
+// checkWelcomeLength enforces the editor's length limit, which a /command
+// invocation can bypass, which is also the longest message of a post.
+func checkWelcomeLength(message string) error {
+	if n := utf8.RuneCountInString(message); n > model.PostMessageMaxRunesV2 {
+		return fmt.Errorf("the welcome message is %d characters long, %d over the limit of %d", n, n-model.PostMessageMaxRunesV2, model.PostMessageMaxRunesV2)
+	}
+	return nil
+}
+
+// checkCanManageChannel checks the acting user against the role required by
+// the settings to manage channel welcomes.
+func checkCanManageChannel(store *Store, cc apps.Context) error {
+	settings, err := store.GetSettings()
+	if err != nil {
+		logger.Error(err)
+	}
+	return CheckCanManageChannel(cc, settings.RequiredRole)
+}
 
-	client := appclient.AsBot(c.Context)
-	client.KVDelete(KVAppPrefix, "welcome_message")
+// checkCanManageTeam checks the acting user against the role required by the
+// settings to manage team welcomes.
+func checkCanManageTeam(store *Store, cc apps.Context) error {
+	settings, err := store.GetSettings()
+	if err != nil {
+		logger.Error(err)
+	}
+	return CheckCanManageTeam(cc, settings.RequiredRole)
+}
+
+func SetRequiredRoleCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	role := c.GetValue("role", "")
+	if !isValidManagerRole(role) {
+		httputils.WriteJSON(w, apps.NewErrorResponse(
+			fmt.Errorf("the role must be one of %s", strings.Join(ManagerRoles, ", "))))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	settings, err := store.GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	settings.RequiredRole = role
+	if err = store.SetSettings(settings); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
 
 	httputils.WriteJSON(w,
-		apps.NewTextResponse("Shown Welcome Bot Delete channel welcome"))
+		apps.NewTextResponse("Welcome messages can now be managed by users with the %s role.", roleName(role)))
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Mattermost doesn't notify apps of new posts, so the first post of a new
+// member is looked for every reactPollInterval, for up to reactWindow after
+// they joined.
+const (
+	reactPollInterval = 10 * time.Minute
+	reactWindow       = 24 * time.Hour
+	reactEmoji        = "wave"
+)
+
+// SetMentionForm makes the channel's welcome @-mention the new member, and
+// optionally react to their first post.
+var SetMentionForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeStaticSelect,
+			Name:                 "mention",
+			Description:          "Whether the welcome mentions the new member",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+			SelectStaticOptions: []apps.SelectOption{
+				{Label: "on", Value: "on"},
+				{Label: "off", Value: "off"},
+			},
+		},
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "react",
+			Label:       "react",
+			ModalLabel:  "React",
+			Description: "Also react with :wave: to the first message of the new member",
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_mention").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// withMention prefixes the message with a mention of the user, unless it
+// already mentions them.
+func withMention(message string, user *model.User) string {
+	mention := "@" + user.Username
+	if strings.Contains(message, mention) {
+		return message
+	}
+	return mention + " " + message
+}
+
+// newReactJob returns the job reacting to the first post of the new member.
+func newReactJob(channelID, userID string) Job {
+	now := model.GetMillis()
+	return Job{
+		ID:        model.NewId(),
+		Kind:      JobKindReact,
+		RunAt:     now + reactPollInterval.Milliseconds(),
+		ChannelID: channelID,
+		UserID:    userID,
+		Since:     now,
+	}
+}
+
+// reactJob reacts to the first post the member made in the channel since they
+// joined. If they haven't posted yet, the job is queued again, until the
+// reaction window is over.
+func reactJob(ctx context.Context, cc apps.Context, client *appclient.Client, job Job) error {
+	posts, _, err := client.GetPostsSince(job.ChannelID, job.Since, false)
+	if err != nil {
+		return err
+	}
+	posts.SortByCreateAt()
+
+	// The posts are sorted from the newest, so they are walked backwards.
+	list := posts.ToSlice()
+	for i := len(list) - 1; i >= 0; i-- {
+		post := list[i]
+		if post.UserId != job.UserID || post.Type != "" || post.DeleteAt != 0 {
+			continue
+		}
+		_, _, err = client.SaveReaction(&model.Reaction{
+			UserId:    cc.BotUserID,
+			PostId:    post.Id,
+			EmojiName: reactEmoji,
+		})
+		return err
+	}
+
+	if time.Duration(model.GetMillis()-job.Since)*time.Millisecond >= reactWindow {
+		return nil
+	}
+	job.RunAt = model.GetMillis() + reactPollInterval.Milliseconds()
+	return scheduler.Enqueue(ctx, cc, []Job{job})
+}
+
+func SetMentionCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = requireValues(c, "mention"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	mention := c.GetValue("mention", "")
+	if mention != "on" && mention != "off" {
+		httputils.WriteJSON(w,
+			errorResponse("mention must be on or off"))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the mention"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.MentionMember = mention == "on"
+	welcome.ReactToFirstPost = c.BoolValue("react")
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the mention"))
+		return
+	}
+
+	var message string
+	switch {
+	case welcome.MentionMember && welcome.ReactToFirstPost:
+		message = "The welcome of ~%s will mention new members, and react to their first message."
+	case welcome.MentionMember:
+		message = "The welcome of ~%s will mention new members."
+	case welcome.ReactToFirstPost:
+		message = "The welcome of ~%s won't mention new members, but will react to their first message."
+	default:
+		message = "The welcome of ~%s won't mention new members."
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse(message, cc.Channel.Name))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsPath is where the Prometheus metrics are served.
+const MetricsPath = "/metrics"
+
+const metricsNamespace = "welcomebot"
+
+var (
+	welcomesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "welcomes_sent_total",
+		Help:      "Welcome posts created, by kind of job: post or digest.",
+	}, []string{"kind"})
+
+	callsExecuted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "calls_total",
+		Help:      "Calls received from Mattermost, e.g. commands and events, by path.",
+	}, []string{"path"})
+
+	kvErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kv_errors_total",
+		Help:      "Failed KV store operations, by operation.",
+	}, []string{"operation"})
+
+	apiDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "mattermost_api_duration_seconds",
+		Help:      "Latency of the requests to the Mattermost API, by API and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api", "code"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests over the rate limits, by direction: inbound requests rejected, or outbound requests to Mattermost delayed.",
+	}, []string{"direction"})
+
+	apiRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mattermost_api_retries_total",
+		Help:      "Requests to Mattermost retried after a temporary failure.",
+	})
+
+	deadLetters = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dead_letters_total",
+		Help:      "Welcome deliveries that failed for good, kept as dead letters.",
+	})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_lookups_total",
+		Help:      "Lookups in the in-memory caches, by cache and result: hit or miss.",
+	}, []string{"cache", "result"})
+
+	reportsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "error_reports_dropped_total",
+		Help:      "Error reports dropped because too many were waiting to be sent.",
+	})
+
+	poolQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "join_queue_length",
+		Help:      "Join events waiting for a worker.",
+	})
+
+	poolQueueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "join_queue_wait_seconds",
+		Help:      "Time the join events waited for a worker.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	poolBlocked = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "join_queue_full_total",
+		Help:      "Join events that found the queue full, holding up the call until there was room.",
+	})
+
+	poolDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "join_events_dropped_total",
+		Help:      "Join events dropped because the queue stayed full until Mattermost gave up on the call.",
+	})
+)
+
+// countCalls counts the calls received on each path.
+func countCalls(path string, next http.HandlerFunc) http.HandlerFunc {
+	counter := callsExecuted.WithLabelValues(path)
+	return func(w http.ResponseWriter, req *http.Request) {
+		counter.Inc()
+		next(w, req)
+	}
+}
+
+// metricsKV counts the errors of the KV store operations.
+type metricsKV struct {
+	KVStore
+}
+
+func (kv metricsKV) KVGet(prefix, key string, ref interface{}) error {
+	err := kv.KVStore.KVGet(prefix, key, ref)
+	if err != nil {
+		kvErrors.WithLabelValues("get").Inc()
+	}
+	return err
+}
+
+func (kv metricsKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	changed, err := kv.KVStore.KVSet(prefix, key, value)
+	if err != nil {
+		kvErrors.WithLabelValues("set").Inc()
+	}
+	return changed, err
+}
+
+func (kv metricsKV) KVDelete(prefix, key string) error {
+	err := kv.KVStore.KVDelete(prefix, key)
+	if err != nil {
+		kvErrors.WithLabelValues("delete").Inc()
+	}
+	return err
+}
+
+// apiTransport measures the latency of the requests made to Mattermost, as the
+// last transport of mattermostHTTPClient.
+type apiTransport struct {
+	next http.RoundTripper
+}
+
+func (t apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	apiDuration.WithLabelValues(apiName(req.URL.Path), code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// apiName returns the API a request path belongs to, e.g. "channels" for
+// /api/v4/channels/{id}/members, keeping the label cardinality low.
+func apiName(path string) string {
+	if strings.HasPrefix(path, "/plugins/com.mattermost.apps/") {
+		return "apps"
+	}
+	rest := strings.TrimPrefix(path, "/api/v4/")
+	if rest == path {
+		return "other"
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	return name
+}
+
+// MetricsHandler serves the metrics in the Prometheus format.
+var MetricsHandler = promhttp.Handler().ServeHTTP
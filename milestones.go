@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Milestone is a congratulation sent to the members of a team some time after
+// they joined, e.g. after a week or a year. It is posted in ChannelID if set,
+// or else sent as a direct message.
+type Milestone struct {
+	Message      string `json:"message"`
+	AfterSeconds int    `json:"after_seconds"`
+	ChannelID    string `json:"channel_id,omitempty"`
+}
+
+// After returns AfterSeconds as a time.Duration.
+func (m Milestone) After() time.Duration {
+	return time.Duration(m.AfterSeconds) * time.Second
+}
+
+// SetMilestoneForm adds, replaces or removes a milestone of the team.
+var SetMilestoneForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			TextMaxLength:        model.PostMessageMaxRunesV2,
+			Name:                 "message",
+			ModalLabel:           "Message",
+			Description:          "The congratulation, with template variables like the welcome. Leave empty to remove the milestone.",
+			AutocompletePosition: -1,
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "after",
+			Label:       "after",
+			ModalLabel:  "After",
+			Description: "How long after joining the team the milestone is reached, e.g. 1w, 30d or 1y",
+		},
+		{
+			Type:                apps.FieldTypeDynamicSelect,
+			Name:                "channel",
+			Label:               "channel",
+			ModalLabel:          "Channel",
+			Description:         "The channel to post the congratulation in, instead of a direct message",
+			SelectDynamicLookup: LookupChannels,
+		},
+		{
+			Type:        apps.FieldTypeText,
+			TextSubtype: apps.TextFieldSubtypeNumber,
+			Name:        "index",
+			Label:       "index",
+			ModalLabel:  "Milestone number",
+			Description: "The number of the milestone, a new one by default",
+		},
+	},
+	Submit: apps.NewCall("/set_milestone").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// parseMilestoneAfter parses a time like parseFollowUpDelay does, or as a
+// number of weeks like "2w" or years like "1y".
+func parseMilestoneAfter(value string) (int, error) {
+	for suffix, days := range map[string]int{"w": 7, "y": 365} {
+		if strings.HasSuffix(value, suffix) {
+			if n, err := strconv.Atoi(strings.TrimSuffix(value, suffix)); err == nil && n > 0 {
+				return n * days * 24 * 60 * 60, nil
+			}
+		}
+	}
+	seconds, err := parseFollowUpDelay(value)
+	if err != nil || seconds <= 0 {
+		return 0, errors.New("the milestone must be a number of weeks like 1w, years like 1y, days like 30d, or a duration like 36h")
+	}
+	return seconds, nil
+}
+
+// milestoneJobs returns the jobs sending the rendered milestones to the new
+// member, in their channel or in the direct channel with the bot.
+func milestoneJobs(dmID, teamID string, user *model.User, milestones []Milestone, data TemplateData) []Job {
+	now := model.GetMillis()
+	jobs := []Job{}
+	for _, milestone := range milestones {
+		channelID := milestone.ChannelID
+		if channelID == "" {
+			channelID = dmID
+		}
+		jobs = append(jobs, Job{
+			ID:        model.NewId(),
+			Kind:      JobKindMilestone,
+			RunAt:     now + milestone.After().Milliseconds(),
+			ChannelID: channelID,
+			Message:   RenderWelcome(milestone.Message, data),
+			UserID:    user.Id,
+			TeamID:    teamID,
+		})
+	}
+	return jobs
+}
+
+// milestoneJob sends the congratulation of a JobKindMilestone job, unless the
+// member left the team in the meantime.
+func milestoneJob(client *appclient.Client, job Job) error {
+	member, _, err := client.GetTeamMember(job.TeamID, job.UserID, "")
+	if err != nil || member.DeleteAt != 0 {
+		return nil
+	}
+	return postJob(client, job)
+}
+
+// cancelMilestones drops the queued milestones of a member who left the team.
+func cancelMilestones(ctx context.Context, cc apps.Context, teamID, userID string) {
+	err := scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.Kind == JobKindMilestone && job.TeamID == teamID && job.UserID == userID
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+func SetMilestoneCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	message := c.GetValue("message", "")
+	if err := checkWelcomeLength(message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if _, err := RenderTemplate(message, TemplateData{}); err != nil {
+		httputils.WriteJSON(w, errorResponse("invalid template: %s", err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the milestone"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+
+	index, err := strconv.Atoi(c.GetValue("index", strconv.Itoa(len(welcome.Milestones)+1)))
+	if err != nil || index < 1 || index > len(welcome.Milestones)+1 {
+		httputils.WriteJSON(w,
+			errorResponse("the milestone number must be between 1 and %d", len(welcome.Milestones)+1))
+		return
+	}
+
+	if message == "" {
+		if index > len(welcome.Milestones) {
+			httputils.WriteJSON(w,
+				errorResponse("there is no milestone %d to remove", index))
+			return
+		}
+		welcome.Milestones = append(welcome.Milestones[:index-1], welcome.Milestones[index:]...)
+	} else {
+		if err = requireValues(c, "after"); err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		after, err := parseMilestoneAfter(c.GetValue("after", ""))
+		if err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		milestone := Milestone{Message: message, AfterSeconds: after}
+		if channelID := c.GetValue("channel", ""); channelID != "" {
+			if err = enableMilestoneChannel(req.Context(), c.Context, channelID); err != nil {
+				httputils.WriteJSON(w, apps.NewErrorResponse(err))
+				return
+			}
+			milestone.ChannelID = channelID
+		}
+		if index > len(welcome.Milestones) {
+			welcome.Milestones = append(welcome.Milestones, milestone)
+		} else {
+			welcome.Milestones[index-1] = milestone
+		}
+	}
+
+	if err = store.SetTeamWelcome(c.Context.Team.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the milestone"))
+		return
+	}
+	// The queued milestones are dropped when members leave the team.
+	if len(welcome.Milestones) > 0 {
+		if err = SubscribeToTeamLeaves(asBot(req.Context(), c.Context), c.Context.Team.Id); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", milestonesSummary(asBot(req.Context(), c.Context), welcome.Milestones)))
+}
+
+// enableMilestoneChannel checks that the channel is in the current team, and
+// adds the bot to it so it can post the congratulations.
+func enableMilestoneChannel(ctx context.Context, cc apps.Context, channelID string) error {
+	client := asActingUser(ctx, cc)
+	channel, _, err := client.GetChannel(channelID, "")
+	if err != nil || channel.TeamId != cc.Team.Id {
+		return errors.New("the channel must be in the current team")
+	}
+	if _, _, err = client.AddChannelMember(channel.Id, cc.BotUserID); err != nil {
+		logger.Error(err)
+		return errors.New("we couldn't add the bot to the channel")
+	}
+	return nil
+}
+
+// milestonesSummary lists the milestones of the team, in the order they are
+// reached.
+func milestonesSummary(client *appclient.Client, milestones []Milestone) string {
+	if len(milestones) == 0 {
+		return "The team has no milestones."
+	}
+
+	order := make([]int, len(milestones))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return milestones[order[i]].AfterSeconds < milestones[order[j]].AfterSeconds
+	})
+
+	message := "Members of the team will get these congratulations:\n"
+	for _, i := range order {
+		where := "in a direct message"
+		if milestones[i].ChannelID != "" {
+			where = "in a channel"
+			if channel, _, err := client.GetChannel(milestones[i].ChannelID, ""); err == nil {
+				where = "in ~" + channel.Name
+			}
+		}
+		message += fmt.Sprintf("\n**Milestone %d** (after %s, %s)\n%s\n", i+1, milestones[i].After(), where, milestones[i].Message)
+	}
+	return message
+}
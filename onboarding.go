@@ -0,0 +1,649 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The steps of the onboarding of a team, in order. Each step is a modal form
+// the new member fills or skips, the next one opening when it is submitted.
+const (
+	OnboardingStepProfile       = "profile"
+	OnboardingStepNotifications = "notifications"
+	OnboardingStepChannels      = "channels"
+	OnboardingStepDone          = "done"
+)
+
+// The onboarding calls. Their state is the ID of the team being onboarded,
+// as the forms are opened from the direct channel with the bot.
+var (
+	StartOnboarding = apps.NewCall("/onboarding/start").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+	})
+	SubmitOnboardingProfile = apps.NewCall("/onboarding/profile").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+	})
+	SubmitOnboardingNotifications = apps.NewCall("/onboarding/notifications").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+	})
+	SubmitOnboardingChannels = apps.NewCall("/onboarding/channels").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+	})
+	LookupOnboardingChannels = apps.NewCall("/onboarding/lookup_channels").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+	})
+	OnboardingEnable = apps.NewCall("/onboarding/enable").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandSummary,
+		TeamMember: apps.ExpandAll,
+	})
+	OnboardingDisable = apps.NewCall("/onboarding/disable").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandSummary,
+		TeamMember: apps.ExpandAll,
+	})
+	OnboardingStatus = apps.NewCall("/onboarding/status").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandSummary,
+		TeamMember: apps.ExpandAll,
+	})
+)
+
+// OnboardingBinding groups the onboarding commands.
+var OnboardingBinding = apps.Binding{
+	Label:       "onboarding", // Guides new members of the team through their setup.
+	Description: "Guide new members of the team through setting up their profile, notifications and channels",
+	Hint:        "[start|status|enable|disable]",
+	Bindings: []apps.Binding{
+		{
+			Label:  "start", // Starts or resumes your onboarding.
+			Submit: StartOnboarding,
+		},
+		{
+			Label:  "status", // Shows the onboarding progress of the team's members.
+			Submit: OnboardingStatus,
+		},
+		{
+			Label:  "enable", // Offers the onboarding to new members of the team.
+			Submit: OnboardingEnable,
+		},
+		{
+			Label:  "disable", // Stops offering the onboarding.
+			Submit: OnboardingDisable,
+		},
+	},
+}
+
+// OnboardingProgress is how far a member went through the onboarding of a
+// team. The times are in milliseconds.
+type OnboardingProgress struct {
+	Step        string `json:"step"`
+	StartedAt   int64  `json:"started_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+	CompletedAt int64  `json:"completed_at,omitempty"`
+}
+
+func onboardingKey(teamID string) string {
+	return "onboarding_" + teamID
+}
+
+// GetOnboarding returns the onboarding progress of the members of the team, by
+// user ID.
+func (s *Store) GetOnboarding(teamID string) (map[string]OnboardingProgress, error) {
+	progress := map[string]OnboardingProgress{}
+	if err := s.kv.KVGet(KVAppPrefix, onboardingKey(teamID), &progress); err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = map[string]OnboardingProgress{}
+	}
+	return progress, nil
+}
+
+// SetOnboardingStep records that the user reached the step of the onboarding
+// of the team.
+func (s *Store) SetOnboardingStep(teamID, userID, step string) error {
+	progress, err := s.GetOnboarding(teamID)
+	if err != nil {
+		return err
+	}
+
+	now := model.GetMillis()
+	p, ok := progress[userID]
+	if !ok || step == OnboardingStepProfile {
+		p = OnboardingProgress{StartedAt: now}
+	}
+	p.Step = step
+	p.UpdatedAt = now
+	if step == OnboardingStepDone {
+		p.CompletedAt = now
+	}
+	progress[userID] = p
+
+	_, err = s.kv.KVSet(KVAppPrefix, onboardingKey(teamID), progress)
+	return err
+}
+
+// DeleteOnboarding removes the onboarding progress of the team.
+func (s *Store) DeleteOnboarding(teamID string) error {
+	return s.kv.KVDelete(KVAppPrefix, onboardingKey(teamID))
+}
+
+// onboardingJob returns the job inviting the new member to start the
+// onboarding, in the direct channel with the bot.
+func onboardingJob(channelID, teamID string, runAt int64) Job {
+	return Job{
+		ID:        model.NewId(),
+		RunAt:     runAt,
+		ChannelID: channelID,
+		Message:   "Let's get you set up: it takes a minute to complete your profile, choose your notifications and join a few channels.",
+		Props: model.StringInterface{apps.PropAppBindings: []apps.Binding{
+			{
+				AppID:    AppID,
+				Location: "onboarding",
+				Bindings: []apps.Binding{
+					{
+						Location: "start",
+						Label:    "Get started",
+						Submit:   StartOnboarding.WithState(teamID),
+					},
+				},
+			},
+		}},
+	}
+}
+
+// onboardingTeamID returns the team being onboarded: the state of the call, or
+// else the team the command was run in.
+func onboardingTeamID(c apps.CallRequest) string {
+	if teamID, _ := c.State.(string); teamID != "" {
+		return teamID
+	}
+	if c.Context.Team != nil {
+		return c.Context.Team.Id
+	}
+	return ""
+}
+
+// selectOption returns the option of the options with the value, or the
+// first one.
+func selectOption(options []apps.SelectOption, value string) *apps.SelectOption {
+	for _, option := range options {
+		if option.Value == value {
+			return &option
+		}
+	}
+	return &options[0]
+}
+
+// multiSelectValues returns the values of the options selected in a
+// multiselect field.
+func multiSelectValues(c apps.CallRequest, name string) []string {
+	data, _ := json.Marshal(c.Values[name])
+	options := []apps.SelectOption{}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil
+	}
+	values := []string{}
+	for _, option := range options {
+		values = append(values, option.Value)
+	}
+	return values
+}
+
+func onboardingProfileForm(teamID string, user *model.User) apps.Form {
+	return apps.Form{
+		Title:  "Welcome! 1/3",
+		Header: "Tell your new teammates who you are. Leave the fields as they are to skip this step.",
+		Icon:   "icon.png",
+		Fields: []apps.Field{
+			{Type: apps.FieldTypeText, Name: "first_name", ModalLabel: "First name", Value: user.FirstName},
+			{Type: apps.FieldTypeText, Name: "last_name", ModalLabel: "Last name", Value: user.LastName},
+			{Type: apps.FieldTypeText, Name: "nickname", ModalLabel: "Nickname", Value: user.Nickname},
+			{Type: apps.FieldTypeText, Name: "position", ModalLabel: "Position", Description: "Your role, e.g. Frontend developer", Value: user.Position},
+		},
+		Submit: SubmitOnboardingProfile.WithState(teamID),
+	}
+}
+
+var (
+	notifyLevelOptions = []apps.SelectOption{
+		{Label: "For all activity", Value: model.UserNotifyAll},
+		{Label: "For mentions and direct messages", Value: model.UserNotifyMention},
+		{Label: "Never", Value: model.UserNotifyNone},
+	}
+	notifyEmailOptions = []apps.SelectOption{
+		{Label: "On", Value: "true"},
+		{Label: "Off", Value: "false"},
+	}
+)
+
+func onboardingNotificationsForm(teamID string, user *model.User) apps.Form {
+	return apps.Form{
+		Title:  "Welcome! 2/3",
+		Header: "Choose how you want to be notified. You can change it later in the settings.",
+		Icon:   "icon.png",
+		Fields: []apps.Field{
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                model.DesktopNotifyProp,
+				ModalLabel:          "Desktop notifications",
+				SelectStaticOptions: notifyLevelOptions,
+				Value:               selectOption(notifyLevelOptions, user.NotifyProps[model.DesktopNotifyProp]),
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                model.PushNotifyProp,
+				ModalLabel:          "Mobile push notifications",
+				SelectStaticOptions: notifyLevelOptions,
+				Value:               selectOption(notifyLevelOptions, user.NotifyProps[model.PushNotifyProp]),
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                model.EmailNotifyProp,
+				ModalLabel:          "Email notifications",
+				SelectStaticOptions: notifyEmailOptions,
+				Value:               selectOption(notifyEmailOptions, user.NotifyProps[model.EmailNotifyProp]),
+			},
+		},
+		Submit: SubmitOnboardingNotifications.WithState(teamID),
+	}
+}
+
+func onboardingChannelsForm(teamID string) apps.Form {
+	return apps.Form{
+		Title:  "Welcome! 3/3",
+		Header: "Join the channels that interest you. Leave it empty to skip this step.",
+		Icon:   "icon.png",
+		Fields: []apps.Field{
+			{
+				Type:                apps.FieldTypeDynamicSelect,
+				Name:                "channels",
+				ModalLabel:          "Channels",
+				SelectIsMulti:       true,
+				SelectDynamicLookup: LookupOnboardingChannels.WithState(teamID),
+			},
+		},
+		Submit: SubmitOnboardingChannels.WithState(teamID),
+	}
+}
+
+// onboardingStep records the step the member reached, and returns the form
+// of the step.
+func onboardingStep(ctx context.Context, c apps.CallRequest, teamID, step string) apps.CallResponse {
+	userID := c.Context.ActingUser.Id
+	if err := NewStore(ctx, c.Context).SetOnboardingStep(teamID, userID, step); err != nil {
+		logger.Error(err)
+	}
+
+	if step == OnboardingStepChannels {
+		return apps.NewFormResponse(onboardingChannelsForm(teamID))
+	}
+
+	user, _, err := asActingUser(ctx, c.Context).GetUser(userID, "")
+	if err != nil {
+		logger.Error(err)
+		return errorResponse("we couldn't load your profile")
+	}
+	if step == OnboardingStepNotifications {
+		return apps.NewFormResponse(onboardingNotificationsForm(teamID, user))
+	}
+	return apps.NewFormResponse(onboardingProfileForm(teamID, user))
+}
+
+// StartOnboardingCall opens the first step of the onboarding, or the step the
+// member stopped at.
+func StartOnboardingCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	teamID := onboardingTeamID(c)
+	if teamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the team to onboard you in, run the command from one of its channels"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	welcome, err := store.GetTeamWelcome(teamID)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if welcome == nil || !welcome.Onboarding {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no onboarding"))
+		return
+	}
+
+	step := OnboardingStepProfile
+	progress, err := store.GetOnboarding(teamID)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if p, ok := progress[c.Context.ActingUser.Id]; ok && p.Step != OnboardingStepDone {
+		step = p.Step
+	}
+
+	httputils.WriteJSON(w, onboardingStep(req.Context(), c, teamID, step))
+}
+
+func SubmitOnboardingProfileCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	teamID := onboardingTeamID(c)
+	if teamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w, errorResponse("we couldn't find the team to onboard you in"))
+		return
+	}
+
+	patch := &model.UserPatch{}
+	for _, field := range []struct {
+		name  string
+		value **string
+	}{
+		{"first_name", &patch.FirstName},
+		{"last_name", &patch.LastName},
+		{"nickname", &patch.Nickname},
+		{"position", &patch.Position},
+	} {
+		if _, ok := c.Values[field.name]; ok {
+			value := strings.TrimSpace(c.GetValue(field.name, ""))
+			*field.value = &value
+		}
+	}
+
+	_, _, err := asActingUser(req.Context(), c.Context).PatchUser(c.Context.ActingUser.Id, patch)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, errorResponse("we couldn't update your profile: %s", err))
+		return
+	}
+
+	httputils.WriteJSON(w, onboardingStep(req.Context(), c, teamID, OnboardingStepNotifications))
+}
+
+func SubmitOnboardingNotificationsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	teamID := onboardingTeamID(c)
+	if teamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w, errorResponse("we couldn't find the team to onboard you in"))
+		return
+	}
+
+	// The notification settings are replaced altogether by a patch, so the
+	// submitted ones are merged into the current ones.
+	client := asActingUser(req.Context(), c.Context)
+	user, _, err := client.GetUser(c.Context.ActingUser.Id, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, errorResponse("we couldn't load your notification settings"))
+		return
+	}
+	notifyProps := model.StringMap{}
+	for name, value := range user.NotifyProps {
+		notifyProps[name] = value
+	}
+	for _, name := range []string{model.DesktopNotifyProp, model.PushNotifyProp, model.EmailNotifyProp} {
+		if value := c.GetValue(name, ""); value != "" {
+			notifyProps[name] = value
+		}
+	}
+
+	if _, _, err = client.PatchUser(user.Id, &model.UserPatch{NotifyProps: notifyProps}); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, errorResponse("we couldn't update your notification settings: %s", err))
+		return
+	}
+
+	httputils.WriteJSON(w, onboardingStep(req.Context(), c, teamID, OnboardingStepChannels))
+}
+
+func SubmitOnboardingChannelsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	teamID := onboardingTeamID(c)
+	if teamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w, errorResponse("we couldn't find the team to onboard you in"))
+		return
+	}
+
+	client := asActingUser(req.Context(), c.Context)
+	userID := c.Context.ActingUser.Id
+	joined := []string{}
+	for _, channelID := range multiSelectValues(c, "channels") {
+		channel, _, err := client.GetChannel(channelID, "")
+		if err != nil {
+			requestLogger(req).Error(err)
+			continue
+		}
+		if _, _, err = client.AddChannelMember(channelID, userID); err != nil {
+			requestLogger(req).Error(err)
+			continue
+		}
+		joined = append(joined, "~"+channel.Name)
+	}
+
+	if err := NewStore(req.Context(), c.Context).SetOnboardingStep(teamID, userID, OnboardingStepDone); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	message := "You're all set, welcome aboard!"
+	if len(joined) > 0 {
+		message += " You joined " + strings.Join(joined, ", ") + "."
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
+
+// LookupOnboardingChannelsCall returns the public channels of the team being
+// onboarded that the member hasn't joined yet.
+func LookupOnboardingChannelsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	teamID := onboardingTeamID(c)
+	if teamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w, apps.NewLookupResponse(nil))
+		return
+	}
+
+	client := asActingUser(req.Context(), c.Context)
+	channels, _, err := client.GetPublicChannelsForTeam(teamID, 0, 200, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	members, _, err := client.GetChannelMembersForUser(c.Context.ActingUser.Id, teamID, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	joined := map[string]bool{}
+	for _, member := range members {
+		joined[member.ChannelId] = true
+	}
+
+	query := strings.ToLower(c.Query)
+	options := []apps.SelectOption{}
+	for _, channel := range channels {
+		if joined[channel.Id] {
+			continue
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(channel.Name), query) &&
+			!strings.Contains(strings.ToLower(channel.DisplayName), query) {
+			continue
+		}
+		options = append(options, apps.SelectOption{
+			Label: channel.DisplayName,
+			Value: channel.Id,
+		})
+	}
+
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Label < options[j].Label
+	})
+	if len(options) > maxLookupOptions {
+		options = options[:maxLookupOptions]
+	}
+
+	httputils.WriteJSON(w, apps.NewLookupResponse(options))
+}
+
+func OnboardingEnableCall(w http.ResponseWriter, req *http.Request) {
+	setOnboarding(w, req, true)
+}
+
+func OnboardingDisableCall(w http.ResponseWriter, req *http.Request) {
+	setOnboarding(w, req, false)
+}
+
+// setOnboarding turns the onboarding of the team on or off. It is offered to
+// new members along with the team's welcome message.
+func setOnboarding(w http.ResponseWriter, req *http.Request, enabled bool) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the onboarding"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+
+	welcome.Onboarding = enabled
+	if err = store.SetTeamWelcome(c.Context.Team.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the onboarding"))
+		return
+	}
+
+	if enabled {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("New members of the team will be offered the onboarding along with the welcome message."))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of the team won't be offered the onboarding anymore."))
+}
+
+// OnboardingStatusCall shows the onboarding progress of the members of the
+// team, the most recent first.
+func OnboardingStatusCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	progress, err := store.GetOnboarding(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the onboarding progress"))
+		return
+	}
+	if len(progress) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("No member started the onboarding of the team yet"))
+		return
+	}
+
+	userIDs := []string{}
+	for userID := range progress {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		return progress[userIDs[i]].UpdatedAt > progress[userIDs[j]].UpdatedAt
+	})
+
+	usernames := map[string]string{}
+	users, _, err := asBot(req.Context(), c.Context).GetUsersByIds(userIDs)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+
+	completed := 0
+	lines := []string{}
+	for _, userID := range userIDs {
+		p := progress[userID]
+		name := "@" + usernames[userID]
+		if usernames[userID] == "" {
+			name = userID
+		}
+		if p.Step == OnboardingStepDone {
+			completed++
+			lines = append(lines, fmt.Sprintf("* %s: completed on %s", name, formatMillis(p.CompletedAt)))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("* %s: at the %s step since %s", name, p.Step, formatMillis(p.UpdatedAt)))
+	}
+
+	message := fmt.Sprintf("#### Onboarding of %s\n\n%d of %d members completed it.\n\n%s\n",
+		c.Context.Team.DisplayName, completed, len(progress), strings.Join(lines, "\n"))
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
@@ -0,0 +1,22 @@
+package main
+
+import "github.com/mattermost/mattermost-plugin-apps/apps"
+
+// OpenFAASFunction is the name of the OpenFaaS function serving all the calls,
+// as declared in openfaas/manifest.yml. The function is the app's HTTP
+// service, run from the image built with the Dockerfile, and Mattermost calls
+// it through the OpenFaaS gateway. The app bundle deployed with appsctl holds
+// the manifest and openfaas/manifest.yml.
+const OpenFAASFunction = "welcome-bot"
+
+// OpenFAASHealthPath is where the OpenFaaS provider probes the function's
+// health.
+const OpenFAASHealthPath = "/_/health"
+
+// OpenFAASFunctions maps all the calls to the single function of the app.
+var OpenFAASFunctions = []apps.OpenFAASFunction{
+	{
+		Path: "/",
+		Name: OpenFAASFunction,
+	},
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const optOutsKey = "opt_outs"
+
+// OptOutForm stops the direct messages of the bot to the acting user, or
+// resumes them with --undo.
+var OptOutForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "undo",
+			Label:       "undo",
+			ModalLabel:  "Undo",
+			Description: "Get the direct messages of the bot again",
+		},
+	},
+	Submit: apps.NewCall("/optout").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// OptOutButton is submitted by the button under the welcomes sent as direct
+// messages.
+var OptOutButton = apps.NewCall("/optout/button").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+})
+
+// GetOptOuts returns the users who opted out of the direct messages of the
+// bot, with the ID of their direct channel with the bot.
+func (s *Store) GetOptOuts() (map[string]string, error) {
+	optOuts := map[string]string{}
+	if err := s.kv.KVGet(KVAppPrefix, optOutsKey, &optOuts); err != nil {
+		return nil, err
+	}
+	if optOuts == nil {
+		optOuts = map[string]string{}
+	}
+	return optOuts, nil
+}
+
+// SetOptOuts stores the users who opted out of the direct messages.
+func (s *Store) SetOptOuts(optOuts map[string]string) error {
+	_, err := s.kv.KVSet(KVAppPrefix, optOutsKey, optOuts)
+	return err
+}
+
+// optedOutChannels returns the direct channels of the users who opted out, the
+// bot doesn't post in.
+func optedOutChannels(store *Store) map[string]bool {
+	optOuts, err := store.GetOptOuts()
+	if err != nil {
+		logger.Error(err)
+	}
+	channels := map[string]bool{}
+	for _, channelID := range optOuts {
+		channels[channelID] = true
+	}
+	return channels
+}
+
+// optOutBinding is the button under the welcomes sent as direct messages, for
+// the member not to get any more of them.
+func optOutBinding() apps.Binding {
+	return apps.Binding{
+		AppID:    AppID,
+		Location: "optout",
+		Bindings: []apps.Binding{
+			{
+				Location: "optout",
+				Label:    "Don't message me again",
+				Submit:   OptOutButton,
+			},
+		},
+	}
+}
+
+// withOptOutButton adds the opt-out button to the bindings of the job's post.
+func withOptOutButton(job Job) Job {
+	props := model.StringInterface{}
+	for key, value := range job.Props {
+		props[key] = value
+	}
+	bindings, _ := props[apps.PropAppBindings].([]apps.Binding)
+	props[apps.PropAppBindings] = append(append([]apps.Binding{}, bindings...), optOutBinding())
+	job.Props = props
+	return job
+}
+
+// optOut records that the user doesn't want direct messages from the bot
+// anymore, and drops the ones already queued.
+func optOut(ctx context.Context, cc apps.Context, store *Store, userID string) error {
+	dm, _, err := asBot(ctx, cc).CreateDirectChannel(cc.BotUserID, userID)
+	if err != nil {
+		return err
+	}
+
+	optOuts, err := store.GetOptOuts()
+	if err != nil {
+		return err
+	}
+	optOuts[userID] = dm.Id
+	if err = store.SetOptOuts(optOuts); err != nil {
+		return err
+	}
+
+	return scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.ChannelID == dm.Id
+	})
+}
+
+// optIn resumes the direct messages of the bot to the user.
+func optIn(store *Store, userID string) error {
+	optOuts, err := store.GetOptOuts()
+	if err != nil {
+		return err
+	}
+	if _, ok := optOuts[userID]; !ok {
+		return nil
+	}
+	delete(optOuts, userID)
+	return store.SetOptOuts(optOuts)
+}
+
+func OptOutCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.ActingUser == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find who you are"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if c.BoolValue("undo") {
+		if err := optIn(store, c.Context.ActingUser.Id); err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w,
+				errorResponse("we couldn't resume your direct messages"))
+			return
+		}
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("You will get the direct messages of the Welcome Bot again."))
+		return
+	}
+
+	if err := optOut(req.Context(), c.Context, store, c.Context.ActingUser.Id); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't stop your direct messages"))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("You won't get direct messages from the Welcome Bot anymore. Run `/welcomebot optout --undo` to get them again."))
+}
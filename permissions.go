@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The roles that can be required to manage welcome messages, from the least to
+// the most privileged. System admins can always manage welcome messages.
+var ManagerRoles = []string{
+	model.ChannelAdminRoleId,
+	model.TeamAdminRoleId,
+	model.SystemAdminRoleId,
+}
+
+var errNoActingUser = errors.New("the acting user was not provided in the call context")
+
+// isValidManagerRole reports whether role is one of ManagerRoles.
+func isValidManagerRole(role string) bool {
+	for _, r := range ManagerRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func isSystemAdmin(cc apps.Context) bool {
+	return cc.ActingUser != nil && cc.ActingUser.IsSystemAdmin()
+}
+
+func isTeamAdmin(cc apps.Context) bool {
+	member := cc.TeamMember
+	return member != nil && (member.SchemeAdmin || hasRole(member.Roles, model.TeamAdminRoleId))
+}
+
+func isChannelAdmin(cc apps.Context) bool {
+	member := cc.ChannelMember
+	return member != nil && (member.SchemeAdmin || hasRole(member.Roles, model.ChannelAdminRoleId))
+}
+
+func hasRole(roles, role string) bool {
+	for _, r := range strings.Fields(roles) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCanManageChannel returns an error unless the acting user holds at least
+// requiredRole in the channel of the call context. The call must expand the
+// acting user, and the channel and team memberships.
+func CheckCanManageChannel(cc apps.Context, requiredRole string) error {
+	if cc.ActingUser == nil {
+		return errNoActingUser
+	}
+
+	switch {
+	case isSystemAdmin(cc):
+		return nil
+	case requiredRole == model.ChannelAdminRoleId && (isChannelAdmin(cc) || isTeamAdmin(cc)):
+		return nil
+	case requiredRole == model.TeamAdminRoleId && isTeamAdmin(cc):
+		return nil
+	}
+
+	return fmt.Errorf("only users with the %s role can manage this channel's welcome message", roleName(requiredRole))
+}
+
+// CheckCanManageTeam returns an error unless the acting user can manage the
+// welcome message of the team in the call context. A channel admin role
+// requirement is raised to team admin, as team welcomes are team-wide. The
+// call must expand the acting user and the team membership.
+func CheckCanManageTeam(cc apps.Context, requiredRole string) error {
+	if cc.ActingUser == nil {
+		return errNoActingUser
+	}
+
+	switch {
+	case isSystemAdmin(cc):
+		return nil
+	case requiredRole != model.SystemAdminRoleId && isTeamAdmin(cc):
+		return nil
+	}
+
+	if requiredRole == model.ChannelAdminRoleId {
+		requiredRole = model.TeamAdminRoleId
+	}
+	return fmt.Errorf("only users with the %s role can manage this team's welcome message", roleName(requiredRole))
+}
+
+// CheckSystemAdmin returns an error unless the acting user is a system admin.
+func CheckSystemAdmin(cc apps.Context) error {
+	if cc.ActingUser == nil {
+		return errNoActingUser
+	}
+	if !isSystemAdmin(cc) {
+		return errors.New("only system admins can use this command")
+	}
+	return nil
+}
+
+func roleName(role string) string {
+	return strings.ReplaceAll(role, "_", " ")
+}
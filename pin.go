@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// SetPinForm makes the bot keep a pinned channel guide post with the welcome
+// messages of the channel, so members who joined before can find them too.
+var SetPinForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeStaticSelect,
+			Name:                 "pin",
+			Description:          "Whether the bot keeps a pinned channel guide post",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+			SelectStaticOptions: []apps.SelectOption{
+				{Label: "on", Value: "on"},
+				{Label: "off", Value: "off"},
+			},
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_pin").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// guideMessage renders the welcome messages of the channel for the guide post.
+// There is no new member to render the guide for, so the user variables are
+// left empty.
+func guideMessage(client *appclient.Client, channel *model.Channel, welcome ChannelWelcome) string {
+	team, _, err := client.GetTeam(channel.TeamId, "")
+	if err != nil {
+		logger.Error(err)
+		team = nil
+	}
+	data := NewTemplateData(nil, channel, team)
+
+	parts := []string{"#### Welcome to ~" + channel.Name}
+	for _, m := range welcome.Messages {
+		parts = append(parts, RenderWelcome(m.Message, data))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// updateGuide updates the pinned guide post of the channel with its current
+// welcome messages. The post is created again if it was deleted in the
+// meantime, in which case welcome.GuidePostID changes and the welcome must be
+// stored by the caller.
+func updateGuide(client *appclient.Client, channel *model.Channel, welcome *ChannelWelcome) error {
+	if !welcome.PinGuide {
+		return nil
+	}
+
+	message := guideMessage(client, channel, *welcome)
+	if welcome.GuidePostID != "" {
+		_, _, err := client.PatchPost(welcome.GuidePostID, &model.PostPatch{Message: &message})
+		if err == nil {
+			return nil
+		}
+		logger.Error(err)
+	}
+
+	post, err := createPost(client, &model.Post{
+		ChannelId: channel.Id,
+		Message:   message,
+	})
+	if err != nil {
+		return err
+	}
+	welcome.GuidePostID = post.Id
+	_, err = client.PinPost(post.Id)
+	return err
+}
+
+// removeGuide unpins and deletes the guide post of the channel, if any. It
+// leaves welcome.PinGuide as is.
+func removeGuide(client *appclient.Client, welcome *ChannelWelcome) error {
+	if welcome.GuidePostID == "" {
+		return nil
+	}
+
+	id := welcome.GuidePostID
+	welcome.GuidePostID = ""
+	if _, err := client.UnpinPost(id); err != nil {
+		logger.Error(err)
+	}
+	_, err := client.DeletePost(id)
+	return err
+}
+
+func SetPinCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = requireValues(c, "pin"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	pin := c.GetValue("pin", "")
+	if pin != "on" && pin != "off" {
+		httputils.WriteJSON(w,
+			errorResponse("pin must be on or off"))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the channel guide"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	client := asBot(req.Context(), cc)
+	welcome.PinGuide = pin == "on"
+	if welcome.PinGuide {
+		err = updateGuide(client, cc.Channel, welcome)
+	} else {
+		err = removeGuide(client, welcome)
+	}
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't update the channel guide post, make sure the bot is a member of ~%s", cc.Channel.Name))
+		return
+	}
+
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the channel guide"))
+		return
+	}
+
+	if welcome.PinGuide {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Pinned the channel guide of ~%s. It is updated whenever the welcome message changes.", cc.Channel.Name))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Removed the channel guide of ~%s", cc.Channel.Name))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errPoolFull is returned when a task can't be queued before the caller gives
+// up.
+var errPoolFull = errors.New("the queue is full")
+
+// errPoolStopped is returned when a task is submitted after the pool stopped.
+var errPoolStopped = errors.New("the pool is stopped")
+
+// WorkerPool runs tasks in a fixed number of goroutines, from a bounded queue.
+// When the queue is full, submitting blocks, which slows the callers down
+// rather than piling up goroutines.
+type WorkerPool struct {
+	queue chan poolTask
+	wg    sync.WaitGroup
+
+	// mu is held for reading while a task is submitted, and for writing to
+	// stop, so the queue is only closed once no Submit is sending to it.
+	mu      sync.RWMutex
+	stopped bool
+}
+
+type poolTask struct {
+	run      func()
+	queuedAt time.Time
+}
+
+// joinPool processes the join events in the background, nil when they are
+// processed inline, e.g. in AWS Lambda where the process is frozen once the
+// response is sent.
+var joinPool *WorkerPool
+
+// NewWorkerPool starts workers goroutines running the tasks queued, up to
+// queueSize of them waiting at a time.
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	p := &WorkerPool{
+		queue: make(chan poolTask, queueSize),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for task := range p.queue {
+		poolQueueLength.Set(float64(len(p.queue)))
+		poolQueueWait.Observe(time.Since(task.queuedAt).Seconds())
+		task.run()
+	}
+}
+
+// Submit queues the task, waiting for room in the queue until the context is
+// done. It fails once the pool is stopped.
+func (p *WorkerPool) Submit(ctx context.Context, run func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		poolDropped.Inc()
+		return errPoolStopped
+	}
+
+	task := poolTask{run: run, queuedAt: time.Now()}
+	select {
+	case p.queue <- task:
+	default:
+		poolBlocked.Inc()
+		select {
+		case p.queue <- task:
+		case <-ctx.Done():
+			poolDropped.Inc()
+			return errPoolFull
+		}
+	}
+	poolQueueLength.Set(float64(len(p.queue)))
+	return nil
+}
+
+// Stop waits for the tasks being submitted and the queued ones to run, and
+// stops the workers. Submit fails afterwards.
+func (p *WorkerPool) Stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// Handle returns the handler of a notification call that answers at once and
+// runs the handler in the pool, if there is one. Mattermost ignores the
+// responses of notifications, so the errors are only logged. Mattermost gets
+// an error if the event can't be queued before it gives up on the call.
+func (p *WorkerPool) Handle(handler http.HandlerFunc) http.HandlerFunc {
+	if p == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w, errorResponse("invalid call request: %s", err))
+			return
+		}
+
+		// The request's context ends with the response, its logger and span
+		// are kept.
+		ctx := withLogger(context.Background(), requestLogger(req))
+		queued := req.Clone(trace.ContextWithSpan(ctx, trace.SpanFromContext(req.Context())))
+		err = p.Submit(req.Context(), func() {
+			ctx, span := tracer.Start(queued.Context(), "process "+req.URL.Path)
+			defer span.End()
+			defer func() {
+				if v := recover(); v != nil {
+					stack := debug.Stack()
+					requestLogger(req).With("stack", string(stack)).Errorf("panic: %v", v)
+					reportPanic(requestLogger(req), v, stack)
+				}
+			}()
+			processed := queued.WithContext(ctx)
+			processed.Body = io.NopCloser(bytes.NewReader(data))
+			recorder := &responseRecorder{header: http.Header{}}
+			handler(recorder, processed)
+			recorder.logError(requestLogger(req))
+		})
+		if err != nil {
+			requestLogger(req).Warnf("dropped the call: %v", err)
+			http.Error(w, "too many events queued", http.StatusServiceUnavailable)
+			return
+		}
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+	}
+}
+
+// responseRecorder keeps the response of a handler run in the background.
+type responseRecorder struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header         { return r.header }
+func (r *responseRecorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *responseRecorder) WriteHeader(int)             {}
+
+// logError logs the error of the call response, if it is one.
+func (r *responseRecorder) logError(l *Logger) {
+	resp := apps.CallResponse{}
+	if json.Unmarshal(r.body.Bytes(), &resp) == nil && resp.Type == apps.CallResponseTypeError {
+		l.Errorf("the call failed: %s", resp.Text)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// SaveAsWelcome is the call of the post menu item saving the post as the
+// channel's welcome message.
+var SaveAsWelcome = apps.NewCall("/save_as_welcome").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Channel:               apps.ExpandSummary,
+	ChannelMember:         apps.ExpandAll,
+	TeamMember:            apps.ExpandAll,
+	Post:                  apps.ExpandAll,
+})
+
+// PostMenuBinding adds an item to the post menu saving the post as the first
+// welcome message of its channel, so a welcome can be drafted as a regular
+// post first.
+var PostMenuBinding = apps.Binding{
+	Location: apps.LocationPostMenu,
+	Bindings: []apps.Binding{
+		{
+			Location:    "save_as_welcome",
+			Icon:        "icon.png",
+			Label:       "Save as welcome message",
+			Description: "Save this message as the welcome message of the channel",
+			Submit:      SaveAsWelcome,
+		},
+	},
+}
+
+// SaveAsWelcomeCall stores the message of the post as the first welcome
+// message of the channel, like set_channel_welcome does.
+func SaveAsWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	post := c.Context.Post
+	if post == nil || post.Message == "" {
+		httputils.WriteJSON(w,
+			errorResponse("this post has no message to save"))
+		return
+	}
+
+	c.Values = map[string]interface{}{"message": post.Message}
+	httputils.WriteJSON(w, setChannelWelcome(req.Context(), c))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const guestsKey = "guests"
+
+// promotionCheckInterval is how often the guests who joined a team with a
+// promotion message are checked for a promotion. Apps get no event when a
+// user's roles change, so the guests are looked up periodically instead.
+const promotionCheckInterval = time.Hour
+
+// promotionPageSize is the number of guests looked up at a time.
+const promotionPageSize = 200
+
+// GetGuests returns the guests awaiting a promotion message, with the IDs of
+// the teams whose message they are to get.
+func (s *Store) GetGuests() (map[string][]string, error) {
+	guests := map[string][]string{}
+	if err := s.kv.KVGet(KVAppPrefix, guestsKey, &guests); err != nil {
+		return nil, err
+	}
+	if guests == nil {
+		guests = map[string][]string{}
+	}
+	return guests, nil
+}
+
+// SetGuests stores the guests awaiting a promotion message.
+func (s *Store) SetGuests(guests map[string][]string) error {
+	_, err := s.kv.KVSet(KVAppPrefix, guestsKey, guests)
+	return err
+}
+
+// TrackGuest records that the guest is to get the team's promotion message
+// once promoted to a member.
+func (s *Store) TrackGuest(teamID, userID string) error {
+	guests, err := s.GetGuests()
+	if err != nil {
+		return err
+	}
+	for _, id := range guests[userID] {
+		if id == teamID {
+			return nil
+		}
+	}
+	guests[userID] = append(guests[userID], teamID)
+	return s.SetGuests(guests)
+}
+
+// newPromotionCheckJob returns the job of the next promotion check.
+func newPromotionCheckJob() Job {
+	return Job{
+		ID:    model.NewId(),
+		Kind:  JobKindPromotionCheck,
+		RunAt: model.GetMillis() + promotionCheckInterval.Milliseconds(),
+	}
+}
+
+// schedulePromotionCheck queues the next promotion check, replacing the one
+// queued by a previous installation, if any.
+func schedulePromotionCheck(ctx context.Context, cc apps.Context) error {
+	err := scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.Kind == JobKindPromotionCheck
+	})
+	if err != nil {
+		return err
+	}
+	return scheduler.Enqueue(ctx, cc, []Job{newPromotionCheckJob()})
+}
+
+// promotionCheckJob looks up the tracked guests, and sends the promotion
+// message of their teams to those who were promoted to members. Deleted users
+// and promoted ones stop being tracked. The next check is queued whatever
+// happens.
+func promotionCheckJob(ctx context.Context, cc apps.Context, client *appclient.Client, store *Store) error {
+	defer func() {
+		if err := scheduler.Enqueue(ctx, cc, []Job{newPromotionCheckJob()}); err != nil {
+			logger.Error(err)
+		}
+	}()
+
+	guests, err := store.GetGuests()
+	if err != nil || len(guests) == 0 {
+		return err
+	}
+	ids := make([]string, 0, len(guests))
+	for id := range guests {
+		ids = append(ids, id)
+	}
+
+	jobs := []Job{}
+	for start := 0; start < len(ids); start += promotionPageSize {
+		end := start + promotionPageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		users, _, err := client.GetUsersByIds(ids[start:end])
+		if err != nil {
+			return err
+		}
+		found := map[string]bool{}
+		for _, user := range users {
+			found[user.Id] = true
+			if user.IsGuest() && user.DeleteAt == 0 {
+				continue
+			}
+			if user.DeleteAt == 0 {
+				jobs = append(jobs, promotionJobs(cc, client, store, user, guests[user.Id])...)
+			}
+			delete(guests, user.Id)
+		}
+		for _, id := range ids[start:end] {
+			if !found[id] {
+				delete(guests, id)
+			}
+		}
+	}
+	if len(guests) == len(ids) {
+		return nil
+	}
+
+	if err = scheduler.Enqueue(ctx, cc, jobs); err != nil {
+		return err
+	}
+	return store.SetGuests(guests)
+}
+
+// promotionJobs returns the jobs sending the promotion messages of the teams
+// to the promoted member, in a direct message. The teams they left, or whose
+// promotion message was removed, are skipped.
+func promotionJobs(cc apps.Context, client *appclient.Client, store *Store, user *model.User, teamIDs []string) []Job {
+	if skipWelcome(store, user) {
+		return nil
+	}
+	dm, _, err := client.CreateDirectChannel(cc.BotUserID, user.Id)
+	if err != nil {
+		logger.Error(err)
+		return nil
+	}
+
+	jobs := []Job{}
+	for _, teamID := range teamIDs {
+		welcome, err := store.GetTeamWelcome(teamID)
+		if err != nil || welcome == nil || welcome.PromotionMessage == "" {
+			continue
+		}
+		team, _, err := client.GetTeam(teamID, "")
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		if member, _, err := client.GetTeamMember(teamID, user.Id, ""); err != nil || member.DeleteAt != 0 {
+			continue
+		}
+		jobs = append(jobs, withOptOutButton(Job{
+			ID:          model.NewId(),
+			RunAt:       model.GetMillis(),
+			ChannelID:   dm.Id,
+			Message:     RenderWelcome(welcome.PromotionMessage, store.NewTemplateData(user, nil, team)),
+			UserID:      user.Id,
+			WelcomeKind: IndexKindTeam,
+			WelcomeID:   teamID,
+		}))
+	}
+	return jobs
+}
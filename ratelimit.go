@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimitBurst is how many seconds of requests a rate limit lets through at
+// once, e.g. 20 requests for a rate of 10 per second.
+const rateLimitBurst = 2
+
+// maxRateLimitedIPs is how many IPs the per-IP rate limit tracks before it
+// forgets the idle ones.
+const maxRateLimitedIPs = 10000
+
+// tokenBucket is a rate limit: it holds up to burst tokens, refilled at rate
+// tokens per second, and each request takes one.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket letting through rate requests per
+// second.
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, rate*rateLimitBurst)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens earned since the last request. b.mu must be held.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// allow takes a token if one is available.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket refilled completely, so it behaves like a
+// new one.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// reserve takes a token, possibly one not available yet, and returns how long
+// to wait before using it.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait takes a token, waiting until it is available or the context is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	d := b.reserve(time.Now())
+	if d == 0 {
+		return nil
+	}
+	rateLimited.WithLabelValues("outbound").Inc()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ipRateLimiter limits the requests of each IP to rate per second.
+type ipRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	buckets map[string]*tokenBucket
+}
+
+// newIPRateLimiter returns a limiter letting through rate requests per second
+// from each IP.
+func newIPRateLimiter(rate float64) *ipRateLimiter {
+	return &ipRateLimiter{
+		rate:    rate,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow reports whether the IP can make a request now.
+func (l *ipRateLimiter) allow(ip string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitedIPs {
+			for ip, bucket := range l.buckets {
+				if bucket.full(now) {
+					delete(l.buckets, ip)
+				}
+			}
+		}
+		bucket = newTokenBucket(l.rate)
+		l.buckets[ip] = bucket
+	}
+	l.mu.Unlock()
+	return bucket.allow(now)
+}
+
+// limitRate rejects the requests of the IPs over the rate limit with 429 Too
+// Many Requests. The IP is the one of the connection: behind a reverse proxy,
+// all the clients share the proxy's limit.
+func limitRate(limiter *ipRateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		if !limiter.allow(ip) {
+			rateLimited.WithLabelValues("inbound").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// rateLimitedTransport delays the requests to the Mattermost API over the
+// rate limit, so a burst of events, e.g. a bulk import of users, doesn't get
+// the bot rate limited by the server or overload it. Other requests, like the
+// events posted to the event webhook, are not limited.
+type rateLimitedTransport struct {
+	next   http.RoundTripper
+	bucket *tokenBucket
+}
+
+func (t rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if apiName(req.URL.Path) != "other" {
+		if err := t.bucket.wait(req.Context()); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		drained bool
+		after   time.Duration
+		allowed int
+	}{
+		{name: "burst", rate: 10, allowed: 20},
+		{name: "minimum burst", rate: 0.1, allowed: 1},
+		{name: "drained", rate: 10, drained: true, allowed: 0},
+		{name: "refilled", rate: 10, drained: true, after: 500 * time.Millisecond, allowed: 5},
+		{name: "refilled up to the burst", rate: 10, drained: true, after: time.Hour, allowed: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.rate)
+			now := b.last
+			if tt.drained {
+				for b.allow(now) {
+				}
+			}
+			now = now.Add(tt.after)
+
+			allowed := 0
+			for i := 0; i < 100; i++ {
+				if b.allow(now) {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	b := newTokenBucket(10)
+	now := b.last
+	for i := 0; i < int(b.burst); i++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("reservation %d waits %v, want 0", i, d)
+		}
+	}
+	if d := b.reserve(now); d != 100*time.Millisecond {
+		t.Errorf("first reservation over the burst waits %v, want 100ms", d)
+	}
+	if d := b.reserve(now); d != 200*time.Millisecond {
+		t.Errorf("second reservation over the burst waits %v, want 200ms", d)
+	}
+	if !b.full(now.Add(time.Hour)) {
+		t.Error("the bucket isn't full an hour later")
+	}
+}
+
+func TestSetupAPIClient(t *testing.T) {
+	previous := mattermostHTTPClient
+	defaultTransport := http.DefaultTransport
+	defer func() {
+		mattermostHTTPClient = previous
+	}()
+
+	setupAPIClient(10, false)
+	limited, ok := mattermostHTTPClient.Transport.(rateLimitedTransport)
+	if !ok {
+		t.Fatalf("the Mattermost client's transport is a %T, want it rate limited", mattermostHTTPClient.Transport)
+	}
+	if _, ok = limited.next.(apiTransport); !ok {
+		t.Errorf("the rate limit is followed by a %T, want the API metrics", limited.next)
+	}
+	if http.DefaultTransport != defaultTransport {
+		t.Error("the default transport was replaced")
+	}
+
+	setupAPIClient(0, false)
+	if _, ok = mattermostHTTPClient.Transport.(apiTransport); !ok {
+		t.Errorf("without a rate the transport is a %T, want the API metrics only", mattermostHTTPClient.Transport)
+	}
+}
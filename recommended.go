@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// SetRecommendedChannelsForm sets the channels suggested to new members, as
+// buttons under the channel's welcome message.
+var SetRecommendedChannelsForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "channels",
+			Description:          "The names of the channels to recommend, separated by spaces. Leave empty to remove the recommendations.",
+			AutocompletePosition: -1,
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_recommended_channels").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// JoinRecommendedChannel is the call made by the buttons of the welcome post.
+// Its state is the ID of the channel to join, the expanded channel is the one
+// the welcome was posted in.
+var JoinRecommendedChannel = apps.NewCall("/join_recommended_channel").WithExpand(apps.Expand{
+	ActingUser:            apps.ExpandSummary,
+	ActingUserAccessToken: apps.ExpandAll,
+	Channel:               apps.ExpandSummary,
+})
+
+func recommendedJoinsKey(channelID string) string {
+	return "recommended_joins_" + channelID
+}
+
+// RecommendedJoin records a channel a new member joined through the buttons of
+// a welcome post.
+type RecommendedJoin struct {
+	ChannelID string `json:"channel_id"`
+	JoinedAt  int64  `json:"joined_at"`
+}
+
+// GetRecommendedJoins returns the channels joined through the buttons of the
+// channel's welcome posts, by user ID.
+func (s *Store) GetRecommendedJoins(channelID string) (map[string][]RecommendedJoin, error) {
+	joins := map[string][]RecommendedJoin{}
+	if err := s.kv.KVGet(KVAppPrefix, recommendedJoinsKey(channelID), &joins); err != nil {
+		return nil, err
+	}
+	if joins == nil {
+		joins = map[string][]RecommendedJoin{}
+	}
+	return joins, nil
+}
+
+// AddRecommendedJoin records that the user joined joinedChannelID through the
+// welcome post of channelID.
+func (s *Store) AddRecommendedJoin(channelID, userID, joinedChannelID string) error {
+	joins, err := s.GetRecommendedJoins(channelID)
+	if err != nil {
+		return err
+	}
+
+	for _, join := range joins[userID] {
+		if join.ChannelID == joinedChannelID {
+			return nil
+		}
+	}
+	joins[userID] = append(joins[userID], RecommendedJoin{
+		ChannelID: joinedChannelID,
+		JoinedAt:  model.GetMillis(),
+	})
+
+	_, err = s.kv.KVSet(KVAppPrefix, recommendedJoinsKey(channelID), joins)
+	return err
+}
+
+// DeleteRecommendedJoins removes the join records of the channel.
+func (s *Store) DeleteRecommendedJoins(channelID string) error {
+	return s.kv.KVDelete(KVAppPrefix, recommendedJoinsKey(channelID))
+}
+
+func SetRecommendedChannelsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the recommended channels"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(
+			errors.New("the channel has no welcome message, set one with `set_channel_welcome` first")))
+		return
+	}
+
+	client := asActingUser(req.Context(), cc)
+	channelIDs := []string{}
+	names := []string{}
+	for _, name := range strings.Fields(c.GetValue("channels", "")) {
+		name = strings.TrimPrefix(name, "~")
+		channel, _, err := client.GetChannelByName(name, cc.Channel.TeamId, "")
+		if err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(fmt.Errorf("we couldn't find the channel %s", name)))
+			return
+		}
+		if channel.Id == cc.Channel.Id {
+			continue
+		}
+		channelIDs = append(channelIDs, channel.Id)
+		names = append(names, "~"+channel.Name)
+	}
+
+	welcome.RecommendedChannels = channelIDs
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the recommended channels"))
+		return
+	}
+
+	if len(names) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Removed the recommended channels"))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members will be invited to join %s", strings.Join(names, ", ")))
+}
+
+// JoinRecommendedChannelCall adds the user who clicked a button of the welcome
+// post to the channel of the button, on their behalf, and records the join.
+func JoinRecommendedChannelCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	channelID, _ := c.State.(string)
+	if c.Context.ActingUser == nil || channelID == "" {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the channel to join"))
+		return
+	}
+	userID := c.Context.ActingUser.Id
+
+	client := asActingUser(req.Context(), c.Context)
+	channel, _, err := client.GetChannel(channelID, "")
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the channel to join"))
+		return
+	}
+
+	if _, _, err = client.AddChannelMember(channelID, userID); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't add you to ~%s", channel.Name))
+		return
+	}
+
+	// Joins are tracked for channel welcomes only: team welcomes are sent
+	// as direct messages.
+	if c.Context.Channel != nil && !c.Context.Channel.IsGroupOrDirect() {
+		err = NewStore(req.Context(), c.Context).AddRecommendedJoin(c.Context.Channel.Id, userID, channelID)
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("You joined ~%s", channel.Name))
+}
+
+// recommendedChannelsBinding returns the in-post binding with a button to join
+// each of the recommended channels. Channels the bot can't see are left out.
+// It returns nil if there is no channel to recommend.
+func recommendedChannelsBinding(client *appclient.Client, channelIDs []string) *apps.Binding {
+	buttons := []apps.Binding{}
+	for _, channelID := range channelIDs {
+		channel, _, err := client.GetChannel(channelID, "")
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		buttons = append(buttons, apps.Binding{
+			Location: apps.Location(channelID),
+			Label:    channel.DisplayName,
+			Submit:   JoinRecommendedChannel.WithState(channelID),
+		})
+	}
+	if len(buttons) == 0 {
+		return nil
+	}
+
+	return &apps.Binding{
+		AppID:       AppID,
+		Location:    "recommended_channels",
+		Label:       "Join these channels",
+		Description: "These channels might interest you too.",
+		Bindings:    buttons,
+	}
+}
+
+// recommendedChannelsSummary describes the recommended channels of the welcome
+// and how many members joined them through the welcome post.
+func recommendedChannelsSummary(store *Store, channelID string, welcome *ChannelWelcome) string {
+	if welcome == nil || len(welcome.RecommendedChannels) == 0 {
+		return ""
+	}
+
+	joins, err := store.GetRecommendedJoins(channelID)
+	if err != nil {
+		logger.Error(err)
+	}
+	count := map[string]int{}
+	for _, userJoins := range joins {
+		for _, join := range userJoins {
+			count[join.ChannelID]++
+		}
+	}
+
+	client := store.client
+	summary := "\n**Recommended channels**\n"
+	for _, id := range welcome.RecommendedChannels {
+		name := id
+		if channel, _, err := client.GetChannel(id, ""); err == nil {
+			name = "~" + channel.Name
+		}
+		summary += fmt.Sprintf("* %s, joined by %d new members\n", name, count[id])
+	}
+	return summary
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisKeyPrefix namespaces the app's keys, so the Redis database can be
+// shared.
+const redisKeyPrefix = "welcomebot:"
+
+// maxIdleRedisConns is how many connections are kept open between commands.
+const maxIdleRedisConns = 16
+
+// RedisBackend keeps the data in Redis, each value under
+// welcomebot:<site URL>:<prefix>/<key>. It speaks RESP itself, with a pool of
+// connections.
+type RedisBackend struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	idle     chan *redisConn
+}
+
+// NewRedisBackend connects to the Redis server at the URL, e.g.
+// redis://:secret@localhost:6379/0, or rediss:// for TLS.
+func NewRedisBackend(rawURL string) (*RedisBackend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, errors.New("the Redis URL must be a redis or rediss URL, e.g. redis://localhost:6379/0")
+	}
+	b := &RedisBackend{
+		addr: u.Host,
+		idle: make(chan *redisConn, maxIdleRedisConns),
+	}
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		b.username = u.User.Username()
+		b.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if b.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	if u.Scheme == "rediss" {
+		b.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+
+	if err = b.Ping(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *RedisBackend) KV(siteURL string) KVStore {
+	return redisKV{backend: b, prefix: redisKeyPrefix + siteURL + ":"}
+}
+
+func (b *RedisBackend) Ping() error {
+	_, err := b.do("PING")
+	return err
+}
+
+func (b *RedisBackend) Close() error {
+	for {
+		select {
+		case conn := <-b.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// redisError is an error reply of Redis.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is a connection to Redis, authenticated and with the database
+// selected.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (b *RedisBackend) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: storageTimeout}
+	var conn net.Conn
+	var err error
+	if b.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.addr, b.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", b.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	if b.password != "" {
+		args := []string{"AUTH", b.password}
+		if b.username != "" {
+			args = []string{"AUTH", b.username, b.password}
+		}
+		if _, err = c.do(args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if b.db != 0 {
+		if _, err = c.do("SELECT", strconv.Itoa(b.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do runs the command on an idle connection, or a new one. The connection is
+// kept if the reply was read, even an error.
+func (b *RedisBackend) do(args ...string) (interface{}, error) {
+	var conn *redisConn
+	select {
+	case conn = <-b.idle:
+	default:
+		var err error
+		if conn, err = b.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := conn.do(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case b.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// do writes the command and reads its reply: a string, an int64, nil, a
+// slice of replies, or a redisError.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(storageTimeout)); err != nil {
+		return nil, err
+	}
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c, cmd.String()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// The scripts acquiring and releasing a lease atomically: the key of the
+// lease holds its holder, and expires with it.
+const (
+	redisAcquireLease = `local holder = redis.call('GET', KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0`
+	redisReleaseLease = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+)
+
+func (b *RedisBackend) Locker(siteURL string) Locker {
+	return redisLocker{backend: b, prefix: redisKeyPrefix + siteURL + ":lease/"}
+}
+
+// redisLocker is the Locker of a Mattermost server in a RedisBackend.
+type redisLocker struct {
+	backend *RedisBackend
+	prefix  string
+}
+
+func (l redisLocker) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	reply, err := l.backend.do("EVAL", redisAcquireLease, "1", l.prefix+name, holder, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+func (l redisLocker) ReleaseLease(name, holder string) error {
+	_, err := l.backend.do("EVAL", redisReleaseLease, "1", l.prefix+name, holder)
+	return err
+}
+
+// redisKV is the KVStore of a Mattermost server in a RedisBackend. Getting a
+// missing key decodes null, as in the Mattermost KV store.
+type redisKV struct {
+	backend *RedisBackend
+	prefix  string
+}
+
+func (kv redisKV) key(prefix, key string) string {
+	return kv.prefix + prefix + "/" + key
+}
+
+func (kv redisKV) KVGet(prefix, key string, ref interface{}) error {
+	reply, err := kv.backend.do("GET", kv.key(prefix, key))
+	if err != nil {
+		return err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		data = "null"
+	}
+	return json.Unmarshal([]byte(data), ref)
+}
+
+func (kv redisKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	if _, err = kv.backend.do("SET", kv.key(prefix, key), string(data)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (kv redisKV) KVDelete(prefix, key string) error {
+	_, err := kv.backend.do("DEL", kv.key(prefix, key))
+	return err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// SetRejoinWindowForm sets how long after being welcomed in a channel members
+// who leave and rejoin it are not welcomed again.
+var SetRejoinWindowForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "days",
+			Description:          "The number of days, e.g. 30. Use 0 to welcome members every time they join.",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+		},
+	},
+	Submit: apps.NewCall("/set_rejoin_window").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+func welcomedKey(channelID string) string {
+	return "welcomed_" + channelID
+}
+
+// GetWelcomed returns when the members of the channel were last welcomed, in
+// milliseconds, by user ID.
+func (s *Store) GetWelcomed(channelID string) (map[string]int64, error) {
+	welcomed := map[string]int64{}
+	if err := s.kv.KVGet(KVAppPrefix, welcomedKey(channelID), &welcomed); err != nil {
+		return nil, err
+	}
+	if welcomed == nil {
+		welcomed = map[string]int64{}
+	}
+	return welcomed, nil
+}
+
+// MarkWelcomed records that the user is welcomed in the channel now, and
+// reports whether the user was already welcomed there within the window. The
+// records older than the window are dropped, so the channel's record only
+// grows with its recent members.
+func (s *Store) MarkWelcomed(channelID, userID string, window time.Duration) (bool, error) {
+	welcomed, err := s.GetWelcomed(channelID)
+	if err != nil {
+		return false, err
+	}
+
+	now := model.GetMillis()
+	since := now - window.Milliseconds()
+	recently := welcomed[userID] > since
+	if recently {
+		return true, nil
+	}
+
+	for id, at := range welcomed {
+		if at <= since {
+			delete(welcomed, id)
+		}
+	}
+	welcomed[userID] = now
+
+	_, err = s.kv.KVSet(KVAppPrefix, welcomedKey(channelID), welcomed)
+	return false, err
+}
+
+// DeleteWelcomed removes the welcome records of the channel.
+func (s *Store) DeleteWelcomed(channelID string) error {
+	return s.kv.KVDelete(KVAppPrefix, welcomedKey(channelID))
+}
+
+// welcomedRecently reports whether the user rejoined the channel within the
+// rejoin window of the welcome they got there, in which case they are not
+// welcomed again. Otherwise the welcome is recorded.
+func welcomedRecently(store *Store, channelID, userID string) bool {
+	settings, err := store.GetSettings()
+	if err != nil {
+		logger.Error(err)
+	}
+	if settings.RejoinWindowDays == 0 {
+		return false
+	}
+
+	recently, err := store.MarkWelcomed(channelID, userID, settings.RejoinWindow())
+	if err != nil {
+		logger.Error(err)
+	}
+	return recently
+}
+
+func SetRejoinWindowCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := requireValues(c, "days"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	days, err := strconv.Atoi(c.GetValue("days", ""))
+	if err != nil || days < 0 {
+		httputils.WriteJSON(w,
+			errorResponse("the number of days must be a positive number"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	settings, err := store.GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	settings.RejoinWindowDays = days
+	if err = store.SetSettings(settings); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if days == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("Members will be welcomed every time they join a channel."))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Members who rejoin a channel within %d days of their welcome won't be welcomed again.", days))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// TemplateData holds the variables available to welcome message templates,
+// e.g. "Welcome {{.FirstName}} to {{.ChannelDisplayName}}!".
+type TemplateData struct {
+	UserName    string
+	NickName    string
+	FirstName   string
+	LastName    string
+	FullName    string
+	DisplayName string
+
+	// IsAdmin and IsGuest tell system admins and guest accounts apart, so a
+	// message can adapt to them, e.g. "{{if .IsGuest}}Ask @support for
+	// access.{{end}}".
+	IsAdmin bool
+	IsGuest bool
+
+	ChannelName        string
+	ChannelDisplayName string
+
+	TeamName        string
+	TeamDisplayName string
+
+	// Mentions @-mentions the members welcomed together in digest mode.
+	Mentions string
+
+	// AddedBy is the username of who added the member to the channel or the
+	// team, empty if they joined on their own, e.g. "{{if .AddedBy}}@{{.AddedBy}}
+	// added you here.{{end}}".
+	AddedBy string
+
+	// Greeting is "Good morning", "Good afternoon" or "Good evening" at the
+	// time the member joined, in their timezone and language, e.g.
+	// "{{.Greeting}} {{.FirstName}}!".
+	Greeting string
+
+	// Vars are the variables given by the system that triggered the welcome
+	// through the webhook, e.g. "Your first day is {{.Vars.start_date}}". They
+	// are empty otherwise.
+	Vars map[string]string
+
+	// snippets are the shared texts included with {{include "name"}}, by
+	// name. They are loaded by Store.NewTemplateData.
+	snippets map[string]string
+}
+
+// NewTemplateData collects the template variables from the (expanded) user,
+// channel and team. Any of them may be nil, leaving its variables empty.
+func NewTemplateData(user *model.User, channel *model.Channel, team *model.Team) TemplateData {
+	data := TemplateData{}
+
+	if user != nil {
+		data.UserName = user.Username
+		data.NickName = user.Nickname
+		data.FirstName = user.FirstName
+		data.LastName = user.LastName
+		data.FullName = user.GetFullName()
+		data.DisplayName = user.GetDisplayName(model.ShowNicknameFullName)
+		data.IsAdmin = user.IsSystemAdmin()
+		data.IsGuest = user.IsGuest()
+		data.Greeting = greeting(user, time.Now())
+	}
+
+	if channel != nil {
+		data.ChannelName = channel.Name
+		data.ChannelDisplayName = channel.DisplayName
+	}
+
+	if team != nil {
+		data.TeamName = team.Name
+		data.TeamDisplayName = team.DisplayName
+	}
+
+	return data
+}
+
+// greeting greets the user according to the time of day in their timezone,
+// in their language. Users with no timezone, or an unknown one, are greeted
+// according to UTC.
+func greeting(user *model.User, now time.Time) string {
+	location, err := time.LoadLocation(user.GetPreferredTimezone())
+	if err != nil {
+		logger.Error(err)
+		location = time.UTC
+	}
+
+	message := msgGoodEvening
+	switch hour := now.In(location).Hour(); {
+	case hour >= 5 && hour < 12:
+		message = msgGoodMorning
+	case hour >= 12 && hour < 18:
+		message = msgGoodAfternoon
+	}
+	return localize(user.Locale, message, nil)
+}
+
+// templateFuncs are the functions available to welcome message templates, in
+// the style of the Sprig library, e.g. "{{.FirstName | default "friend" |
+// upper}}" or "{{now | date "Monday"}}".
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"title": cases.Title(language.Und).String,
+	"trim":  strings.TrimSpace,
+
+	// default returns def if value is empty.
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+
+	// trunc shortens s to n characters.
+	"trunc": func(n int, s string) string {
+		runes := []rune(s)
+		if n >= 0 && n < len(runes) {
+			return string(runes[:n])
+		}
+		return s
+	},
+
+	// now and date format the current time, in UTC, with a Go layout, e.g.
+	// "January 2".
+	"now": func() time.Time {
+		return time.Now().UTC()
+	},
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+
+	// link, channel and mention write the markdown of a link, a channel
+	// link and a mention.
+	"link": func(text, url string) string {
+		return fmt.Sprintf("[%s](%s)", text, url)
+	},
+	"channel": func(name string) string {
+		return "~" + name
+	},
+	"mention": func(username string) string {
+		return "@" + username
+	},
+
+	// include inserts a snippet, rendered with the same variables, e.g.
+	// "{{include "rules"}}". It is bound to the snippets when rendering.
+	"include": func(name string) string {
+		return ""
+	},
+}
+
+// maxIncludeDepth caps how deep snippets can include other snippets, so a
+// snippet including itself doesn't loop forever.
+const maxIncludeDepth = 5
+
+// RenderTemplate executes the welcome message as a text/template against data.
+// Unknown snippets are included as empty texts.
+func RenderTemplate(message string, data TemplateData) (string, error) {
+	return renderTemplate(message, data, 0)
+}
+
+func renderTemplate(message string, data TemplateData, depth int) (string, error) {
+	include := func(name string) (string, error) {
+		snippet, ok := data.snippets[name]
+		if !ok {
+			return "", nil
+		}
+		if depth >= maxIncludeDepth {
+			return "", fmt.Errorf("snippet %q: snippets are included more than %d levels deep", name, maxIncludeDepth)
+		}
+		return renderTemplate(snippet, data, depth+1)
+	}
+
+	tmpl, err := template.New("welcome").Funcs(templateFuncs).Funcs(template.FuncMap{"include": include}).Option("missingkey=zero").Parse(message)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// RenderWelcome renders the welcome message, falling back to the message as it
+// was stored if the template is broken, so the new member still gets greeted.
+func RenderWelcome(message string, data TemplateData) string {
+	rendered, err := RenderTemplate(message, data)
+	if err != nil {
+		logger.Errorf("failed to render welcome message template: %v", err)
+		return message
+	}
+
+	return rendered
+}
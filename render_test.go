@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestNewTemplateData(t *testing.T) {
+	user := &model.User{Username: "jdoe", Nickname: "JD", FirstName: "Jane", LastName: "Doe"}
+	channel := &model.Channel{Name: "town-square", DisplayName: "Town Square"}
+	team := &model.Team{Name: "acme", DisplayName: "Acme"}
+
+	data := NewTemplateData(user, channel, team)
+	data.Greeting = ""
+	want := TemplateData{
+		UserName:           "jdoe",
+		NickName:           "JD",
+		FirstName:          "Jane",
+		LastName:           "Doe",
+		FullName:           "Jane Doe",
+		DisplayName:        "JD",
+		ChannelName:        "town-square",
+		ChannelDisplayName: "Town Square",
+		TeamName:           "acme",
+		TeamDisplayName:    "Acme",
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("variables = %+v, want %+v", data, want)
+	}
+
+	if empty := NewTemplateData(nil, nil, nil); !reflect.DeepEqual(empty, TemplateData{}) {
+		t.Errorf("the variables of no user, channel or team are %+v", empty)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	data := TemplateData{
+		UserName:           "jdoe",
+		FirstName:          "Jane",
+		ChannelDisplayName: "Town Square",
+		TeamDisplayName:    "Acme",
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+		valid   bool
+	}{
+		{name: "plain", message: "Welcome!", want: "Welcome!", valid: true},
+		{name: "variables", message: "Welcome {{.FirstName}} (@{{.UserName}}) to {{.ChannelDisplayName}} in {{.TeamDisplayName}}!", want: "Welcome Jane (@jdoe) to Town Square in Acme!", valid: true},
+		{name: "empty variable", message: "Hi {{.NickName}}!", want: "Hi !", valid: true},
+		{name: "unclosed action", message: "Hi {{.FirstName"},
+		{name: "unknown variable", message: "Hi {{.Unknown}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplate(tt.message, data)
+			if (err == nil) != tt.valid {
+				t.Fatalf("err = %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid && got != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderWelcomeFallsBack(t *testing.T) {
+	message := "Hi {{.FirstName"
+	if got := RenderWelcome(message, TemplateData{FirstName: "Jane"}); got != message {
+		t.Errorf("rendered %q, want the message as it was stored", got)
+	}
+	if got := RenderWelcome("Hi {{.FirstName}}", TemplateData{FirstName: "Jane"}); got != "Hi Jane" {
+		t.Errorf("rendered %q, want Hi Jane", got)
+	}
+}
+
+func TestRenderTemplateRoles(t *testing.T) {
+	message := "Welcome!{{if .IsAdmin}} See the admin guide.{{end}}{{if .IsGuest}} Ask @support for access.{{end}}"
+	tests := []struct {
+		name  string
+		roles string
+		want  string
+	}{
+		{name: "member", roles: model.SystemUserRoleId, want: "Welcome!"},
+		{name: "admin", roles: model.SystemUserRoleId + " " + model.SystemAdminRoleId, want: "Welcome! See the admin guide."},
+		{name: "guest", roles: model.SystemGuestRoleId, want: "Welcome! Ask @support for access."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := NewTemplateData(&model.User{Username: "jdoe", Roles: tt.roles}, nil, nil)
+			got, err := RenderTemplate(message, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateFuncs(t *testing.T) {
+	data := TemplateData{UserName: "jdoe", FirstName: "jane doe", ChannelName: "town-square"}
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "upper", message: "{{.UserName | upper}}", want: "JDOE"},
+		{name: "lower", message: `{{"JDOE" | lower}}`, want: "jdoe"},
+		{name: "title", message: "{{.FirstName | title}}", want: "Jane Doe"},
+		{name: "trim", message: `{{"  hi  " | trim}}`, want: "hi"},
+		{name: "default of empty", message: `{{.NickName | default "friend"}}`, want: "friend"},
+		{name: "default of set", message: `{{.UserName | default "friend"}}`, want: "jdoe"},
+		{name: "trunc", message: "{{.FirstName | trunc 4}}", want: "jane"},
+		{name: "trunc longer", message: "{{.UserName | trunc 10}}", want: "jdoe"},
+		{name: "date", message: `{{now | date "2006"}}`, want: time.Now().UTC().Format("2006")},
+		{name: "link", message: `{{link "the handbook" "https://example.com"}}`, want: "[the handbook](https://example.com)"},
+		{name: "channel", message: "{{channel .ChannelName}}", want: "~town-square"},
+		{name: "mention", message: "{{mention .UserName}}", want: "@jdoe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplate(tt.message, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGreeting(t *testing.T) {
+	now := time.Date(2024, time.January, 15, 14, 0, 0, 0, time.UTC)
+	timezone := func(name string) model.StringMap {
+		return model.StringMap{"useAutomaticTimezone": "false", "manualTimezone": name}
+	}
+
+	tests := []struct {
+		name string
+		user *model.User
+		want string
+	}{
+		{name: "no timezone", user: &model.User{}, want: "Good afternoon"},
+		{name: "morning", user: &model.User{Timezone: timezone("America/New_York")}, want: "Good morning"},
+		{name: "evening", user: &model.User{Timezone: timezone("Asia/Tokyo")}, want: "Good evening"},
+		{name: "automatic", user: &model.User{Timezone: model.StringMap{"useAutomaticTimezone": "true", "automaticTimezone": "Asia/Tokyo"}}, want: "Good evening"},
+		{name: "unknown timezone", user: &model.User{Timezone: timezone("Mars/Olympus_Mons")}, want: "Good afternoon"},
+		{name: "locale", user: &model.User{Locale: "es", Timezone: timezone("America/New_York")}, want: "Buenos días"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := greeting(tt.user, now); got != tt.want {
+				t.Errorf("greeting = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
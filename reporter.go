@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportTimeout is how long the error reporting service has to answer.
+const reportTimeout = 5 * time.Second
+
+// reportQueueSize is how many reports wait to be sent, the others are
+// dropped.
+const reportQueueSize = 100
+
+// ErrorReport is an unexpected failure reported to the operators: a panic,
+// with its stack, or a welcome that failed to be delivered after retrying.
+// Tags are the fields of the request or job that failed, e.g. its ID and
+// channel.
+type ErrorReport struct {
+	Message string
+	Panic   bool
+	Stack   string
+	Tags    map[string]string
+}
+
+// ErrorReporter sends the error reports to a service the operators watch, so
+// they find out about failures before the users do.
+type ErrorReporter interface {
+	Report(report ErrorReport)
+
+	// Stop sends the reports waiting to be sent, and stops reporting.
+	Stop()
+}
+
+// errorReporter is the error reporter configured, nil if there is none.
+var errorReporter ErrorReporter
+
+// reportPanic reports the panic recovered with the fields of the logger.
+func reportPanic(l *Logger, v interface{}, stack []byte) {
+	if errorReporter == nil {
+		return
+	}
+	errorReporter.Report(ErrorReport{
+		Message: fmt.Sprintf("panic: %v", v),
+		Panic:   true,
+		Stack:   string(stack),
+		Tags:    loggerTags(l),
+	})
+}
+
+// reportError reports the error with the fields of the logger.
+func reportError(l *Logger, err error) {
+	if errorReporter == nil {
+		return
+	}
+	errorReporter.Report(ErrorReport{
+		Message: err.Error(),
+		Tags:    loggerTags(l),
+	})
+}
+
+// loggerTags returns the fields of the logger as tags, but the stack which is
+// reported on its own.
+func loggerTags(l *Logger) map[string]string {
+	tags := map[string]string{}
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		if key := fmt.Sprint(l.fields[i]); key != "stack" {
+			tags[key] = fmt.Sprint(l.fields[i+1])
+		}
+	}
+	return tags
+}
+
+// SentryReporter sends the error reports to Sentry, or a service compatible
+// with its store API, e.g. GlitchTip, in the background so the requests and
+// jobs failing don't wait for it.
+type SentryReporter struct {
+	storeURL    string
+	auth        string
+	environment string
+	sendEach    bool
+	client      *http.Client
+
+	queue chan sentryEvent
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewSentryReporter returns the reporter sending to the project of the DSN,
+// e.g. https://key@o0.ingest.sentry.io/42, tagging the reports with the
+// environment if it is set. If sendEach is set, each report is sent right
+// away instead, e.g. in AWS Lambda where the process is frozen once the
+// response is sent.
+func NewSentryReporter(dsn, environment string, sendEach bool) (*SentryReporter, error) {
+	storeURL, auth, err := parseSentryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	r := &SentryReporter{
+		storeURL:    storeURL,
+		auth:        auth,
+		environment: environment,
+		sendEach:    sendEach,
+		client:      &http.Client{Timeout: reportTimeout},
+		queue:       make(chan sentryEvent, reportQueueSize),
+		done:        make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+// parseSentryDSN returns the URL of the store API of the project of the DSN,
+// and the authentication header of its key.
+func parseSentryDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("the Sentry DSN must be an http or https URL with a key, e.g. https://key@o0.ingest.sentry.io/42")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	prefix, project := path[:i+1], path[i+1:]
+	if project == "" {
+		return "", "", errors.New("the Sentry DSN must end with the project ID, e.g. https://key@o0.ingest.sentry.io/42")
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=welcomebot/%s, sentry_key=%s", Version, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return fmt.Sprintf("%s://%s/%sapi/%s/store/", u.Scheme, u.Host, prefix, project), auth, nil
+}
+
+func (s *SentryReporter) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case event := <-s.queue:
+			s.sendLogged(event)
+		case <-s.done:
+			for {
+				select {
+				case event := <-s.queue:
+					s.sendLogged(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stop sends the reports queued, and stops sending.
+func (s *SentryReporter) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// sentryEvent is an event of the Sentry store API.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Platform    string            `json:"platform"`
+	Release     string            `json:"release"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// Report queues the report to be sent, or drops it if too many are queued.
+// The reports are best effort: the errors are logged, and the report dropped.
+func (s *SentryReporter) Report(report ErrorReport) {
+	event := sentryEvent{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       "error",
+		Logger:      "welcomebot",
+		Platform:    "go",
+		Release:     Version,
+		Environment: s.environment,
+		Message:     report.Message,
+		Tags:        report.Tags,
+	}
+	if report.Panic {
+		event.Level = "fatal"
+	}
+	if report.Stack != "" {
+		event.Extra = map[string]string{"stack": report.Stack}
+	}
+	if s.sendEach {
+		s.sendLogged(event)
+		return
+	}
+	select {
+	case s.queue <- event:
+	default:
+		reportsDropped.Inc()
+	}
+}
+
+func (s *SentryReporter) sendLogged(event sentryEvent) {
+	if err := s.send(event); err != nil {
+		logger.Warnf("failed to report the error: %v", err)
+	}
+}
+
+// newEventID returns a random event ID, 32 hexadecimal digits.
+func newEventID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (s *SentryReporter) send(event sentryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.storeURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Sentry answered %s", resp.Status)
+	}
+	return nil
+}
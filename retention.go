@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// retentionInterval is how often the records older than the retention window
+// are pruned.
+const retentionInterval = 24 * time.Hour
+
+// SetRetentionForm sets how long the records kept by user ID are kept.
+var SetRetentionForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "days",
+			Description:          "The number of days, e.g. 90. Use 0 to keep the records forever.",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+		},
+	},
+	Submit: apps.NewCall("/set_retention").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// recordTimes are the times of the records kept by user ID, in milliseconds:
+// acknowledgments, variant assignments, onboarding and checklist progress,
+// drip campaigns and survey responses each have some of them.
+type recordTimes struct {
+	WelcomedAt     int64 `json:"welcomed_at"`
+	AcknowledgedAt int64 `json:"acknowledged_at"`
+	AssignedAt     int64 `json:"assigned_at"`
+	StartedAt      int64 `json:"started_at"`
+	UpdatedAt      int64 `json:"updated_at"`
+	CompletedAt    int64 `json:"completed_at"`
+	JoinedAt       int64 `json:"joined_at"`
+	CanceledAt     int64 `json:"canceled_at"`
+	SubmittedAt    int64 `json:"submitted_at"`
+}
+
+// lastUpdate returns the latest time of the record, or zero if it has none.
+// The welcome times of channels are plain times, and the recommended channel
+// joins lists of joins.
+func lastUpdate(raw json.RawMessage) int64 {
+	var at int64
+	if err := json.Unmarshal(raw, &at); err == nil {
+		return at
+	}
+	var joins []RecommendedJoin
+	if err := json.Unmarshal(raw, &joins); err == nil {
+		for _, join := range joins {
+			if join.JoinedAt > at {
+				at = join.JoinedAt
+			}
+		}
+		return at
+	}
+
+	var times recordTimes
+	if err := json.Unmarshal(raw, &times); err != nil {
+		return 0
+	}
+	for _, t := range []int64{times.WelcomedAt, times.AcknowledgedAt, times.AssignedAt, times.StartedAt,
+		times.UpdatedAt, times.CompletedAt, times.JoinedAt, times.CanceledAt, times.SubmittedAt} {
+		if t > at {
+			at = t
+		}
+	}
+	return at
+}
+
+// pruneUserRecords removes the records of the map stored under the key that
+// were last updated before cutoff. Records without a time are kept. It returns
+// the number of records removed.
+func (s *Store) pruneUserRecords(key string, cutoff int64) (int, error) {
+	records := map[string]json.RawMessage{}
+	if err := s.kv.KVGet(KVAppPrefix, key, &records); err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for userID, raw := range records {
+		if at := lastUpdate(raw); at != 0 && at < cutoff {
+			delete(records, userID)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+	_, err := s.kv.KVSet(KVAppPrefix, key, records)
+	return pruned, err
+}
+
+// PruneRecords removes the records kept by user ID by the channel and team
+// welcomes that were last updated before cutoff. The statistics, which only
+// hold counters, are kept. It returns the number of records removed.
+func (s *Store) PruneRecords(cutoff int64) (int, error) {
+	channelIDs, teamIDs, err := s.userRecordChannels()
+	if err != nil {
+		return 0, err
+	}
+	keys := []string{}
+	for _, channelID := range channelIDs {
+		keys = append(keys, channelUserRecordKeys(channelID)...)
+	}
+	for _, teamID := range teamIDs {
+		keys = append(keys, teamUserRecordKeys(teamID)...)
+	}
+
+	pruned := 0
+	for _, key := range keys {
+		n, err := s.pruneUserRecords(key, cutoff)
+		pruned += n
+		if err != nil {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// newRetentionJob returns the job of the next pruning.
+func newRetentionJob() Job {
+	return Job{
+		ID:    model.NewId(),
+		Kind:  JobKindRetention,
+		RunAt: model.GetMillis() + retentionInterval.Milliseconds(),
+	}
+}
+
+// scheduleRetention queues the next pruning, replacing the one queued by a
+// previous installation, if any.
+func scheduleRetention(ctx context.Context, cc apps.Context) error {
+	err := scheduler.Cancel(ctx, cc, func(job Job) bool {
+		return job.Kind == JobKindRetention
+	})
+	if err != nil {
+		return err
+	}
+	return scheduler.Enqueue(ctx, cc, []Job{newRetentionJob()})
+}
+
+// retentionJob prunes the records older than the retention window, if one is
+// set. The next pruning is queued whatever happens.
+func retentionJob(ctx context.Context, cc apps.Context, store *Store) error {
+	defer func() {
+		if err := scheduler.Enqueue(ctx, cc, []Job{newRetentionJob()}); err != nil {
+			logger.Error(err)
+		}
+	}()
+
+	settings, err := store.GetSettings()
+	if err != nil || settings.RetentionDays == 0 {
+		return err
+	}
+	pruned, err := store.PruneRecords(model.GetMillis() - settings.Retention().Milliseconds())
+	if pruned > 0 {
+		logger.Infof("pruned %d records older than %d days", pruned, settings.RetentionDays)
+	}
+	return err
+}
+
+func SetRetentionCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := requireValues(c, "days"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	days, err := strconv.Atoi(c.GetValue("days", ""))
+	if err != nil || days < 0 {
+		httputils.WriteJSON(w,
+			errorResponse("the number of days must be a positive number"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	settings, err := store.GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	settings.RetentionDays = days
+	if err = store.SetSettings(settings); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err = scheduleRetention(req.Context(), c.Context); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	if days == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("The acknowledgments, onboarding progress, survey responses and other records of members will be kept forever."))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("The acknowledgments, onboarding progress, survey responses and other records of members will be removed %d days after their last update, once a day. The delivery statistics are kept.", days))
+}
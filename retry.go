@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The retry policy of the requests to Mattermost: up to retryAttempts
+// attempts, waiting a random time up to retryBaseDelay before the second one,
+// doubling for each next one.
+const (
+	retryAttempts  = 3
+	retryBaseDelay = 200 * time.Millisecond
+)
+
+// statusPattern matches the errors of the app client for the unexpected
+// statuses, e.g. of the KV store operations.
+var statusPattern = regexp.MustCompile(`^returned with status (\d+)$`)
+
+// temporary reports whether the request may succeed if retried: Mattermost
+// failed, was rate limiting, or couldn't be reached. Rejected requests, e.g.
+// for lack of permissions, and the errors whose status is unknown are not
+// retried.
+func temporary(err error) bool {
+	var appErr *model.AppError
+	if errors.As(err, &appErr) {
+		return appErr.StatusCode == 0 || temporaryStatus(appErr.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if match := statusPattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		return temporaryStatus(status)
+	}
+	// The app client returns the body of the other failed responses as the
+	// error, the app error of Mattermost as JSON.
+	body := struct {
+		StatusCode int `json:"status_code"`
+	}{}
+	if json.Unmarshal([]byte(err.Error()), &body) == nil {
+		return temporaryStatus(body.StatusCode)
+	}
+	return false
+}
+
+func temporaryStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retry runs op until it succeeds, fails with an error that is not temporary,
+// or the attempts are exhausted, with an exponential backoff and full jitter
+// between the attempts. It returns the last error.
+func retry(op func() error) error {
+	var err error
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || attempt == retryAttempts || !temporary(err) {
+			return err
+		}
+		apiRetries.Inc()
+		time.Sleep(time.Duration(rand.Int63n(int64(delay))))
+		delay *= 2
+	}
+}
+
+// createPost creates the post, retrying the temporary failures. The pending
+// post ID lets Mattermost drop the duplicates, should an attempt fail after
+// the post was created.
+func createPost(poster Poster, post *model.Post) (*model.Post, error) {
+	if post.PendingPostId == "" {
+		post.PendingPostId = model.NewId()
+	}
+	var created *model.Post
+	err := retry(func() error {
+		var err error
+		created, err = poster.CreatePost(post)
+		return err
+	})
+	return created, err
+}
+
+// createEphemeralPost shows the post to the user only, retrying the temporary
+// failures. Ephemeral posts aren't stored, so an attempt failing after the
+// post was shown may show it twice.
+func createEphemeralPost(client *appclient.Client, userID string, post *model.Post) error {
+	return retry(func() error {
+		_, _, err := client.CreatePostEphemeral(&model.PostEphemeral{
+			UserID: userID,
+			Post:   post,
+		})
+		return err
+	})
+}
+
+// retryKV retries the temporary failures of the KV store operations, which
+// can all be repeated safely.
+type retryKV struct {
+	KVStore
+}
+
+func (kv retryKV) KVGet(prefix, key string, ref interface{}) error {
+	return retry(func() error {
+		return kv.KVStore.KVGet(prefix, key, ref)
+	})
+}
+
+func (kv retryKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	var changed bool
+	err := retry(func() error {
+		var err error
+		changed, err = kv.KVStore.KVSet(prefix, key, value)
+		return err
+	})
+	return changed, err
+}
+
+func (kv retryKV) KVDelete(prefix, key string) error {
+	return retry(func() error {
+		return kv.KVStore.KVDelete(prefix, key)
+	})
+}
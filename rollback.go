@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// RollbackForm restores a revision of the channel's welcome.
+var RollbackForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeNumber,
+			Name:                 "revision",
+			ModalLabel:           "Revision",
+			Description:          "The number of the revision to restore, as shown by `history`. The one before the last change by default.",
+			AutocompletePosition: 1,
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/rollback").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// RollbackCall restores the channel's welcome as it was after a revision. The
+// restore is itself recorded as a new revision, so it can be undone too.
+func RollbackCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	revisions, err := store.GetHistory(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the history"))
+		return
+	}
+	if len(revisions) < 2 && c.GetValue("revision", "") == "" {
+		httputils.WriteJSON(w,
+			errorResponse("the welcome message of ~%s has no earlier revision", cc.Channel.Name))
+		return
+	}
+
+	var revision *Revision
+	if value := c.GetValue("revision", ""); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			httputils.WriteJSON(w,
+				errorResponse("the revision must be a number, got %q", value))
+			return
+		}
+		for i := range revisions {
+			if revisions[i].Number == number {
+				revision = &revisions[i]
+			}
+		}
+		if revision == nil {
+			httputils.WriteJSON(w,
+				errorResponse("there is no revision #%d, the history keeps the last %d changes", number, maxRevisions))
+			return
+		}
+	} else {
+		revision = &revisions[len(revisions)-2]
+	}
+	if revision.Welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("revision #%d deleted the welcome message, use `delete_channel_welcome` instead", revision.Number))
+		return
+	}
+
+	// The guide post of the current welcome is kept, as the one of the
+	// revision may be gone, and its version is incremented from the current
+	// one.
+	welcome := *revision.Welcome
+	current, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	welcome.GuidePostID = ""
+	welcome.Version = 0
+	if current != nil {
+		welcome.GuidePostID = current.GuidePostID
+		welcome.Version = current.Version
+	}
+	client := asBot(req.Context(), cc)
+	if welcome.PinGuide {
+		if err = updateGuide(client, cc.Channel, &welcome); err != nil {
+			requestLogger(req).Error(err)
+		}
+	} else if current != nil {
+		if err = removeGuide(client, current); err != nil {
+			requestLogger(req).Error(err)
+		}
+		welcome.GuidePostID = ""
+	}
+
+	if err = store.SetChannelWelcome(cc.Channel.Id, welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't restore the welcome message"))
+		return
+	}
+	if current == nil {
+		if err = enableChannelWelcome(req.Context(), cc); err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w,
+				errorResponse("restored the welcome message, but we couldn't subscribe to the join events of ~%s: %s", cc.Channel.Name, err))
+			return
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Restored the welcome message of ~%s as of revision #%d%s.", cc.Channel.Name, revision.Number, revisionSummary(*revision)))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// rotationSeparator separates the messages of the rotation pool: a line with
+// three dashes or more.
+var rotationSeparator = regexp.MustCompile(`(?m)^\s*-{3,}\s*$`)
+
+// SetRotationForm sets the pool of alternative texts of the first message of
+// the channel's welcome.
+var SetRotationForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			Name:                 "messages",
+			ModalLabel:           "Messages",
+			Description:          "Alternative texts of the first welcome message, separated by a line with ---. Leave empty to always send the same text.",
+			AutocompletePosition: -1,
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_rotation").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// rotateWelcome returns the welcome with its first message replaced by a
+// random text of the pool, the first message itself included. The welcome is
+// returned as is if it has no rotation, or the first message was picked.
+func rotateWelcome(welcome ChannelWelcome) ChannelWelcome {
+	if len(welcome.Rotation) == 0 || len(welcome.Messages) == 0 {
+		return welcome
+	}
+
+	n := rand.Intn(len(welcome.Rotation) + 1)
+	if n == 0 {
+		return welcome
+	}
+
+	// Like the variants, the alternative texts are the same in every
+	// locale.
+	messages := append([]WelcomeMessage{}, welcome.Messages...)
+	messages[0].Message = welcome.Rotation[n-1]
+	messages[0].Translations = nil
+	welcome.Messages = messages
+	return welcome
+}
+
+func SetRotationCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	rotation := []string{}
+	for _, message := range rotationSeparator.Split(c.GetValue("messages", ""), -1) {
+		message = strings.TrimSpace(message)
+		if message == "" {
+			continue
+		}
+		if err = checkWelcomeLength(message); err != nil {
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		if _, err = RenderTemplate(message, TemplateData{}); err != nil {
+			httputils.WriteJSON(w, errorResponse("invalid template: %s", err))
+			return
+		}
+		rotation = append(rotation, message)
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the rotation"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	welcome.Rotation = rotation
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the rotation"))
+		return
+	}
+
+	if len(rotation) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("New members of ~%s will always get the same first welcome message.", cc.Channel.Name))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of ~%s will get one of %d texts of the first welcome message, at random.", cc.Channel.Name, len(rotation)+1))
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// requestIDHeader is the header carrying the ID of a request, logged with
+// each request. One is generated if the caller didn't provide it.
+const requestIDHeader = "X-Request-Id"
+
+// maxCallBodySize is the largest call body read, in bytes.
+const maxCallBodySize = 4 << 20
+
+// Router maps the app's paths to their handlers, wrapped in the middleware
+// shared by every endpoint: tracing, request logging and panic recovery. Call
+// endpoints, which Mattermost always POSTs to, also reject other methods, and
+// the calls not signed by Mattermost if the app has a secret, and the bodies
+// larger than maxCallBodySize. Plain endpoints are rate limited by IP.
+type Router struct {
+	mux     *http.ServeMux
+	secret  []byte
+	limiter *ipRateLimiter
+}
+
+// NewRouter returns an empty Router. If secret is not empty, the calls must
+// carry a JWT signed with it. If rateLimit is not zero, each IP can make that
+// many requests per second to the plain endpoints.
+func NewRouter(secret string, rateLimit float64) *Router {
+	r := &Router{
+		mux:    http.NewServeMux(),
+		secret: []byte(secret),
+	}
+	if rateLimit > 0 {
+		r.limiter = newIPRateLimiter(rateLimit)
+	}
+	return r
+}
+
+// Call registers the handler of a call, e.g. a command submission or a
+// subscription notification. Calls are counted in the metrics, and give the
+// scheduler the bot credentials once authenticated.
+func (r *Router) Call(path string, handler http.HandlerFunc) {
+	handler = resumeJobs(scheduler, handler)
+	if len(r.secret) > 0 {
+		handler = requireJWT(r.secret, handler)
+	}
+	r.mux.Handle(path, traceRequests(path, logRequests(limitCallBody(logCalls(recoverCall(requirePost(countCalls(path, handler))))))))
+}
+
+// Handle registers a plain HTTP handler, e.g. a static asset.
+func (r *Router) Handle(path string, handler http.HandlerFunc) {
+	if r.limiter != nil {
+		handler = limitRate(r.limiter, handler)
+	}
+	r.mux.Handle(path, traceRequests(path, logRequests(recoverPlain(handler))))
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// statusRecorder remembers the status code written by a handler, for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs every request with its ID, status and latency. The
+// handlers log with requestLogger, which adds the request ID, the correlation
+// ID of their entries.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestID := req.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = model.NewId()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		fields := &requestFields{logger: logger.With("request_id", requestID, "path", req.URL.Path)}
+		if id := traceID(req.Context()); id != "" {
+			fields.logger = fields.logger.With("trace_id", id)
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := withLogger(context.WithValue(req.Context(), requestFieldsKey{}, fields), fields.logger)
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+
+		level := LevelInfo
+		if recorder.status >= 500 {
+			level = LevelError
+		}
+		fields.logger.With("method", req.Method, "status", recorder.status, "duration", time.Since(start)).log(level, "request")
+	})
+}
+
+// requestFields holds the logger of a request, which the call middleware adds
+// the call's entities to.
+type requestFields struct {
+	logger *Logger
+}
+
+type requestFieldsKey struct{}
+
+// limitCallBody reads the body of the call, rejecting it if it is larger than
+// maxCallBodySize, so the handlers read it from memory.
+func limitCallBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Body != nil {
+			data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxCallBodySize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, fmt.Sprintf("the body must be at most %d bytes", maxCallBodySize), http.StatusRequestEntityTooLarge)
+				} else {
+					http.Error(w, "failed to read the body", http.StatusBadRequest)
+				}
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		next(w, req)
+	}
+}
+
+// logCalls adds the call's entities to the request's logger: the channel,
+// team and users it is about.
+func logCalls(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		fields, ok := req.Context().Value(requestFieldsKey{}).(*requestFields)
+		if ok && req.Body != nil {
+			data, err := io.ReadAll(req.Body)
+			c := apps.CallRequest{}
+			if err == nil && json.Unmarshal(data, &c) == nil {
+				fields.logger = fields.logger.With(callFields(c.Context)...)
+			}
+			req.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		if ok {
+			req = req.WithContext(withLogger(req.Context(), fields.logger))
+		}
+		next(w, req)
+	}
+}
+
+// callFields returns the log fields of the entities of the call.
+func callFields(cc apps.Context) []interface{} {
+	fields := []interface{}{}
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key, value)
+		}
+	}
+	channelID, teamID := cc.UserAgentContext.ChannelID, cc.UserAgentContext.TeamID
+	if cc.Channel != nil {
+		channelID = cc.Channel.Id
+	}
+	if cc.Team != nil {
+		teamID = cc.Team.Id
+	}
+	add("channel_id", channelID)
+	add("team_id", teamID)
+	if cc.ActingUser != nil {
+		add("acting_user_id", cc.ActingUser.Id)
+	}
+	if cc.User != nil {
+		add("user_id", cc.User.Id)
+	}
+	return fields
+}
+
+// requirePost rejects the requests that aren't POSTs.
+func requirePost(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// callClaims are the claims of the JWT Mattermost sends with the calls: the
+// user the call is made for, if any.
+type callClaims struct {
+	jwt.RegisteredClaims
+	ActingUserID string `json:"acting_user_id,omitempty"`
+}
+
+// requireJWT rejects the calls without a valid JWT signed with the app's
+// secret, which Mattermost sends in the apps.OutgoingAuthHeader header when
+// the manifest sets UseJWT. The acting user of the call must be the one the
+// token was issued for, so a token can't be replayed for another user.
+func requireJWT(secret []byte, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := req.Header.Get(apps.OutgoingAuthHeader)
+		if !strings.HasPrefix(token, "Bearer ") {
+			http.Error(w, "missing "+apps.OutgoingAuthHeader+" header", http.StatusUnauthorized)
+			return
+		}
+		claims := callClaims{}
+		_, err := jwt.ParseWithClaims(strings.TrimPrefix(token, "Bearer "), &claims, func(token *jwt.Token) (interface{}, error) {
+			return secret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err == nil && req.Body != nil {
+			err = checkActingUser(req, claims.ActingUserID)
+		}
+		if err != nil {
+			requestLogger(req).Warnf("rejected the call: %v", err)
+			http.Error(w, "invalid JWT", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// checkActingUser makes sure the acting user of the call, if any, is the one
+// of the JWT. The body is read, and restored for the next handlers.
+func checkActingUser(req *http.Request, actingUserID string) error {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	c := apps.CallRequest{}
+	if err = json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("invalid call request: %w", err)
+	}
+	if c.Context.ActingUser == nil {
+		return nil
+	}
+	if c.Context.ActingUser.Id != actingUserID {
+		return fmt.Errorf("the call acts as %q, the JWT was issued for %q", c.Context.ActingUser.Id, actingUserID)
+	}
+	return nil
+}
+
+// resumeJobs gives the scheduler the bot credentials of the first call
+// received, once it is authenticated, so the jobs queued before a restart are
+// run without waiting for a new job to be queued.
+func resumeJobs(s *Scheduler, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if _, known := s.Context(); !known && req.Body != nil {
+			data, err := io.ReadAll(req.Body)
+			c := apps.CallRequest{}
+			if err == nil && json.Unmarshal(data, &c) == nil {
+				s.SetContext(c.Context)
+			}
+			req.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		next(w, req)
+	}
+}
+
+// recoverCall turns a panic of a call handler into an error response, so the
+// user sees the call failed rather than a dropped connection.
+func recoverCall(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				stack := debug.Stack()
+				requestLogger(req).With("stack", string(stack)).Errorf("panic: %v", v)
+				reportPanic(requestLogger(req), v, stack)
+				httputils.WriteJSON(w, errorResponse("the Welcome Bot failed unexpectedly, please try again"))
+			}
+		}()
+		next(w, req)
+	}
+}
+
+// recoverPlain turns a panic of a plain handler into an internal server error.
+func recoverPlain(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				stack := debug.Stack()
+				requestLogger(req).With("stack", string(stack)).Errorf("panic: %v", v)
+				reportPanic(requestLogger(req), v, stack)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+		}()
+		next(w, req)
+	}
+}
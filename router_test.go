@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestRequireJWT(t *testing.T) {
+	secret := []byte("secret")
+	sign := func(method jwt.SigningMethod, key interface{}, expiresAt time.Time) string {
+		token, err := jwt.NewWithClaims(method, callClaims{
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expiresAt)},
+			ActingUserID:     "user",
+		}).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+	later := time.Now().Add(time.Minute)
+	valid := sign(jwt.SigningMethodHS256, secret, later)
+	asUser := `{"context":{"acting_user":{"id":"user"}}}`
+
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		status int
+	}{
+		{name: "valid", header: valid, body: asUser, status: http.StatusOK},
+		{name: "no acting user", header: valid, body: `{"context":{}}`, status: http.StatusOK},
+		{name: "other acting user", header: valid, body: `{"context":{"acting_user":{"id":"admin"}}}`, status: http.StatusUnauthorized},
+		{name: "invalid body", header: valid, body: "{", status: http.StatusUnauthorized},
+		{name: "missing", header: "", body: asUser, status: http.StatusUnauthorized},
+		{name: "not bearer", header: "Basic dXNlcjpwYXNz", body: asUser, status: http.StatusUnauthorized},
+		{name: "other secret", header: sign(jwt.SigningMethodHS256, []byte("other"), later), body: asUser, status: http.StatusUnauthorized},
+		{name: "other algorithm", header: sign(jwt.SigningMethodHS512, secret, later), body: asUser, status: http.StatusUnauthorized},
+		{name: "unsigned", header: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, later), body: asUser, status: http.StatusUnauthorized},
+		{name: "expired", header: sign(jwt.SigningMethodHS256, secret, time.Now().Add(-time.Minute)), body: asUser, status: http.StatusUnauthorized},
+		{name: "malformed", header: "Bearer not.a.token", body: asUser, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			called := false
+			handler := requireJWT(secret, func(w http.ResponseWriter, req *http.Request) {
+				called = true
+				data, _ := io.ReadAll(req.Body)
+				body = string(data)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/call", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set(apps.OutgoingAuthHeader, tt.header)
+			}
+			recorder := httptest.NewRecorder()
+			handler(recorder, req)
+
+			if recorder.Code != tt.status {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.status)
+			}
+			if called != (tt.status == http.StatusOK) {
+				t.Errorf("handler called = %v, want %v", called, tt.status == http.StatusOK)
+			}
+			if called && body != tt.body {
+				t.Errorf("the handler read %q, want the call request", body)
+			}
+		})
+	}
+}
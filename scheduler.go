@@ -0,0 +1,687 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// jobsKey is the name of the lock of the queue, and the key the jobs were
+// all stored under before they got one key each, for their migration.
+const jobsKey = "jobs"
+
+// jobIndexKey is the key of the index of the queued jobs, each job being
+// stored under its jobKey.
+const jobIndexKey = "job_index"
+
+// jobClaimTimeout is how long a job claimed by the scheduler is left to run
+// before it is run again, the replica running it having likely crashed. Jobs
+// are run at least once.
+const jobClaimTimeout = 10 * time.Minute
+
+// schedulerPollInterval is how often the scheduler checks the queue for jobs
+// that are due, when it isn't woken up by a new job.
+const schedulerPollInterval = 15 * time.Second
+
+// schedulerLease is the lease of the replica running the jobs, when the app
+// runs as several replicas: it is held for schedulerLeaseTTL, and renewed each
+// poll interval while the replica checks the queue.
+const (
+	schedulerLease    = "scheduler"
+	schedulerLeaseTTL = 3 * schedulerPollInterval
+)
+
+// The kinds of jobs: posting a rendered message, rendering and posting the
+// pending digest of a channel, reacting to the first post of a new member,
+// sending a follow-up of a team's drip campaign, cleaning up the data of the
+// channels that were archived or deleted, sending a channel's welcome to one
+// of its members as part of a broadcast, or checking whether the tracked
+// guests were promoted to members, congratulating a member on a milestone of
+// the team, or pruning the records older than the retention window.
+const (
+	JobKindPost      = ""
+	JobKindDigest    = "digest"
+	JobKindReact     = "react"
+	JobKindFollowUp  = "follow_up"
+	JobKindCleanup   = "cleanup"
+	JobKindBroadcast = "broadcast"
+
+	JobKindPromotionCheck = "promotion_check"
+	JobKindMilestone      = "milestone"
+	JobKindRetention      = "retention"
+)
+
+// Job is a post the bot has to create at a later time. Jobs are queued in KV so
+// they are sent even if the app restarts in the meantime.
+type Job struct {
+	ID        string                `json:"id"`
+	Kind      string                `json:"kind,omitempty"`
+	RunAt     int64                 `json:"run_at"`
+	ChannelID string                `json:"channel_id"`
+	Message   string                `json:"message"`
+	Props     model.StringInterface `json:"props,omitempty"`
+
+	// UserID and Since are the member whose first post a JobKindReact job
+	// reacts to, and when they joined. UserID is also the only member who
+	// sees the post of an Ephemeral JobKindPost job. The post of a Thread
+	// job is a reply in the channel's welcome thread.
+	UserID    string `json:"user_id,omitempty"`
+	Since     int64  `json:"since,omitempty"`
+	Ephemeral bool   `json:"ephemeral,omitempty"`
+	Thread    bool   `json:"thread,omitempty"`
+
+	// TeamID is the team whose drip campaign a JobKindFollowUp job is part
+	// of, or whose milestone a JobKindMilestone job sends, for UserID.
+	TeamID string `json:"team_id,omitempty"`
+
+	// WelcomeKind and WelcomeID are the welcome a job sends, IndexKindChannel
+	// or IndexKindTeam and its ID, whose statistics count the job when it
+	// runs, and UserID the member it welcomes, if it welcomes a single one.
+	// Other jobs, like reactions, are not counted.
+	WelcomeKind string `json:"welcome_kind,omitempty"`
+	WelcomeID   string `json:"welcome_id,omitempty"`
+}
+
+// JobEntry is a job in the index of the queue: when it is due, when the
+// scheduler claimed it to run it, if it did, and the fields of the job the
+// jobs are cancelled by.
+type JobEntry struct {
+	ID        string `json:"id"`
+	RunAt     int64  `json:"run_at"`
+	ClaimedAt int64  `json:"claimed_at,omitempty"`
+
+	Kind        string `json:"kind,omitempty"`
+	ChannelID   string `json:"channel_id,omitempty"`
+	UserID      string `json:"user_id,omitempty"`
+	TeamID      string `json:"team_id,omitempty"`
+	WelcomeKind string `json:"welcome_kind,omitempty"`
+	WelcomeID   string `json:"welcome_id,omitempty"`
+}
+
+func newJobEntry(job Job) JobEntry {
+	return JobEntry{
+		ID:          job.ID,
+		RunAt:       job.RunAt,
+		Kind:        job.Kind,
+		ChannelID:   job.ChannelID,
+		UserID:      job.UserID,
+		TeamID:      job.TeamID,
+		WelcomeKind: job.WelcomeKind,
+		WelcomeID:   job.WelcomeID,
+	}
+}
+
+// job returns the job with the indexed fields of the entry only.
+func (e JobEntry) job() Job {
+	return Job{
+		ID:          e.ID,
+		Kind:        e.Kind,
+		RunAt:       e.RunAt,
+		ChannelID:   e.ChannelID,
+		UserID:      e.UserID,
+		TeamID:      e.TeamID,
+		WelcomeKind: e.WelcomeKind,
+		WelcomeID:   e.WelcomeID,
+	}
+}
+
+func jobKey(jobID string) string {
+	return "job_" + jobID
+}
+
+// GetJobIndex returns the index of the queued jobs, in the order they are
+// due.
+func (s *Store) GetJobIndex() ([]JobEntry, error) {
+	var entries []JobEntry
+	err := s.kv.KVGet(KVAppPrefix, jobIndexKey, &entries)
+	return entries, err
+}
+
+// SetJobIndex replaces the index of the queued jobs.
+func (s *Store) SetJobIndex(entries []JobEntry) error {
+	if len(entries) == 0 {
+		return s.kv.KVDelete(KVAppPrefix, jobIndexKey)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].RunAt < entries[j].RunAt
+	})
+	_, err := s.kv.KVSet(KVAppPrefix, jobIndexKey, entries)
+	return err
+}
+
+// GetJob returns the queued job, nil if there is none with the ID.
+func (s *Store) GetJob(jobID string) (*Job, error) {
+	var job *Job
+	err := s.kv.KVGet(KVAppPrefix, jobKey(jobID), &job)
+	return job, err
+}
+
+// SetJob stores the job, which must be indexed to be run.
+func (s *Store) SetJob(job Job) error {
+	_, err := s.kv.KVSet(KVAppPrefix, jobKey(job.ID), job)
+	return err
+}
+
+// DeleteJob removes the stored job.
+func (s *Store) DeleteJob(jobID string) error {
+	return s.kv.KVDelete(KVAppPrefix, jobKey(jobID))
+}
+
+// migrateJobs moves the jobs stored under jobsKey to their own keys and the
+// index. It must be called holding the lock of the queue.
+func (s *Store) migrateJobs() error {
+	var legacy []Job
+	if err := s.kv.KVGet(KVAppPrefix, jobsKey, &legacy); err != nil || len(legacy) == 0 {
+		return err
+	}
+	entries, err := s.GetJobIndex()
+	if err != nil {
+		return err
+	}
+	for _, job := range legacy {
+		if err = s.SetJob(job); err != nil {
+			return err
+		}
+		entries = append(entries, newJobEntry(job))
+	}
+	if err = s.SetJobIndex(entries); err != nil {
+		return err
+	}
+	logger.Infof("migrated %d queued jobs to their own keys", len(legacy))
+	return s.kv.KVDelete(KVAppPrefix, jobsKey)
+}
+
+// Scheduler runs the jobs queued in KV once they are due. It needs the bot's
+// credentials to access KV and post, which Mattermost only sends along with
+// calls: until the first call after a restart, the queue is left untouched.
+// Of the replicas of the app, only the one holding the scheduler lease runs
+// the jobs, so they don't run twice; the others only queue them. The index of
+// the queue is updated holding its lock, so the replicas don't overwrite each
+// other's updates.
+type Scheduler struct {
+	mu   sync.Mutex
+	cc   *apps.Context
+	wake chan struct{}
+
+	// migrated is whether the jobs of the legacy queue were migrated, under
+	// mu.
+	migrated bool
+
+	// leaseMu guards leaseRenewed, when the replica last acquired or renewed
+	// the scheduler lease, apart from mu so the jobs are queued meanwhile.
+	leaseMu      sync.Mutex
+	leaseRenewed time.Time
+}
+
+// NewScheduler returns a scheduler; call Run to start it.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		wake: make(chan struct{}, 1),
+	}
+}
+
+var scheduler = NewScheduler()
+
+// SetContext keeps the bot credentials of the call context for running jobs.
+func (s *Scheduler) SetContext(cc apps.Context) {
+	if cc.BotAccessToken == "" || cc.MattermostSiteURL == "" {
+		return
+	}
+
+	s.mu.Lock()
+	first := s.cc == nil
+	s.cc = &apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			BotUserID:         cc.BotUserID,
+			BotAccessToken:    cc.BotAccessToken,
+			MattermostSiteURL: cc.MattermostSiteURL,
+		},
+	}
+	s.mu.Unlock()
+
+	if first {
+		s.Wake()
+	}
+}
+
+// Context returns the bot credentials kept by SetContext, if any, for use
+// outside of calls.
+func (s *Scheduler) Context() (apps.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cc == nil {
+		return apps.Context{}, false
+	}
+	return *s.cc, true
+}
+
+// lockQueue locks the queue, migrating the legacy jobs first if they weren't
+// yet. It must be called holding mu.
+func (s *Scheduler) lockQueue(store *Store) (func(), error) {
+	unlock, err := store.Lock(jobsKey)
+	if err != nil {
+		return nil, err
+	}
+	if !s.migrated {
+		if err = store.migrateJobs(); err != nil {
+			unlock()
+			return nil, err
+		}
+		s.migrated = true
+	}
+	return unlock, nil
+}
+
+// Enqueue adds the jobs to the queue, and wakes the scheduler up in case some
+// are already due.
+func (s *Scheduler) Enqueue(ctx context.Context, cc apps.Context, jobs []Job) error {
+	s.SetContext(cc)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store := NewStore(ctx, cc)
+	unlock, err := s.lockQueue(store)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := store.GetJobIndex()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if err = store.SetJob(job); err != nil {
+			return err
+		}
+		entries = append(entries, newJobEntry(job))
+	}
+	if err = store.SetJobIndex(entries); err != nil {
+		return err
+	}
+
+	s.Wake()
+	return nil
+}
+
+// Cancel removes the queued jobs matching match. match is only given the
+// indexed fields of the jobs: their kind, channel, user, team and welcome.
+func (s *Scheduler) Cancel(ctx context.Context, cc apps.Context, match func(Job) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store := NewStore(ctx, cc)
+	unlock, err := s.lockQueue(store)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := store.GetJobIndex()
+	if err != nil {
+		return err
+	}
+	kept := []JobEntry{}
+	var cancelled []string
+	for _, entry := range entries {
+		if match(entry.job()) {
+			cancelled = append(cancelled, entry.ID)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	if len(cancelled) == 0 {
+		return nil
+	}
+	if err = store.SetJobIndex(kept); err != nil {
+		return err
+	}
+	for _, id := range cancelled {
+		if err = store.DeleteJob(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimDue marks the due jobs that aren't claimed, or whose claim expired, as
+// claimed now, and returns them.
+func (s *Scheduler) claimDue(store *Store) ([]JobEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lockQueue(store)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, err := store.GetJobIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	now := model.GetMillis()
+	var due []JobEntry
+	for i, entry := range entries {
+		if entry.RunAt <= now && (entry.ClaimedAt == 0 || now-entry.ClaimedAt > jobClaimTimeout.Milliseconds()) {
+			entries[i].ClaimedAt = now
+			due = append(due, entries[i])
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	if err = store.SetJobIndex(entries); err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// finish removes the job from the queue, once it ran or is dropped.
+func (s *Scheduler) finish(store *Store, jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lockQueue(store)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := store.GetJobIndex()
+	if err != nil {
+		return err
+	}
+	kept := []JobEntry{}
+	for _, entry := range entries {
+		if entry.ID != jobID {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) != len(entries) {
+		if err = store.SetJobIndex(kept); err != nil {
+			return err
+		}
+	}
+	return store.DeleteJob(jobID)
+}
+
+// release clears the claims of the jobs, for the replica holding the lease
+// to run them.
+func (s *Scheduler) release(store *Store, claimed []JobEntry) error {
+	ids := map[string]bool{}
+	for _, entry := range claimed {
+		ids[entry.ID] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lockQueue(store)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := store.GetJobIndex()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if ids[entries[i].ID] {
+			entries[i].ClaimedAt = 0
+		}
+	}
+	return store.SetJobIndex(entries)
+}
+
+// Wake makes the scheduler check the queue now.
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the queue periodically, or when woken up, and runs the jobs that
+// are due. When ctx is done, it runs the jobs that are due one last time and
+// returns. The jobs that are not due yet stay queued in KV, for the next
+// start, and the lease is released for another replica to run them.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(schedulerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			s.runDue()
+			s.releaseLease()
+			return
+		}
+		s.runDue()
+	}
+}
+
+// runDue claims the due jobs and runs them, removing each from the queue once
+// it ran: the jobs of a replica that crashes meanwhile are run again once
+// their claim expires. A job that fails is logged and dropped, or kept as a
+// dead letter if it delivered a welcome. All the due jobs are dropped while
+// the welcomes are paused, and the posts to the direct channels of the users
+// who opted out. Nothing runs unless the replica holds the scheduler lease,
+// which is renewed between the jobs: if it is lost, the claims of the jobs
+// left are cleared for the replica holding it.
+func (s *Scheduler) runDue() {
+	cc, ok := s.Context()
+	if !ok {
+		return
+	}
+	store := NewStore(context.Background(), cc)
+	if !s.holdLease(store) {
+		return
+	}
+
+	due, err := s.claimDue(store)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if len(due) == 0 {
+		return
+	}
+	if welcomesPaused(store) {
+		logger.Infof("welcomes are paused, dropped %d due jobs", len(due))
+		for _, entry := range due {
+			if err = s.finish(store, entry.ID); err != nil {
+				logger.Error(err)
+			}
+		}
+		return
+	}
+
+	optedOut := optedOutChannels(store)
+	for i, entry := range due {
+		if !s.holdLease(store) {
+			if err = s.release(store, due[i:]); err != nil {
+				logger.Errorf("failed to release the %d jobs left: %v", len(due)-i, err)
+			}
+			return
+		}
+		if entry.ChannelID == "" || !optedOut[entry.ChannelID] {
+			if err = s.run(cc, store, entry); err != nil {
+				// Left claimed, to be loaded again once the claim expires.
+				jobLogger(entry.job()).Errorf("failed to load the job: %v", err)
+				continue
+			}
+		}
+		if err = s.finish(store, entry.ID); err != nil {
+			logger.Error(err)
+		}
+	}
+}
+
+// run loads the job of the entry and runs it. It only fails if the job
+// couldn't be loaded, the failures of the job being logged and kept as dead
+// letters.
+func (s *Scheduler) run(cc apps.Context, store *Store, entry JobEntry) error {
+	job, err := store.GetJob(entry.ID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		jobLogger(entry.job()).Warn("the job is missing, dropped it")
+		return nil
+	}
+
+	kind := job.Kind
+	if kind == JobKindPost {
+		kind = "post"
+	}
+	ctx, span := tracer.Start(context.Background(), "job "+kind, trace.WithAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.String("job.kind", kind),
+		attribute.String("channel_id", job.ChannelID)))
+	err = runJob(ctx, cc, NewStore(ctx, cc), *job)
+	endSpan(span, err)
+	if err != nil {
+		jobLogger(*job).Errorf("failed to run the job: %v", err)
+		deadLetterJob(store, *job, err)
+	}
+	return nil
+}
+
+// holdLease reports whether the replica holds the scheduler lease, acquiring
+// or renewing it if it is due.
+func (s *Scheduler) holdLease(store *Store) bool {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+
+	if !s.leaseRenewed.IsZero() && time.Since(s.leaseRenewed) < schedulerPollInterval {
+		return true
+	}
+	held, err := store.AcquireLease(schedulerLease, replicaID, schedulerLeaseTTL)
+	if err != nil {
+		logger.Error(err)
+		held = false
+	}
+	switch {
+	case held && s.leaseRenewed.IsZero():
+		logger.With("replica", replicaID).Info("acquired the scheduler lease, running the jobs")
+	case !held && !s.leaseRenewed.IsZero():
+		logger.With("replica", replicaID).Warn("lost the scheduler lease, another replica runs the jobs")
+	}
+	if held {
+		s.leaseRenewed = time.Now()
+	} else {
+		s.leaseRenewed = time.Time{}
+	}
+	return held
+}
+
+// releaseLease releases the scheduler lease, if the replica holds it.
+func (s *Scheduler) releaseLease() {
+	cc, ok := s.Context()
+	if !ok {
+		return
+	}
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	if s.leaseRenewed.IsZero() {
+		return
+	}
+	if err := NewStore(context.Background(), cc).ReleaseLease(schedulerLease, replicaID); err != nil {
+		logger.Error(err)
+	}
+	s.leaseRenewed = time.Time{}
+}
+
+// jobLogger returns the logger of the job, whose ID correlates its entries,
+// with the channel, welcome and member of the job.
+func jobLogger(job Job) *Logger {
+	fields := []interface{}{"job_id", job.ID, "job_kind", job.Kind, "channel_id", job.ChannelID}
+	if job.WelcomeKind != "" {
+		fields = append(fields, "welcome_kind", job.WelcomeKind, "welcome_id", job.WelcomeID)
+	}
+	if job.TeamID != "" {
+		fields = append(fields, "team_id", job.TeamID)
+	}
+	if job.UserID != "" {
+		fields = append(fields, "user_id", job.UserID)
+	}
+	return logger.With(fields...)
+}
+
+func runJob(ctx context.Context, cc apps.Context, store *Store, job Job) error {
+	jobLogger(job).Debug("running the job")
+	client := asBot(ctx, cc)
+	kind := job.Kind
+	var err error
+	switch kind {
+	case JobKindReact:
+		return reactJob(ctx, cc, client, job)
+	case JobKindDigest:
+		err = runDigest(client, store, job.ChannelID)
+	case JobKindFollowUp:
+		err = followUpJob(client, store, job)
+	case JobKindCleanup:
+		return cleanupJob(ctx, cc, client, store)
+	case JobKindBroadcast:
+		return broadcastJob(ctx, cc, client, store, job)
+	case JobKindPromotionCheck:
+		return promotionCheckJob(ctx, cc, client, store)
+	case JobKindMilestone:
+		err = milestoneJob(client, job)
+	case JobKindRetention:
+		return retentionJob(ctx, cc, store)
+	default:
+		kind = "post"
+		switch {
+		case job.Ephemeral:
+			err = postEphemeralJob(client, job)
+		case job.Thread:
+			err = postThreadJob(client, store, job)
+		default:
+			err = postJob(client, job)
+		}
+	}
+	if err == nil {
+		welcomesSent.WithLabelValues(kind).Inc()
+	}
+	if job.WelcomeKind != "" {
+		if err == nil {
+			countWelcome(store, job.WelcomeKind, job.WelcomeID, countSent)
+			eventWebhook.Send(sentEvent(job))
+		} else {
+			countWelcome(store, job.WelcomeKind, job.WelcomeID, countFailed)
+		}
+	}
+	return err
+}
+
+// postJob creates the post of a JobKindPost job.
+func postJob(poster Poster, job Job) error {
+	post := &model.Post{
+		ChannelId: job.ChannelID,
+		Message:   job.Message,
+	}
+	post.SetProps(job.Props)
+	_, err := createPost(poster, post)
+	return err
+}
+
+// postEphemeralJob creates the post of an Ephemeral JobKindPost job. It is
+// lost if the member isn't online to see it.
+func postEphemeralJob(client *appclient.Client, job Job) error {
+	post := &model.Post{
+		ChannelId: job.ChannelID,
+		Message:   job.Message,
+	}
+	post.SetProps(job.Props)
+	return createEphemeralPost(client, job.UserID, post)
+}
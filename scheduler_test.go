@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// schedulerContext returns the context of the calls queueing the jobs, whose
+// data useMemoryBackend keeps in memory.
+func schedulerContext(t *testing.T) apps.Context {
+	useMemoryBackend(t)
+	return apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			MattermostSiteURL: "https://mattermost.example.com",
+			BotUserID:         "bot",
+			BotAccessToken:    "bot-token",
+		},
+	}
+}
+
+func jobIDs(entries []JobEntry) string {
+	ids := ""
+	for _, entry := range entries {
+		ids += entry.ID
+	}
+	return ids
+}
+
+func TestPostJob(t *testing.T) {
+	poster := &MemoryPoster{}
+	job := Job{
+		ID:        model.NewId(),
+		ChannelID: "channel",
+		Message:   "Welcome!",
+		Props:     model.StringInterface{"from_bot": "true"},
+	}
+	if err := postJob(poster, job); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(poster.Posts) != 1 {
+		t.Fatalf("%d posts created, want 1", len(poster.Posts))
+	}
+	post := poster.Posts[0]
+	if post.ChannelId != job.ChannelID || post.Message != job.Message || post.GetProp("from_bot") != "true" {
+		t.Errorf("created %+v", post)
+	}
+	if post.PendingPostId == "" {
+		t.Error("the post has no pending post ID to deduplicate its retries")
+	}
+}
+
+func TestJobIndex(t *testing.T) {
+	store := NewMemoryStore()
+	entries := []JobEntry{{ID: "c", RunAt: 3}, {ID: "a", RunAt: 1}, {ID: "b", RunAt: 2}}
+	if err := store.SetJobIndex(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed, err := store.GetJobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(indexed); ids != "abc" {
+		t.Errorf("jobs indexed in the order %q, want abc", ids)
+	}
+}
+
+func TestSchedulerClaimAndFinish(t *testing.T) {
+	cc := schedulerContext(t)
+	s := NewScheduler()
+	now := model.GetMillis()
+	jobs := []Job{
+		{ID: "b", RunAt: now - 1000, ChannelID: "channel", Message: "second"},
+		{ID: "a", RunAt: now - 2000, ChannelID: "channel", Message: "first"},
+		{ID: "later", RunAt: now + 60*60*1000, ChannelID: "channel", Message: "later"},
+	}
+	if err := s.Enqueue(context.Background(), cc, jobs); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(context.Background(), cc)
+
+	due, err := s.claimDue(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(due); ids != "ab" {
+		t.Fatalf("claimed %q, want the due jobs ab", ids)
+	}
+	if due, _ = s.claimDue(store); len(due) != 0 {
+		t.Errorf("claimed %q again before their claims expired", jobIDs(due))
+	}
+
+	if err = s.finish(store, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := store.GetJob("a"); err != nil || job != nil {
+		t.Errorf("the finished job is still stored: %+v, %v", job, err)
+	}
+	if job, err := store.GetJob("b"); err != nil || job == nil || job.Message != "second" {
+		t.Errorf("the job claimed is %+v, %v, want it stored until it ran", job, err)
+	}
+
+	if err = s.release(store, []JobEntry{{ID: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ = s.claimDue(store); jobIDs(due) != "b" {
+		t.Errorf("claimed %q once released, want b", jobIDs(due))
+	}
+	indexed, err := store.GetJobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(indexed); ids != "blater" {
+		t.Errorf("the index holds %q, want blater", ids)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	cc := schedulerContext(t)
+	s := NewScheduler()
+	jobs := []Job{
+		{ID: "a1", RunAt: 1, UserID: "a", Message: "for a"},
+		{ID: "b1", RunAt: 2, UserID: "b", Message: "for b"},
+		{ID: "a2", RunAt: 3, UserID: "a", Message: "for a again"},
+	}
+	if err := s.Enqueue(context.Background(), cc, jobs); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.Cancel(context.Background(), cc, func(job Job) bool {
+		return job.UserID == "a"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(context.Background(), cc)
+	indexed, err := store.GetJobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(indexed); ids != "b1" {
+		t.Errorf("the index holds %q, want b1", ids)
+	}
+	for _, id := range []string{"a1", "a2"} {
+		if job, _ := store.GetJob(id); job != nil {
+			t.Errorf("the cancelled job %s is still stored", id)
+		}
+	}
+}
+
+func TestSchedulerMigratesLegacyJobs(t *testing.T) {
+	cc := schedulerContext(t)
+	store := NewStore(context.Background(), cc)
+	legacy := []Job{{ID: "a", RunAt: 1, Message: "queued before the upgrade"}}
+	if _, err := store.kv.KVSet(KVAppPrefix, jobsKey, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler()
+	if err := s.Enqueue(context.Background(), cc, []Job{{ID: "b", RunAt: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed, err := store.GetJobIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := jobIDs(indexed); ids != "ab" {
+		t.Errorf("the index holds %q, want ab", ids)
+	}
+	if job, err := store.GetJob("a"); err != nil || job == nil || job.Message != legacy[0].Message {
+		t.Errorf("the legacy job is %+v, %v", job, err)
+	}
+	var left []Job
+	if err = store.kv.KVGet(KVAppPrefix, jobsKey, &left); err != nil || left != nil {
+		t.Errorf("the legacy queue is left: %+v, %v", left, err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// SendForm sends the channel's or the team's welcome to a member again, e.g.
+// when they missed it, or joined before the welcome was set.
+var SendForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "user",
+			ModalLabel:           "User",
+			Description:          "The username of the member to welcome, e.g. @alice",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+		},
+		channelField,
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "team",
+			Label:       "team",
+			ModalLabel:  "Team welcome",
+			Description: "Send the team's welcome instead of the channel's",
+		},
+	},
+	Submit: apps.NewCall("/send").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// isGreeter reports whether the acting user is a greeter of the channel.
+func isGreeter(store *Store, cc apps.Context) bool {
+	if cc.ActingUser == nil || cc.Channel == nil {
+		return false
+	}
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil || welcome == nil {
+		return false
+	}
+	for _, id := range welcome.Greeters {
+		if id == cc.ActingUser.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// SendCall queues the welcome for the member as if they had just joined, but
+// regardless of when they were last welcomed and without notifying the
+// greeters again. Channel welcomes set to a digest are sent on their own. The
+// channel's welcome can be sent by its greeters too.
+func SendCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := requireValues(c, "user"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	username := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(c.GetValue("user", "")), "@"))
+	client := asBot(req.Context(), c.Context)
+	user, _, err := client.GetUserByUsername(username, "")
+	if err != nil {
+		httputils.WriteJSON(w,
+			errorResponse("user @%s was not found", username))
+		return
+	}
+	if user.IsBot {
+		httputils.WriteJSON(w,
+			errorResponse("@%s is a bot, bots are not welcomed", username))
+		return
+	}
+
+	if c.BoolValue("team") {
+		sendTeamWelcome(req.Context(), w, c, client, user)
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil && !isGreeter(store, cc) {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the welcome message"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("~%s has no welcome message, set one with `set_channel_welcome` first", cc.Channel.Name))
+		return
+	}
+	if _, _, err = client.GetChannelMember(cc.Channel.Id, user.Id, ""); err != nil {
+		httputils.WriteJSON(w,
+			errorResponse("@%s isn't a member of ~%s", username, cc.Channel.Name))
+		return
+	}
+
+	team := cc.Team
+	if team == nil || team.Id != cc.Channel.TeamId {
+		if team, _, err = client.GetTeam(cc.Channel.TeamId, ""); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+	if err = queueChannelWelcome(req.Context(), cc, client, store, cc.Channel, team, user, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't send the welcome message: %s", err))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Sent the welcome message of ~%s to @%s.", cc.Channel.Name, username))
+}
+
+// sendTeamWelcome queues the team's welcome and its campaign for the member.
+func sendTeamWelcome(ctx context.Context, w http.ResponseWriter, c apps.CallRequest, client *appclient.Client, user *model.User) {
+	team := c.Context.Team
+	if team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(ctx, c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(team.Id)
+	if err != nil {
+		logger.Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the welcome message"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+	if _, _, err = client.GetTeamMember(team.Id, user.Id, ""); err != nil {
+		httputils.WriteJSON(w,
+			errorResponse("@%s isn't a member of the team", user.Username))
+		return
+	}
+
+	if err = queueTeamWelcome(ctx, c.Context, client, store, team, user, *welcome); err != nil {
+		logger.Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't send the welcome message: %s", err))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Sent the team welcome message to @%s.", user.Username))
+}
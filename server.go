@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long the in-flight requests are given to complete
+// when the app is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// listen serves the requests with TLS if a certificate is configured.
+func listen(server *http.Server, config Config) error {
+	if config.TLS() {
+		return server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+	}
+	return server.ListenAndServe()
+}
+
+// serve runs the HTTP server, the debug server if it is configured, and the
+// scheduler until the process receives SIGINT or SIGTERM. The server then stops
+// accepting requests and drains the in-flight ones and the queued join events,
+// after which the scheduler runs the jobs that are due, e.g. the welcomes
+// queued by the last calls, before serve returns.
+func serve(handler http.Handler, config Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              config.ServerAddress,
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	schedulerDone := make(chan struct{})
+	go func() {
+		scheduler.Run(schedulerCtx)
+		close(schedulerDone)
+	}()
+
+	go func() {
+		if err := listen(server, config); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(err)
+		}
+	}()
+
+	var debugServer *http.Server
+	if config.DebugAddress != "" {
+		// No write timeout: CPU profiles and traces take as long as asked.
+		debugServer = &http.Server{
+			Addr:              config.DebugAddress,
+			Handler:           DebugHandler(),
+			ReadHeaderTimeout: config.ReadTimeout,
+		}
+		go func() {
+			if err := debugServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Fatal(err)
+			}
+		}()
+		logger.Infof("serving the pprof profiles on %s", config.DebugAddress)
+	}
+
+	<-ctx.Done()
+	logger.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error(err)
+	}
+	if debugServer != nil {
+		_ = debugServer.Close()
+	}
+	joinPool.Stop()
+
+	stopScheduler()
+	<-schedulerDone
+	stopTracing()
+	if errorReporter != nil {
+		errorReporter.Stop()
+	}
+	if backend != nil {
+		if err := backend.Close(); err != nil {
+			logger.Error(err)
+		}
+	}
+	logger.Info("stopped")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const serverWelcomeKey = "server_welcome"
+
+// UserCreated is the call Mattermost makes when an account is created on the
+// server, fully expanding the new user.
+var UserCreated = apps.NewCall("/event/user-created")
+
+// ServerWelcome is the welcome sent in a direct message to every new account
+// of the server, whichever teams and channels they join, e.g. a compliance
+// notice or a getting-started guide.
+type ServerWelcome struct {
+	Message string `json:"message"`
+}
+
+// SetServerWelcomeForm sets the server welcome.
+var SetServerWelcomeForm = apps.Form{
+	Title:  "Server welcome message",
+	Header: "Every new account of the server will get this message in a direct message.",
+	Icon:   "icon.png",
+	Fields: []apps.Field{
+		welcomeMessageField,
+		checkLinksField,
+		dryRunField,
+	},
+	Submit: apps.NewCall("/set_server_welcome").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// GetServerWelcome shows the server welcome.
+var GetServerWelcome = apps.NewCall("/get_server_welcome").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+})
+
+// DeleteServerWelcome deletes the server welcome.
+var DeleteServerWelcome = apps.NewCall("/delete_server_welcome").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+})
+
+// GetServerWelcome returns the server welcome, or nil if none was set.
+func (s *Store) GetServerWelcome() (*ServerWelcome, error) {
+	var welcome *ServerWelcome
+	if err := s.kv.KVGet(KVAppPrefix, serverWelcomeKey, &welcome); err != nil {
+		return nil, err
+	}
+	if welcome == nil || welcome.Message == "" {
+		return nil, nil
+	}
+	return welcome, nil
+}
+
+// SetServerWelcome stores the server welcome.
+func (s *Store) SetServerWelcome(welcome ServerWelcome) error {
+	_, err := s.kv.KVSet(KVAppPrefix, serverWelcomeKey, welcome)
+	return err
+}
+
+// DeleteServerWelcome removes the server welcome.
+func (s *Store) DeleteServerWelcome() error {
+	return s.kv.KVDelete(KVAppPrefix, serverWelcomeKey)
+}
+
+// SubscribeToUserCreated registers the app for user_created events, across the
+// server.
+func SubscribeToUserCreated(client *appclient.Client) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject: apps.SubjectUserCreated,
+		Call:    *UserCreated,
+	})
+}
+
+// UnsubscribeFromUserCreated stops the user_created events.
+func UnsubscribeFromUserCreated(client *appclient.Client) error {
+	return client.Unsubscribe(&apps.Subscription{
+		Subject: apps.SubjectUserCreated,
+		Call:    *UserCreated,
+	})
+}
+
+func SetServerWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err := requireValues(c, "message"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	message := c.GetValue("message", "")
+	if err := checkWelcomeLength(message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	welcome, err := store.GetServerWelcome()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the server welcome"))
+		return
+	}
+	previous := ""
+	if welcome != nil {
+		previous = welcome.Message
+	}
+	if c.BoolValue("dry_run") {
+		httputils.WriteJSON(w, dryRunSetResponse(c.Context, message, previous, saveWarnings(req.Context(), c, message)))
+		return
+	}
+
+	if err = store.SetServerWelcome(ServerWelcome{Message: message}); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the server welcome"))
+		return
+	}
+	if err = SubscribeToUserCreated(asBot(req.Context(), c.Context)); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("stored the server welcome, but couldn't subscribe to the new accounts: %s", err))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", lintResponse(c.Context, "Every new account of the server will get this message:\n"+quoted(message), saveWarnings(req.Context(), c, message))))
+}
+
+func GetServerWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := NewStore(req.Context(), c.Context).GetServerWelcome()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the server welcome"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("There is no server welcome, set one with `set_server_welcome`."))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Server welcome message is:\n%s", welcome.Message))
+}
+
+func DeleteServerWelcomeCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err := NewStore(req.Context(), c.Context).DeleteServerWelcome(); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't delete the server welcome"))
+		return
+	}
+	if err := UnsubscribeFromUserCreated(asBot(req.Context(), c.Context)); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Deleted the server welcome."))
+}
+
+// UserCreatedCall sends the server welcome to the new account in a direct
+// message. Bots and excluded users are not welcomed.
+func UserCreatedCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	user := c.Context.User
+	if user == nil || user.IsBot || user.Id == c.Context.BotUserID {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	welcome, err := store.GetServerWelcome()
+	if err != nil || welcome == nil || skipWelcome(store, user) {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	dm, _, err := client.CreateDirectChannel(c.Context.BotUserID, user.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	err = scheduler.Enqueue(req.Context(), c.Context, []Job{withOptOutButton(Job{
+		ID:        model.NewId(),
+		RunAt:     model.GetMillis(),
+		ChannelID: dm.Id,
+		Message:   RenderWelcome(welcome.Message, store.NewTemplateData(user, nil, nil)),
+	})})
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const settingsKey = "settings"
+
+// Settings are the app-wide options, stored in KV. They are seeded with
+// DefaultSettings when the app is installed.
+type Settings struct {
+	// ChannelJoinHint makes the bot post a short how-to when it is added to
+	// a channel that has no welcome message yet.
+	ChannelJoinHint bool `json:"channel_join_hint"`
+
+	// ChannelCreatedPrompt offers the creators of new channels to set their
+	// welcome message.
+	ChannelCreatedPrompt bool `json:"channel_created_prompt"`
+
+	// RequiredRole is the least privileged role allowed to set and delete
+	// welcome messages, one of ManagerRoles.
+	RequiredRole string `json:"required_role"`
+
+	// WelcomesPaused pauses all the welcomes, server-wide, without deleting
+	// them. The welcomes that become due while paused are dropped.
+	WelcomesPaused bool `json:"welcomes_paused"`
+
+	// ExcludedUsernames are the patterns, as matched by path.Match, of the
+	// usernames that are never welcomed, e.g. "svc-*". Bots are never
+	// welcomed either.
+	ExcludedUsernames []string `json:"excluded_usernames,omitempty"`
+
+	// RejoinWindowDays is how long after being welcomed in a channel members
+	// who leave and rejoin it are not welcomed again. Zero turns it off.
+	RejoinWindowDays int `json:"rejoin_window_days"`
+
+	// RetentionDays is how long after their last update the records kept by
+	// user ID, e.g. acknowledgments and survey responses, are kept. Zero
+	// keeps them forever.
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// DefaultSettings are used until an admin changes them.
+var DefaultSettings = Settings{
+	ChannelJoinHint:      true,
+	ChannelCreatedPrompt: true,
+	RequiredRole:         model.ChannelAdminRoleId,
+	RejoinWindowDays:     30,
+}
+
+// RejoinWindow returns RejoinWindowDays as a time.Duration.
+func (s Settings) RejoinWindow() time.Duration {
+	return time.Duration(s.RejoinWindowDays) * 24 * time.Hour
+}
+
+// Retention returns RetentionDays as a time.Duration.
+func (s Settings) Retention() time.Duration {
+	return time.Duration(s.RetentionDays) * 24 * time.Hour
+}
+
+// GetSettings returns the stored settings. Settings that were never stored,
+// e.g. options added in a newer version of the app, keep their default value.
+func (s *Store) GetSettings() (Settings, error) {
+	settings := DefaultSettings
+	if err := s.kv.KVGet(KVAppPrefix, settingsKey, &settings); err != nil {
+		return DefaultSettings, err
+	}
+	return settings, nil
+}
+
+// SetSettings stores the settings, and clears the bindings cached, which
+// depend on them.
+func (s *Store) SetSettings(settings Settings) error {
+	_, err := s.kv.KVSet(KVAppPrefix, settingsKey, settings)
+	bindingsCache.Clear()
+	return err
+}
+
+// SeedSettings stores DefaultSettings unless settings were already stored,
+// e.g. by a previous installation of the app.
+func (s *Store) SeedSettings() error {
+	var settings *Settings
+	if err := s.kv.KVGet(KVAppPrefix, settingsKey, &settings); err != nil {
+		return err
+	}
+	if settings != nil {
+		return nil
+	}
+	return s.SetSettings(DefaultSettings)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// TestForm sends the channel's or the team's welcome to the acting user as if
+// they had just joined, to check it as new members get it.
+var TestForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		channelField,
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "team",
+			Label:       "team",
+			ModalLabel:  "Team welcome",
+			Description: "Test the team's welcome instead of the channel's",
+		},
+	},
+	Submit: apps.NewCall("/test").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		Team:                  apps.ExpandSummary,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// deliveryNames describe the deliveries in the test report.
+var deliveryNames = map[string]string{
+	DeliveryChannel:   "in the channel",
+	DeliveryDM:        "as a direct message",
+	DeliveryEphemeral: "as an ephemeral post",
+	DeliveryThread:    "in the channel's welcome thread",
+}
+
+// untracked returns the jobs without their welcome, so a test isn't counted
+// in the welcome's statistics.
+func untracked(jobs []Job) []Job {
+	for i := range jobs {
+		jobs[i].WelcomeKind = ""
+		jobs[i].WelcomeID = ""
+	}
+	return jobs
+}
+
+// TestCall runs the join pipeline for the acting user: the welcome is
+// rendered for them, delayed and delivered as set, with its buttons. Unlike a
+// real join, the greeters aren't notified, the variant picked isn't recorded,
+// digests are skipped and the statistics are left as they are.
+func TestCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.ActingUser == nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(errNoActingUser))
+		return
+	}
+	if c.BoolValue("team") {
+		testTeamWelcome(req.Context(), w, c)
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the welcome message"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("~%s has no welcome message, set one with `set_channel_welcome` first", cc.Channel.Name))
+		return
+	}
+
+	test := *welcome
+	if len(test.Variants) > 0 && len(test.Messages) > 0 {
+		assignments, err := store.GetVariantAssignments(cc.Channel.Id)
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+		test = withVariant(test, *pickVariant(test, assignments))
+	} else {
+		test = rotateWelcome(test)
+	}
+
+	client := asBot(req.Context(), cc)
+	user := cc.ActingUser
+	jobs := welcomeJobs(client, cc.Channel.Id, user, test, store.NewTemplateData(user, cc.Channel, cc.Team))
+	if err = deliverJobs(client, cc.BotUserID, user.Id, test.Delivery, jobs); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err = scheduler.Enqueue(req.Context(), cc, untracked(jobs)); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	message := "Sending you the welcome of ~" + cc.Channel.Name + " " + deliveryNames[test.Delivery] + ", as new members get it."
+	if len(test.Messages) > 0 && test.Messages[0].Delay() > 0 {
+		message += " The first message is delayed by " + test.Messages[0].Delay().String() + "."
+	}
+	if welcome.Disabled {
+		message += " The welcome is paused, new members don't get it for now."
+	}
+	if welcome.DigestWindowSeconds > 0 {
+		message += " New members get it in a digest, this test sends it to you alone."
+	}
+	httputils.WriteJSON(w, apps.NewTextResponse("%s", message))
+}
+
+// testTeamWelcome sends the team's welcome to the acting user in a direct
+// message, with the onboarding, checklist, follow-ups and survey of the team.
+// Their checklist and drip campaign are started, as for a new member.
+func testTeamWelcome(ctx context.Context, w http.ResponseWriter, c apps.CallRequest) {
+	team := c.Context.Team
+	if team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(ctx, c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(team.Id)
+	if err != nil {
+		logger.Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the welcome message"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+
+	jobs, err := teamJoinJobs(c.Context, asBot(ctx, c.Context), store, team, c.Context.ActingUser, *welcome)
+	if err == nil {
+		err = scheduler.Enqueue(ctx, c.Context, untracked(jobs))
+	}
+	if err != nil {
+		logger.Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Sending you the team welcome in a direct message, as new members get it."))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const snippetsKey = "snippets"
+
+var (
+	snippetNameRegexp    = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+	snippetIncludeRegexp = regexp.MustCompile(`{{-?\s*include\s+"([^"]*)"`)
+)
+
+// SetSnippetForm sets a shared text that any welcome can include with
+// {{include "name"}}.
+var SetSnippetForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "name",
+			ModalLabel:           "Name",
+			Description:          "The name the welcomes include the snippet by, e.g. rules",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+		},
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			Name:                 "text",
+			ModalLabel:           "Text",
+			Description:          "Markdown and template variables like {{.UserName}} are supported",
+			IsRequired:           true,
+			AutocompletePosition: 2,
+		},
+	},
+	Submit: apps.NewCall("/snippet/set").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// DeleteSnippetForm deletes a snippet. The welcomes including it get an empty
+// text instead.
+var DeleteSnippetForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			Name:                 "name",
+			ModalLabel:           "Name",
+			IsRequired:           true,
+			AutocompletePosition: 1,
+		},
+	},
+	Submit: apps.NewCall("/snippet/delete").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// ListSnippets lists the snippets.
+var ListSnippets = apps.NewCall("/snippet/list")
+
+// SnippetBinding groups the commands managing the snippets, shared across all
+// the welcomes of the server.
+var SnippetBinding = apps.Binding{
+	Label:       "snippet", // Manages the texts shared by the welcome messages.
+	Description: "Manage the texts shared by the welcome messages",
+	Hint:        "[set|delete|list]",
+	Bindings: []apps.Binding{
+		{
+			Label: "set", // Sets a snippet.
+			Form:  &SetSnippetForm,
+		},
+		{
+			Label: "delete", // Deletes a snippet.
+			Form:  &DeleteSnippetForm,
+		},
+		{
+			Label:  "list", // Lists the snippets.
+			Submit: ListSnippets,
+		},
+	},
+}
+
+// GetSnippets returns the snippets by name.
+func (s *Store) GetSnippets() (map[string]string, error) {
+	snippets := map[string]string{}
+	if err := s.kv.KVGet(KVAppPrefix, snippetsKey, &snippets); err != nil {
+		return nil, err
+	}
+	if snippets == nil {
+		snippets = map[string]string{}
+	}
+	return snippets, nil
+}
+
+// SetSnippets stores the snippets.
+func (s *Store) SetSnippets(snippets map[string]string) error {
+	_, err := s.kv.KVSet(KVAppPrefix, snippetsKey, snippets)
+	return err
+}
+
+// NewTemplateData collects the template variables like NewTemplateData, and
+// the snippets the welcomes can include.
+func (s *Store) NewTemplateData(user *model.User, channel *model.Channel, team *model.Team) TemplateData {
+	data := NewTemplateData(user, channel, team)
+	snippets, err := s.GetSnippets()
+	if err != nil {
+		logger.Error(err)
+	}
+	data.snippets = snippets
+	return data
+}
+
+// snippetWarnings warns about the snippets the message includes that don't
+// exist.
+func snippetWarnings(store *Store, message string) []string {
+	matches := snippetIncludeRegexp.FindAllStringSubmatch(message, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	snippets, err := store.GetSnippets()
+	if err != nil {
+		logger.Error(err)
+		return nil
+	}
+	warnings := []string{}
+	for _, match := range matches {
+		if _, ok := snippets[match[1]]; !ok {
+			warnings = append(warnings, fmt.Sprintf("the snippet %q doesn't exist, it will be left out", match[1]))
+		}
+	}
+	return warnings
+}
+
+func SetSnippetCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if err := requireValues(c, "name", "text"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(c.GetValue("name", "")))
+	if !snippetNameRegexp.MatchString(name) {
+		httputils.WriteJSON(w,
+			errorResponse("the snippet name must be up to 64 lowercase letters, digits, dashes and underscores"))
+		return
+	}
+	text := c.GetValue("text", "")
+	if err := checkWelcomeLength(text); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if _, err := RenderTemplate(text, TemplateData{}); err != nil {
+		httputils.WriteJSON(w,
+			errorResponse("the snippet is not a valid template: %s", err))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	snippets, err := store.GetSnippets()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the snippet"))
+		return
+	}
+	snippets[name] = text
+	if err = store.SetSnippets(snippets); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the snippet"))
+		return
+	}
+
+	message := fmt.Sprintf("Stored the snippet %q, include it in a welcome with `{{include %q}}`.", name, name)
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", lintResponse(c.Context, message, LintWelcome(text))))
+}
+
+func DeleteSnippetCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(c.GetValue("name", "")))
+
+	store := NewStore(req.Context(), c.Context)
+	snippets, err := store.GetSnippets()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't delete the snippet"))
+		return
+	}
+	if _, ok := snippets[name]; !ok {
+		httputils.WriteJSON(w,
+			errorResponse("the snippet %q doesn't exist", name))
+		return
+	}
+	delete(snippets, name)
+	if err = store.SetSnippets(snippets); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't delete the snippet"))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Deleted the snippet %q, the welcomes including it will leave it out.", name))
+}
+
+func ListSnippetsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	snippets, err := NewStore(req.Context(), c.Context).GetSnippets()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't list the snippets"))
+		return
+	}
+	if len(snippets) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("There are no snippets, a system admin can add one with `snippet set`."))
+		return
+	}
+
+	names := []string{}
+	for name := range snippets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	message := "Include the snippets in a welcome with `{{include \"name\"}}`:\n\n" +
+		"| Name | Text |\n" +
+		"| --- | --- |\n"
+	for _, name := range names {
+		message += fmt.Sprintf("| %s | %s |\n", name, snippet(snippets[name]))
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
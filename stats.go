@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const statsKey = "stats"
+
+// WelcomeStats are the delivery counters of a welcome: the posts sent, the
+// posts that failed, and the members who joined but were not welcomed
+// because they are bots or excluded users, or the welcomes were paused.
+type WelcomeStats struct {
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// Stats are the delivery counters of the channel and team welcomes, by ID,
+// since they were last reset. The time is in milliseconds.
+type Stats struct {
+	Since    int64                   `json:"since"`
+	Channels map[string]WelcomeStats `json:"channels"`
+	Teams    map[string]WelcomeStats `json:"teams"`
+}
+
+// StatsForm shows the welcome delivery statistics, or resets them.
+var StatsForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:        apps.FieldTypeBool,
+			Name:        "reset",
+			Label:       "reset",
+			ModalLabel:  "Reset",
+			Description: "Reset the statistics after showing them",
+		},
+	},
+	Submit: apps.NewCall("/stats").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	}),
+}
+
+// GetStats returns the stored delivery statistics.
+func (s *Store) GetStats() (Stats, error) {
+	stats := Stats{}
+	if err := s.kv.KVGet(KVAppPrefix, statsKey, &stats); err != nil {
+		return Stats{}, err
+	}
+	if stats.Channels == nil {
+		stats.Channels = map[string]WelcomeStats{}
+	}
+	if stats.Teams == nil {
+		stats.Teams = map[string]WelcomeStats{}
+	}
+	return stats, nil
+}
+
+// UpdateStats applies update to the counters of the welcome of the kind,
+// IndexKindChannel or IndexKindTeam, and stores them.
+func (s *Store) UpdateStats(kind, id string, update func(*WelcomeStats)) error {
+	stats, err := s.GetStats()
+	if err != nil {
+		return err
+	}
+	if stats.Since == 0 {
+		stats.Since = model.GetMillis()
+	}
+
+	counters := stats.Channels
+	if kind == IndexKindTeam {
+		counters = stats.Teams
+	}
+	welcomeStats := counters[id]
+	update(&welcomeStats)
+	counters[id] = welcomeStats
+
+	_, err = s.kv.KVSet(KVAppPrefix, statsKey, stats)
+	return err
+}
+
+// DeleteStats removes the counters of the welcome of the kind,
+// IndexKindChannel or IndexKindTeam.
+func (s *Store) DeleteStats(kind, id string) error {
+	stats, err := s.GetStats()
+	if err != nil {
+		return err
+	}
+
+	counters := stats.Channels
+	if kind == IndexKindTeam {
+		counters = stats.Teams
+	}
+	if _, ok := counters[id]; !ok {
+		return nil
+	}
+	delete(counters, id)
+
+	_, err = s.kv.KVSet(KVAppPrefix, statsKey, stats)
+	return err
+}
+
+// ResetStats removes the delivery statistics.
+func (s *Store) ResetStats() error {
+	return s.kv.KVDelete(KVAppPrefix, statsKey)
+}
+
+// countWelcome updates the counters of the welcome, logging the errors as the
+// statistics are best effort.
+func countWelcome(store *Store, kind, id string, update func(*WelcomeStats)) {
+	if err := store.UpdateStats(kind, id, update); err != nil {
+		logger.Error(err)
+	}
+}
+
+func countSent(stats *WelcomeStats)    { stats.Sent++ }
+func countFailed(stats *WelcomeStats)  { stats.Failed++ }
+func countSkipped(stats *WelcomeStats) { stats.Skipped++ }
+
+// statsTable renders the counters as the rows of a markdown table, sorted by
+// name.
+func statsTable(counters map[string]WelcomeStats, name func(id string) string) string {
+	names := map[string]string{}
+	ids := []string{}
+	for id := range counters {
+		names[id] = name(id)
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return names[ids[i]] < names[ids[j]]
+	})
+
+	table := ""
+	for _, id := range ids {
+		s := counters[id]
+		table += fmt.Sprintf("| %s | %d | %d | %d |\n", names[id], s.Sent, s.Failed, s.Skipped)
+	}
+	return table
+}
+
+// StatsCall shows how many welcomes were sent, failed and skipped in each
+// channel and team, and resets the counters if asked to.
+func StatsCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if err := CheckSystemAdmin(c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	stats, err := store.GetStats()
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the statistics"))
+		return
+	}
+
+	var message string
+	if len(stats.Channels) == 0 && len(stats.Teams) == 0 {
+		message = "No welcome was sent yet."
+	} else {
+		client := asBot(req.Context(), c.Context)
+		message = fmt.Sprintf("#### Welcome statistics since %s\n\n", formatMillis(stats.Since))
+		message += "| Welcome | Sent | Failed | Skipped |\n|:--|--:|--:|--:|\n"
+		message += statsTable(stats.Channels, func(id string) string {
+			channel, _, err := client.GetChannel(id, "")
+			if err != nil {
+				return id
+			}
+			return "~" + channel.Name
+		})
+		message += statsTable(stats.Teams, func(id string) string {
+			team, _, err := client.GetTeam(id, "")
+			if err != nil {
+				return id
+			}
+			return team.DisplayName + " (team)"
+		})
+	}
+
+	if c.BoolValue("reset") {
+		if err = store.ResetStats(); err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w,
+				errorResponse("we couldn't reset the statistics"))
+			return
+		}
+		message += "\nThe statistics were reset."
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// The storages the app can keep its data in.
+const (
+	StorageMattermost = "mattermost"
+	StorageRedis      = "redis"
+	StoragePostgres   = "postgres"
+	StorageMySQL      = "mysql"
+)
+
+// storageTimeout is how long a storage backend has to complete an operation.
+const storageTimeout = 10 * time.Second
+
+// Backend is a storage the Store keeps the app's data in instead of the
+// Mattermost KV store, for large deployments whose tracking data, e.g. the
+// acknowledgements and stats of each member, outgrows it. The data of each
+// Mattermost server the app is installed on is kept apart, as it is in their
+// KV stores.
+type Backend interface {
+	// KV returns the KV store of the Mattermost server.
+	KV(siteURL string) KVStore
+
+	// Locker returns the locker of the Mattermost server, which grants the
+	// leases atomically.
+	Locker(siteURL string) Locker
+
+	// Ping checks the backend can be reached.
+	Ping() error
+
+	// Close releases the connections to the backend.
+	Close() error
+}
+
+// backend is the storage backend configured, nil if the data is kept in the
+// Mattermost KV store.
+var backend Backend
+
+// OpenBackend connects to the storage at the URL, e.g.
+// redis://localhost:6379/0 or postgres://welcomebot@localhost/welcomebot.
+func OpenBackend(storage, url string) (Backend, error) {
+	switch storage {
+	case StorageRedis:
+		return NewRedisBackend(url)
+	case StoragePostgres, StorageMySQL:
+		return NewSQLBackend(storage, url)
+	}
+	return nil, fmt.Errorf("unknown storage %q", storage)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+)
+
+// legacyWelcomeKey is the single, global key early versions of the app stored
+// the welcome message under, shared by every channel.
+const legacyWelcomeKey = "welcome_message"
+
+// Store keeps the app's data in the Mattermost KV store, under KVAppPrefix, or
+// in the storage backend configured. Large values are split into chunks by
+// chunkedKV, and the welcome configurations are cached by cachedKV.
+// Keys are always written with the bot's credentials, so every call sees the
+// same data regardless of the acting user. The client is used for the few
+// lookups the store needs besides the KV store, e.g. to rebuild the index.
+// The changes of the channel welcomes are recorded in their history as made
+// by the acting user of the call, if any.
+type Store struct {
+	kv           KVStore
+	locker       Locker
+	client       *appclient.Client
+	actingUserID string
+}
+
+// NewStore returns a Store using the bot access token from the call context,
+// keeping the data of its Mattermost server in the storage backend if one is
+// configured. Its operations are part of the trace of ctx.
+func NewStore(ctx context.Context, cc apps.Context) *Store {
+	client := asBot(ctx, cc)
+	var kv KVStore = retryKV{client}
+	if backend != nil {
+		kv = backend.KV(cc.MattermostSiteURL)
+	}
+	store := &Store{
+		kv:     tracingKV{KVStore: chunkedKV{metricsKV{kv}}, ctx: ctx},
+		client: client,
+	}
+	if welcomeCache != nil && cc.MattermostSiteURL != "" {
+		store.kv = cachedKV{KVStore: store.kv, site: cc.MattermostSiteURL}
+	}
+	store.locker = kvLocker{store.kv}
+	if backend != nil {
+		store.locker = backend.Locker(cc.MattermostSiteURL)
+	}
+	if cc.ActingUser != nil {
+		store.actingUserID = cc.ActingUser.Id
+	}
+	return store
+}
+
+// NewMemoryStore returns a Store keeping its data in memory, without a
+// Mattermost client: the methods looking up channels, teams or subscriptions
+// can't be used.
+func NewMemoryStore() *Store {
+	kv := chunkedKV{NewMemoryKV()}
+	return &Store{
+		kv:     kv,
+		locker: kvLocker{kv},
+	}
+}
+
+func channelWelcomeKey(channelID string) string {
+	return "channel_welcome_" + channelID
+}
+
+// GetChannelWelcome returns the welcome configuration of the channel, or nil
+// if none was set.
+func (s *Store) GetChannelWelcome(channelID string) (*ChannelWelcome, error) {
+	var data json.RawMessage
+	if err := s.kv.KVGet(KVAppPrefix, channelWelcomeKey(channelID), &data); err != nil {
+		return nil, err
+	}
+	return decodeChannelWelcome(data)
+}
+
+// SetChannelWelcome stores the welcome configuration of the channel, with its
+// version incremented, and records the change in its history.
+func (s *Store) SetChannelWelcome(channelID string, welcome ChannelWelcome) error {
+	welcome.Version++
+	if _, err := s.kv.KVSet(KVAppPrefix, channelWelcomeKey(channelID), welcome); err != nil {
+		return err
+	}
+	if err := s.addRevision(channelID, &welcome); err != nil {
+		logger.Error(err)
+	}
+	return nil
+}
+
+// DeleteChannelWelcome removes the welcome message for the channel, and
+// records the change in its history.
+func (s *Store) DeleteChannelWelcome(channelID string) error {
+	if err := s.kv.KVDelete(KVAppPrefix, channelWelcomeKey(channelID)); err != nil {
+		return err
+	}
+	if err := s.addRevision(channelID, nil); err != nil {
+		logger.Error(err)
+	}
+	return nil
+}
+
+func teamWelcomeKey(teamID string) string {
+	return "team_welcome_" + teamID
+}
+
+// GetTeamWelcome returns the welcome configuration of the team, or nil if
+// none was set.
+func (s *Store) GetTeamWelcome(teamID string) (*TeamWelcome, error) {
+	var data json.RawMessage
+	if err := s.kv.KVGet(KVAppPrefix, teamWelcomeKey(teamID), &data); err != nil {
+		return nil, err
+	}
+	return decodeTeamWelcome(data)
+}
+
+// SetTeamWelcome stores the welcome configuration of the team, and records
+// the change in its history.
+func (s *Store) SetTeamWelcome(teamID string, welcome TeamWelcome) error {
+	if _, err := s.kv.KVSet(KVAppPrefix, teamWelcomeKey(teamID), welcome); err != nil {
+		return err
+	}
+	if err := s.addTeamRevision(teamID, &welcome); err != nil {
+		logger.Error(err)
+	}
+	return nil
+}
+
+// DeleteTeamWelcome removes the welcome message for the team, and records the
+// change in its history.
+func (s *Store) DeleteTeamWelcome(teamID string) error {
+	if err := s.kv.KVDelete(KVAppPrefix, teamWelcomeKey(teamID)); err != nil {
+		return err
+	}
+	if err := s.addTeamRevision(teamID, nil); err != nil {
+		logger.Error(err)
+	}
+	return nil
+}
+
+// MigrateLegacyWelcome moves the message stored under the legacy global key to
+// every channel the app is subscribed to that doesn't have its own message
+// yet, then removes the legacy key. It is a no-op once migrated.
+func (s *Store) MigrateLegacyWelcome() error {
+	var message string
+	err := s.kv.KVGet(KVAppPrefix, legacyWelcomeKey, &message)
+	if err != nil || message == "" {
+		return err
+	}
+
+	channelIDs, err := s.subscribedChannelIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, channelID := range channelIDs {
+		existing, err := s.GetChannelWelcome(channelID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		welcome := ChannelWelcome{Messages: []WelcomeMessage{{Message: message}}}
+		if err = s.SetChannelWelcome(channelID, welcome); err != nil {
+			return err
+		}
+	}
+
+	return s.kv.KVDelete(KVAppPrefix, legacyWelcomeKey)
+}
+
+// DeleteAll removes every key the app stored under KVAppPrefix. Keys are
+// found through the welcome index, as the KV store can't be enumerated.
+func (s *Store) DeleteAll() error {
+	index, err := s.GetIndex()
+	if err != nil {
+		return err
+	}
+
+	jobs, err := s.GetJobIndex()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{legacyWelcomeKey, settingsKey, welcomeIndexKey, jobsKey, jobIndexKey, statsKey, snippetsKey, serverWelcomeKey, guestsKey, optOutsKey, deadLettersKey}
+	for _, entry := range jobs {
+		keys = append(keys, jobKey(entry.ID))
+	}
+	for _, entry := range index {
+		switch entry.Kind {
+		case IndexKindChannel:
+			keys = append(keys, channelKeys(entry.ID)...)
+		case IndexKindTeam:
+			keys = append(keys, teamKeys(entry.ID)...)
+		case IndexKindChannelFarewell:
+			keys = append(keys, channelFarewellKey(entry.ID))
+		case IndexKindTeamFarewell:
+			keys = append(keys, teamFarewellKey(entry.ID))
+		case IndexKindChannelDefault:
+			keys = append(keys, channelDefaultKey(entry.ID))
+		}
+	}
+
+	for _, key := range keys {
+		if err = s.kv.KVDelete(KVAppPrefix, key); err != nil {
+			return err
+		}
+	}
+	bindingsCache.Clear()
+	return nil
+}
+
+// channelKeys are the keys of the data stored for the welcome of a channel.
+func channelKeys(channelID string) []string {
+	return []string{channelWelcomeKey(channelID), recommendedJoinsKey(channelID),
+		acknowledgmentsKey(channelID), digestKey(channelID), welcomedKey(channelID),
+		welcomeThreadKey(channelID), variantsKey(channelID), historyKey(channelID)}
+}
+
+// teamKeys are the keys of the data stored for the welcome of a team.
+func teamKeys(teamID string) []string {
+	return []string{teamWelcomeKey(teamID), onboardingKey(teamID),
+		checklistKey(teamID), campaignKey(teamID), surveyKey(teamID),
+		teamHistoryKey(teamID)}
+}
+
+// subscribedChannelIDs lists the channels the app receives join events for,
+// which are the channels a welcome message was configured in.
+func (s *Store) subscribedChannelIDs() ([]string, error) {
+	subs, err := s.client.GetSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	channelIDs := []string{}
+	for _, sub := range subs {
+		if sub.Subject == apps.SubjectUserJoinedChannel && sub.ChannelID != "" {
+			channelIDs = append(channelIDs, sub.ChannelID)
+		}
+	}
+	return channelIDs, nil
+}
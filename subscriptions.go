@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// UserJoinedChannel is the call Mattermost makes when a user joins a channel
+// the app is subscribed to. The joining user and the channel are expanded so
+// the handler knows who to welcome and where; the team is expanded for the
+// message template. The acting user is who added them, or themselves if they
+// joined on their own.
+var UserJoinedChannel = apps.NewCall("/event/user-joined-channel").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+	User:       apps.ExpandSummary,
+	Channel:    apps.ExpandSummary,
+	Team:       apps.ExpandSummary,
+})
+
+// UserJoinedTeam is the call Mattermost makes when a user joins a team the app
+// is subscribed to.
+var UserJoinedTeam = apps.NewCall("/event/user-joined-team").WithExpand(apps.Expand{
+	ActingUser: apps.ExpandSummary,
+	User:       apps.ExpandSummary,
+	Team:       apps.ExpandSummary,
+})
+
+// UserLeftChannel is the call Mattermost makes when a user leaves a channel the
+// app is subscribed to, to send the channel's farewell.
+var UserLeftChannel = apps.NewCall("/event/user-left-channel").WithExpand(apps.Expand{
+	User:    apps.ExpandSummary,
+	Channel: apps.ExpandSummary,
+	Team:    apps.ExpandSummary,
+})
+
+// UserLeftTeam is the call Mattermost makes when a user leaves a team the app
+// is subscribed to.
+var UserLeftTeam = apps.NewCall("/event/user-left-team").WithExpand(apps.Expand{
+	User: apps.ExpandSummary,
+	Team: apps.ExpandSummary,
+})
+
+// BotJoinedChannel is the call Mattermost makes when the app's bot is added to
+// a channel.
+var BotJoinedChannel = apps.NewCall("/event/bot-joined-channel").WithExpand(apps.Expand{
+	Channel: apps.ExpandSummary,
+})
+
+// SubscribeToBotJoinedChannel registers the app for bot_joined_channel events,
+// across all channels.
+func SubscribeToBotJoinedChannel(client *appclient.Client) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject: apps.SubjectBotJoinedChannel,
+		Call:    *BotJoinedChannel,
+	})
+}
+
+// SubscribeToChannel registers the app for user_joined_channel events in the
+// given channel. Subscribing again to the same channel replaces the existing
+// subscription, so it is safe to call every time a welcome is stored.
+func SubscribeToChannel(client *appclient.Client, channelID string) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject:   apps.SubjectUserJoinedChannel,
+		ChannelID: channelID,
+		Call:      *UserJoinedChannel,
+	})
+}
+
+// UnsubscribeFromChannel stops the user_joined_channel events for the channel.
+func UnsubscribeFromChannel(client *appclient.Client, channelID string) error {
+	return client.Unsubscribe(&apps.Subscription{
+		Subject:   apps.SubjectUserJoinedChannel,
+		ChannelID: channelID,
+		Call:      *UserJoinedChannel,
+	})
+}
+
+// SubscribeToTeam registers the app for user_joined_team events in the given
+// team.
+func SubscribeToTeam(client *appclient.Client, teamID string) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject: apps.SubjectUserJoinedTeam,
+		TeamID:  teamID,
+		Call:    *UserJoinedTeam,
+	})
+}
+
+// UnsubscribeFromTeam stops the user_joined_team events for the team.
+func UnsubscribeFromTeam(client *appclient.Client, teamID string) error {
+	return client.Unsubscribe(&apps.Subscription{
+		Subject: apps.SubjectUserJoinedTeam,
+		TeamID:  teamID,
+		Call:    *UserJoinedTeam,
+	})
+}
+
+// SubscribeToChannelLeaves registers the app for user_left_channel events in
+// the given channel.
+func SubscribeToChannelLeaves(client *appclient.Client, channelID string) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject:   apps.SubjectUserLeftChannel,
+		ChannelID: channelID,
+		Call:      *UserLeftChannel,
+	})
+}
+
+// UnsubscribeFromChannelLeaves stops the user_left_channel events for the
+// channel.
+func UnsubscribeFromChannelLeaves(client *appclient.Client, channelID string) error {
+	return client.Unsubscribe(&apps.Subscription{
+		Subject:   apps.SubjectUserLeftChannel,
+		ChannelID: channelID,
+		Call:      *UserLeftChannel,
+	})
+}
+
+// SubscribeToTeamLeaves registers the app for user_left_team events in the
+// given team.
+func SubscribeToTeamLeaves(client *appclient.Client, teamID string) error {
+	return client.Subscribe(&apps.Subscription{
+		Subject: apps.SubjectUserLeftTeam,
+		TeamID:  teamID,
+		Call:    *UserLeftTeam,
+	})
+}
+
+// UnsubscribeFromTeamLeaves stops the user_left_team events for the team.
+func UnsubscribeFromTeamLeaves(client *appclient.Client, teamID string) error {
+	return client.Unsubscribe(&apps.Subscription{
+		Subject: apps.SubjectUserLeftTeam,
+		TeamID:  teamID,
+		Call:    *UserLeftTeam,
+	})
+}
+
+// UserJoinedChannelCall looks up the welcome messages stored for the channel,
+// renders them for the joining user and posts them as the bot. In digest mode
+// the user is added to the channel's pending digest instead.
+func UserJoinedChannelCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	user := c.Context.User
+	channel := c.Context.Channel
+	if user == nil || channel == nil || user.Id == c.Context.BotUserID {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := store.MigrateLegacyWelcome(); err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	welcome, _, err := store.GetEffectiveChannelWelcome(channel)
+	if err != nil || welcome == nil || welcome.Disabled {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+	if skipWelcome(store, user) {
+		countWelcome(store, IndexKindChannel, channel.Id, countSkipped)
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+	if welcomedRecently(store, channel.Id, user.Id) {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	client := asBot(req.Context(), c.Context)
+	if len(welcome.Greeters) > 0 {
+		notifyGreeters(client, c.Context.BotUserID, channel, user, welcome.Greeters)
+	}
+
+	if welcome.DigestWindowSeconds > 0 {
+		if err = addToDigest(req.Context(), c.Context, *welcome); err != nil {
+			requestLogger(req).Error(err)
+			httputils.WriteJSON(w, apps.NewErrorResponse(err))
+			return
+		}
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	if err = queueChannelWelcome(req.Context(), c.Context, client, store, channel, c.Context.Team, user, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
+
+// addedBy returns who added the member of a join event to the channel or the
+// team, or nil if they joined on their own. Members the bot added, e.g. to a
+// recommended channel they picked, joined on their own.
+func addedBy(cc apps.Context) *model.User {
+	if cc.User == nil || cc.ActingUser == nil || cc.ActingUser.Id == cc.User.Id || cc.ActingUser.Id == cc.BotUserID {
+		return nil
+	}
+	return cc.ActingUser
+}
+
+// queueChannelWelcome queues the welcome of the channel for the member, in the
+// variant or the text picked for them, posted as set by the welcome's
+// delivery.
+func queueChannelWelcome(ctx context.Context, cc apps.Context, client *appclient.Client, store *Store, channel *model.Channel, team *model.Team, user *model.User, welcome ChannelWelcome) error {
+	if welcome.Acknowledgment != "" {
+		if err := store.AddPendingAcknowledgment(channel.Id, user.Id); err != nil {
+			logger.Error(err)
+		}
+	}
+
+	if len(welcome.Variants) > 0 {
+		welcome = applyVariant(store, channel.Id, user.Id, welcome)
+	} else {
+		welcome = rotateWelcome(welcome)
+	}
+
+	// The messages are queued rather than posted right away, so delayed
+	// messages are still sent if the app restarts in the meantime.
+	data := store.NewTemplateData(user, channel, team)
+	if by := addedBy(cc); by != nil {
+		data.AddedBy = by.Username
+	}
+	jobs := welcomeJobs(client, channel.Id, user, welcome, data)
+	if err := deliverJobs(client, cc.BotUserID, user.Id, welcome.Delivery, jobs); err != nil {
+		return err
+	}
+	return scheduler.Enqueue(ctx, cc, jobs)
+}
+
+// welcomeJobs renders the welcome messages, in the variant matching the new
+// member's locale, or the guest variant for guests, or the variant for added
+// members if data.AddedBy is set, and returns the jobs posting them to the
+// channel, each after its delay from the previous message. The buttons to join
+// the recommended channels, to open the links and to acknowledge the welcome
+// are added to the last message. The first message mentions the member if the
+// welcome is set to, and the messages with an attachment show it rendered for
+// the member.
+func welcomeJobs(client *appclient.Client, channelID string, user *model.User, welcome ChannelWelcome, data TemplateData) []Job {
+	jobs := []Job{}
+	runAt := model.GetMillis()
+	for i, m := range welcome.Messages {
+		runAt += m.Delay().Milliseconds()
+
+		job := Job{
+			ID:          model.NewId(),
+			RunAt:       runAt,
+			ChannelID:   channelID,
+			Message:     RenderWelcome(m.MessageForMember(user, data.AddedBy != ""), data),
+			UserID:      user.Id,
+			WelcomeKind: IndexKindChannel,
+			WelcomeID:   channelID,
+		}
+		if i == 0 && welcome.MentionMember {
+			job.Message = withMention(job.Message, user)
+		}
+		props := model.StringInterface{}
+		if m.Attachment != nil {
+			props["attachments"] = []*model.SlackAttachment{m.Attachment.Render(data)}
+		}
+		if i == len(welcome.Messages)-1 {
+			bindings := []apps.Binding{}
+			if binding := recommendedChannelsBinding(client, welcome.RecommendedChannels); binding != nil {
+				bindings = append(bindings, *binding)
+			}
+			if binding := linksBinding(welcome.Links); binding != nil {
+				bindings = append(bindings, *binding)
+			}
+			if welcome.Acknowledgment != "" {
+				bindings = append(bindings, acknowledgmentBinding(welcome.Acknowledgment, user.Id, channelID))
+			}
+			if len(bindings) > 0 {
+				props[apps.PropAppBindings] = bindings
+			}
+		}
+		if len(props) > 0 {
+			job.Props = props
+		}
+		jobs = append(jobs, job)
+	}
+	if welcome.ReactToFirstPost {
+		jobs = append(jobs, newReactJob(channelID, user.Id))
+	}
+	return jobs
+}
+
+// UserJoinedTeamCall looks up the welcome message stored for the team, renders
+// it and queues it to be sent to the new member as a direct message from the
+// bot, after the welcome's delay, followed by the invitation to the onboarding,
+// the checklist, the follow-ups of the drip campaign, the milestones and the
+// survey if the team has them.
+func UserJoinedTeamCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	user := c.Context.User
+	team := c.Context.Team
+	if user == nil || team == nil || user.Id == c.Context.BotUserID {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	welcome, err := store.GetTeamWelcome(team.Id)
+	if err != nil || welcome == nil {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+	if skipWelcome(store, user) {
+		countWelcome(store, IndexKindTeam, team.Id, countSkipped)
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+	if user.IsGuest() && welcome.PromotionMessage != "" {
+		if err = store.TrackGuest(team.Id, user.Id); err != nil {
+			requestLogger(req).Error(err)
+		}
+	}
+
+	if err = queueTeamWelcome(req.Context(), c.Context, asBot(req.Context(), c.Context), store, team, user, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
+
+// queueTeamWelcome queues the welcome of the team for the member, in a direct
+// message with the bot, with the onboarding, checklist, follow-ups and survey
+// of the team.
+func queueTeamWelcome(ctx context.Context, cc apps.Context, client *appclient.Client, store *Store, team *model.Team, user *model.User, welcome TeamWelcome) error {
+	jobs, err := teamJoinJobs(cc, client, store, team, user, welcome)
+	if err != nil {
+		return err
+	}
+	return scheduler.Enqueue(ctx, cc, jobs)
+}
+
+// teamJoinJobs returns the jobs of the team's welcome for the member, and
+// starts their checklist and drip campaign.
+func teamJoinJobs(cc apps.Context, client *appclient.Client, store *Store, team *model.Team, user *model.User, welcome TeamWelcome) ([]Job, error) {
+	dm, _, err := client.CreateDirectChannel(cc.BotUserID, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := store.NewTemplateData(user, nil, team)
+	if by := addedBy(cc); by != nil {
+		data.AddedBy = by.Username
+	}
+	return teamWelcomeJobs(client, store, dm.Id, team, user, welcome, data), nil
+}
+
+// teamWelcomeJobs returns the jobs of the team's welcome for the member in
+// their direct channel with the bot, rendered with the data, and starts their
+// checklist.
+func teamWelcomeJobs(client *appclient.Client, store *Store, dmID string, team *model.Team, user *model.User, welcome TeamWelcome, data TemplateData) []Job {
+	jobs := []Job{teamWelcomeJob(client, dmID, team.Id, user, welcome, data)}
+	if welcome.Onboarding {
+		jobs = append(jobs, onboardingJob(dmID, team.Id, jobs[0].RunAt+1))
+	}
+	if len(welcome.FollowUps) > 0 {
+		jobs = append(jobs, followUpJobs(store, dmID, team.Id, user, welcome.FollowUps, data)...)
+	}
+	if len(welcome.Milestones) > 0 {
+		jobs = append(jobs, milestoneJobs(dmID, team.Id, user, welcome.Milestones, data)...)
+	}
+	if welcome.Survey != nil {
+		jobs = append(jobs, surveyJob(dmID, team.Id, welcome.Survey))
+	}
+	if len(welcome.Checklist) > 0 {
+		if err := store.StartChecklist(team.Id, user.Id); err != nil {
+			logger.Error(err)
+		}
+		jobs = append(jobs, checklistJob(dmID, team.Id, user.Id, welcome.Checklist, jobs[0].RunAt+2))
+	}
+	return jobs
+}
+
+// teamWelcomeJob renders the team welcome and returns the job posting it to
+// the direct channel with the new member after the welcome's delay, with the
+// buttons to join the recommended channels, the interest picker and the
+// opt-out button.
+func teamWelcomeJob(client *appclient.Client, channelID, teamID string, user *model.User, welcome TeamWelcome, data TemplateData) Job {
+	job := Job{
+		ID:          model.NewId(),
+		RunAt:       model.GetMillis() + welcome.Delay().Milliseconds(),
+		ChannelID:   channelID,
+		Message:     RenderWelcome(welcome.MessageForMember(user, data.AddedBy != ""), data),
+		UserID:      user.Id,
+		WelcomeKind: IndexKindTeam,
+		WelcomeID:   teamID,
+	}
+	bindings := []apps.Binding{}
+	if binding := recommendedChannelsBinding(client, welcome.RecommendedChannels); binding != nil {
+		bindings = append(bindings, *binding)
+	}
+	if binding := interestsBinding(teamID, welcome.Interests); binding != nil {
+		bindings = append(bindings, *binding)
+	}
+	bindings = append(bindings, optOutBinding())
+	job.Props = model.StringInterface{apps.PropAppBindings: bindings}
+	return job
+}
+
+// BotJoinedChannelCall posts a short how-to when the bot is added to a channel
+// that has no welcome message yet, unless disabled in the settings or the
+// channel gets the team's default channel welcome.
+func BotJoinedChannelCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	channel := c.Context.Channel
+	if channel == nil || channel.IsGroupOrDirect() {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	settings, err := store.GetSettings()
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	welcome, err := store.GetChannelWelcome(channel.Id)
+	if err != nil || welcome != nil || !settings.ChannelJoinHint || hasChannelDefault(store, channel) {
+		httputils.WriteJSON(w, apps.NewTextResponse(""))
+		return
+	}
+
+	_, err = createPost(asBot(req.Context(), c.Context), &model.Post{
+		ChannelId: channel.Id,
+		Message:   channelJoinHint,
+	})
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewTextResponse(""))
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// defaultSurveyQuestion is asked by the survey when the team doesn't set
+// its own question.
+const defaultSurveyQuestion = "How was your first week with the team?"
+
+// maxSurveyComments caps the number of comments shown by the survey report.
+const maxSurveyComments = 20
+
+// Survey is the survey sent to new members of a team: the bot invites them to
+// rate their start and leave a comment, some time after they joined.
+type Survey struct {
+	Question     string `json:"question,omitempty"`
+	DelaySeconds int    `json:"delay_seconds"`
+}
+
+// SurveyResponse is the answer of a member to the survey of a team. The time
+// is in milliseconds.
+type SurveyResponse struct {
+	Rating      int    `json:"rating"`
+	Comment     string `json:"comment,omitempty"`
+	SubmittedAt int64  `json:"submitted_at"`
+}
+
+// The survey calls. The state of TakeSurvey and SubmitSurvey is the ID of
+// the team surveyed, as they are made from the direct channel with the bot.
+var (
+	TakeSurvey = apps.NewCall("/survey/take").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	})
+	SubmitSurvey = apps.NewCall("/survey/submit").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+	})
+	SurveyDisable = apps.NewCall("/survey/disable").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandSummary,
+		TeamMember: apps.ExpandAll,
+	})
+	SurveyReport = apps.NewCall("/survey/report").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandSummary,
+		TeamMember: apps.ExpandAll,
+	})
+)
+
+// SurveyEnableForm sends the survey to new members of the team.
+var SurveyEnableForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "delay",
+			Label:       "delay",
+			ModalLabel:  "Delay",
+			Description: "How long after joining the team the survey is sent, e.g. 7d",
+			IsRequired:  true,
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "question",
+			Label:       "question",
+			ModalLabel:  "Question",
+			Description: "The question new members rate, \"" + defaultSurveyQuestion + "\" by default",
+		},
+	},
+	Submit: apps.NewCall("/survey/enable").WithExpand(apps.Expand{
+		ActingUser: apps.ExpandSummary,
+		Team:       apps.ExpandSummary,
+		TeamMember: apps.ExpandAll,
+	}),
+}
+
+// SurveyBinding groups the survey commands.
+var SurveyBinding = apps.Binding{
+	Label:       "survey", // Asks new members of the team how their start went.
+	Description: "Ask new members of the team to rate their start, and see the results",
+	Hint:        "[enable|disable|report]",
+	Bindings: []apps.Binding{
+		{
+			Label: "enable", // Sends the survey to new members of the team.
+			Form:  &SurveyEnableForm,
+		},
+		{
+			Label:  "disable", // Stops sending the survey.
+			Submit: SurveyDisable,
+		},
+		{
+			Label:  "report", // Shows the results of the survey.
+			Submit: SurveyReport,
+		},
+	},
+}
+
+// surveyRatingOptions are the ratings of the survey form, from 1 to 5 stars.
+var surveyRatingOptions = func() []apps.SelectOption {
+	options := []apps.SelectOption{}
+	for rating := 5; rating >= 1; rating-- {
+		options = append(options, apps.SelectOption{
+			Label: strings.Repeat("★", rating) + strings.Repeat("☆", 5-rating),
+			Value: strconv.Itoa(rating),
+		})
+	}
+	return options
+}()
+
+func surveyKey(teamID string) string {
+	return "survey_" + teamID
+}
+
+// GetSurveyResponses returns the survey responses of the members of the team,
+// by user ID.
+func (s *Store) GetSurveyResponses(teamID string) (map[string]SurveyResponse, error) {
+	responses := map[string]SurveyResponse{}
+	if err := s.kv.KVGet(KVAppPrefix, surveyKey(teamID), &responses); err != nil {
+		return nil, err
+	}
+	if responses == nil {
+		responses = map[string]SurveyResponse{}
+	}
+	return responses, nil
+}
+
+// SetSurveyResponse stores the survey response of the user in the team,
+// replacing their previous one.
+func (s *Store) SetSurveyResponse(teamID, userID string, response SurveyResponse) error {
+	responses, err := s.GetSurveyResponses(teamID)
+	if err != nil {
+		return err
+	}
+	responses[userID] = response
+
+	_, err = s.kv.KVSet(KVAppPrefix, surveyKey(teamID), responses)
+	return err
+}
+
+// DeleteSurveyResponses removes the survey responses of the team.
+func (s *Store) DeleteSurveyResponses(teamID string) error {
+	return s.kv.KVDelete(KVAppPrefix, surveyKey(teamID))
+}
+
+// surveyQuestion returns the question of the survey, or the default one.
+func surveyQuestion(survey *Survey) string {
+	if survey == nil || survey.Question == "" {
+		return defaultSurveyQuestion
+	}
+	return survey.Question
+}
+
+// surveyJob returns the job inviting the new member to take the survey, in
+// the direct channel with the bot.
+func surveyJob(channelID, teamID string, survey *Survey) Job {
+	return Job{
+		ID:        model.NewId(),
+		RunAt:     model.GetMillis() + int64(survey.DelaySeconds)*1000,
+		ChannelID: channelID,
+		Message:   "You've been with us for a little while now. " + surveyQuestion(survey) + " It takes a few seconds to tell us.",
+		Props: model.StringInterface{apps.PropAppBindings: []apps.Binding{
+			{
+				AppID:    AppID,
+				Location: "survey",
+				Bindings: []apps.Binding{
+					{
+						Location: "take",
+						Label:    "Take the survey",
+						Submit:   TakeSurvey.WithState(teamID),
+					},
+				},
+			},
+		}},
+	}
+}
+
+// TakeSurveyCall opens the survey form.
+func TakeSurveyCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	teamID, _ := c.State.(string)
+	welcome, err := NewStore(req.Context(), c.Context).GetTeamWelcome(teamID)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	if welcome == nil || welcome.Survey == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the survey is closed"))
+		return
+	}
+
+	httputils.WriteJSON(w, apps.NewFormResponse(apps.Form{
+		Title:  "Welcome Bot",
+		Header: surveyQuestion(welcome.Survey),
+		Icon:   "icon.png",
+		Fields: []apps.Field{
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                "rating",
+				ModalLabel:          "Rating",
+				SelectStaticOptions: surveyRatingOptions,
+				IsRequired:          true,
+			},
+			{
+				Type:          apps.FieldTypeText,
+				TextSubtype:   apps.TextFieldSubtypeTextarea,
+				TextMaxLength: 2000,
+				Name:          "comment",
+				ModalLabel:    "Anything to add?",
+				Description:   "What went well, and what could have been better",
+			},
+		},
+		Submit: SubmitSurvey.WithState(teamID),
+	}))
+}
+
+// SubmitSurveyCall stores the survey response of the member.
+func SubmitSurveyCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	teamID, _ := c.State.(string)
+	if teamID == "" || c.Context.ActingUser == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the survey"))
+		return
+	}
+
+	rating, err := strconv.Atoi(c.GetValue("rating", ""))
+	if err != nil || rating < 1 || rating > 5 {
+		httputils.WriteJSON(w,
+			errorResponse("the rating must be between 1 and 5"))
+		return
+	}
+
+	err = NewStore(req.Context(), c.Context).SetSurveyResponse(teamID, c.Context.ActingUser.Id, SurveyResponse{
+		Rating:      rating,
+		Comment:     strings.TrimSpace(c.GetValue("comment", "")),
+		SubmittedAt: model.GetMillis(),
+	})
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't save your answer"))
+		return
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("Thanks for your feedback!"))
+}
+
+func SurveyEnableCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	delay, err := parseFollowUpDelay(c.GetValue("delay", ""))
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	setSurvey(req.Context(), w, c, &Survey{
+		Question:     strings.TrimSpace(c.GetValue("question", "")),
+		DelaySeconds: delay,
+	})
+}
+
+func SurveyDisableCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	setSurvey(req.Context(), w, c, nil)
+}
+
+// setSurvey sets the survey of the team, or turns it off if survey is nil.
+// The responses are kept, so the report still shows them.
+func setSurvey(ctx context.Context, w http.ResponseWriter, c apps.CallRequest, survey *Survey) {
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(ctx, c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	welcome, err := store.GetTeamWelcome(c.Context.Team.Id)
+	if err != nil {
+		logger.Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the survey"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the team has no welcome message, set one with `set_team_welcome` first"))
+		return
+	}
+
+	welcome.Survey = survey
+	if err = store.SetTeamWelcome(c.Context.Team.Id, *welcome); err != nil {
+		logger.Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the survey"))
+		return
+	}
+
+	if survey == nil {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("New members of the team won't be surveyed anymore."))
+		return
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of the team will be asked %q %s after they join.", surveyQuestion(survey), time.Duration(survey.DelaySeconds)*time.Second))
+}
+
+// SurveyReportCall shows the results of the team's survey: the average
+// rating, how the ratings spread, and the latest comments.
+func SurveyReportCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	if c.Context.Team == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current team"))
+		return
+	}
+
+	store := NewStore(req.Context(), c.Context)
+	if err := checkCanManageTeam(store, c.Context); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	responses, err := store.GetSurveyResponses(c.Context.Team.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the survey responses"))
+		return
+	}
+	if len(responses) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("No member answered the survey of the team yet"))
+		return
+	}
+
+	counts := [6]int{}
+	total := 0
+	userIDs := []string{}
+	for userID, response := range responses {
+		counts[response.Rating]++
+		total += response.Rating
+		if response.Comment != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		return responses[userIDs[i]].SubmittedAt > responses[userIDs[j]].SubmittedAt
+	})
+	if len(userIDs) > maxSurveyComments {
+		userIDs = userIDs[:maxSurveyComments]
+	}
+
+	message := fmt.Sprintf("#### Survey of %s\n\n%d members answered, with an average rating of **%.1f** out of 5.\n\n",
+		c.Context.Team.DisplayName, len(responses), float64(total)/float64(len(responses)))
+	for rating := 5; rating >= 1; rating-- {
+		message += fmt.Sprintf("* %s: %d (%d%%)\n", strings.Repeat("★", rating), counts[rating], counts[rating]*100/len(responses))
+	}
+
+	if len(userIDs) > 0 {
+		usernames := map[string]string{}
+		users, _, err := asBot(req.Context(), c.Context).GetUsersByIds(userIDs)
+		if err != nil {
+			requestLogger(req).Error(err)
+		}
+		for _, user := range users {
+			usernames[user.Id] = user.Username
+		}
+
+		message += "\n##### Latest comments\n\n"
+		for _, userID := range userIDs {
+			name := "@" + usernames[userID]
+			if usernames[userID] == "" {
+				name = userID
+			}
+			response := responses[userID]
+			message += fmt.Sprintf("> %s\n\n— %s, %s (%d/5)\n\n", strings.ReplaceAll(response.Comment, "\n", "\n> "), name, formatMillis(response.SubmittedAt), response.Rating)
+		}
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// welcomeThreadMessage is the root post of the welcome thread of a channel in
+// thread mode.
+const welcomeThreadMessage = "#### :wave: Welcome\nNew members of this channel are welcomed in this thread."
+
+func welcomeThreadKey(channelID string) string {
+	return "thread_" + channelID
+}
+
+// GetWelcomeThread returns the ID of the root post of the channel's welcome
+// thread, or "" if there is none yet.
+func (s *Store) GetWelcomeThread(channelID string) (string, error) {
+	var rootID string
+	err := s.kv.KVGet(KVAppPrefix, welcomeThreadKey(channelID), &rootID)
+	return rootID, err
+}
+
+// SetWelcomeThread stores the ID of the root post of the channel's welcome
+// thread.
+func (s *Store) SetWelcomeThread(channelID, rootID string) error {
+	_, err := s.kv.KVSet(KVAppPrefix, welcomeThreadKey(channelID), rootID)
+	return err
+}
+
+// DeleteWelcomeThread forgets the channel's welcome thread. The thread itself
+// is left in the channel.
+func (s *Store) DeleteWelcomeThread(channelID string) error {
+	return s.kv.KVDelete(KVAppPrefix, welcomeThreadKey(channelID))
+}
+
+// welcomeThread returns the ID of the root post of the channel's welcome
+// thread, posting a new root if there is none yet or it was deleted.
+func welcomeThread(client *appclient.Client, store *Store, channelID string) (string, error) {
+	rootID, err := store.GetWelcomeThread(channelID)
+	if err != nil {
+		return "", err
+	}
+	if rootID != "" {
+		if root, _, err := client.GetPost(rootID, ""); err == nil && root.DeleteAt == 0 {
+			return rootID, nil
+		}
+	}
+
+	root, err := createPost(client, &model.Post{
+		ChannelId: channelID,
+		Message:   welcomeThreadMessage,
+	})
+	if err != nil {
+		return "", err
+	}
+	return root.Id, store.SetWelcomeThread(channelID, root.Id)
+}
+
+// postThreadJob creates the post of a Thread JobKindPost job, as a reply in
+// the welcome thread of the channel.
+func postThreadJob(client *appclient.Client, store *Store, job Job) error {
+	rootID, err := welcomeThread(client, store, job.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	post := &model.Post{
+		ChannelId: job.ChannelID,
+		RootId:    rootID,
+		Message:   job.Message,
+	}
+	post.SetProps(job.Props)
+	_, err = createPost(client, post)
+	return err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The spans are exported in batches of up to traceBatchSize spans, every
+// traceExportInterval at least. Up to traceQueueSize spans wait to be
+// exported, the others are dropped.
+const (
+	traceBatchSize      = 512
+	traceQueueSize      = 2048
+	traceExportInterval = 5 * time.Second
+	traceExportTimeout  = 10 * time.Second
+)
+
+// tracer records the spans of the calls, the KV store operations and the
+// requests to Mattermost. It records nothing until setupTracing replaces it
+// with the tracer of the provider exporting them.
+//
+// The spans are children of the span of the context they are started with:
+// the handlers pass the context of the request on to the stores and the
+// Mattermost clients they create, whose KV store operations and requests
+// carry it.
+var tracer = otel.Tracer("welcomebot")
+
+// tracerProvider exports the spans, nil if tracing is not configured.
+var tracerProvider *sdktrace.TracerProvider
+
+// tracingFlushEach is whether the spans are exported as soon as the request
+// they are part of was served, e.g. in AWS Lambda where the process is frozen
+// once the response is sent.
+var tracingFlushEach bool
+
+// setupTracing exports the spans to the OTLP/HTTP endpoint, e.g.
+// http://localhost:4318, with the headers, as the service. Only the
+// sampleRatio of the traces is recorded, and the traces of the requests that
+// carry a traceparent header are continued.
+func setupTracing(endpoint string, headers map[string]string, service string, sampleRatio float64, flushEach bool) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	path := u.Path
+	if !strings.HasSuffix(path, "/v1/traces") {
+		path = strings.TrimSuffix(path, "/") + "/v1/traces"
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(path),
+		otlptracehttp.WithHeaders(headers),
+		otlptracehttp.WithTimeout(traceExportTimeout),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter,
+			sdktrace.WithMaxExportBatchSize(traceBatchSize),
+			sdktrace.WithMaxQueueSize(traceQueueSize),
+			sdktrace.WithBatchTimeout(traceExportInterval),
+			sdktrace.WithExportTimeout(traceExportTimeout)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", service),
+			attribute.String("service.version", Version))),
+	)
+	tracingFlushEach = flushEach
+	tracer = tracerProvider.Tracer("welcomebot")
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warnf("tracing: %v", err)
+	}))
+	return nil
+}
+
+// stopTracing exports the spans ended, and stops exporting.
+func stopTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logger.Error(err)
+	}
+}
+
+// endSpan ends the span, failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceRequests records a span for each request, continuing its trace if it
+// carries one, and passes it on in the request's context.
+func traceRequests(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if tracerProvider == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer.Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", req.Method),
+				attribute.String("http.route", route),
+				attribute.String("http.target", req.URL.Path)))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.status_code", recorder.status))
+		var err error
+		if recorder.status >= 500 {
+			err = errors.New(http.StatusText(recorder.status))
+		}
+		endSpan(span, err)
+
+		if tracingFlushEach {
+			if err = tracerProvider.ForceFlush(context.Background()); err != nil {
+				logger.Warnf("failed to export the spans: %v", err)
+			}
+		}
+	})
+}
+
+// tracingKV records a span for each KV store operation, a child of the span
+// of ctx.
+type tracingKV struct {
+	KVStore
+	ctx context.Context
+}
+
+func (kv tracingKV) start(operation, prefix, key string) trace.Span {
+	_, span := tracer.Start(kv.ctx, "kv "+operation, trace.WithAttributes(
+		attribute.String("kv.prefix", prefix),
+		attribute.String("kv.key", key)))
+	return span
+}
+
+func (kv tracingKV) KVGet(prefix, key string, ref interface{}) error {
+	span := kv.start("get", prefix, key)
+	err := kv.KVStore.KVGet(prefix, key, ref)
+	endSpan(span, err)
+	return err
+}
+
+func (kv tracingKV) KVSet(prefix, key string, value interface{}) (bool, error) {
+	span := kv.start("set", prefix, key)
+	changed, err := kv.KVStore.KVSet(prefix, key, value)
+	endSpan(span, err)
+	return changed, err
+}
+
+func (kv tracingKV) KVDelete(prefix, key string) error {
+	span := kv.start("delete", prefix, key)
+	err := kv.KVStore.KVDelete(prefix, key)
+	endSpan(span, err)
+	return err
+}
+
+// contextTransport makes the requests part of the trace of ctx, for the
+// clients whose methods take no context, like the Mattermost client. Only
+// the span of ctx is passed on, the requests are not cancelled with it.
+type contextTransport struct {
+	next http.RoundTripper
+	ctx  context.Context
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := trace.SpanFromContext(t.ctx)
+	return t.next.RoundTrip(req.WithContext(trace.ContextWithSpan(req.Context(), span)))
+}
+
+// tracingTransport records a span for each request made, e.g. to Mattermost,
+// and passes its trace on.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := "HTTP " + req.Method
+	if api := apiName(req.URL.Path); api != "other" {
+		name = "mattermost " + api
+	}
+	target := url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: req.URL.Path}
+	ctx, span := tracer.Start(req.Context(), name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", target.String())))
+
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= 500 {
+			err = errors.New(resp.Status)
+		}
+	}
+	endSpan(span, err)
+	return resp, err
+}
+
+// traceID returns the ID of the trace of ctx, "" if it has none.
+func traceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// parseTracingHeaders parses the headers sent with the spans, as in
+// OTEL_EXPORTER_OTLP_HEADERS: comma separated key=value pairs, whose values
+// are URL encoded.
+func parseTracingHeaders(value string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("the tracing headers must be key=value pairs separated by commas, got %q", pair)
+		}
+		val, err := url.QueryUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tracing header %s: %w", key, err)
+		}
+		headers[key] = val
+	}
+	return headers, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider recording the spans ended, for the
+// length of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := tracer
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer = tracerProvider.Tracer("welcomebot")
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		tracerProvider = nil
+		tracer = previous
+	})
+	return recorder
+}
+
+func spanNamed(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("no span %s was recorded", name)
+	return nil
+}
+
+func TestTraceRequestsPropagation(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var received string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header.Get("traceparent")
+	}))
+	defer downstream.Close()
+
+	handler := traceRequests("/call", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client := &http.Client{Transport: contextTransport{next: tracingTransport{next: http.DefaultTransport}, ctx: req.Context()}}
+		resp, err := client.Get(downstream.URL + "/api/v4/users/me")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		span := tracingKV{KVStore: NewMemoryKV(), ctx: req.Context()}.start("get", KVAppPrefix, "key")
+		span.End()
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/call", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	server := spanNamed(t, spans, "POST /call")
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("the request's trace is %s, not the one it carried", got)
+	}
+	if got := server.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("the request's span is the child of %s", got)
+	}
+	for _, name := range []string{"mattermost users", "kv get"} {
+		if span := spanNamed(t, spans, name); span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("the %s span isn't a child of the request's", name)
+		}
+	}
+	outgoing := spanNamed(t, spans, "mattermost users")
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + outgoing.SpanContext().SpanID().String() + "-01"; received != want {
+		t.Errorf("traceparent = %q, want %q", received, want)
+	}
+}
+
+func TestTraceRequestsWithoutParent(t *testing.T) {
+	recorder := recordSpans(t)
+
+	handler := traceRequests("/call", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if traceID(req.Context()) == "" {
+			t.Error("the request's context carries no trace")
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/call", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/call", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans were recorded, want 2", len(spans))
+	}
+	if spans[0].SpanContext().TraceID() == spans[1].SpanContext().TraceID() {
+		t.Error("the requests share a trace")
+	}
+	for _, span := range spans {
+		if span.Parent().IsValid() {
+			t.Error("a request without a traceparent has a parent")
+		}
+		if span.Status().Code != codes.Error {
+			t.Errorf("the status of a failed request is %s", span.Status().Code)
+		}
+	}
+}
+
+func TestSetupTracingExports(t *testing.T) {
+	exported := make(chan *http.Request, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case exported <- req:
+		default:
+		}
+	}))
+	defer collector.Close()
+
+	previous := tracer
+	defer func() {
+		tracer = previous
+		tracerProvider = nil
+		tracingFlushEach = false
+	}()
+	if err := setupTracing(collector.URL, map[string]string{"x-api-key": "secret"}, "welcomebot", 1, true); err != nil {
+		t.Fatal(err)
+	}
+	defer stopTracing()
+
+	handler := traceRequests("/call", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/call", nil))
+
+	select {
+	case req := <-exported:
+		if req.URL.Path != "/v1/traces" {
+			t.Errorf("the spans were exported to %s", req.URL.Path)
+		}
+		if got := req.Header.Get("x-api-key"); got != "secret" {
+			t.Errorf("x-api-key = %q, want the configured header", got)
+		}
+	default:
+		t.Error("the spans weren't exported once the request was served")
+	}
+}
+
+func TestTraceIDWithoutSpan(t *testing.T) {
+	if id := traceID(context.Background()); id != "" {
+		t.Errorf("traceID = %q, want none", id)
+	}
+}
+
+func TestParseTracingHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+		valid bool
+	}{
+		{name: "empty", value: "", want: map[string]string{}, valid: true},
+		{name: "pairs", value: "x-api-key=secret, tenant = acme", want: map[string]string{"x-api-key": "secret", "tenant": "acme"}, valid: true},
+		{name: "URL encoded", value: "authorization=Basic%20dXNlcg%3D%3D", want: map[string]string{"authorization": "Basic dXNlcg=="}, valid: true},
+		{name: "trailing comma", value: "a=1,", want: map[string]string{"a": "1"}, valid: true},
+		{name: "no value", value: "a"},
+		{name: "no key", value: "=1"},
+		{name: "invalid encoding", value: "a=%zz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTracingHeaders(tt.value)
+			if (err == nil) != tt.valid {
+				t.Fatalf("err = %v, want valid %v", err, tt.valid)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("headers = %v, want %v", got, tt.want)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("%s = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// The ways a variant of the welcome is picked for a new member: at random,
+// in proportion to the weights, or in turns so the number of members of each
+// variant follows the weights.
+const (
+	VariantSelectionRandom     = ""
+	VariantSelectionRoundRobin = "round_robin"
+)
+
+// WelcomeVariant is an alternative text of the first welcome message of a
+// channel, sent to a share of the new members to compare how well each text
+// does.
+type WelcomeVariant struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Weight  int    `json:"weight"`
+}
+
+// VariantAssignment records the variant a new member of the channel got. The
+// time is in milliseconds.
+type VariantAssignment struct {
+	Variant    string `json:"variant"`
+	AssignedAt int64  `json:"assigned_at"`
+}
+
+// SetVariantForm adds, replaces or removes a variant of the channel's
+// welcome.
+var SetVariantForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		{
+			Type:                 apps.FieldTypeText,
+			TextSubtype:          apps.TextFieldSubtypeTextarea,
+			TextMaxLength:        model.PostMessageMaxRunesV2,
+			Name:                 "message",
+			ModalLabel:           "Message",
+			Description:          "The text of the first welcome message in this variant. Leave empty to remove the variant.",
+			AutocompletePosition: -1,
+		},
+		{
+			Type:        apps.FieldTypeText,
+			Name:        "name",
+			Label:       "name",
+			ModalLabel:  "Name",
+			Description: "The name of the variant, e.g. B",
+			IsRequired:  true,
+		},
+		{
+			Type:        apps.FieldTypeText,
+			TextSubtype: apps.TextFieldSubtypeNumber,
+			Name:        "weight",
+			Label:       "weight",
+			ModalLabel:  "Weight",
+			Description: "The share of new members getting the variant, relative to the other variants, 1 by default",
+		},
+		{
+			Type:        apps.FieldTypeStaticSelect,
+			Name:        "selection",
+			Label:       "selection",
+			ModalLabel:  "Selection",
+			Description: "How the variant of each new member is picked",
+			SelectStaticOptions: []apps.SelectOption{
+				{Label: "random", Value: "random"},
+				{Label: "round_robin", Value: VariantSelectionRoundRobin},
+			},
+		},
+		channelField,
+	},
+	Submit: apps.NewCall("/set_variant").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+// VariantReportForm compares the variants of the channel's welcome.
+var VariantReportForm = apps.Form{
+	Title: "Welcome Bot",
+	Icon:  "icon.png",
+	Fields: []apps.Field{
+		channelField,
+	},
+	Submit: apps.NewCall("/variant_report").WithExpand(apps.Expand{
+		ActingUser:            apps.ExpandSummary,
+		ActingUserAccessToken: apps.ExpandAll,
+		Channel:               apps.ExpandSummary,
+		ChannelMember:         apps.ExpandAll,
+		TeamMember:            apps.ExpandAll,
+	}),
+}
+
+func variantsKey(channelID string) string {
+	return "variants_" + channelID
+}
+
+// GetVariantAssignments returns the variants the new members of the channel
+// got, by user ID.
+func (s *Store) GetVariantAssignments(channelID string) (map[string]VariantAssignment, error) {
+	assignments := map[string]VariantAssignment{}
+	if err := s.kv.KVGet(KVAppPrefix, variantsKey(channelID), &assignments); err != nil {
+		return nil, err
+	}
+	if assignments == nil {
+		assignments = map[string]VariantAssignment{}
+	}
+	return assignments, nil
+}
+
+// AssignVariant records that the user got the variant of the channel's
+// welcome.
+func (s *Store) AssignVariant(channelID, userID, variant string) error {
+	assignments, err := s.GetVariantAssignments(channelID)
+	if err != nil {
+		return err
+	}
+	assignments[userID] = VariantAssignment{Variant: variant, AssignedAt: model.GetMillis()}
+
+	_, err = s.kv.KVSet(KVAppPrefix, variantsKey(channelID), assignments)
+	return err
+}
+
+// DeleteVariantAssignments removes the variant assignments of the channel.
+func (s *Store) DeleteVariantAssignments(channelID string) error {
+	return s.kv.KVDelete(KVAppPrefix, variantsKey(channelID))
+}
+
+// pickVariant picks the variant of the welcome for a new member, given the
+// variants the earlier members got. It returns nil if the welcome has no
+// variants.
+func pickVariant(welcome ChannelWelcome, assignments map[string]VariantAssignment) *WelcomeVariant {
+	if len(welcome.Variants) == 0 {
+		return nil
+	}
+
+	if welcome.VariantSelection == VariantSelectionRoundRobin {
+		// The variant furthest behind its share goes next.
+		counts := map[string]int{}
+		for _, assignment := range assignments {
+			counts[assignment.Variant]++
+		}
+		next := 0
+		for i, variant := range welcome.Variants {
+			best := welcome.Variants[next]
+			if counts[variant.Name]*best.Weight < counts[best.Name]*variant.Weight {
+				next = i
+			}
+		}
+		return &welcome.Variants[next]
+	}
+
+	total := 0
+	for _, variant := range welcome.Variants {
+		total += variant.Weight
+	}
+	n := rand.Intn(total)
+	for i, variant := range welcome.Variants {
+		if n < variant.Weight {
+			return &welcome.Variants[i]
+		}
+		n -= variant.Weight
+	}
+	return &welcome.Variants[len(welcome.Variants)-1]
+}
+
+// applyVariant picks the variant of the welcome for the new member, records
+// it, and returns the welcome with the first message replaced by the
+// variant. The welcome is returned as is if it has no variants.
+func applyVariant(store *Store, channelID, userID string, welcome ChannelWelcome) ChannelWelcome {
+	if len(welcome.Variants) == 0 || len(welcome.Messages) == 0 {
+		return welcome
+	}
+
+	assignments, err := store.GetVariantAssignments(channelID)
+	if err != nil {
+		logger.Error(err)
+		return welcome
+	}
+	variant := pickVariant(welcome, assignments)
+	if err = store.AssignVariant(channelID, userID, variant.Name); err != nil {
+		logger.Error(err)
+	}
+	return withVariant(welcome, *variant)
+}
+
+// withVariant returns the welcome with the first message replaced by the
+// variant.
+func withVariant(welcome ChannelWelcome, variant WelcomeVariant) ChannelWelcome {
+	// The variant is the same text in every locale, as the translations
+	// would hide the difference.
+	messages := append([]WelcomeMessage{}, welcome.Messages...)
+	messages[0].Message = variant.Message
+	messages[0].Translations = nil
+	welcome.Messages = messages
+	return welcome
+}
+
+func SetVariantCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	if err = requireValues(c, "name"); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	name := strings.TrimSpace(c.GetValue("name", ""))
+	message := c.GetValue("message", "")
+	if err = checkWelcomeLength(message); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if _, err = RenderTemplate(message, TemplateData{}); err != nil {
+		httputils.WriteJSON(w, errorResponse("invalid template: %s", err))
+		return
+	}
+	weight, err := strconv.Atoi(c.GetValue("weight", "1"))
+	if err != nil || weight < 1 {
+		httputils.WriteJSON(w,
+			errorResponse("the weight must be a positive number"))
+		return
+	}
+
+	welcome, err := store.GetChannelWelcome(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the variant"))
+		return
+	}
+	if welcome == nil {
+		httputils.WriteJSON(w,
+			errorResponse("the channel has no welcome message, set one with `set_channel_welcome` first"))
+		return
+	}
+
+	variants := []WelcomeVariant{}
+	found := false
+	for _, variant := range welcome.Variants {
+		if variant.Name == name {
+			found = true
+			if message == "" {
+				continue
+			}
+			variant = WelcomeVariant{Name: name, Message: message, Weight: weight}
+		}
+		variants = append(variants, variant)
+	}
+	if !found {
+		if message == "" {
+			httputils.WriteJSON(w,
+				errorResponse("there is no variant %s to remove", name))
+			return
+		}
+		variants = append(variants, WelcomeVariant{Name: name, Message: message, Weight: weight})
+	}
+	welcome.Variants = variants
+	switch c.GetValue("selection", "") {
+	case "random":
+		welcome.VariantSelection = VariantSelectionRandom
+	case VariantSelectionRoundRobin:
+		welcome.VariantSelection = VariantSelectionRoundRobin
+	}
+
+	if err = store.SetChannelWelcome(cc.Channel.Id, *welcome); err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't set the variant"))
+		return
+	}
+
+	if len(variants) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("All new members of ~%s will get the same welcome again.", cc.Channel.Name))
+		return
+	}
+	names := []string{}
+	for _, variant := range variants {
+		names = append(names, fmt.Sprintf("%s (weight %d)", variant.Name, variant.Weight))
+	}
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("New members of ~%s will get one of the variants %s of the first welcome message.", cc.Channel.Name, strings.Join(names, ", ")))
+}
+
+// VariantReportCall compares the variants of the channel's welcome: how many
+// members got each of them, and how many of those acknowledged the welcome
+// and joined a recommended channel.
+func VariantReportCall(w http.ResponseWriter, req *http.Request) {
+	c, ok := decodeCall(w, req)
+	if !ok {
+		return
+	}
+
+	cc, err := withSelectedChannel(req.Context(), c)
+	if err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+	if cc.Channel == nil {
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't find the current channel"))
+		return
+	}
+
+	store := NewStore(req.Context(), cc)
+	if err = checkCanManageChannel(store, cc); err != nil {
+		httputils.WriteJSON(w, apps.NewErrorResponse(err))
+		return
+	}
+
+	assignments, err := store.GetVariantAssignments(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+		httputils.WriteJSON(w,
+			errorResponse("we couldn't load the variants"))
+		return
+	}
+	if len(assignments) == 0 {
+		httputils.WriteJSON(w,
+			apps.NewTextResponse("No new member of ~%s got a variant of the welcome yet", cc.Channel.Name))
+		return
+	}
+	acks, err := store.GetAcknowledgments(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+	joins, err := store.GetRecommendedJoins(cc.Channel.Id)
+	if err != nil {
+		requestLogger(req).Error(err)
+	}
+
+	type variantResults struct {
+		members, acknowledged, joined int
+	}
+	results := map[string]*variantResults{}
+	names := []string{}
+	if welcome, _ := store.GetChannelWelcome(cc.Channel.Id); welcome != nil {
+		for _, variant := range welcome.Variants {
+			results[variant.Name] = &variantResults{}
+			names = append(names, variant.Name)
+		}
+	}
+	for userID, assignment := range assignments {
+		r, ok := results[assignment.Variant]
+		if !ok {
+			// The variant was removed since, it is shown last.
+			r = &variantResults{}
+			results[assignment.Variant] = r
+			names = append(names, assignment.Variant)
+		}
+		r.members++
+		if acks[userID].AcknowledgedAt != 0 {
+			r.acknowledged++
+		}
+		if len(joins[userID]) > 0 {
+			r.joined++
+		}
+	}
+
+	percent := func(n, total int) string {
+		if total == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d (%d%%)", n, n*100/total)
+	}
+	message := fmt.Sprintf("#### Variants of the welcome message of ~%s\n\n", cc.Channel.Name)
+	message += "| Variant | Members | Acknowledged | Joined a recommended channel |\n|:--|--:|--:|--:|\n"
+	for _, name := range names {
+		r := results[name]
+		message += fmt.Sprintf("| %s | %d | %s | %s |\n", name, r.members, percent(r.acknowledged, r.members), percent(r.joined, r.members))
+	}
+
+	httputils.WriteJSON(w,
+		apps.NewTextResponse("%s", message))
+}
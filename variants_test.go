@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func TestPickVariantRoundRobin(t *testing.T) {
+	welcome := ChannelWelcome{
+		Variants:         []WelcomeVariant{{Name: "A", Weight: 2}, {Name: "B", Weight: 1}},
+		VariantSelection: VariantSelectionRoundRobin,
+	}
+	assignments := map[string]VariantAssignment{}
+	order := ""
+	for i := 0; i < 6; i++ {
+		variant := pickVariant(welcome, assignments)
+		assignments[model.NewId()] = VariantAssignment{Variant: variant.Name}
+		order += variant.Name
+	}
+	if order != "ABAABA" {
+		t.Errorf("picked %s, want the variants in turns following their weights", order)
+	}
+}
+
+func TestPickVariantRandom(t *testing.T) {
+	welcome := ChannelWelcome{
+		Variants: []WelcomeVariant{{Name: "A", Weight: 3}, {Name: "B", Weight: 1}},
+	}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		counts[pickVariant(welcome, nil).Name]++
+	}
+	if counts["A"]+counts["B"] != 1000 || counts["B"] < 150 || counts["B"] > 350 {
+		t.Errorf("picked %v, want about a quarter of B", counts)
+	}
+
+	if variant := pickVariant(ChannelWelcome{}, nil); variant != nil {
+		t.Errorf("picked %+v for a welcome without variants", variant)
+	}
+}
+
+func TestApplyVariant(t *testing.T) {
+	store := NewMemoryStore()
+	welcome := ChannelWelcome{
+		Messages: []WelcomeMessage{
+			{Message: "Welcome!", Translations: map[string]string{"es": "¡Bienvenido!"}},
+			{Message: "Second"},
+		},
+		Variants: []WelcomeVariant{{Name: "B", Message: "Hey, welcome!", Weight: 1}},
+	}
+
+	applied := applyVariant(store, "channel", "user", welcome)
+	if applied.Messages[0].Message != "Hey, welcome!" || applied.Messages[0].Translations != nil || applied.Messages[1].Message != "Second" {
+		t.Errorf("applied the variant as %+v", applied.Messages)
+	}
+	if welcome.Messages[0].Message != "Welcome!" {
+		t.Error("the welcome applied to was changed")
+	}
+	assignments, err := store.GetVariantAssignments("channel")
+	if err != nil || assignments["user"].Variant != "B" {
+		t.Errorf("recorded the assignments %+v, %v", assignments, err)
+	}
+
+	welcome.Variants = nil
+	if applied = applyVariant(store, "channel", "other", welcome); applied.Messages[0].Message != "Welcome!" {
+		t.Errorf("applied %q without variants", applied.Messages[0].Message)
+	}
+}
+
+func TestVariantReportCall(t *testing.T) {
+	useMemoryBackend(t)
+	fake := newFakeMattermost(t)
+	cc := callContext(fake, &model.User{Id: "admin", Roles: model.SystemAdminRoleId}, &model.Team{Id: "team"})
+	cc.Channel = &model.Channel{Id: "channel", Name: "town-square", TeamId: "team"}
+
+	resp := call(t, VariantReportCall, apps.CallRequest{Context: cc})
+	if !strings.Contains(resp.Text, "No new member of ~town-square got a variant") {
+		t.Errorf("response without assignments = %q", resp.Text)
+	}
+
+	store := NewStore(context.Background(), cc)
+	welcome := ChannelWelcome{
+		Messages: []WelcomeMessage{{Message: "Welcome!"}},
+		Variants: []WelcomeVariant{{Name: "A", Weight: 1}, {Name: "B", Weight: 1}},
+	}
+	if err := store.SetChannelWelcome("channel", welcome); err != nil {
+		t.Fatal(err)
+	}
+	for user, variant := range map[string]string{"u1": "A", "u2": "A", "u3": "B", "u4": "gone"} {
+		if err := store.AssignVariant("channel", user, variant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.AddPendingAcknowledgment("channel", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Acknowledge("channel", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddRecommendedJoin("channel", "u3", "random"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = call(t, VariantReportCall, apps.CallRequest{Context: cc})
+	for _, want := range []string{
+		"| A | 2 | 1 (50%) | 0 (0%) |",
+		"| B | 1 | 0 (0%) | 1 (100%) |",
+		"| gone | 1 | 0 (0%) | 0 (0%) |",
+	} {
+		if !strings.Contains(resp.Text, want) {
+			t.Errorf("the report %q has no line %q", resp.Text, want)
+		}
+	}
+	if strings.Index(resp.Text, "| gone |") < strings.Index(resp.Text, "| B |") {
+		t.Error("the removed variant isn't shown last")
+	}
+}
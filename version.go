@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/utils/httputils"
+)
+
+// VersionPath is where the version of the running build is served.
+const VersionPath = "/version"
+
+// The version of the build, set at link time, e.g. with
+//
+//	go build -ldflags "-X main.Version=$(git describe --tags --always --dirty) \
+//		-X main.Commit=$(git rev-parse HEAD) \
+//		-X main.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// The commit and build date default to the ones recorded by go build, if any.
+var (
+	Version   = "v0.1.0"
+	Commit    = ""
+	BuildDate = ""
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && Commit == "":
+			Commit = setting.Value
+		case setting.Key == "vcs.time" && BuildDate == "":
+			BuildDate = setting.Value
+		}
+	}
+}
+
+// manifestVersion returns the version to declare in the manifest. Mattermost
+// only accepts short versions, so the suffix git describe adds to builds
+// after a tag, e.g. -3-gabc1234-dirty, is dropped from it.
+func manifestVersion(version string) apps.AppVersion {
+	v := apps.AppVersion(version)
+	if v.Validate() != nil {
+		release, _, _ := strings.Cut(version, "-")
+		v = apps.AppVersion(release)
+	}
+	return v
+}
+
+// VersionInfo describes the running build.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+}
+
+// VersionHandler serves the version of the running build, so admins can tell
+// which one is deployed.
+func VersionHandler(w http.ResponseWriter, req *http.Request) {
+	httputils.WriteJSON(w, VersionInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+	})
+}
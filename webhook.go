@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// WebhookPath is where the inbound welcome webhook is served.
+const WebhookPath = "/webhook/welcome"
+
+// WebhookSignatureHeader is the header holding the signature of the webhook's
+// body: "sha256=" followed by the hex HMAC-SHA256 of the body, keyed with the
+// webhook secret.
+const WebhookSignatureHeader = "X-Welcomebot-Signature"
+
+// minWebhookSecretLength is the shortest webhook secret accepted, so it can't
+// be guessed.
+const minWebhookSecretLength = 16
+
+// maxWebhookBodySize is the largest webhook body read, in bytes.
+const maxWebhookBodySize = 64 * 1024
+
+// webhookRequest is the body of the welcome webhook: the member to welcome, by
+// email or username, the team whose welcome they get, by name or ID, and the
+// variables for the templates.
+type webhookRequest struct {
+	Email     string            `json:"email,omitempty"`
+	Username  string            `json:"username,omitempty"`
+	Team      string            `json:"team"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// WebhookHandler lets an external system, e.g. an HR system on an employee's
+// start date, send them the welcome of a team they are a member of, before
+// they join any of its channels:
+//
+//	body='{"email": "alice@example.com", "team": "acme", "variables": {"manager": "bob"}}'
+//	curl -H "X-Welcomebot-Signature: sha256=$(echo -n "$body" | openssl dgst -sha256 -hmac "$WEBHOOK_SECRET" -hex | cut -d' ' -f2)" \
+//		--data "$body" $APP_URL/webhook/welcome
+//
+// The variables are available to the messages of the team's welcome as
+// {{.Vars.manager}}. The welcome is queued as when the member joins the team,
+// unless the welcomes are paused or the member is excluded.
+//
+// The app needs the bot's credentials, so the webhook is only available once
+// Mattermost made a call to the app since it started.
+func WebhookHandler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			apiMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxWebhookBodySize))
+		if err != nil {
+			apiError(w, http.StatusRequestEntityTooLarge, "the body must be at most %d bytes", maxWebhookBodySize)
+			return
+		}
+		if !validWebhookSignature(secret, data, req.Header.Get(WebhookSignatureHeader)) {
+			apiError(w, http.StatusUnauthorized, "invalid signature")
+			return
+		}
+
+		body := webhookRequest{}
+		if err = json.Unmarshal(data, &body); err != nil {
+			apiError(w, http.StatusBadRequest, "invalid request: %s", err)
+			return
+		}
+		if body.Team == "" || (body.Email == "") == (body.Username == "") {
+			apiError(w, http.StatusBadRequest, "the team and either the email or the username are required")
+			return
+		}
+
+		cc, ok := scheduler.Context()
+		if !ok {
+			apiError(w, http.StatusServiceUnavailable, "the app has not been called by Mattermost yet, try again later")
+			return
+		}
+		client := asBot(req.Context(), cc)
+		store := NewStore(req.Context(), cc)
+
+		var user *model.User
+		if body.Email != "" {
+			user, _, err = client.GetUserByEmail(body.Email, "")
+		} else {
+			user, _, err = client.GetUserByUsername(strings.ToLower(strings.TrimPrefix(body.Username, "@")), "")
+		}
+		if err != nil {
+			apiError(w, http.StatusNotFound, "user %s%s not found", body.Email, body.Username)
+			return
+		}
+		if user.IsBot {
+			apiError(w, http.StatusBadRequest, "@%s is a bot, bots are not welcomed", user.Username)
+			return
+		}
+
+		team, _, err := client.GetTeamByName(strings.ToLower(body.Team), "")
+		if err != nil && model.IsValidId(body.Team) {
+			team, _, err = client.GetTeam(body.Team, "")
+		}
+		if err != nil {
+			apiError(w, http.StatusNotFound, "team %s not found", body.Team)
+			return
+		}
+		welcome, err := store.GetTeamWelcome(team.Id)
+		if err != nil || welcome == nil {
+			apiError(w, http.StatusNotFound, "the team has no welcome message")
+			return
+		}
+		if _, _, err = client.GetTeamMember(team.Id, user.Id, ""); err != nil {
+			apiError(w, http.StatusBadRequest, "@%s isn't a member of the team", user.Username)
+			return
+		}
+		if skipWelcome(store, user) {
+			countWelcome(store, IndexKindTeam, team.Id, countSkipped)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		dm, _, err := client.CreateDirectChannel(cc.BotUserID, user.Id)
+		if err == nil {
+			templateData := store.NewTemplateData(user, nil, team)
+			templateData.Vars = body.Variables
+			err = scheduler.Enqueue(req.Context(), cc, teamWelcomeJobs(client, store, dm.Id, team, user, *welcome, templateData))
+		}
+		if err != nil {
+			requestLogger(req).Error(err)
+			apiError(w, http.StatusInternalServerError, "couldn't send the welcome message: %s", err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// webhookSignature returns the signature of the body, as set in the
+// WebhookSignatureHeader header.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validWebhookSignature checks the signature of the webhook's body, in
+// constant time.
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(webhookSignature(secret, body)))
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// WelcomeMessage is one of the messages posted, in order, to new members of a
+// channel.
+type WelcomeMessage struct {
+	Message string `json:"message"`
+
+	// DelaySeconds is how long to wait before posting the message, counted
+	// from the previous message of the sequence.
+	DelaySeconds int `json:"delay_seconds,omitempty"`
+
+	// Translations are variants of Message by locale, e.g. "es".
+	Translations map[string]string `json:"translations,omitempty"`
+
+	// GuestMessage is the variant of Message for guest accounts, e.g. to tell
+	// who to contact for access, whatever their locale.
+	GuestMessage string `json:"guest_message,omitempty"`
+
+	// AddedMessage is the variant of Message for members added to the channel
+	// by someone else, rather than who joined on their own, e.g. "{{.AddedBy}}
+	// added you to this channel because...". Guests still get GuestMessage.
+	AddedMessage string `json:"added_message,omitempty"`
+
+	// Attachment is shown under the message, in all its variants.
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+// MessageForUser returns the variant of the message for the user: the guest
+// variant for guests, if any, or else the variant for the user's locale.
+func (m WelcomeMessage) MessageForUser(user *model.User) string {
+	return m.MessageForMember(user, false)
+}
+
+// MessageForMember returns the variant of the message for the member like
+// MessageForUser, but for the variant for added members if they were added
+// by someone else, whatever their locale.
+func (m WelcomeMessage) MessageForMember(user *model.User, added bool) string {
+	if m.GuestMessage != "" && user.IsGuest() {
+		return m.GuestMessage
+	}
+	if m.AddedMessage != "" && added {
+		return m.AddedMessage
+	}
+	return m.MessageFor(user.Locale)
+}
+
+// MessageFor returns the variant of the message for the locale, falling back
+// to the variant of its base language, e.g. "pt" for "pt-BR", then to the
+// default message.
+func (m WelcomeMessage) MessageFor(locale string) string {
+	if message, ok := m.Translations[locale]; ok {
+		return message
+	}
+	if base, _, found := strings.Cut(locale, "-"); found {
+		if message, ok := m.Translations[base]; ok {
+			return message
+		}
+	}
+	return m.Message
+}
+
+// Delay returns DelaySeconds as a time.Duration.
+func (m WelcomeMessage) Delay() time.Duration {
+	return time.Duration(m.DelaySeconds) * time.Second
+}
+
+// Locales returns the locales the message has a variant for, sorted.
+func (m WelcomeMessage) Locales() []string {
+	locales := make([]string, 0, len(m.Translations))
+	for locale := range m.Translations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// ChannelWelcome is the welcome configuration of a channel.
+type ChannelWelcome struct {
+	Messages []WelcomeMessage `json:"messages"`
+
+	// RecommendedChannels are the IDs of the channels new members are
+	// offered to join, with buttons under the last message.
+	RecommendedChannels []string `json:"recommended_channels,omitempty"`
+
+	// Links are the buttons opening external pages, e.g. the code of
+	// conduct, under the last message.
+	Links []WelcomeLink `json:"links,omitempty"`
+
+	// Acknowledgment is the label of the button new members are asked to
+	// click under the last message. There is no button if it is empty.
+	Acknowledgment string `json:"acknowledgment,omitempty"`
+
+	// DigestWindowSeconds turns on the digest mode: new members are collected
+	// for this long, then welcomed together in a single post.
+	DigestWindowSeconds int `json:"digest_window_seconds,omitempty"`
+
+	// MentionMember @-mentions the new member in the first message, so the
+	// welcome shows in their mentions.
+	MentionMember bool `json:"mention_member,omitempty"`
+
+	// ReactToFirstPost makes the bot react with :wave: to the first message
+	// the new member posts in the channel.
+	ReactToFirstPost bool `json:"react_to_first_post,omitempty"`
+
+	// Delivery is where the welcome is sent, DeliveryChannel by default.
+	// Digests are always posted in the channel.
+	Delivery string `json:"delivery,omitempty"`
+
+	// PinGuide makes the bot keep a pinned post in the channel with the
+	// welcome messages, GuidePostID, updated whenever they change.
+	PinGuide    bool   `json:"pin_guide,omitempty"`
+	GuidePostID string `json:"guide_post_id,omitempty"`
+
+	// Variants are alternative texts of the first message, one of them sent
+	// to each new member as picked by VariantSelection, to compare how well
+	// they do.
+	Variants         []WelcomeVariant `json:"variants,omitempty"`
+	VariantSelection string           `json:"variant_selection,omitempty"`
+
+	// Rotation is a pool of alternative texts of the first message, which is
+	// picked at random among them and itself for each new member. The
+	// variants take precedence.
+	Rotation []string `json:"rotation,omitempty"`
+
+	// Greeters are the users notified with a direct message when a new
+	// member joins, so they can follow up personally.
+	Greeters []string `json:"greeters,omitempty"`
+
+	// Disabled pauses the welcome: new members are not welcomed until it is
+	// enabled again, but the messages are kept.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// AppendToDefault posts the messages after those of the team's default
+	// channel welcome, instead of replacing it.
+	AppendToDefault bool `json:"append_to_default,omitempty"`
+
+	// Version is incremented every time the welcome is stored, so an editor
+	// opened before a change can't overwrite it.
+	Version int `json:"version,omitempty"`
+}
+
+// CheckLimits checks the texts and attachments of the welcome against the
+// limits of Mattermost posts, so a welcome that couldn't be posted is refused
+// when it is saved rather than when the first member joins. The errors tell
+// which message is over the limit, and by how much.
+func (w ChannelWelcome) CheckLimits() error {
+	for i, m := range w.Messages {
+		texts := []string{m.Message, m.GuestMessage, m.AddedMessage}
+		for _, translation := range m.Translations {
+			texts = append(texts, translation)
+		}
+		if i == 0 {
+			for _, variant := range w.Variants {
+				texts = append(texts, variant.Message)
+			}
+			texts = append(texts, w.Rotation...)
+		}
+		for _, text := range texts {
+			if err := checkWelcomeLength(text); err != nil {
+				return fmt.Errorf("message %d: %w", i+1, err)
+			}
+		}
+		if m.Attachment != nil {
+			if err := m.Attachment.Validate(); err != nil {
+				return fmt.Errorf("message %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DigestWindow returns DigestWindowSeconds as a time.Duration.
+func (w *ChannelWelcome) DigestWindow() time.Duration {
+	return time.Duration(w.DigestWindowSeconds) * time.Second
+}
+
+// Message returns the message at the 1-based index, if any.
+func (w *ChannelWelcome) Message(index int) (WelcomeMessage, bool) {
+	if w == nil || index < 1 || index > len(w.Messages) {
+		return WelcomeMessage{}, false
+	}
+	return w.Messages[index-1], true
+}
+
+// SetMessage replaces the message at the 1-based index, or appends it if index
+// is right after the last message.
+func (w *ChannelWelcome) SetMessage(index int, message WelcomeMessage) error {
+	switch {
+	case index >= 1 && index <= len(w.Messages):
+		w.Messages[index-1] = message
+	case index == len(w.Messages)+1:
+		w.Messages = append(w.Messages, message)
+	default:
+		return fmt.Errorf("the message number must be between 1 and %d", len(w.Messages)+1)
+	}
+	return nil
+}
+
+// SetTranslation sets the variant of the message at the 1-based index for the
+// locale. The message itself must be set first.
+func (w *ChannelWelcome) SetTranslation(index int, locale, message string) error {
+	if index < 1 || index > len(w.Messages) {
+		return fmt.Errorf("there is no message number %d, set it without a locale first", index)
+	}
+	m := &w.Messages[index-1]
+	if m.Translations == nil {
+		m.Translations = map[string]string{}
+	}
+	m.Translations[locale] = message
+	return nil
+}
+
+// RemoveTranslation removes the variant of the message at the 1-based index
+// for the locale.
+func (w *ChannelWelcome) RemoveTranslation(index int, locale string) error {
+	if index < 1 || index > len(w.Messages) {
+		return fmt.Errorf("there is no message number %d", index)
+	}
+	m := &w.Messages[index-1]
+	if _, ok := m.Translations[locale]; !ok {
+		return fmt.Errorf("message number %d has no %s variant", index, locale)
+	}
+	delete(m.Translations, locale)
+	return nil
+}
+
+// SetGuestMessage sets the guest variant of the message at the 1-based index.
+// The message itself must be set first.
+func (w *ChannelWelcome) SetGuestMessage(index int, message string) error {
+	if index < 1 || index > len(w.Messages) {
+		return fmt.Errorf("there is no message number %d, set it without --guest first", index)
+	}
+	w.Messages[index-1].GuestMessage = message
+	return nil
+}
+
+// SetAddedMessage sets the variant for added members of the message at the
+// 1-based index, which must exist.
+func (w *ChannelWelcome) SetAddedMessage(index int, message string) error {
+	if index < 1 || index > len(w.Messages) {
+		return fmt.Errorf("there is no message number %d, set it without --added first", index)
+	}
+	w.Messages[index-1].AddedMessage = message
+	return nil
+}
+
+// RemoveAddedMessage removes the variant for added members of the message at
+// the 1-based index.
+func (w *ChannelWelcome) RemoveAddedMessage(index int) error {
+	if index < 1 || index > len(w.Messages) {
+		return fmt.Errorf("there is no message number %d", index)
+	}
+	m := &w.Messages[index-1]
+	if m.AddedMessage == "" {
+		return fmt.Errorf("message number %d has no variant for added members", index)
+	}
+	m.AddedMessage = ""
+	return nil
+}
+
+// RemoveGuestMessage removes the guest variant of the message at the 1-based
+// index.
+func (w *ChannelWelcome) RemoveGuestMessage(index int) error {
+	if index < 1 || index > len(w.Messages) {
+		return fmt.Errorf("there is no message number %d", index)
+	}
+	m := &w.Messages[index-1]
+	if m.GuestMessage == "" {
+		return fmt.Errorf("message number %d has no guest variant", index)
+	}
+	m.GuestMessage = ""
+	return nil
+}
+
+// RemoveMessage removes the message at the 1-based index.
+func (w *ChannelWelcome) RemoveMessage(index int) error {
+	if index < 1 || index > len(w.Messages) {
+		return fmt.Errorf("there is no message number %d", index)
+	}
+	w.Messages = append(w.Messages[:index-1], w.Messages[index:]...)
+	return nil
+}
+
+// TeamWelcome is the welcome configuration of a team, sent to new members as
+// a direct message.
+type TeamWelcome struct {
+	Message string `json:"message"`
+
+	// DelaySeconds is how long to wait after the member joined the team
+	// before sending the message.
+	DelaySeconds int `json:"delay_seconds,omitempty"`
+
+	// RecommendedChannels are the IDs of the channels new members are
+	// offered to join, with buttons under the message.
+	RecommendedChannels []string `json:"recommended_channels,omitempty"`
+
+	// GuestMessage is the variant of Message for guest accounts.
+	GuestMessage string `json:"guest_message,omitempty"`
+
+	// AddedMessage is the variant of Message for members added to the team by
+	// someone else.
+	AddedMessage string `json:"added_message,omitempty"`
+
+	// PromotionMessage is sent to the guests who joined the team once they
+	// are promoted to members, e.g. to introduce the channels they can now
+	// join.
+	PromotionMessage string `json:"promotion_message,omitempty"`
+
+	// Interests are the options of the picker under the message, adding new
+	// members to the channels of the interest they pick.
+	Interests []Interest `json:"interests,omitempty"`
+
+	// Onboarding offers new members to go through the onboarding forms, after
+	// the message.
+	Onboarding bool `json:"onboarding,omitempty"`
+
+	// Checklist is the list of tasks posted to new members after the message,
+	// which they tick off as they complete them.
+	Checklist []ChecklistItem `json:"checklist,omitempty"`
+
+	// FollowUps are the messages of the drip campaign, sent to new members
+	// some days after they joined, e.g. a tip after a day and a survey after
+	// a week.
+	FollowUps []FollowUp `json:"follow_ups,omitempty"`
+
+	// Milestones are the congratulations sent to members once they have been
+	// in the team for some time, e.g. a week or a year.
+	Milestones []Milestone `json:"milestones,omitempty"`
+
+	// Survey asks new members to rate their start, some time after they
+	// joined. Nil if the team doesn't survey its new members.
+	Survey *Survey `json:"survey,omitempty"`
+}
+
+// MessageForUser returns the guest variant of the message for guests, if any,
+// or else the message.
+func (w *TeamWelcome) MessageForUser(user *model.User) string {
+	return w.MessageForMember(user, false)
+}
+
+// MessageForMember returns the variant of the message for the member: the
+// guest variant for guests, if any, or else the variant for added members if
+// they were added by someone else.
+func (w *TeamWelcome) MessageForMember(user *model.User, added bool) string {
+	if w.GuestMessage != "" && user.IsGuest() {
+		return w.GuestMessage
+	}
+	if w.AddedMessage != "" && added {
+		return w.AddedMessage
+	}
+	return w.Message
+}
+
+// GetMessage returns the message of the welcome, or an empty string if w is
+// nil.
+func (w *TeamWelcome) GetMessage() string {
+	if w == nil {
+		return ""
+	}
+	return w.Message
+}
+
+// Delay returns DelaySeconds as a time.Duration.
+func (w *TeamWelcome) Delay() time.Duration {
+	return time.Duration(w.DelaySeconds) * time.Second
+}
+
+// CheckLimits checks the texts of the team welcome against the limits of
+// Mattermost posts.
+func (w TeamWelcome) CheckLimits() error {
+	for _, text := range []string{w.Message, w.GuestMessage, w.AddedMessage, w.PromotionMessage} {
+		if err := checkWelcomeLength(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeTeamWelcome decodes a stored team welcome. Welcomes stored by earlier
+// versions of the app are a plain JSON string. It returns nil if there is no
+// message.
+func decodeTeamWelcome(data json.RawMessage) (*TeamWelcome, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	welcome := TeamWelcome{}
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		welcome.Message = message
+	} else if err = json.Unmarshal(data, &welcome); err != nil {
+		return nil, err
+	}
+	if welcome.Message == "" {
+		return nil, nil
+	}
+	return &welcome, nil
+}
+
+// decodeChannelWelcome decodes a stored channel welcome. Welcomes stored
+// before sequences were supported are a single JSON string. It returns nil if
+// there are no messages.
+func decodeChannelWelcome(data json.RawMessage) (*ChannelWelcome, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		if message == "" {
+			return nil, nil
+		}
+		return &ChannelWelcome{Messages: []WelcomeMessage{{Message: message}}}, nil
+	}
+
+	welcome := ChannelWelcome{}
+	if err := json.Unmarshal(data, &welcome); err != nil {
+		return nil, err
+	}
+	if len(welcome.Messages) == 0 {
+		return nil, nil
+	}
+	return &welcome, nil
+}